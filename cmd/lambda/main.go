@@ -3,11 +3,13 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 
 	"mowsy-api/internal/routes"
 	"mowsy-api/pkg/database"
+	"mowsy-api/pkg/logging"
+	"mowsy-api/pkg/tracing"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
@@ -19,8 +21,8 @@ var ginLambda *ginadapter.GinLambda
 var initialized bool
 
 func init() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	
+	logging.Setup()
+
 	// Set Gin to release mode in production
 	if os.Getenv("GIN_MODE") != "debug" {
 		gin.SetMode(gin.ReleaseMode)
@@ -32,35 +34,39 @@ func ensureInitialized() error {
 		return nil
 	}
 
-	log.Println("Initializing database...")
+	// The provider lives as long as the execution environment; spans are
+	// flushed at the end of each invocation instead of at shutdown.
+	if _, err := tracing.Setup(context.Background()); err != nil {
+		return fmt.Errorf("failed to set up tracing: %w", err)
+	}
+
+	slog.Info("initializing database")
 	// Initialize database
 	if err := database.InitDB(); err != nil {
 		return fmt.Errorf("failed to initialize database: %w", err)
 	}
 
-	log.Println("Running auto migrations...")
+	slog.Info("running auto migrations")
 	// Run auto migrations
 	if err := database.AutoMigrate(); err != nil {
 		return fmt.Errorf("failed to run auto migrations: %w", err)
 	}
 
-	log.Println("Setting up routes...")
+	slog.Info("setting up routes")
 	// Setup routes
 	r := routes.SetupRoutes()
 
 	// Create Gin Lambda adapter
 	ginLambda = ginadapter.New(r)
 	initialized = true
-	log.Println("Initialization complete")
+	slog.Info("initialization complete")
 	return nil
 }
 
 func Handler(ctx context.Context, req events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	log.Printf("Request: %s %s", req.HTTPMethod, req.Path)
-	
 	// Ensure database and routes are initialized
 	if err := ensureInitialized(); err != nil {
-		log.Printf("Initialization failed: %v", err)
+		slog.Error("initialization failed", "error", err)
 		return events.APIGatewayProxyResponse{
 			StatusCode: 500,
 			Body:       `{"error":"Internal server error"}`,
@@ -69,6 +75,7 @@ func Handler(ctx context.Context, req events.APIGatewayProxyRequest) (events.API
 	}
 	
 	// Handle the request
+	defer tracing.Flush(ctx)
 	return ginLambda.ProxyWithContext(ctx, req)
 }
 
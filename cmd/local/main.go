@@ -21,19 +21,32 @@
 package main
 
 import (
+	"context"
 	"log"
+	"log/slog"
 	"os"
+	"time"
 
 	"mowsy-api/internal/routes"
+	"mowsy-api/internal/services"
+	"mowsy-api/internal/tasks"
 	"mowsy-api/pkg/database"
 
 	"github.com/gin-gonic/gin"
 	// _ "mowsy-api/docs" // This will be generated by swag - commented out for Go 1.20 compatibility
+	"mowsy-api/pkg/logging"
+	"mowsy-api/pkg/tracing"
 )
 
 func main() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-	
+	logging.Setup()
+
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
 	// Set Gin to debug mode for local development
 	gin.SetMode(gin.DebugMode)
 
@@ -47,8 +60,18 @@ func main() {
 		log.Fatalf("Failed to run auto migrations: %v", err)
 	}
 
+	// One set of services serves requests, streams events and runs tasks, so
+	// the stream sees everything published on the bus
+	container := services.NewContainer(services.DependenciesFromEnv())
+
 	// Setup routes
-	r := routes.SetupRoutes()
+	r := routes.NewRouter(container)
+	routes.RegisterStreamingRoutes(r, container)
+
+	// Run background tasks in-process; deployed environments use cmd/worker
+	queue := tasks.NewQueue(container.DB)
+	container.RegisterTaskHandlers(queue)
+	go queue.Run(context.Background(), 5*time.Second, 50)
 
 	// Get port from environment or use default
 	port := os.Getenv("PORT")
@@ -56,7 +79,7 @@ func main() {
 		port = "8080"
 	}
 
-	log.Printf("Starting server on port %s", port)
+	slog.Info("starting server", "port", port)
 	if err := r.Run(":" + port); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
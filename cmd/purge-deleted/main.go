@@ -0,0 +1,47 @@
+// Command purge-deleted permanently removes jobs, equipment and users that
+// were soft-deleted longer ago than the retention period. It only reports by
+// default; pass -apply to delete.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"mowsy-api/internal/services"
+	"mowsy-api/pkg/database"
+	"mowsy-api/pkg/logging"
+)
+
+func main() {
+	logging.Setup()
+
+	apply := flag.Bool("apply", false, "purge rows instead of only reporting them")
+	retention := flag.Duration("retention", services.DefaultDeletedRetention, "keep rows deleted more recently than this")
+	flag.Parse()
+
+	if err := database.InitDB(); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report, err := services.NewContainer(services.DependenciesFromEnv()).Admin.PurgeDeleted(ctx, services.PurgeOptions{
+		Retention: *retention,
+		DryRun:    !*apply,
+	})
+	if err != nil {
+		log.Fatalf("Purge failed: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+}
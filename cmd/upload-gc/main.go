@@ -0,0 +1,52 @@
+// Command upload-gc deletes stored uploads that no job, equipment listing,
+// insurance submission or message refers to. It only reports by default;
+// pass -apply to delete.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"mowsy-api/internal/services"
+	"mowsy-api/pkg/database"
+	"mowsy-api/pkg/logging"
+)
+
+func main() {
+	logging.Setup()
+
+	apply := flag.Bool("apply", false, "delete orphaned files instead of only reporting them")
+	grace := flag.Duration("grace", services.DefaultUploadGracePeriod, "ignore files modified more recently than this")
+	flag.Parse()
+
+	if err := database.InitDB(); err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+
+	uploadService := services.NewContainer(services.DependenciesFromEnv()).Uploads
+	if uploadService == nil {
+		log.Fatal("File storage is not configured")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report, err := uploadService.CollectGarbage(ctx, services.UploadGCOptions{
+		GracePeriod: *grace,
+		DryRun:      !*apply,
+	})
+	if err != nil {
+		log.Fatalf("Upload GC failed: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		log.Fatalf("Failed to write report: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"mowsy-api/internal/services"
+	"mowsy-api/internal/tasks"
+	"mowsy-api/pkg/database"
+	"mowsy-api/pkg/logging"
+	"mowsy-api/pkg/tracing"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+const batchSize = 50
+
+// lambdaTimeMargin is left unused at the end of an invocation so the last
+// task can record its result before Lambda freezes the process.
+const lambdaTimeMargin = 10 * time.Second
+
+type drainResult struct {
+	Processed int `json:"processed"`
+}
+
+func newQueue() (*tasks.Queue, error) {
+	if err := database.InitDB(); err != nil {
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+
+	if err := database.AutoMigrate(); err != nil {
+		return nil, fmt.Errorf("failed to run auto migrations: %w", err)
+	}
+
+	container := services.NewContainer(services.DependenciesFromEnv())
+	queue := tasks.NewQueue(container.DB)
+	container.RegisterTaskHandlers(queue)
+	return queue, nil
+}
+
+// Handler drains the queue once per invocation. Schedule it with an
+// EventBridge rule; the event payload is ignored.
+func Handler(queue *tasks.Queue) func(ctx context.Context) (drainResult, error) {
+	return func(ctx context.Context) (drainResult, error) {
+		defer tracing.Flush(ctx)
+
+		if deadline, ok := ctx.Deadline(); ok {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, deadline.Add(-lambdaTimeMargin))
+			defer cancel()
+		}
+
+		processed, err := queue.Drain(ctx, batchSize)
+		slog.Info("drained task queue", "processed", processed)
+		return drainResult{Processed: processed}, err
+	}
+}
+
+func main() {
+	logging.Setup()
+
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	queue, err := newQueue()
+	if err != nil {
+		log.Fatalf("Failed to start worker: %v", err)
+	}
+
+	if os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" {
+		lambda.Start(Handler(queue))
+		return
+	}
+
+	interval := 5 * time.Second
+	if value := os.Getenv("WORKER_POLL_INTERVAL"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds <= 0 {
+			log.Fatalf("Invalid WORKER_POLL_INTERVAL: %q", value)
+		}
+		interval = time.Duration(seconds) * time.Second
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	slog.Info("worker polling", "interval", interval.String())
+	queue.Run(ctx, interval, batchSize)
+	slog.Info("worker stopped")
+}
@@ -0,0 +1,176 @@
+package events
+
+import (
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Type string
+
+const (
+	JobPosted           Type = "job.posted"
+	JobCompleted        Type = "job.completed"
+	ApplicationReceived Type = "application.received"
+	ApplicationAccepted Type = "application.accepted"
+	ApplicationRejected Type = "application.rejected"
+	EquipmentListed     Type = "equipment.listed"
+	RentalRequested     Type = "rental.requested"
+	RentalApproved      Type = "rental.approved"
+	RentalCancelled     Type = "rental.cancelled"
+	RentalCompleted     Type = "rental.completed"
+	PaymentSucceeded    Type = "payment.succeeded"
+	PaymentFailed       Type = "payment.failed"
+	MessageReceived     Type = "message.received"
+)
+
+// Types lists every event type, in the order above.
+var Types = []Type{
+	JobPosted, JobCompleted,
+	ApplicationReceived, ApplicationAccepted, ApplicationRejected,
+	EquipmentListed,
+	RentalRequested, RentalApproved, RentalCancelled, RentalCompleted,
+	PaymentSucceeded, PaymentFailed,
+	MessageReceived,
+}
+
+// Broadcast reports whether events of this type announce a new listing rather
+// than a change someone in particular is party to. They are published with no
+// recipients.
+func (t Type) Broadcast() bool {
+	return t == JobPosted || t == EquipmentListed
+}
+
+// Event is a domain change that one or more users should hear about.
+type Event struct {
+	ID         uint64      `json:"id"`
+	Type       Type        `json:"type"`
+	UserIDs    []uint      `json:"-"`
+	Data       interface{} `json:"data"`
+	OccurredAt time.Time   `json:"occurred_at"`
+}
+
+// Handler receives every published event. Handlers run synchronously on the
+// publishing goroutine, so they must be quick and must not block.
+type Handler func(Event)
+
+// Subscription delivers the events addressed to a single user.
+type Subscription struct {
+	C      <-chan Event
+	ch     chan Event
+	userID uint
+	bus    *Bus
+	once   sync.Once
+}
+
+// Close stops delivery and releases the subscription.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.unsubscribe(s)
+	})
+}
+
+// Bus is an in-process publish/subscribe hub. Services publish to it and
+// streaming connections and background consumers subscribe.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers []Handler
+	users    map[uint]map[*Subscription]struct{}
+	nextID   uint64
+}
+
+func NewBus() *Bus {
+	return &Bus{
+		users: make(map[uint]map[*Subscription]struct{}),
+	}
+}
+
+var defaultBus = NewBus()
+
+// Default returns the process-wide bus.
+func Default() *Bus {
+	return defaultBus
+}
+
+// Subscribe registers a handler for all events.
+func (b *Bus) Subscribe(handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers = append(b.handlers, handler)
+}
+
+// SubscribeUser opens a buffered stream of the events addressed to userID.
+func (b *Bus) SubscribeUser(userID uint, buffer int) *Subscription {
+	ch := make(chan Event, buffer)
+	sub := &Subscription{C: ch, ch: ch, userID: userID, bus: b}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.users[userID] == nil {
+		b.users[userID] = make(map[*Subscription]struct{})
+	}
+	b.users[userID][sub] = struct{}{}
+
+	return sub
+}
+
+func (b *Bus) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if subs, ok := b.users[sub.userID]; ok {
+		delete(subs, sub)
+		if len(subs) == 0 {
+			delete(b.users, sub.userID)
+		}
+	}
+	close(sub.ch)
+}
+
+// Publish fans an event out to handlers and to the recipients' streams. It is
+// safe to call on a nil bus, which discards the event.
+func (b *Bus) Publish(eventType Type, userIDs []uint, data interface{}) {
+	if b == nil {
+		return
+	}
+
+	event := Event{
+		ID:         atomic.AddUint64(&b.nextID, 1),
+		Type:       eventType,
+		UserIDs:    userIDs,
+		Data:       data,
+		OccurredAt: time.Now(),
+	}
+
+	b.mu.RLock()
+	handlers := make([]Handler, len(b.handlers))
+	copy(handlers, b.handlers)
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		b.runHandler(handler, event)
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, userID := range userIDs {
+		for sub := range b.users[userID] {
+			select {
+			case sub.ch <- event:
+			default:
+				slog.Warn("dropping event: subscriber is not keeping up", "event", event.Type, "user_id", userID)
+			}
+		}
+	}
+}
+
+func (b *Bus) runHandler(handler Handler, event Event) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			slog.Error("event handler panicked", "event", event.Type, "panic", fmt.Sprint(recovered))
+		}
+	}()
+	handler(event)
+}
@@ -0,0 +1,90 @@
+package events
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBus_Publish(t *testing.T) {
+	t.Run("DeliversToAddressedUsersOnly", func(t *testing.T) {
+		bus := NewBus()
+		recipient := bus.SubscribeUser(1, 4)
+		defer recipient.Close()
+		bystander := bus.SubscribeUser(2, 4)
+		defer bystander.Close()
+
+		bus.Publish(RentalApproved, []uint{1}, map[string]uint{"rental_id": 7})
+
+		select {
+		case event := <-recipient.C:
+			assert.Equal(t, RentalApproved, event.Type)
+			assert.NotZero(t, event.ID)
+		case <-time.After(time.Second):
+			t.Fatal("expected event for recipient")
+		}
+
+		select {
+		case event := <-bystander.C:
+			t.Fatalf("unexpected event for bystander: %v", event.Type)
+		default:
+		}
+	})
+
+	t.Run("HandlersSeeEveryEvent", func(t *testing.T) {
+		bus := NewBus()
+		var received []Type
+		bus.Subscribe(func(event Event) {
+			received = append(received, event.Type)
+		})
+
+		bus.Publish(ApplicationAccepted, []uint{1}, nil)
+		bus.Publish(PaymentSucceeded, []uint{2}, nil)
+
+		assert.Equal(t, []Type{ApplicationAccepted, PaymentSucceeded}, received)
+	})
+
+	t.Run("PanickingHandlerDoesNotStopDelivery", func(t *testing.T) {
+		bus := NewBus()
+		bus.Subscribe(func(event Event) { panic("boom") })
+		sub := bus.SubscribeUser(1, 1)
+		defer sub.Close()
+
+		require.NotPanics(t, func() {
+			bus.Publish(MessageReceived, []uint{1}, nil)
+		})
+		assert.Len(t, sub.C, 1)
+	})
+
+	t.Run("FullSubscriberDropsEvents", func(t *testing.T) {
+		bus := NewBus()
+		sub := bus.SubscribeUser(1, 1)
+		defer sub.Close()
+
+		bus.Publish(MessageReceived, []uint{1}, nil)
+		bus.Publish(MessageReceived, []uint{1}, nil)
+
+		assert.Len(t, sub.C, 1)
+	})
+
+	t.Run("ClosedSubscriptionStopsReceiving", func(t *testing.T) {
+		bus := NewBus()
+		sub := bus.SubscribeUser(1, 1)
+		sub.Close()
+		sub.Close()
+
+		bus.Publish(MessageReceived, []uint{1}, nil)
+
+		_, open := <-sub.C
+		assert.False(t, open)
+	})
+
+	t.Run("NilBusDiscards", func(t *testing.T) {
+		var bus *Bus
+		assert.NotPanics(t, func() {
+			bus.Publish(MessageReceived, []uint{1}, nil)
+		})
+	})
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"mowsy-api/internal/services"
+	"mowsy-api/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AccessTokenHandler struct {
+	accessTokenService *services.AccessTokenService
+}
+
+func NewAccessTokenHandler(tokens *services.AccessTokenService) *AccessTokenHandler {
+	return &AccessTokenHandler{
+		accessTokenService: tokens,
+	}
+}
+
+// CreateToken godoc
+// @Summary Create a personal access token
+// @Description Create a long-lived token for an integration, limited to the given scopes (jobs:read, jobs:write, equipment:write, payments:read). The token is only returned in this response.
+// @Tags tokens
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param token body services.CreateAccessTokenRequest true "Token name, scopes and lifetime"
+// @Success 201 {object} services.CreatedAccessToken "Token created"
+// @Failure 400 {object} utils.ErrorResponseModel "Invalid request body, unknown scope or lifetime out of range"
+// @Failure 401 {object} utils.ErrorResponseModel "User not authenticated"
+// @Failure 409 {object} utils.ErrorResponseModel "Too many active tokens"
+// @Router /users/me/tokens [post]
+func (h *AccessTokenHandler) CreateToken(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req services.CreateAccessTokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	token, err := h.accessTokenService.CreateToken(userID.(uint), req)
+	switch {
+	case errors.Is(err, services.ErrInvalidTokenScope), errors.Is(err, services.ErrInvalidTokenExpiry):
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrTooManyAccessTokens):
+		utils.ErrorResponse(c, http.StatusConflict, err.Error())
+	case err != nil:
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+	default:
+		utils.DataResponse(c, http.StatusCreated, token)
+	}
+}
+
+// GetTokens godoc
+// @Summary List personal access tokens
+// @Description List the current user's tokens that have not been revoked, including expired ones
+// @Tags tokens
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.PersonalAccessToken "Tokens"
+// @Failure 401 {object} utils.ErrorResponseModel "User not authenticated"
+// @Failure 500 {object} utils.ErrorResponseModel "Internal server error"
+// @Router /users/me/tokens [get]
+func (h *AccessTokenHandler) GetTokens(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	tokens, err := h.accessTokenService.GetTokens(userID.(uint))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, tokens)
+}
+
+// RevokeToken godoc
+// @Summary Revoke a personal access token
+// @Description Revoke one of the current user's tokens. It stops working immediately.
+// @Tags tokens
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Token ID"
+// @Success 200 {object} utils.SuccessResponseModel "Token revoked"
+// @Failure 400 {object} utils.ErrorResponseModel "Invalid token ID"
+// @Failure 401 {object} utils.ErrorResponseModel "User not authenticated"
+// @Failure 404 {object} utils.ErrorResponseModel "Token not found"
+// @Router /users/me/tokens/{id} [delete]
+func (h *AccessTokenHandler) RevokeToken(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	tokenID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid token ID")
+		return
+	}
+
+	if err := h.accessTokenService.RevokeToken(userID.(uint), uint(tokenID)); err != nil {
+		if errors.Is(err, services.ErrAccessTokenNotFound) {
+			utils.ErrorResponse(c, http.StatusNotFound, err.Error())
+			return
+		}
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Token revoked", nil)
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"net/http"
 	"strconv"
+	"time"
 
 	"mowsy-api/internal/services"
 	"mowsy-api/internal/utils"
@@ -14,9 +15,9 @@ type AdminHandler struct {
 	adminService *services.AdminService
 }
 
-func NewAdminHandler() *AdminHandler {
+func NewAdminHandler(admin *services.AdminService) *AdminHandler {
 	return &AdminHandler{
-		adminService: services.NewAdminService(),
+		adminService: admin,
 	}
 }
 
@@ -70,6 +71,52 @@ func (h *AdminHandler) ActivateUser(c *gin.Context) {
 	utils.SuccessResponse(c, http.StatusOK, "User activated successfully", nil)
 }
 
+func (h *AdminHandler) SetUserRole(c *gin.Context) {
+	userIDStr := c.Param("id")
+	userID, err := strconv.ParseUint(userIDStr, 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var req services.SetUserRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	err = h.adminService.SetUserRole(uint(userID), req.Role)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "User role updated successfully", nil)
+}
+
+func (h *AdminHandler) SetPayoutAccount(c *gin.Context) {
+	userIDStr := c.Param("id")
+	userID, err := strconv.ParseUint(userIDStr, 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	var req services.SetPayoutAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	err = h.adminService.SetPayoutAccount(uint(userID), req.StripeAccountID)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Payout account updated successfully", nil)
+}
+
 func (h *AdminHandler) VerifyInsurance(c *gin.Context) {
 	userIDStr := c.Param("id")
 	userID, err := strconv.ParseUint(userIDStr, 10, 32)
@@ -121,6 +168,108 @@ func (h *AdminHandler) RemoveEquipment(c *gin.Context) {
 	utils.SuccessResponse(c, http.StatusOK, "Equipment removed successfully", nil)
 }
 
+func (h *AdminHandler) RemoveUser(c *gin.Context) {
+	userIDStr := c.Param("id")
+	userID, err := strconv.ParseUint(userIDStr, 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	err = h.adminService.RemoveUser(uint(userID))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "User removed successfully", nil)
+}
+
+func (h *AdminHandler) RestoreUser(c *gin.Context) {
+	userIDStr := c.Param("id")
+	userID, err := strconv.ParseUint(userIDStr, 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	err = h.adminService.RestoreUser(uint(userID))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "User restored successfully", nil)
+}
+
+func (h *AdminHandler) RestoreJob(c *gin.Context) {
+	jobIDStr := c.Param("id")
+	jobID, err := strconv.ParseUint(jobIDStr, 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid job ID")
+		return
+	}
+
+	err = h.adminService.RestoreJob(uint(jobID))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Job restored successfully", nil)
+}
+
+func (h *AdminHandler) RestoreEquipment(c *gin.Context) {
+	equipmentIDStr := c.Param("id")
+	equipmentID, err := strconv.ParseUint(equipmentIDStr, 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid equipment ID")
+		return
+	}
+
+	err = h.adminService.RestoreEquipment(uint(equipmentID))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Equipment restored successfully", nil)
+}
+
+func (h *AdminHandler) PurgeDeleted(c *gin.Context) {
+	var req struct {
+		DryRun    *bool  `json:"dry_run"`
+		Retention string `json:"retention"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	opts := services.PurgeOptions{DryRun: true}
+	if req.DryRun != nil {
+		opts.DryRun = *req.DryRun
+	}
+	if req.Retention != "" {
+		retention, err := time.ParseDuration(req.Retention)
+		if err != nil || retention <= 0 {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid retention period")
+			return
+		}
+		opts.Retention = retention
+	}
+
+	report, err := h.adminService.PurgeDeleted(c.Request.Context(), opts)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, report)
+}
+
 func (h *AdminHandler) GetStats(c *gin.Context) {
 	stats, err := h.adminService.GetStats()
 	if err != nil {
@@ -129,4 +278,62 @@ func (h *AdminHandler) GetStats(c *gin.Context) {
 	}
 
 	utils.DataResponse(c, http.StatusOK, stats)
-}
\ No newline at end of file
+}
+func (h *AdminHandler) GetTasks(c *gin.Context) {
+	var filters services.AdminTaskListFilters
+	if err := c.ShouldBindQuery(&filters); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid query parameters")
+		return
+	}
+
+	taskList, err := h.adminService.GetTasks(filters)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, taskList)
+}
+
+func (h *AdminHandler) RetryTask(c *gin.Context) {
+	taskIDStr := c.Param("id")
+	taskID, err := strconv.ParseUint(taskIDStr, 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid task ID")
+		return
+	}
+
+	err = h.adminService.RetryTask(uint(taskID))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Task requeued successfully", nil)
+}
+
+func (h *AdminHandler) GetLockedAccounts(c *gin.Context) {
+	accounts, err := h.adminService.GetLockedAccounts()
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, accounts)
+}
+
+func (h *AdminHandler) UnlockUser(c *gin.Context) {
+	userIDStr := c.Param("id")
+	userID, err := strconv.ParseUint(userIDStr, 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if err := h.adminService.UnlockUser(uint(userID)); err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "User unlocked successfully", nil)
+}
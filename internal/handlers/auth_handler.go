@@ -1,21 +1,28 @@
 package handlers
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 
 	"mowsy-api/internal/services"
 	"mowsy-api/internal/utils"
+	"mowsy-api/pkg/auth"
+	"mowsy-api/pkg/oidc"
 
 	"github.com/gin-gonic/gin"
 )
 
 type AuthHandler struct {
-	userService *services.UserService
+	userService  *services.UserService
+	oauthService *services.OAuthService
 }
 
-func NewAuthHandler() *AuthHandler {
+func NewAuthHandler(users *services.UserService, oauth *services.OAuthService) *AuthHandler {
 	return &AuthHandler{
-		userService: services.NewUserService(),
+		userService:  users,
+		oauthService: oauth,
 	}
 }
 
@@ -52,9 +59,11 @@ func (h *AuthHandler) Register(c *gin.Context) {
 // @Accept json
 // @Produce json
 // @Param credentials body services.LoginRequest true "User login credentials"
-// @Success 200 {object} services.LoginResponse "User logged in successfully"
+// @Success 200 {object} services.LoginResponse "User logged in successfully, or an MFAChallenge when two-factor authentication is needed"
 // @Failure 400 {object} utils.ErrorResponseModel "Invalid request body"
 // @Failure 401 {object} utils.ErrorResponseModel "Invalid credentials"
+// @Failure 423 {object} utils.ErrorResponseModel "Account locked after too many failed attempts"
+// @Failure 429 {object} utils.ErrorResponseModel "Too many failed attempts; retry after the Retry-After header"
 // @Router /auth/login [post]
 func (h *AuthHandler) Login(c *gin.Context) {
 	var req services.LoginRequest
@@ -63,15 +72,154 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	response, err := h.userService.Login(req)
+	response, err := h.userService.Login(req, c.ClientIP())
+	var mfaRequired *services.MFARequiredError
+	if errors.As(err, &mfaRequired) {
+		utils.DataResponse(c, http.StatusOK, mfaRequired.Challenge)
+		return
+	}
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusUnauthorized, err.Error())
+		signInErrorResponse(c, err)
 		return
 	}
 
 	utils.DataResponse(c, http.StatusOK, response)
 }
 
+// OAuthLogin godoc
+// @Summary Sign in with Google or Apple
+// @Description Exchange an ID token from the provider's sign-in flow for an access and refresh token. The account is linked to an existing passwordless user with the same verified email, or a new user is created. Users with a password must link the account from POST /users/me/oauth/{provider} first.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param provider path string true "Provider" Enums(google, apple)
+// @Param token body services.OAuthLoginRequest true "ID token"
+// @Success 200 {object} services.LoginResponse "User signed in successfully, or an MFAChallenge when two-factor authentication is needed"
+// @Failure 400 {object} utils.ErrorResponseModel "Invalid request body"
+// @Failure 401 {object} utils.ErrorResponseModel "Invalid ID token"
+// @Failure 403 {object} utils.ErrorResponseModel "Email not verified by the provider, or account disabled"
+// @Failure 404 {object} utils.ErrorResponseModel "Provider not configured"
+// @Failure 409 {object} utils.ErrorResponseModel "A user with a password has this email and must link the account first"
+// @Router /auth/oauth/{provider} [post]
+func (h *AuthHandler) OAuthLogin(c *gin.Context) {
+	var req services.OAuthLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	response, err := h.oauthService.Login(c.Request.Context(), c.Param("provider"), req)
+	var mfaRequired *services.MFARequiredError
+	switch {
+	case errors.As(err, &mfaRequired):
+		utils.DataResponse(c, http.StatusOK, mfaRequired.Challenge)
+	case errors.Is(err, oidc.ErrUnknownProvider):
+		utils.ErrorResponse(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, oidc.ErrInvalidToken):
+		utils.ErrorResponse(c, http.StatusUnauthorized, oidc.ErrInvalidToken.Error())
+	case errors.Is(err, services.ErrOAuthEmailUnverified), errors.Is(err, services.ErrOAuthAccountDisabled):
+		utils.ErrorResponse(c, http.StatusForbidden, err.Error())
+	case errors.Is(err, services.ErrOAuthLinkRequired):
+		utils.ErrorResponse(c, http.StatusConflict, err.Error())
+	case err != nil:
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to sign in")
+	default:
+		utils.DataResponse(c, http.StatusOK, response)
+	}
+}
+
+// LinkOAuth godoc
+// @Summary Link a Google or Apple account
+// @Description Link the provider account behind an ID token to the current user, so they can sign in with it.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param provider path string true "Provider" Enums(google, apple)
+// @Param token body services.OAuthLinkRequest true "ID token"
+// @Success 200 {object} utils.SuccessResponseModel "Account linked"
+// @Failure 400 {object} utils.ErrorResponseModel "Invalid request body"
+// @Failure 401 {object} utils.ErrorResponseModel "User not authenticated or invalid ID token"
+// @Failure 404 {object} utils.ErrorResponseModel "Provider not configured"
+// @Failure 409 {object} utils.ErrorResponseModel "Provider account is linked to another user"
+// @Router /users/me/oauth/{provider} [post]
+func (h *AuthHandler) LinkOAuth(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req services.OAuthLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	err := h.oauthService.Link(c.Request.Context(), userID.(uint), c.Param("provider"), req)
+	switch {
+	case errors.Is(err, oidc.ErrUnknownProvider):
+		utils.ErrorResponse(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, oidc.ErrInvalidToken):
+		utils.ErrorResponse(c, http.StatusUnauthorized, oidc.ErrInvalidToken.Error())
+	case errors.Is(err, services.ErrOAuthIdentityInUse):
+		utils.ErrorResponse(c, http.StatusConflict, err.Error())
+	case err != nil:
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to link account")
+	default:
+		utils.SuccessResponse(c, http.StatusOK, "Account linked", nil)
+	}
+}
+
+// signInErrorResponse reports a failed sign-in step, telling throttled and
+// locked-out clients when they may try again.
+func signInErrorResponse(c *gin.Context, err error) {
+	var throttled *services.LoginThrottledError
+	switch {
+	case errors.As(err, &throttled):
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(throttled.RetryAfter.Seconds()))))
+		utils.ErrorResponse(c, http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, services.ErrAccountLocked):
+		utils.ErrorResponse(c, http.StatusLocked, err.Error())
+	default:
+		utils.ErrorResponse(c, http.StatusUnauthorized, err.Error())
+	}
+}
+
+// UnlockAccountRequest represents the request body for lifting a lockout
+type UnlockAccountRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// UnlockAccount godoc
+// @Summary Unlock account
+// @Description Lift a sign-in lockout using the token from the unlock email
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param token body UnlockAccountRequest true "Unlock token"
+// @Success 200 {object} utils.SuccessResponseModel "Account unlocked"
+// @Failure 400 {object} utils.ErrorResponseModel "Invalid request body or invalid or expired token"
+// @Router /auth/unlock [post]
+func (h *AuthHandler) UnlockAccount(c *gin.Context) {
+	var req UnlockAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.userService.UnlockAccount(req.Token); err != nil {
+		if errors.Is(err, services.ErrInvalidUnlockToken) {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Account unlocked", nil)
+}
+
 // RefreshTokenRequest represents the request body for token refresh
 type RefreshTokenRequest struct {
 	RefreshToken string `json:"refresh_token" binding:"required"`
@@ -105,6 +253,26 @@ func (h *AuthHandler) RefreshToken(c *gin.Context) {
 	utils.DataResponse(c, http.StatusOK, response)
 }
 
+// JWKS godoc
+// @Summary Token signing keys
+// @Description Public keys that access tokens are signed with, as a JSON Web Key Set. Tokens name their key in the kid header.
+// @Tags auth
+// @Produce json
+// @Success 200 {object} oidc.JSONWebKeySet "Active signing keys"
+// @Failure 503 {object} utils.ErrorResponseModel "Signing keys not configured"
+// @Router /.well-known/jwks.json [get]
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	keys, err := auth.SigningKeys()
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusServiceUnavailable, "Signing keys not configured")
+		return
+	}
+
+	// Short enough that a newly added key is picked up well before it signs
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, keys.JWKS())
+}
+
 // Logout godoc
 // @Summary Logout user
 // @Description Logout the current user (client-side token cleanup)
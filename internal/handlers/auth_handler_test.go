@@ -2,50 +2,44 @@ package handlers
 
 import (
 	"bytes"
+	"crypto"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"testing"
 
 	"mowsy-api/internal/services"
 	"mowsy-api/internal/testutils"
 	"mowsy-api/pkg/auth"
+	"mowsy-api/pkg/oidc"
 
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
-func setupAuthHandler() (*AuthHandler, *gin.Engine) {
+func setupAuthHandler(t *testing.T) (*AuthHandler, *gin.Engine) {
 	gin.SetMode(gin.TestMode)
 	r := gin.New()
 	
 	// Set up test environment
-	originalSecret := os.Getenv("JWT_SECRET")
-	if originalSecret == "" {
-		os.Setenv("JWT_SECRET", "test-secret-key-for-jwt-testing")
-	}
+	testutils.UseTestSigningKeys(t)
 	
-	handler := NewAuthHandler()
+	c := newTestContainer(t, nil)
+	handler := NewAuthHandler(c.Users, c.OAuth)
 	return handler, r
 }
 
 func TestAuthHandler_Register(t *testing.T) {
-	handler, r := setupAuthHandler()
+	handler, r := setupAuthHandler(t)
 	
 	// Setup route
 	r.POST("/register", handler.Register)
-	
-	// Override the user service with one that uses test DB
-	db := testutils.SetupTestDB()
-	defer testutils.CleanupTestDB(db)
-	handler.userService = services.NewUserServiceWithDB(db)
 
 	t.Run("ValidRegistration", func(t *testing.T) {
 		reqBody := services.RegisterRequest{
 			Email:     "test@example.com",
-			Password:  "password123",
+			Password:  "mower-shed-42",
 			FirstName: "Test",
 			LastName:  "User",
 			Phone:     "555-123-4567",
@@ -97,7 +91,7 @@ func TestAuthHandler_Register(t *testing.T) {
 }
 
 func TestAuthHandler_Login(t *testing.T) {
-	handler, r := setupAuthHandler()
+	handler, r := setupAuthHandler(t)
 	
 	// Setup route
 	r.POST("/login", handler.Login)
@@ -136,15 +130,10 @@ func TestAuthHandler_Login(t *testing.T) {
 }
 
 func TestAuthHandler_RefreshToken(t *testing.T) {
-	handler, r := setupAuthHandler()
+	handler, r := setupAuthHandler(t)
 	
 	// Setup route
 	r.POST("/refresh", handler.RefreshToken)
-	
-	// Override the user service with one that uses test DB
-	db := testutils.SetupTestDB()
-	defer testutils.CleanupTestDB(db)
-	handler.userService = services.NewUserServiceWithDB(db)
 
 	t.Run("InvalidRequestBody", func(t *testing.T) {
 		invalidJSON := `{"refresh_token": 123}` // invalid token type
@@ -201,7 +190,7 @@ func TestAuthHandler_RefreshToken(t *testing.T) {
 }
 
 func TestAuthHandler_Logout(t *testing.T) {
-	handler, r := setupAuthHandler()
+	handler, r := setupAuthHandler(t)
 	
 	// Setup route
 	r.POST("/logout", handler.Logout)
@@ -217,19 +206,47 @@ func TestAuthHandler_Logout(t *testing.T) {
 	})
 }
 
-// Integration test that demonstrates the full flow with a test database
-func TestAuthHandler_IntegrationTest(t *testing.T) {
-	// This test would require proper dependency injection to work fully
-	t.Skip("Skipping integration test - requires proper DI setup")
+func TestAuthHandler_JWKS(t *testing.T) {
+	handler, r := setupAuthHandler(t)
+	r.GET("/.well-known/jwks.json", handler.JWKS)
 
-	db := testutils.SetupTestDB()
-	defer testutils.CleanupTestDB(db)
+	t.Run("PublishesSigningKey", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
 
-	// Setup handler with test database
-	userService := &services.UserService{} // Would need to inject test DB here
-	handler := &AuthHandler{userService: userService}
+		require.Equal(t, http.StatusOK, w.Code)
+		var jwks oidc.JSONWebKeySet
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &jwks))
+		require.Len(t, jwks.Keys, 1)
 
-	r := gin.New()
+		// A token we issue can be verified with the published key alone
+		token, err := auth.GenerateToken(123, "test@example.com")
+		require.NoError(t, err)
+		publicKey, err := jwks.Keys[0].PublicKey()
+		require.NoError(t, err)
+		provider := &oidc.Provider{
+			Name: "mowsy", Issuers: []string{"mowsy-api"}, Audiences: []string{"mowsy-api"},
+			Keys: oidc.NewStaticKeySet(map[string]crypto.PublicKey{jwks.Keys[0].Kid: publicKey}),
+		}
+		_, err = provider.Verify(req.Context(), token, "")
+		assert.NoError(t, err)
+	})
+
+	t.Run("NotConfigured", func(t *testing.T) {
+		t.Setenv("JWT_SIGNING_KEYS", "")
+
+		req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	})
+}
+
+// Integration test that demonstrates the full flow with a test database
+func TestAuthHandler_IntegrationTest(t *testing.T) {
+	handler, r := setupAuthHandler(t)
 	r.POST("/register", handler.Register)
 	r.POST("/login", handler.Login)
 
@@ -238,7 +255,7 @@ func TestAuthHandler_IntegrationTest(t *testing.T) {
 		// Register
 		regReq := services.RegisterRequest{
 			Email:     "integration@example.com",
-			Password:  "password123",
+			Password:  "mower-shed-42",
 			FirstName: "Integration",
 			LastName:  "Test",
 		}
@@ -263,7 +280,7 @@ func TestAuthHandler_IntegrationTest(t *testing.T) {
 		// Login with same credentials
 		loginReq := services.LoginRequest{
 			Email:    "integration@example.com",
-			Password: "password123",
+			Password: "mower-shed-42",
 		}
 
 		jsonData, err = json.Marshal(loginReq)
@@ -16,9 +16,9 @@ type EquipmentHandler struct {
 	equipmentService *services.EquipmentService
 }
 
-func NewEquipmentHandler() *EquipmentHandler {
+func NewEquipmentHandler(equipment *services.EquipmentService) *EquipmentHandler {
 	return &EquipmentHandler{
-		equipmentService: services.NewEquipmentService(),
+		equipmentService: equipment,
 	}
 }
 
@@ -215,7 +215,7 @@ func (h *EquipmentHandler) UpdateRentalStatus(c *gin.Context) {
 	}
 
 	var req struct {
-		Status models.RentalStatus `json:"status" binding:"required"`
+		Status models.RentalStatus `json:"status" binding:"required,oneof=approved cancelled"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
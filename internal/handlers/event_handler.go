@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"mowsy-api/internal/events"
+	"mowsy-api/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	eventStreamBuffer    = 32
+	eventStreamHeartbeat = 25 * time.Second
+)
+
+type EventHandler struct {
+	bus *events.Bus
+}
+
+func NewEventHandler(bus *events.Bus) *EventHandler {
+	return &EventHandler{
+		bus: bus,
+	}
+}
+
+// Stream godoc
+// @Summary Stream status events
+// @Description Server-Sent Events stream of the current user's application, rental, payment and message events. Available on the local server only.
+// @Tags events
+// @Produce text/event-stream
+// @Security BearerAuth
+// @Success 200 {object} events.Event "Event stream"
+// @Failure 401 {object} utils.ErrorResponseModel "User not authenticated"
+// @Router /events/stream [get]
+func (h *EventHandler) Stream(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	sub := h.bus.SubscribeUser(userID.(uint), eventStreamBuffer)
+	defer sub.Close()
+
+	heartbeat := time.NewTicker(eventStreamHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.SSEvent("ready", gin.H{"user_id": userID})
+	c.Writer.Flush()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-sub.C:
+			if !ok {
+				return false
+			}
+			c.SSEvent(string(event.Type), event)
+			return true
+		case <-heartbeat.C:
+			c.SSEvent("heartbeat", gin.H{"timestamp": time.Now().Unix()})
+			return true
+		}
+	})
+}
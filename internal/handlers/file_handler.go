@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mowsy-api/internal/utils"
+	"mowsy-api/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxLocalUploadSize matches the limit UploadService applies to multipart
+// uploads.
+const maxLocalUploadSize = 10 * 1024 * 1024
+
+// FileHandler serves files for the local storage backend, standing in for S3
+// object URLs and presigned URLs.
+type FileHandler struct {
+	backend *storage.LocalBackend
+}
+
+func NewFileHandler(backend *storage.LocalBackend) *FileHandler {
+	return &FileHandler{backend: backend}
+}
+
+// GetFile serves a stored file. Public files can be fetched directly, like
+// the public-read objects the S3 backend creates; private files need a
+// presigned URL.
+func (h *FileHandler) GetFile(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+
+	if c.Query("signature") != "" || storage.KeyVisibility(key) == storage.VisibilityPrivate {
+		if err := h.backend.Verify("GET", key, "", c.Request.URL.Query()); err != nil {
+			utils.ErrorResponse(c, http.StatusForbidden, err.Error())
+			return
+		}
+	}
+
+	path, err := h.backend.Path(key)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() {
+		utils.ErrorResponse(c, http.StatusNotFound, "File not found")
+		return
+	}
+
+	if contentType := mime.TypeByExtension(filepath.Ext(path)); contentType != "" {
+		c.Header("Content-Type", contentType)
+	}
+	c.File(path)
+}
+
+// PutFile accepts an upload to a presigned URL from
+// LocalBackend.GetPresignedUploadURL.
+func (h *FileHandler) PutFile(c *gin.Context) {
+	key := strings.TrimPrefix(c.Param("key"), "/")
+
+	if err := h.backend.Verify("PUT", key, c.ContentType(), c.Request.URL.Query()); err != nil {
+		utils.ErrorResponse(c, http.StatusForbidden, err.Error())
+		return
+	}
+
+	limit := int64(maxLocalUploadSize)
+	if size := storage.SignedContentLength(c.Request.URL.Query()); size > 0 {
+		// Like S3, refuse a body that differs from the signed Content-Length
+		if c.Request.ContentLength != size {
+			utils.ErrorResponse(c, http.StatusForbidden, "Content-Length does not match the signed upload size")
+			return
+		}
+		limit = size
+	}
+
+	body := http.MaxBytesReader(c.Writer, c.Request.Body, limit)
+	if _, err := h.backend.Write(key, body); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			utils.ErrorResponse(c, http.StatusRequestEntityTooLarge, "File size exceeds 10MB limit")
+			return
+		}
+		utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to store file")
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"mowsy-api/internal/services"
+	"mowsy-api/internal/testutils"
+	"mowsy-api/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupFileHandler(t *testing.T) (*storage.LocalBackend, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+
+	backend, err := storage.NewLocalBackend(t.TempDir(), "http://localhost:8080/files", []byte("test-signing-key"))
+	require.NoError(t, err)
+
+	handler := NewFileHandler(backend)
+	r := gin.New()
+	r.GET("/files/*key", handler.GetFile)
+	r.PUT("/files/*key", handler.PutFile)
+
+	return backend, r
+}
+
+// requestPath strips the scheme and host from a backend URL so it can be
+// served by the test router.
+func requestPath(t *testing.T, raw string) string {
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+	return u.RequestURI()
+}
+
+func TestFileHandler(t *testing.T) {
+	backend, r := setupFileHandler(t)
+	db := testutils.SetupTestDB()
+	defer testutils.CleanupTestDB(db)
+	uploads := services.NewUploadServiceWithBackend(db, backend)
+
+	t.Run("PresignedUploadThenDownload", func(t *testing.T) {
+		presigned, err := uploads.GetPresignedUploadURL(1, "lawn.jpg", "image/jpeg", "")
+		require.NoError(t, err)
+		uploadURL := presigned.UploadURL
+
+		req, _ := http.NewRequest("PUT", requestPath(t, uploadURL), strings.NewReader("jpeg bytes"))
+		req.Header.Set("Content-Type", "image/jpeg")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		key := strings.TrimPrefix(strings.SplitN(requestPath(t, uploadURL), "?", 2)[0], "/files/")
+		downloadURL, err := backend.GetPresignedURL(key, time.Hour)
+		require.NoError(t, err)
+
+		req, _ = http.NewRequest("GET", requestPath(t, downloadURL), nil)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "jpeg bytes", w.Body.String())
+		assert.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+	})
+
+	t.Run("UploadRequiresSignature", func(t *testing.T) {
+		req, _ := http.NewRequest("PUT", "/files/uploads/1/forged.jpg", strings.NewReader("x"))
+		req.Header.Set("Content-Type", "image/jpeg")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("UploadWithWrongContentType", func(t *testing.T) {
+		presigned, err := uploads.GetPresignedUploadURL(1, "lawn.jpg", "image/jpeg", "")
+		require.NoError(t, err)
+
+		req, _ := http.NewRequest("PUT", requestPath(t, presigned.UploadURL), strings.NewReader("<html>"))
+		req.Header.Set("Content-Type", "text/html")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("SessionUploadMustMatchSignedLength", func(t *testing.T) {
+		image := testutils.CreateTestImage(64, 48)
+		session, err := uploads.CreateUploadSession(1, services.CreateUploadSessionRequest{
+			FileName: "lawn.png",
+			MimeType: "image/png",
+			Size:     int64(len(image)),
+		})
+		require.NoError(t, err)
+
+		req, _ := http.NewRequest("PUT", requestPath(t, session.UploadURL), bytes.NewReader(append(image, 0)))
+		req.Header.Set("Content-Type", "image/png")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		req, _ = http.NewRequest("PUT", requestPath(t, session.UploadURL), bytes.NewReader(image))
+		req.Header.Set("Content-Type", "image/png")
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		completed, err := uploads.CompleteUploadSession(1, session.ID)
+		require.NoError(t, err)
+		assert.Equal(t, session.Key, completed.Upload.Key)
+	})
+
+	t.Run("PublicURLFromUpload", func(t *testing.T) {
+		result, err := uploads.UploadFromReader(2, bytes.NewReader(testutils.CreateTestImage(64, 48)), "mower.png", "image/png", services.UploadCategoryListingPhoto)
+		require.NoError(t, err)
+
+		for _, url := range []string{result.URL, result.Variants["thumbnail"]} {
+			req, _ := http.NewRequest("GET", requestPath(t, url), nil)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.Equal(t, "image/jpeg", w.Header().Get("Content-Type"))
+		}
+	})
+
+	t.Run("PrivateFileNeedsSignature", func(t *testing.T) {
+		result, err := uploads.UploadFromReader(2, strings.NewReader("%PDF-1.4"), "policy.pdf", "application/pdf", services.UploadCategoryInsurance)
+		require.NoError(t, err)
+
+		req, _ := http.NewRequest("GET", "/files/"+result.Key, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+
+		// The upload response carries a presigned URL instead
+		req, _ = http.NewRequest("GET", requestPath(t, result.URL), nil)
+		w = httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "%PDF-1.4", w.Body.String())
+	})
+
+	t.Run("TamperedSignature", func(t *testing.T) {
+		downloadURL, err := backend.GetPresignedURL("uploads/1/other.jpg", time.Hour)
+		require.NoError(t, err)
+
+		req, _ := http.NewRequest("GET", strings.Replace(requestPath(t, downloadURL), "signature=", "signature=0", 1), nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("MissingFile", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/files/uploads/9/missing.jpg", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusNotFound, w.Code)
+	})
+}
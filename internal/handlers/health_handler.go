@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"mowsy-api/pkg/auth"
+	"mowsy-api/pkg/database"
+	"mowsy-api/pkg/storage"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+const (
+	checkOK   = "ok"
+	checkFail = "fail"
+)
+
+type HealthCheck struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type HealthResponse struct {
+	Status    string                 `json:"status"`
+	Checks    map[string]HealthCheck `json:"checks,omitempty"`
+	Timestamp int64                  `json:"timestamp"`
+}
+
+type HealthHandler struct {
+	db          func() *sql.DB
+	config      func() error
+	pingTimeout time.Duration
+}
+
+func NewHealthHandler(db *gorm.DB) *HealthHandler {
+	return &HealthHandler{
+		db:          func() *sql.DB { return database.SQLDB(db) },
+		config:      checkConfig,
+		pingTimeout: 2 * time.Second,
+	}
+}
+
+// Live reports that the process is up and serving. It checks nothing else,
+// so a database outage does not get healthy instances restarted.
+func (h *HealthHandler) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, HealthResponse{
+		Status:    checkOK,
+		Timestamp: time.Now().Unix(),
+	})
+}
+
+// Ready reports whether the instance can serve traffic: the database answers
+// a ping and the required configuration is present.
+func (h *HealthHandler) Ready(c *gin.Context) {
+	checks := map[string]HealthCheck{
+		"database": result(h.pingDB(c.Request.Context())),
+		"config":   result(h.config()),
+	}
+
+	response := HealthResponse{
+		Status:    checkOK,
+		Checks:    checks,
+		Timestamp: time.Now().Unix(),
+	}
+	status := http.StatusOK
+	for _, check := range checks {
+		if check.Status != checkOK {
+			response.Status = checkFail
+			status = http.StatusServiceUnavailable
+		}
+	}
+
+	c.JSON(status, response)
+}
+
+func (h *HealthHandler) pingDB(ctx context.Context) error {
+	db := h.db()
+	if db == nil {
+		return errors.New("database not connected")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, h.pingTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		return errors.New("database ping failed")
+	}
+	return nil
+}
+
+func checkConfig() error {
+	if _, err := auth.SigningKeys(); err != nil {
+		return errors.New("JWT signing keys not configured")
+	}
+	if _, err := storage.Default(); err != nil {
+		return errors.New("storage backend not configured")
+	}
+	return nil
+}
+
+func result(err error) HealthCheck {
+	if err != nil {
+		return HealthCheck{Status: checkFail, Error: err.Error()}
+	}
+	return HealthCheck{Status: checkOK}
+}
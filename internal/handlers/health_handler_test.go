@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mowsy-api/internal/testutils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveHealth(t *testing.T, handler *HealthHandler, path string) (int, HealthResponse) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/health/live", handler.Live)
+	r.GET("/health/ready", handler.Ready)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var response HealthResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	return w.Code, response
+}
+
+func testSQLDB(t *testing.T) *sql.DB {
+	sqlDB, err := testutils.SetupTestDB().DB()
+	require.NoError(t, err)
+	return sqlDB
+}
+
+func TestHealthHandler_Ready(t *testing.T) {
+	sqlDB := testSQLDB(t)
+	handler := &HealthHandler{
+		db:          func() *sql.DB { return sqlDB },
+		config:      func() error { return nil },
+		pingTimeout: time.Second,
+	}
+
+	code, response := serveHealth(t, handler, "/health/ready")
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", response.Status)
+	assert.Equal(t, "ok", response.Checks["database"].Status)
+	assert.Equal(t, "ok", response.Checks["config"].Status)
+}
+
+func TestHealthHandler_ReadyWithoutDatabase(t *testing.T) {
+	handler := &HealthHandler{
+		db:          func() *sql.DB { return nil },
+		config:      func() error { return nil },
+		pingTimeout: time.Second,
+	}
+
+	code, response := serveHealth(t, handler, "/health/ready")
+
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "fail", response.Status)
+	assert.Equal(t, "fail", response.Checks["database"].Status)
+	assert.Equal(t, "ok", response.Checks["config"].Status)
+}
+
+func TestHealthHandler_ReadyWithClosedDatabase(t *testing.T) {
+	sqlDB := testSQLDB(t)
+	require.NoError(t, sqlDB.Close())
+	handler := &HealthHandler{
+		db:          func() *sql.DB { return sqlDB },
+		config:      func() error { return nil },
+		pingTimeout: time.Second,
+	}
+
+	code, response := serveHealth(t, handler, "/health/ready")
+
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "database ping failed", response.Checks["database"].Error)
+}
+
+func TestHealthHandler_ReadyWithBadConfig(t *testing.T) {
+	sqlDB := testSQLDB(t)
+	handler := &HealthHandler{
+		db:          func() *sql.DB { return sqlDB },
+		config:      func() error { return errors.New("JWT signing keys not configured") },
+		pingTimeout: time.Second,
+	}
+
+	code, response := serveHealth(t, handler, "/health/ready")
+
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "JWT signing keys not configured", response.Checks["config"].Error)
+}
+
+func TestHealthHandler_LiveIgnoresDependencies(t *testing.T) {
+	handler := &HealthHandler{
+		db:     func() *sql.DB { return nil },
+		config: func() error { return errors.New("JWT signing keys not configured") },
+	}
+
+	code, response := serveHealth(t, handler, "/health/live")
+
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ok", response.Status)
+}
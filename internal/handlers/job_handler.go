@@ -15,9 +15,9 @@ type JobHandler struct {
 	jobService *services.JobService
 }
 
-func NewJobHandler() *JobHandler {
+func NewJobHandler(jobs *services.JobService) *JobHandler {
 	return &JobHandler{
-		jobService: services.NewJobService(),
+		jobService: jobs,
 	}
 }
 
@@ -58,7 +58,7 @@ func (h *JobHandler) CreateJob(c *gin.Context) {
 		return
 	}
 
-	job, err := h.jobService.CreateJob(userID.(uint), req)
+	job, err := h.jobService.CreateJob(c.Request.Context(), userID.(uint), req)
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
 		return
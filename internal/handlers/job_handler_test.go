@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/services"
+	"mowsy-api/internal/tasks"
+	"mowsy-api/internal/testutils"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobHandler_CreateJobIsGeocodedInBackground(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := newTestContainer(t, map[string]services.GeocodeResult{
+		"42 Elm St, Springfield": {Latitude: 39.78, Longitude: -89.65, ZipCode: "62701"},
+	})
+	user := testutils.CreateTestUser(c.DB)
+
+	handler := NewJobHandler(c.Jobs)
+	r := gin.New()
+	r.POST("/jobs", func(ctx *gin.Context) { ctx.Set("user_id", user.ID) }, handler.CreateJob)
+	r.GET("/jobs/:id", handler.GetJobByID)
+
+	body, err := json.Marshal(services.CreateJobRequest{
+		Title:      "Mow the back lawn",
+		Category:   models.JobCategoryMowing,
+		FixedPrice: 40,
+		Address:    "42 Elm St, Springfield",
+		Visibility: models.VisibilityZipCode,
+	})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/jobs", bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	var created models.JobResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, user.ZipCode, created.ZipCode, "starts from the poster's zip code")
+
+	queue := tasks.NewQueue(c.DB)
+	c.RegisterTaskHandlers(queue)
+	_, err = queue.Drain(context.Background(), 10)
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/jobs/%d", created.ID), nil)
+	r.ServeHTTP(w, req)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	var fetched models.JobResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &fetched))
+	assert.Equal(t, "62701", fetched.ZipCode)
+}
@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"mowsy-api/internal/services"
+	"mowsy-api/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MessageHandler struct {
+	messageService *services.MessageService
+	uploadService  *services.UploadService
+}
+
+// NewMessageHandler takes a nil uploads service when storage is not
+// configured; messaging keeps working without attachments.
+func NewMessageHandler(messages *services.MessageService, uploads *services.UploadService) *MessageHandler {
+	return &MessageHandler{
+		messageService: messages,
+		uploadService:  uploads,
+	}
+}
+
+// GetConversations godoc
+// @Summary List conversations
+// @Description List the current user's conversations with unread message counts
+// @Tags messages
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.ConversationResponse "Conversations retrieved successfully"
+// @Failure 401 {object} utils.ErrorResponseModel "User not authenticated"
+// @Failure 500 {object} utils.ErrorResponseModel "Internal server error"
+// @Router /conversations [get]
+func (h *MessageHandler) GetConversations(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	conversations, err := h.messageService.GetConversations(userID.(uint))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, conversations)
+}
+
+// StartConversation godoc
+// @Summary Start or open a conversation
+// @Description Get the conversation for a job application or equipment rental, creating it if needed
+// @Tags messages
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param conversation body services.StartConversationRequest true "Booking to converse about"
+// @Success 200 {object} models.ConversationResponse "Conversation retrieved successfully"
+// @Failure 400 {object} utils.ErrorResponseModel "Invalid request body"
+// @Failure 403 {object} utils.ErrorResponseModel "Not a participant in the booking"
+// @Router /conversations [post]
+func (h *MessageHandler) StartConversation(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req services.StartConversationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	conversation, err := h.messageService.StartConversation(userID.(uint), req)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusForbidden, err.Error())
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, conversation)
+}
+
+// GetMessages godoc
+// @Summary Get conversation messages
+// @Description Get messages in a conversation, newest first, and mark them as read
+// @Tags messages
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Conversation ID"
+// @Param page query int false "Page number (default: 1)"
+// @Param limit query int false "Items per page (default: 50, max: 100)"
+// @Success 200 {array} models.MessageResponse "Messages retrieved successfully"
+// @Failure 404 {object} utils.ErrorResponseModel "Conversation not found"
+// @Router /conversations/{id}/messages [get]
+func (h *MessageHandler) GetMessages(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	conversationIDStr := c.Param("id")
+	conversationID, err := strconv.ParseUint(conversationIDStr, 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid conversation ID")
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+	messages, err := h.messageService.GetMessages(uint(conversationID), userID.(uint), page, limit)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, err.Error())
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, messages)
+}
+
+// SendMessage godoc
+// @Summary Send a message
+// @Description Send a message with up to 5 attachments. Accepts JSON with the keys of message_attachment uploads, or multipart form data with a "body" field and "attachments" files. Contact details are hidden until the booking is confirmed.
+// @Tags messages
+// @Accept json,mpfd
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Conversation ID"
+// @Param message body services.SendMessageRequest false "Message content"
+// @Success 201 {object} models.MessageResponse "Message sent successfully"
+// @Failure 400 {object} utils.ErrorResponseModel "Invalid message"
+// @Router /conversations/{id}/messages [post]
+func (h *MessageHandler) SendMessage(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	conversationIDStr := c.Param("id")
+	conversationID, err := strconv.ParseUint(conversationIDStr, 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid conversation ID")
+		return
+	}
+
+	var req services.SendMessageRequest
+	if c.ContentType() == "multipart/form-data" {
+		form, err := c.MultipartForm()
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid multipart form")
+			return
+		}
+
+		req.Body = c.PostForm("body")
+		files := form.File["attachments"]
+		if len(files) > services.MaxMessageAttachments {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Too many attachments")
+			return
+		}
+
+		if len(files) > 0 && h.uploadService == nil {
+			utils.ErrorResponse(c, http.StatusServiceUnavailable, "Attachments are not available")
+			return
+		}
+
+		for _, file := range files {
+			upload, err := h.uploadService.UploadImage(userID.(uint), file, services.UploadCategoryMessageAttachment)
+			if err != nil {
+				utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			req.Attachments = append(req.Attachments, upload.Key)
+		}
+	} else if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	message, err := h.messageService.SendMessage(uint(conversationID), userID.(uint), req)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.DataResponse(c, http.StatusCreated, message)
+}
+
+// MarkConversationRead godoc
+// @Summary Mark conversation as read
+// @Description Mark all messages from the other participant as read
+// @Tags messages
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Conversation ID"
+// @Success 200 {object} utils.SuccessResponseModel "Conversation marked as read"
+// @Failure 404 {object} utils.ErrorResponseModel "Conversation not found"
+// @Router /conversations/{id}/read [put]
+func (h *MessageHandler) MarkConversationRead(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	conversationIDStr := c.Param("id")
+	conversationID, err := strconv.ParseUint(conversationIDStr, 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid conversation ID")
+		return
+	}
+
+	if err := h.messageService.MarkConversationRead(uint(conversationID), userID.(uint)); err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Conversation marked as read", nil)
+}
+
+func (h *MessageHandler) GetConversationMessagesAdmin(c *gin.Context) {
+	conversationIDStr := c.Param("id")
+	conversationID, err := strconv.ParseUint(conversationIDStr, 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid conversation ID")
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+
+	messages, err := h.messageService.GetMessagesAsAdmin(uint(conversationID), page, limit)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusNotFound, err.Error())
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, messages)
+}
@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"mowsy-api/internal/services"
+	"mowsy-api/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MFAHandler struct {
+	userService *services.UserService
+}
+
+func NewMFAHandler(users *services.UserService) *MFAHandler {
+	return &MFAHandler{
+		userService: users,
+	}
+}
+
+// mfaErrorStatus maps 2FA errors to a status; anything else is a server error.
+func mfaErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, services.ErrInvalidMFACode):
+		return http.StatusUnauthorized
+	case errors.Is(err, services.ErrMFAAlreadyEnabled),
+		errors.Is(err, services.ErrMFANotEnabled),
+		errors.Is(err, services.ErrMFAEnrollmentNotStarted):
+		return http.StatusConflict
+	case errors.Is(err, services.ErrMFARequiredForRole):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// VerifyMFA godoc
+// @Summary Complete two-factor sign-in
+// @Description Exchange the mfa_token from login and a TOTP or recovery code for tokens. For an enrollment challenge, the code must come from the newly enrolled authenticator and the response includes recovery codes.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param verification body services.MFAVerifyRequest true "MFA token and code"
+// @Success 200 {object} services.LoginResponse "User logged in successfully"
+// @Failure 400 {object} utils.ErrorResponseModel "Invalid request body"
+// @Failure 401 {object} utils.ErrorResponseModel "Invalid code or expired MFA token"
+// @Failure 423 {object} utils.ErrorResponseModel "Account locked after too many failed attempts"
+// @Failure 429 {object} utils.ErrorResponseModel "Too many failed attempts; retry after the Retry-After header"
+// @Router /auth/mfa/verify [post]
+func (h *MFAHandler) VerifyMFA(c *gin.Context) {
+	var req services.MFAVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	response, err := h.userService.VerifyMFA(req, c.ClientIP())
+	if err != nil {
+		if errors.Is(err, services.ErrMFAEnrollmentNotStarted) {
+			utils.ErrorResponse(c, http.StatusConflict, err.Error())
+			return
+		}
+		signInErrorResponse(c, err)
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, response)
+}
+
+// MFAEnrollChallengeRequest represents the request body for enrolling during sign-in
+type MFAEnrollChallengeRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+}
+
+// EnrollWithChallenge godoc
+// @Summary Enroll in two-factor authentication during sign-in
+// @Description Start enrollment for a user whose login returned enrollment_required. Show provisioning_uri as a QR code, then finish with /auth/mfa/verify.
+// @Tags auth
+// @Accept json
+// @Produce json
+// @Param token body MFAEnrollChallengeRequest true "MFA token from login"
+// @Success 200 {object} services.MFAEnrollment "TOTP secret and provisioning URI"
+// @Failure 400 {object} utils.ErrorResponseModel "Invalid request body"
+// @Failure 401 {object} utils.ErrorResponseModel "Invalid or expired MFA token"
+// @Router /auth/mfa/enroll [post]
+func (h *MFAHandler) EnrollWithChallenge(c *gin.Context) {
+	var req MFAEnrollChallengeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	enrollment, err := h.userService.BeginMFAEnrollmentWithChallenge(req.MFAToken)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidMFAToken) {
+			utils.ErrorResponse(c, http.StatusUnauthorized, err.Error())
+			return
+		}
+		utils.ErrorResponse(c, mfaErrorStatus(err), err.Error())
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, enrollment)
+}
+
+// Enroll godoc
+// @Summary Start two-factor enrollment
+// @Description Generate a TOTP secret for the current user. Show provisioning_uri as a QR code, then confirm with a code from the authenticator app.
+// @Tags mfa
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} services.MFAEnrollment "TOTP secret and provisioning URI"
+// @Failure 401 {object} utils.ErrorResponseModel "User not authenticated"
+// @Failure 409 {object} utils.ErrorResponseModel "Two-factor authentication is already enabled"
+// @Router /users/me/mfa/enroll [post]
+func (h *MFAHandler) Enroll(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	enrollment, err := h.userService.BeginMFAEnrollment(userID.(uint))
+	if err != nil {
+		utils.ErrorResponse(c, mfaErrorStatus(err), err.Error())
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, enrollment)
+}
+
+// Confirm godoc
+// @Summary Confirm two-factor enrollment
+// @Description Turn on two-factor authentication with a code from the newly enrolled authenticator. The recovery codes are shown only once.
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param code body services.MFACodeRequest true "TOTP code"
+// @Success 200 {object} services.RecoveryCodesResponse "Two-factor authentication enabled"
+// @Failure 400 {object} utils.ErrorResponseModel "Invalid request body"
+// @Failure 401 {object} utils.ErrorResponseModel "User not authenticated or invalid code"
+// @Failure 409 {object} utils.ErrorResponseModel "Enrollment not started or already enabled"
+// @Router /users/me/mfa/confirm [post]
+func (h *MFAHandler) Confirm(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req services.MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	codes, err := h.userService.ConfirmMFAEnrollment(userID.(uint), req.Code)
+	if err != nil {
+		utils.ErrorResponse(c, mfaErrorStatus(err), err.Error())
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, services.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// Disable godoc
+// @Summary Turn off two-factor authentication
+// @Description Turn off two-factor authentication with a current TOTP or recovery code. Not allowed for roles that require it.
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param code body services.MFACodeRequest true "TOTP or recovery code"
+// @Success 200 {object} utils.SuccessResponseModel "Two-factor authentication disabled"
+// @Failure 400 {object} utils.ErrorResponseModel "Invalid request body"
+// @Failure 401 {object} utils.ErrorResponseModel "User not authenticated or invalid code"
+// @Failure 403 {object} utils.ErrorResponseModel "Two-factor authentication is required for this account"
+// @Failure 409 {object} utils.ErrorResponseModel "Two-factor authentication is not enabled"
+// @Router /users/me/mfa [delete]
+func (h *MFAHandler) Disable(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req services.MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	if err := h.userService.DisableMFA(userID.(uint), req.Code); err != nil {
+		utils.ErrorResponse(c, mfaErrorStatus(err), err.Error())
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Two-factor authentication disabled", nil)
+}
+
+// RegenerateRecoveryCodes godoc
+// @Summary Regenerate recovery codes
+// @Description Replace all recovery codes after checking a current TOTP or recovery code. The new codes are shown only once.
+// @Tags mfa
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param code body services.MFACodeRequest true "TOTP or recovery code"
+// @Success 200 {object} services.RecoveryCodesResponse "New recovery codes"
+// @Failure 400 {object} utils.ErrorResponseModel "Invalid request body"
+// @Failure 401 {object} utils.ErrorResponseModel "User not authenticated or invalid code"
+// @Failure 409 {object} utils.ErrorResponseModel "Two-factor authentication is not enabled"
+// @Router /users/me/mfa/recovery-codes [post]
+func (h *MFAHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req services.MFACodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	codes, err := h.userService.RegenerateRecoveryCodes(userID.(uint), req.Code)
+	if err != nil {
+		utils.ErrorResponse(c, mfaErrorStatus(err), err.Error())
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, services.RecoveryCodesResponse{RecoveryCodes: codes})
+}
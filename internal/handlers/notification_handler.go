@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"net/http"
+
+	"mowsy-api/internal/services"
+	"mowsy-api/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type NotificationHandler struct {
+	notificationService *services.NotificationService
+}
+
+func NewNotificationHandler(notifications *services.NotificationService) *NotificationHandler {
+	return &NotificationHandler{
+		notificationService: notifications,
+	}
+}
+
+// GetPreferences godoc
+// @Summary Get notification preferences
+// @Description Get the current user's notification channels and quiet hours
+// @Tags notifications
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} models.NotificationPreference "Notification preferences"
+// @Failure 401 {object} utils.ErrorResponseModel "User not authenticated"
+// @Failure 500 {object} utils.ErrorResponseModel "Internal server error"
+// @Router /users/me/notification-preferences [get]
+func (h *NotificationHandler) GetPreferences(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	prefs, err := h.notificationService.GetPreferences(userID.(uint))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, prefs)
+}
+
+// UpdatePreferences godoc
+// @Summary Update notification preferences
+// @Description Enable or disable email, SMS and push notifications, register a push token, and set quiet hours (HH:MM in the given IANA timezone). SMS and push are held until quiet hours end.
+// @Tags notifications
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param preferences body services.UpdateNotificationPreferencesRequest true "Preference changes"
+// @Success 200 {object} models.NotificationPreference "Notification preferences updated"
+// @Failure 400 {object} utils.ErrorResponseModel "Invalid request body or validation error"
+// @Failure 401 {object} utils.ErrorResponseModel "User not authenticated"
+// @Router /users/me/notification-preferences [put]
+func (h *NotificationHandler) UpdatePreferences(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req services.UpdateNotificationPreferencesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	prefs, err := h.notificationService.UpdatePreferences(userID.(uint), req)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, prefs)
+}
@@ -14,9 +14,9 @@ type PaymentHandler struct {
 	paymentService *services.PaymentService
 }
 
-func NewPaymentHandler() *PaymentHandler {
+func NewPaymentHandler(payments *services.PaymentService) *PaymentHandler {
 	return &PaymentHandler{
-		paymentService: services.NewPaymentService(),
+		paymentService: payments,
 	}
 }
 
@@ -33,7 +33,7 @@ func (h *PaymentHandler) CreatePaymentIntent(c *gin.Context) {
 		return
 	}
 
-	response, err := h.paymentService.CreatePaymentIntent(userID.(uint), req)
+	response, err := h.paymentService.CreatePaymentIntent(c.Request.Context(), userID.(uint), req)
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
 		return
@@ -58,7 +58,7 @@ func (h *PaymentHandler) ConfirmPayment(c *gin.Context) {
 		return
 	}
 
-	response, err := h.paymentService.ConfirmPayment(req.PaymentID, userID.(uint))
+	response, err := h.paymentService.ConfirmPayment(c.Request.Context(), req.PaymentID, userID.(uint))
 	if err != nil {
 		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
 		return
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/services"
+	"mowsy-api/internal/tasks"
+	"mowsy-api/internal/testutils"
+	"mowsy-api/pkg/payments"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRentalRouter serves the rental and payment endpoints, acting as the user
+// named in the X-User-ID header.
+func newRentalRouter(c *services.Container) *gin.Engine {
+	equipment := NewEquipmentHandler(c.Equipment)
+	payment := NewPaymentHandler(c.Payments)
+
+	r := gin.New()
+	r.Use(func(ctx *gin.Context) {
+		id, _ := strconv.ParseUint(ctx.GetHeader("X-User-ID"), 10, 32)
+		ctx.Set("user_id", uint(id))
+	})
+	r.POST("/equipment/:id/rent", equipment.RequestRental)
+	r.PUT("/equipment/:id/rentals/:rental_id", equipment.UpdateRentalStatus)
+	r.POST("/equipment/rentals/:rental_id/complete", equipment.CompleteRental)
+	r.POST("/payments/create-intent", payment.CreatePaymentIntent)
+	r.POST("/payments/confirm", payment.ConfirmPayment)
+	r.GET("/payments/:id", payment.GetPaymentByID)
+	return r
+}
+
+func serveAs(t *testing.T, r *gin.Engine, userID uint, method, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		require.NoError(t, json.NewEncoder(&payload).Encode(body))
+	}
+
+	w := httptest.NewRecorder()
+	req, _ := http.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User-ID", strconv.FormatUint(uint64(userID), 10))
+	r.ServeHTTP(w, req)
+	return w
+}
+
+func TestPaymentHandler_RentalLifecycle(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := newTestContainer(t, nil)
+	gateway := c.Dependencies.Payments.(*payments.Fake)
+	r := newRentalRouter(c)
+	queue := tasks.NewQueue(c.DB)
+	c.RegisterTaskHandlers(queue)
+
+	owner := testutils.CreateTestUser(c.DB)
+	require.NoError(t, c.Admin.SetPayoutAccount(owner.ID, "acct_owner"))
+	renter := &models.User{Email: "renter@example.com", FirstName: "Renter", LastName: "Test", IsActive: true}
+	require.NoError(t, c.DB.Create(renter).Error)
+	equipment := testutils.CreateTestEquipment(c.DB, owner.ID)
+
+	// The renter asks, the owner approves
+	start := time.Now().Add(24 * time.Hour)
+	w := serveAs(t, r, renter.ID, "POST", fmt.Sprintf("/equipment/%d/rent", equipment.ID), gin.H{
+		"start_date": start, "end_date": start.Add(48 * time.Hour),
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var rental models.EquipmentRentalResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &rental))
+
+	w = serveAs(t, r, owner.ID, "PUT", fmt.Sprintf("/equipment/%d/rentals/%d", equipment.ID, rental.ID), gin.H{"status": models.RentalStatusApproved})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	// The renter pays, first with a card that needs 3DS
+	w = serveAs(t, r, renter.ID, "POST", "/payments/create-intent", services.CreatePaymentIntentRequest{
+		Amount: rental.TotalPrice, Type: models.PaymentTypeEquipmentRental, RelatedID: rental.ID,
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var intent services.PaymentIntentResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &intent))
+
+	var payment models.Payment
+	require.NoError(t, c.DB.First(&payment, intent.PaymentID).Error)
+	_, err := gateway.Confirm(payment.StripePaymentIntentID, payments.TestCardRequires3DS)
+	require.NoError(t, err)
+
+	confirm := func() models.PaymentResponse {
+		t.Helper()
+		w := serveAs(t, r, renter.ID, "POST", "/payments/confirm", gin.H{"payment_id": intent.PaymentID})
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var confirmed models.PaymentResponse
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &confirmed))
+		return confirmed
+	}
+	assert.Equal(t, models.PaymentStatusPending, confirm().Status)
+
+	_, err = gateway.Authenticate(payment.StripePaymentIntentID, true)
+	require.NoError(t, err)
+	assert.Equal(t, models.PaymentStatusAuthorized, confirm().Status)
+
+	// Someone else cannot see the payment
+	w = serveAs(t, r, owner.ID, "GET", fmt.Sprintf("/payments/%d", intent.PaymentID), nil)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Returning the equipment charges the renter and pays the owner
+	w = serveAs(t, r, renter.ID, "POST", fmt.Sprintf("/equipment/rentals/%d/complete", rental.ID), gin.H{"return_notes": "All good"})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	_, err = queue.Drain(context.Background(), 10)
+	require.NoError(t, err)
+
+	w = serveAs(t, r, renter.ID, "GET", fmt.Sprintf("/payments/%d", intent.PaymentID), nil)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var settled models.PaymentResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &settled))
+	assert.Equal(t, models.PaymentStatusSucceeded, settled.Status)
+
+	transfers := gateway.Transfers()
+	require.Len(t, transfers, 1)
+	assert.Equal(t, "acct_owner", transfers[0].DestinationID)
+
+	// A finished rental can no longer be cancelled
+	w = serveAs(t, r, owner.ID, "PUT", fmt.Sprintf("/equipment/%d/rentals/%d", equipment.ID, rental.ID), gin.H{"status": models.RentalStatusCancelled})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPaymentHandler_DeclinedCard(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c := newTestContainer(t, nil)
+	gateway := c.Dependencies.Payments.(*payments.Fake)
+	r := newRentalRouter(c)
+
+	owner := testutils.CreateTestUser(c.DB)
+	renter := &models.User{Email: "renter@example.com", FirstName: "Renter", LastName: "Test", IsActive: true}
+	require.NoError(t, c.DB.Create(renter).Error)
+	equipment := testutils.CreateTestEquipment(c.DB, owner.ID)
+
+	start := time.Now().Add(24 * time.Hour)
+	rental, err := c.Equipment.RequestRental(equipment.ID, renter.ID, start, start.Add(24*time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, c.Equipment.UpdateRentalStatus(equipment.ID, rental.ID, owner.ID, models.RentalStatusApproved))
+
+	w := serveAs(t, r, renter.ID, "POST", "/payments/create-intent", services.CreatePaymentIntentRequest{
+		Amount: rental.TotalPrice, Type: models.PaymentTypeEquipmentRental, RelatedID: rental.ID,
+	})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var intent services.PaymentIntentResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &intent))
+
+	var payment models.Payment
+	require.NoError(t, c.DB.First(&payment, intent.PaymentID).Error)
+	_, err = gateway.Confirm(payment.StripePaymentIntentID, payments.TestCardDeclined)
+	require.NoError(t, err)
+
+	w = serveAs(t, r, renter.ID, "POST", "/payments/confirm", gin.H{"payment_id": intent.PaymentID})
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var failed models.PaymentResponse
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &failed))
+	assert.Equal(t, models.PaymentStatusFailed, failed.Status)
+	assert.Equal(t, "Your card was declined.", failed.FailureReason)
+
+	var stored models.EquipmentRental
+	require.NoError(t, c.DB.First(&stored, rental.ID).Error)
+	assert.Equal(t, models.RentalStatusApproved, stored.Status, "the rental waits for a working card")
+}
@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"mowsy-api/internal/services"
+	"mowsy-api/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type PrivacyHandler struct {
+	privacyService *services.PrivacyService
+}
+
+func NewPrivacyHandler(privacy *services.PrivacyService) *PrivacyHandler {
+	return &PrivacyHandler{
+		privacyService: privacy,
+	}
+}
+
+func (h *PrivacyHandler) available(c *gin.Context) bool {
+	if h.privacyService == nil {
+		utils.ErrorResponse(c, http.StatusServiceUnavailable, "Data requests are not available")
+		return false
+	}
+	return true
+}
+
+// ExportData godoc
+// @Summary Export my data
+// @Description Start building a ZIP of the current user's profile, listings, rentals, reviews, payments and files. Poll the returned request for a download URL.
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Success 202 {object} services.DataRequestResponse "Export queued"
+// @Failure 401 {object} utils.ErrorResponseModel "User not authenticated"
+// @Router /users/me/export [get]
+func (h *PrivacyHandler) ExportData(c *gin.Context) {
+	if !h.available(c) {
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	response, err := h.privacyService.RequestExport(userID.(uint))
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.DataResponse(c, http.StatusAccepted, response)
+}
+
+// DeleteAccount godoc
+// @Summary Delete my account
+// @Description Queue the erasure of the current user's personal data and files. Payment records are kept anonymized.
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Success 202 {object} services.DataRequestResponse "Erasure queued"
+// @Failure 401 {object} utils.ErrorResponseModel "User not authenticated"
+// @Failure 409 {object} utils.ErrorResponseModel "Jobs in progress or active rentals"
+// @Router /users/me [delete]
+func (h *PrivacyHandler) DeleteAccount(c *gin.Context) {
+	if !h.available(c) {
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	response, err := h.privacyService.RequestErasure(userID.(uint))
+	if err != nil {
+		if errors.Is(err, services.ErrAccountHasActiveWork) {
+			utils.ErrorResponse(c, http.StatusConflict, err.Error())
+			return
+		}
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	utils.DataResponse(c, http.StatusAccepted, response)
+}
+
+// GetDataRequest godoc
+// @Summary Get data request status
+// @Description Get the status of an export or erasure. Completed exports include a short-lived download URL.
+// @Tags users
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Data request ID"
+// @Success 200 {object} services.DataRequestResponse "Data request retrieved successfully"
+// @Failure 400 {object} utils.ErrorResponseModel "Invalid data request ID"
+// @Failure 404 {object} utils.ErrorResponseModel "Data request not found"
+// @Router /users/me/data-requests/{id} [get]
+func (h *PrivacyHandler) GetDataRequest(c *gin.Context) {
+	if !h.available(c) {
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	requestID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid data request ID")
+		return
+	}
+
+	response, err := h.privacyService.GetDataRequest(userID.(uint), uint(requestID))
+	if err != nil {
+		if errors.Is(err, services.ErrDataRequestNotFound) {
+			utils.ErrorResponse(c, http.StatusNotFound, err.Error())
+			return
+		}
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, response)
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"testing"
+
+	"mowsy-api/internal/events"
+	"mowsy-api/internal/services"
+	"mowsy-api/internal/testutils"
+	"mowsy-api/pkg/payments"
+)
+
+// newTestContainer wires the services to a fresh SQLite database, a geocoder
+// that only knows the given addresses, the in-memory payment gateway and a
+// private event bus.
+func newTestContainer(t *testing.T, addresses map[string]services.GeocodeResult) *services.Container {
+	t.Helper()
+	db := testutils.SetupTestDB()
+	t.Cleanup(func() { testutils.CleanupTestDB(db) })
+
+	return services.NewContainer(services.Dependencies{
+		DB:       db,
+		Geocoder: services.NewStaticGeocoder(addresses),
+		Payments: payments.NewFake(),
+		Bus:      events.NewBus(),
+	})
+}
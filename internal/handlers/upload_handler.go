@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
 	"mowsy-api/internal/services"
 	"mowsy-api/internal/utils"
@@ -13,18 +16,25 @@ type UploadHandler struct {
 	uploadService *services.UploadService
 }
 
-func NewUploadHandler() *UploadHandler {
-	uploadService, err := services.NewUploadService()
-	if err != nil {
-		panic("Failed to initialize upload service: " + err.Error())
+func NewUploadHandler(uploads *services.UploadService) *UploadHandler {
+	return &UploadHandler{
+		uploadService: uploads,
 	}
+}
 
-	return &UploadHandler{
-		uploadService: uploadService,
+func (h *UploadHandler) available(c *gin.Context) bool {
+	if h.uploadService == nil {
+		utils.ErrorResponse(c, http.StatusServiceUnavailable, "File uploads are not available")
+		return false
 	}
+	return true
 }
 
 func (h *UploadHandler) UploadImage(c *gin.Context) {
+	if !h.available(c) {
+		return
+	}
+
 	userID, exists := c.Get("user_id")
 	if !exists {
 		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
@@ -49,6 +59,10 @@ func (h *UploadHandler) UploadImage(c *gin.Context) {
 }
 
 func (h *UploadHandler) GetPresignedUploadURL(c *gin.Context) {
+	if !h.available(c) {
+		return
+	}
+
 	userID, exists := c.Get("user_id")
 	if !exists {
 		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
@@ -58,6 +72,7 @@ func (h *UploadHandler) GetPresignedUploadURL(c *gin.Context) {
 	var req struct {
 		FileName string `json:"file_name" binding:"required"`
 		MimeType string `json:"mime_type" binding:"required"`
+		Category string `json:"category"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -65,24 +80,142 @@ func (h *UploadHandler) GetPresignedUploadURL(c *gin.Context) {
 		return
 	}
 
-	url, err := h.uploadService.GetPresignedUploadURL(userID.(uint), req.FileName, req.MimeType)
+	response, err := h.uploadService.GetPresignedUploadURL(userID.(uint), req.FileName, req.MimeType, req.Category)
 	if err != nil {
+		if errors.Is(err, services.ErrInvalidUploadCategory) || errors.Is(err, services.ErrUnsupportedFile) {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
 		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	utils.DataResponse(c, http.StatusOK, gin.H{
-		"upload_url": url,
-	})
+	utils.DataResponse(c, http.StatusOK, response)
+}
+
+// CreateUploadSession starts a direct upload. The client PUTs the file to
+// the returned URL with the returned headers, then calls
+// CompleteUploadSession.
+func (h *UploadHandler) CreateUploadSession(c *gin.Context) {
+	if !h.available(c) {
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	var req services.CreateUploadSessionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	session, err := h.uploadService.CreateUploadSession(userID.(uint), req)
+	if err != nil {
+		h.uploadSessionError(c, err)
+		return
+	}
+
+	utils.DataResponse(c, http.StatusCreated, session)
+}
+
+func (h *UploadHandler) GetUploadSession(c *gin.Context) {
+	if !h.available(c) {
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	sessionID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid upload session ID")
+		return
+	}
+
+	session, err := h.uploadService.GetUploadSession(userID.(uint), uint(sessionID))
+	if err != nil {
+		h.uploadSessionError(c, err)
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, session)
+}
+
+func (h *UploadHandler) CompleteUploadSession(c *gin.Context) {
+	if !h.available(c) {
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	sessionID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid upload session ID")
+		return
+	}
+
+	session, err := h.uploadService.CompleteUploadSession(userID.(uint), uint(sessionID))
+	if err != nil {
+		h.uploadSessionError(c, err)
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, session)
+}
+
+func (h *UploadHandler) uploadSessionError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrUploadSessionNotFound):
+		utils.ErrorResponse(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrUploadSessionClosed):
+		utils.ErrorResponse(c, http.StatusGone, err.Error())
+	case errors.Is(err, services.ErrUploadNotReceived):
+		utils.ErrorResponse(c, http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrFileTooLarge):
+		utils.ErrorResponse(c, http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, services.ErrUploadSizeMismatch), errors.Is(err, services.ErrUnsupportedFile):
+		utils.ErrorResponse(c, http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, services.ErrInvalidUploadCategory), errors.Is(err, services.ErrInvalidUploadSize):
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+	default:
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+	}
 }
 
 func (h *UploadHandler) DeleteFile(c *gin.Context) {
-	_, exists := c.Get("user_id")
+	if !h.available(c) {
+		return
+	}
+
+	userID, exists := c.Get("user_id")
 	if !exists {
 		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
 		return
 	}
 
+	h.deleteFile(c, userID.(uint), false)
+}
+
+func (h *UploadHandler) DeleteFileAdmin(c *gin.Context) {
+	if !h.available(c) {
+		return
+	}
+
+	h.deleteFile(c, 0, true)
+}
+
+func (h *UploadHandler) deleteFile(c *gin.Context, userID uint, isAdmin bool) {
 	var req struct {
 		Key string `json:"key" binding:"required"`
 	}
@@ -92,11 +225,116 @@ func (h *UploadHandler) DeleteFile(c *gin.Context) {
 		return
 	}
 
-	err := h.uploadService.DeleteFile(req.Key)
+	err := h.uploadService.DeleteFile(req.Key, userID, isAdmin)
 	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		switch {
+		case errors.Is(err, services.ErrFileAccessDenied):
+			utils.ErrorResponse(c, http.StatusForbidden, err.Error())
+		case errors.Is(err, services.ErrFileInUse):
+			utils.ErrorResponse(c, http.StatusConflict, err.Error())
+		case errors.Is(err, services.ErrFileNotFound):
+			utils.ErrorResponse(c, http.StatusNotFound, err.Error())
+		default:
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		}
 		return
 	}
 
 	utils.SuccessResponse(c, http.StatusOK, "File deleted successfully", nil)
-}
\ No newline at end of file
+}
+
+// GetFileURL returns a short-lived download URL for one of the current
+// user's files. Private files belonging to other users are refused.
+func (h *UploadHandler) GetFileURL(c *gin.Context) {
+	if !h.available(c) {
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	key := c.Query("key")
+	if key == "" {
+		utils.ErrorResponse(c, http.StatusBadRequest, "File key is required")
+		return
+	}
+
+	url, err := h.uploadService.GetFileURL(key, userID.(uint), false)
+	if err != nil {
+		if errors.Is(err, services.ErrFileAccessDenied) {
+			utils.ErrorResponse(c, http.StatusForbidden, err.Error())
+			return
+		}
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, gin.H{
+		"url": url,
+	})
+}
+
+func (h *UploadHandler) GetFileURLAdmin(c *gin.Context) {
+	if !h.available(c) {
+		return
+	}
+
+	key := c.Query("key")
+	if key == "" {
+		utils.ErrorResponse(c, http.StatusBadRequest, "File key is required")
+		return
+	}
+
+	url, err := h.uploadService.GetFileURL(key, 0, true)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, gin.H{
+		"url": url,
+	})
+}
+
+// CollectGarbage reports orphaned uploads, and deletes them when dry_run is
+// false. grace_period is a Go duration such as "72h".
+func (h *UploadHandler) CollectGarbage(c *gin.Context) {
+	if !h.available(c) {
+		return
+	}
+
+	var req struct {
+		DryRun      *bool  `json:"dry_run"`
+		GracePeriod string `json:"grace_period"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+	}
+
+	opts := services.UploadGCOptions{DryRun: true}
+	if req.DryRun != nil {
+		opts.DryRun = *req.DryRun
+	}
+	if req.GracePeriod != "" {
+		grace, err := time.ParseDuration(req.GracePeriod)
+		if err != nil || grace <= 0 {
+			utils.ErrorResponse(c, http.StatusBadRequest, "Invalid grace period")
+			return
+		}
+		opts.GracePeriod = grace
+	}
+
+	report, err := h.uploadService.CollectGarbage(c.Request.Context(), opts)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, report)
+}
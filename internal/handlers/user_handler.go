@@ -14,9 +14,9 @@ type UserHandler struct {
 	userService *services.UserService
 }
 
-func NewUserHandler() *UserHandler {
+func NewUserHandler(users *services.UserService) *UserHandler {
 	return &UserHandler{
-		userService: services.NewUserService(),
+		userService: users,
 	}
 }
 
@@ -137,9 +137,12 @@ func (h *UserHandler) GetUserPublicProfile(c *gin.Context) {
 	utils.DataResponse(c, http.StatusOK, profile)
 }
 
-// UploadInsuranceDocumentRequest represents the request body for insurance document upload
+// UploadInsuranceDocumentRequest represents the request body for insurance document upload.
+// DocumentKey is the key of a file uploaded with the "insurance" category; DocumentURL is
+// accepted for documents hosted elsewhere.
 type UploadInsuranceDocumentRequest struct {
-	DocumentURL string `json:"document_url" binding:"required"`
+	DocumentKey string `json:"document_key"`
+	DocumentURL string `json:"document_url"`
 }
 
 // UploadInsuranceDocument godoc
@@ -169,9 +172,22 @@ func (h *UserHandler) UploadInsuranceDocument(c *gin.Context) {
 		return
 	}
 
-	err := h.userService.UploadInsuranceDocument(userID.(uint), req.DocumentURL)
-	if err != nil {
-		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+	var err error
+	switch {
+	case req.DocumentKey != "":
+		err = h.userService.AttachInsuranceDocument(userID.(uint), req.DocumentKey)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	case req.DocumentURL != "":
+		err = h.userService.UploadInsuranceDocument(userID.(uint), req.DocumentURL)
+		if err != nil {
+			utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+	default:
+		utils.ErrorResponse(c, http.StatusBadRequest, "document_key or document_url is required")
 		return
 	}
 
@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"mowsy-api/internal/services"
+	"mowsy-api/internal/utils"
+
+	"github.com/gin-gonic/gin"
+)
+
+type WebhookHandler struct {
+	webhookService *services.WebhookService
+}
+
+func NewWebhookHandler(webhooks *services.WebhookService) *WebhookHandler {
+	return &WebhookHandler{
+		webhookService: webhooks,
+	}
+}
+
+func webhookErrorResponse(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidWebhookURL), errors.Is(err, services.ErrInvalidWebhookEvent),
+		errors.Is(err, services.ErrInvalidWebhookZipCode):
+		utils.ErrorResponse(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrTooManyWebhooks):
+		utils.ErrorResponse(c, http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrWebhookNotFound), errors.Is(err, services.ErrWebhookDeliveryNotFound):
+		utils.ErrorResponse(c, http.StatusNotFound, err.Error())
+	default:
+		utils.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// webhookOwner returns the signed-in user, or nil on admin routes, which
+// manage app endpoints. It writes the error response when it returns false.
+func webhookOwner(c *gin.Context, admin bool) (*uint, bool) {
+	if admin {
+		return nil, true
+	}
+	userID, exists := c.Get("user_id")
+	if !exists {
+		utils.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return nil, false
+	}
+	owner := userID.(uint)
+	return &owner, true
+}
+
+// CreateWebhook godoc
+// @Summary Register a webhook
+// @Description Register an https endpoint to be sent the chosen events: your own jobs, applications, rentals and payments, plus job.posted and equipment.listed for new listings, which zip_codes can narrow. Each request is signed in the Mowsy-Signature header. The signing secret is only returned in this response.
+// @Tags webhooks
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param webhook body services.CreateWebhookRequest true "Endpoint URL and filters"
+// @Success 201 {object} services.CreatedWebhook "Webhook registered"
+// @Failure 400 {object} utils.ErrorResponseModel "Invalid URL, event or zip code"
+// @Failure 401 {object} utils.ErrorResponseModel "User not authenticated"
+// @Failure 409 {object} utils.ErrorResponseModel "Too many webhooks"
+// @Router /users/me/webhooks [post]
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	owner, ok := webhookOwner(c, false)
+	if !ok {
+		return
+	}
+
+	var req services.CreateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	webhook, err := h.webhookService.CreateEndpoint(*owner, req)
+	if err != nil {
+		webhookErrorResponse(c, err)
+		return
+	}
+
+	utils.DataResponse(c, http.StatusCreated, webhook)
+}
+
+// GetWebhooks godoc
+// @Summary List webhooks
+// @Description List the current user's webhook endpoints
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {array} models.WebhookEndpoint "Webhooks"
+// @Failure 401 {object} utils.ErrorResponseModel "User not authenticated"
+// @Failure 500 {object} utils.ErrorResponseModel "Internal server error"
+// @Router /users/me/webhooks [get]
+func (h *WebhookHandler) GetWebhooks(c *gin.Context) {
+	h.getWebhooks(c, false)
+}
+
+// DeleteWebhook godoc
+// @Summary Delete a webhook
+// @Description Stop sending events to an endpoint and discard its delivery log
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Webhook ID"
+// @Success 200 {object} utils.SuccessResponseModel "Webhook deleted"
+// @Failure 400 {object} utils.ErrorResponseModel "Invalid webhook ID"
+// @Failure 401 {object} utils.ErrorResponseModel "User not authenticated"
+// @Failure 404 {object} utils.ErrorResponseModel "Webhook not found"
+// @Router /users/me/webhooks/{id} [delete]
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	h.deleteWebhook(c, false)
+}
+
+// GetDeliveries godoc
+// @Summary List webhook deliveries
+// @Description Page through the deliveries sent to an endpoint, newest first, with each one's status, attempts and the receiver's last response
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Webhook ID"
+// @Param page query int false "Page number" default(1)
+// @Param limit query int false "Items per page" default(20)
+// @Success 200 {array} models.WebhookDelivery "Deliveries"
+// @Failure 400 {object} utils.ErrorResponseModel "Invalid webhook ID"
+// @Failure 401 {object} utils.ErrorResponseModel "User not authenticated"
+// @Failure 404 {object} utils.ErrorResponseModel "Webhook not found"
+// @Router /users/me/webhooks/{id}/deliveries [get]
+func (h *WebhookHandler) GetDeliveries(c *gin.Context) {
+	h.getDeliveries(c, false)
+}
+
+// ReplayDelivery godoc
+// @Summary Replay a webhook delivery
+// @Description Send a logged event to the endpoint again. The replay is a new delivery with the same event ID, so receivers can recognise it.
+// @Tags webhooks
+// @Produce json
+// @Security BearerAuth
+// @Param id path int true "Webhook ID"
+// @Param delivery_id path int true "Delivery ID"
+// @Success 202 {object} models.WebhookDelivery "Replay queued"
+// @Failure 400 {object} utils.ErrorResponseModel "Invalid webhook or delivery ID"
+// @Failure 401 {object} utils.ErrorResponseModel "User not authenticated"
+// @Failure 404 {object} utils.ErrorResponseModel "Webhook or delivery not found"
+// @Router /users/me/webhooks/{id}/deliveries/{delivery_id}/replay [post]
+func (h *WebhookHandler) ReplayDelivery(c *gin.Context) {
+	h.replayDelivery(c, false)
+}
+
+func (h *WebhookHandler) CreateAppWebhook(c *gin.Context) {
+	var req services.CreateAppWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	webhook, err := h.webhookService.CreateAppEndpoint(req)
+	if err != nil {
+		webhookErrorResponse(c, err)
+		return
+	}
+
+	utils.DataResponse(c, http.StatusCreated, webhook)
+}
+
+func (h *WebhookHandler) GetAppWebhooks(c *gin.Context) {
+	h.getWebhooks(c, true)
+}
+
+func (h *WebhookHandler) DeleteAppWebhook(c *gin.Context) {
+	h.deleteWebhook(c, true)
+}
+
+func (h *WebhookHandler) GetAppDeliveries(c *gin.Context) {
+	h.getDeliveries(c, true)
+}
+
+func (h *WebhookHandler) ReplayAppDelivery(c *gin.Context) {
+	h.replayDelivery(c, true)
+}
+
+func (h *WebhookHandler) getWebhooks(c *gin.Context, admin bool) {
+	owner, ok := webhookOwner(c, admin)
+	if !ok {
+		return
+	}
+
+	webhooks, err := h.webhookService.GetEndpoints(owner)
+	if err != nil {
+		webhookErrorResponse(c, err)
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, webhooks)
+}
+
+func (h *WebhookHandler) deleteWebhook(c *gin.Context, admin bool) {
+	owner, ok := webhookOwner(c, admin)
+	if !ok {
+		return
+	}
+
+	webhookID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	if err := h.webhookService.DeleteEndpoint(owner, uint(webhookID)); err != nil {
+		webhookErrorResponse(c, err)
+		return
+	}
+
+	utils.SuccessResponse(c, http.StatusOK, "Webhook deleted", nil)
+}
+
+func (h *WebhookHandler) getDeliveries(c *gin.Context, admin bool) {
+	owner, ok := webhookOwner(c, admin)
+	if !ok {
+		return
+	}
+
+	webhookID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "20"))
+
+	deliveries, err := h.webhookService.GetDeliveries(owner, uint(webhookID), page, limit)
+	if err != nil {
+		webhookErrorResponse(c, err)
+		return
+	}
+
+	utils.DataResponse(c, http.StatusOK, deliveries)
+}
+
+func (h *WebhookHandler) replayDelivery(c *gin.Context, admin bool) {
+	owner, ok := webhookOwner(c, admin)
+	if !ok {
+		return
+	}
+
+	webhookID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid webhook ID")
+		return
+	}
+	deliveryID, err := strconv.ParseUint(c.Param("delivery_id"), 10, 32)
+	if err != nil {
+		utils.ErrorResponse(c, http.StatusBadRequest, "Invalid delivery ID")
+		return
+	}
+
+	delivery, err := h.webhookService.ReplayDelivery(owner, uint(webhookID), uint(deliveryID))
+	if err != nil {
+		webhookErrorResponse(c, err)
+		return
+	}
+
+	utils.DataResponse(c, http.StatusAccepted, delivery)
+}
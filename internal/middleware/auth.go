@@ -1,16 +1,23 @@
 package middleware
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 
-	"mowsy-api/pkg/auth"
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/services"
 	"mowsy-api/internal/utils"
+	"mowsy-api/pkg/auth"
 
 	"github.com/gin-gonic/gin"
 )
 
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware requires a signed-in user. Routes that name scopes also
+// accept a personal access token that was granted all of them; routes that
+// name none only accept the access tokens issued at sign-in.
+func AuthMiddleware(tokens *services.AccessTokenService, scopes ...models.TokenScope) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -26,6 +33,28 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if services.IsPersonalAccessToken(tokenString) {
+			token, user, err := tokens.Authenticate(tokenString)
+			if err != nil {
+				if errors.Is(err, services.ErrInvalidAccessToken) {
+					utils.ErrorResponse(c, http.StatusUnauthorized, "Invalid or expired token")
+				} else {
+					utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to verify token")
+				}
+				c.Abort()
+				return
+			}
+			if message := missingScope(token, scopes); message != "" {
+				utils.ErrorResponse(c, http.StatusForbidden, message)
+				c.Abort()
+				return
+			}
+
+			setAccessTokenUser(c, token, user)
+			c.Next()
+			return
+		}
+
 		claims, err := auth.ValidateToken(tokenString)
 		if err != nil {
 			utils.ErrorResponse(c, http.StatusUnauthorized, "Invalid or expired token")
@@ -35,11 +64,15 @@ func AuthMiddleware() gin.HandlerFunc {
 
 		c.Set("user_id", claims.UserID)
 		c.Set("user_email", claims.Email)
+		setRequestUser(c, claims.UserID)
 		c.Next()
 	}
 }
 
-func OptionalAuthMiddleware() gin.HandlerFunc {
+// OptionalAuthMiddleware identifies the user when the request carries valid
+// credentials, on the same terms as AuthMiddleware, and otherwise lets the
+// request through anonymously.
+func OptionalAuthMiddleware(tokens *services.AccessTokenService, scopes ...models.TokenScope) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -53,6 +86,15 @@ func OptionalAuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if services.IsPersonalAccessToken(tokenString) {
+			token, user, err := tokens.Authenticate(tokenString)
+			if err == nil && missingScope(token, scopes) == "" {
+				setAccessTokenUser(c, token, user)
+			}
+			c.Next()
+			return
+		}
+
 		claims, err := auth.ValidateToken(tokenString)
 		if err != nil {
 			c.Next()
@@ -61,6 +103,28 @@ func OptionalAuthMiddleware() gin.HandlerFunc {
 
 		c.Set("user_id", claims.UserID)
 		c.Set("user_email", claims.Email)
+		setRequestUser(c, claims.UserID)
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+// missingScope explains why token may not call a route needing scopes, or
+// returns "" when it may.
+func missingScope(token *models.PersonalAccessToken, scopes []models.TokenScope) string {
+	if len(scopes) == 0 {
+		return "Personal access tokens cannot be used for this endpoint"
+	}
+	for _, scope := range scopes {
+		if !token.HasScope(scope) {
+			return fmt.Sprintf("Token is missing the %s scope", scope)
+		}
+	}
+	return ""
+}
+
+func setAccessTokenUser(c *gin.Context, token *models.PersonalAccessToken, user *models.User) {
+	c.Set("user_id", user.ID)
+	c.Set("user_email", user.Email)
+	c.Set("access_token_id", token.ID)
+	setRequestUser(c, user.ID)
+}
@@ -1,11 +1,14 @@
 package middleware
 
 import (
+	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"os"
 	"testing"
 
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/services"
+	"mowsy-api/internal/testutils"
 	"mowsy-api/pkg/auth"
 
 	"github.com/gin-gonic/gin"
@@ -20,16 +23,12 @@ func setupGin() *gin.Engine {
 
 func TestAuthMiddleware(t *testing.T) {
 	// Set up test environment
-	originalSecret := os.Getenv("JWT_SECRET")
-	defer os.Setenv("JWT_SECRET", originalSecret)
-	
-	testSecret := "test-secret-key-for-jwt-testing"
-	os.Setenv("JWT_SECRET", testSecret)
+	testutils.UseTestSigningKeys(t)
 
 	r := setupGin()
 	
 	// Protected route
-	r.GET("/protected", AuthMiddleware(), func(c *gin.Context) {
+	r.GET("/protected", AuthMiddleware(new(services.AccessTokenService)), func(c *gin.Context) {
 		userID, exists := c.Get("user_id")
 		if !exists {
 			c.JSON(500, gin.H{"error": "user_id not set"})
@@ -101,16 +100,12 @@ func TestAuthMiddleware(t *testing.T) {
 
 func TestOptionalAuthMiddleware(t *testing.T) {
 	// Set up test environment
-	originalSecret := os.Getenv("JWT_SECRET")
-	defer os.Setenv("JWT_SECRET", originalSecret)
-	
-	testSecret := "test-secret-key-for-jwt-testing"
-	os.Setenv("JWT_SECRET", testSecret)
+	testutils.UseTestSigningKeys(t)
 
 	r := setupGin()
 	
 	// Route with optional auth
-	r.GET("/optional", OptionalAuthMiddleware(), func(c *gin.Context) {
+	r.GET("/optional", OptionalAuthMiddleware(new(services.AccessTokenService)), func(c *gin.Context) {
 		userID, exists := c.Get("user_id")
 		if exists {
 			c.JSON(200, gin.H{"authenticated": true, "user_id": userID})
@@ -168,4 +163,59 @@ func TestOptionalAuthMiddleware(t *testing.T) {
 		assert.Equal(t, http.StatusOK, w.Code)
 		assert.Contains(t, w.Body.String(), "\"authenticated\":false")
 	})
-}
\ No newline at end of file
+}
+func TestAuthMiddleware_PersonalAccessTokens(t *testing.T) {
+	testutils.UseTestSigningKeys(t)
+	db := testutils.SetupTestDB()
+	defer testutils.CleanupTestDB(db)
+	tokens := services.NewContainer(services.Dependencies{DB: db}).AccessTokens
+
+	user := testutils.CreateTestUser(db)
+	created, err := tokens.CreateToken(user.ID, services.CreateAccessTokenRequest{
+		Name: "Scheduler", Scopes: []models.TokenScope{models.ScopeJobsRead},
+	})
+	require.NoError(t, err)
+
+	r := setupGin()
+	ok := func(c *gin.Context) { c.JSON(200, gin.H{"user_id": c.GetUint("user_id")}) }
+	r.GET("/jobs", AuthMiddleware(tokens, models.ScopeJobsRead), ok)
+	r.POST("/jobs", AuthMiddleware(tokens, models.ScopeJobsWrite), ok)
+	r.GET("/me", AuthMiddleware(tokens), ok)
+
+	request := func(method, path, token string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	t.Run("GrantedScope", func(t *testing.T) {
+		w := request("GET", "/jobs", created.Token)
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Contains(t, w.Body.String(), fmt.Sprintf(`"user_id":%d`, user.ID))
+	})
+
+	t.Run("MissingScope", func(t *testing.T) {
+		w := request("POST", "/jobs", created.Token)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+		assert.Contains(t, w.Body.String(), "jobs:write")
+	})
+
+	t.Run("RouteWithoutScopes", func(t *testing.T) {
+		w := request("GET", "/me", created.Token)
+		assert.Equal(t, http.StatusForbidden, w.Code)
+	})
+
+	t.Run("SignedInUsersHaveEveryScope", func(t *testing.T) {
+		token, err := auth.GenerateToken(user.ID, user.Email)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, request("POST", "/jobs", token).Code)
+		assert.Equal(t, http.StatusOK, request("GET", "/me", token).Code)
+	})
+
+	t.Run("UnknownToken", func(t *testing.T) {
+		w := request("GET", "/jobs", services.PersonalAccessTokenPrefix+"unknown")
+		assert.Equal(t, http.StatusUnauthorized, w.Code)
+	})
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConfigureClientIP decides which forwarded headers c.ClientIP believes, so
+// rate limits and sign-in protection can't be dodged with a made-up
+// X-Forwarded-For. By default none are and the client is whoever opened the
+// connection. TRUSTED_PROXIES lists the addresses or CIDR ranges of load
+// balancers whose X-Forwarded-For and X-Real-IP are believed, and
+// TRUSTED_PLATFORM names a header a CDN sets to the client address, such as
+// CF-Connecting-IP. If TRUSTED_PROXIES is invalid no proxy is trusted.
+func ConfigureClientIP(r *gin.Engine) error {
+	r.TrustedPlatform = os.Getenv("TRUSTED_PLATFORM")
+
+	var proxies []string
+	for _, proxy := range strings.Split(os.Getenv("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			proxies = append(proxies, proxy)
+		}
+	}
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		_ = r.SetTrustedProxies(nil)
+		return fmt.Errorf("invalid TRUSTED_PROXIES: %w", err)
+	}
+	return nil
+}
+
+// RemoteAddrMiddleware gives the request's remote address a port when it has
+// none. The Lambda adapter sets it to API Gateway's bare source IP, which
+// c.ClientIP can't parse, so every caller would share one empty address.
+func RemoteAddrMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if ip := net.ParseIP(c.Request.RemoteAddr); ip != nil {
+			c.Request.RemoteAddr = net.JoinHostPort(ip.String(), "0")
+		}
+		c.Next()
+	}
+}
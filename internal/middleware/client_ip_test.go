@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigureClientIP(t *testing.T) {
+	keyFor := func(r *gin.Engine, remoteAddr string, headers map[string]string) string {
+		var key string
+		r.GET("/key", func(c *gin.Context) { key = KeyByIP(c) })
+
+		req := httptest.NewRequest(http.MethodGet, "/key", nil)
+		req.RemoteAddr = remoteAddr
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		r.ServeHTTP(httptest.NewRecorder(), req)
+		return key
+	}
+
+	t.Run("IgnoresSpoofedForwardedFor", func(t *testing.T) {
+		t.Setenv("TRUSTED_PROXIES", "")
+		t.Setenv("TRUSTED_PLATFORM", "")
+		r := setupGin()
+		require.NoError(t, ConfigureClientIP(r))
+
+		key := keyFor(r, "203.0.113.7:4711", map[string]string{"X-Forwarded-For": "198.51.100.1"})
+
+		assert.Equal(t, "ip:203.0.113.7", key)
+	})
+
+	t.Run("BelievesTrustedProxy", func(t *testing.T) {
+		t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.1")
+		r := setupGin()
+		require.NoError(t, ConfigureClientIP(r))
+
+		assert.Equal(t, "ip:198.51.100.1", keyFor(r, "10.1.2.3:4711", map[string]string{"X-Forwarded-For": "198.51.100.1"}))
+
+		r = setupGin()
+		require.NoError(t, ConfigureClientIP(r))
+		assert.Equal(t, "ip:203.0.113.7", keyFor(r, "203.0.113.7:4711", map[string]string{"X-Forwarded-For": "198.51.100.1"}),
+			"only the listed proxies are believed")
+	})
+
+	t.Run("BelievesTrustedPlatform", func(t *testing.T) {
+		t.Setenv("TRUSTED_PLATFORM", gin.PlatformCloudflare)
+		r := setupGin()
+		require.NoError(t, ConfigureClientIP(r))
+
+		key := keyFor(r, "203.0.113.7:4711", map[string]string{
+			"CF-Connecting-IP": "198.51.100.1",
+			"X-Forwarded-For":  "192.0.2.9",
+		})
+
+		assert.Equal(t, "ip:198.51.100.1", key)
+	})
+
+	t.Run("InvalidProxiesTrustNone", func(t *testing.T) {
+		t.Setenv("TRUSTED_PROXIES", "not-an-address")
+		r := setupGin()
+		assert.Error(t, ConfigureClientIP(r))
+
+		key := keyFor(r, "203.0.113.7:4711", map[string]string{"X-Forwarded-For": "198.51.100.1"})
+
+		assert.Equal(t, "ip:203.0.113.7", key)
+	})
+}
+
+func TestRemoteAddrMiddleware(t *testing.T) {
+	r := setupGin()
+	require.NoError(t, ConfigureClientIP(r))
+	r.Use(RemoteAddrMiddleware())
+	var key string
+	r.GET("/key", func(c *gin.Context) { key = KeyByIP(c) })
+
+	req := httptest.NewRequest(http.MethodGet, "/key", nil)
+	req.RemoteAddr = "203.0.113.7"
+	req.Header.Set("X-Forwarded-For", "198.51.100.1")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.Equal(t, "ip:203.0.113.7", key)
+}
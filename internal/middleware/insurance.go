@@ -9,7 +9,7 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func InsuranceRequiredMiddleware() gin.HandlerFunc {
+func InsuranceRequiredMiddleware(users *services.UserService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userID, exists := c.Get("user_id")
 		if !exists {
@@ -18,8 +18,7 @@ func InsuranceRequiredMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		userService := services.NewUserService()
-		user, err := userService.GetUserByID(userID.(uint))
+		user, err := users.GetUserByID(userID.(uint))
 		if err != nil {
 			utils.ErrorResponse(c, http.StatusInternalServerError, "Failed to verify user status")
 			c.Abort()
@@ -1,32 +1,114 @@
 package middleware
 
 import (
-	"log"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"runtime/debug"
 	"time"
 
+	"mowsy-api/pkg/logging"
+
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// RequestIDHeader carries a request's ID in from a proxy or client and back
+// out in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID keeps a caller-supplied ID from injecting anything odd into
+// logs or headers.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:\-]{1,128}$`)
+
+// RequestIDMiddleware adopts the incoming X-Request-ID or generates one, and
+// puts a logger tagged with it into the request context. When the request is
+// traced, the trace ID is added too.
+func RequestIDMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+
+		c.Set("request_id", id)
+		c.Header(RequestIDHeader, id)
+		ctx := logging.With(c.Request.Context(), "request_id", id)
+		if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+			ctx = logging.With(ctx, "trace_id", spanContext.TraceID().String())
+		}
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// setRequestUser tags the request's logger with the authenticated user, so
+// services logging through the request context include it.
+func setRequestUser(c *gin.Context, userID uint) {
+	c.Request = c.Request.WithContext(logging.With(c.Request.Context(), "user_id", userID))
+}
+
+// LoggingMiddleware logs one line per request once it has been handled.
+// The route template is logged rather than the path, so IDs and file keys
+// in URLs stay out of the logs; query strings, which can hold presigned
+// signatures, are never logged.
 func LoggingMiddleware() gin.HandlerFunc {
-	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
-		log.Printf("[%s] %s %s %d %s %s\n",
-			param.TimeStamp.Format(time.RFC3339),
-			param.Method,
-			param.Path,
-			param.StatusCode,
-			param.Latency,
-			param.ClientIP,
-		)
-		return ""
-	})
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("route", route),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int("bytes", c.Writer.Size()),
+			slog.String("client_ip", c.ClientIP()),
+		}
+		if userID, ok := c.Get("user_id"); ok {
+			attrs = append(attrs, slog.Any("user_id", userID))
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, slog.String("errors", c.Errors.String()))
+		}
+
+		logging.FromContext(c.Request.Context()).LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
 }
 
 func RecoveryMiddleware() gin.HandlerFunc {
-	return gin.RecoveryWithWriter(gin.DefaultWriter, func(c *gin.Context, recovered interface{}) {
-		log.Printf("Panic recovered: %v", recovered)
+	// Gin's own panic output is plain text with the request headers dumped
+	// in, so it is discarded in favour of a structured record.
+	return gin.RecoveryWithWriter(io.Discard, func(c *gin.Context, recovered interface{}) {
+		logging.FromContext(c.Request.Context()).Error("panic recovered",
+			"panic", fmt.Sprint(recovered), "stack", string(debug.Stack()))
 		c.JSON(500, gin.H{
 			"error":   "Internal Server Error",
 			"message": "An unexpected error occurred",
 		})
 	})
-}
\ No newline at end of file
+}
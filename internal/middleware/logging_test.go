@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"mowsy-api/internal/services"
+	"mowsy-api/internal/testutils"
+	"mowsy-api/pkg/auth"
+	"mowsy-api/pkg/logging"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// captureLogs routes the default logger into a buffer for the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	previous := slog.Default()
+	slog.SetDefault(logging.New(&buf, slog.LevelDebug))
+	t.Cleanup(func() { slog.SetDefault(previous) })
+	return &buf
+}
+
+func logRecords(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	var records []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &record))
+		records = append(records, record)
+	}
+	buf.Reset()
+	return records
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	testutils.UseTestSigningKeys(t)
+
+	logs := captureLogs(t)
+
+	r := setupGin()
+	r.Use(RequestIDMiddleware(), LoggingMiddleware(), RecoveryMiddleware())
+	r.GET("/jobs/:id", AuthMiddleware(new(services.AccessTokenService)), func(c *gin.Context) {
+		logging.FromContext(c.Request.Context()).Info("loading job")
+		c.Status(http.StatusOK)
+	})
+	r.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+
+	token, err := auth.GenerateToken(42, "owner@example.com")
+	require.NoError(t, err)
+
+	t.Run("GeneratesRequestID", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/jobs/17?signature=secret", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		id := w.Header().Get(RequestIDHeader)
+		assert.Len(t, id, 32)
+
+		// Query strings can hold presigned signatures
+		assert.NotContains(t, logs.String(), "signature")
+
+		records := logRecords(t, logs)
+		require.Len(t, records, 2)
+
+		// The handler's own log line carries the request and user
+		assert.Equal(t, "loading job", records[0]["msg"])
+		assert.Equal(t, id, records[0]["request_id"])
+		assert.Equal(t, float64(42), records[0]["user_id"])
+
+		request := records[1]
+		assert.Equal(t, "request", request["msg"])
+		assert.Equal(t, id, request["request_id"])
+		assert.Equal(t, "/jobs/:id", request["route"])
+		assert.Equal(t, float64(200), request["status"])
+		assert.Equal(t, float64(42), request["user_id"])
+		assert.Contains(t, request, "latency_ms")
+	})
+
+	t.Run("PropagatesRequestID", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/jobs/17", nil)
+		req.Header.Set(RequestIDHeader, "edge-123")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, "edge-123", w.Header().Get(RequestIDHeader))
+		records := logRecords(t, logs)
+		require.Len(t, records, 1)
+		assert.Equal(t, "edge-123", records[0]["request_id"])
+		assert.Equal(t, "WARN", records[0]["level"])
+	})
+
+	t.Run("RejectsMalformedRequestID", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/jobs/17", nil)
+		req.Header.Set(RequestIDHeader, "bad id\nwith newline")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Len(t, w.Header().Get(RequestIDHeader), 32)
+		logRecords(t, logs)
+	})
+
+	t.Run("PanicIsLoggedAsError", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/panic", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+		records := logRecords(t, logs)
+		require.Len(t, records, 2)
+		assert.Equal(t, "panic recovered", records[0]["msg"])
+		assert.Equal(t, "boom", records[0]["panic"])
+		assert.Equal(t, "ERROR", records[1]["level"])
+		assert.Equal(t, float64(500), records[1]["status"])
+	})
+}
@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"mowsy-api/pkg/metrics"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsMiddleware_RecordsRouteTemplate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(MetricsMiddleware())
+	r.GET("/jobs/:id", func(c *gin.Context) { c.Status(http.StatusTeapot) })
+	r.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/jobs/42", nil))
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Contains(t, w.Body.String(), `mowsy_http_requests_total{method="GET",route="/jobs/:id",status="418"} 1`)
+	assert.NotContains(t, w.Body.String(), "/jobs/42")
+}
+
+func TestMetricsAuthMiddleware(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.GET("/metrics", MetricsAuthMiddleware(), func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	serve := func(authorization string) int {
+		req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	os.Unsetenv("METRICS_TOKEN")
+	assert.Equal(t, http.StatusOK, serve(""))
+
+	os.Setenv("METRICS_TOKEN", "scrape-secret")
+	defer os.Unsetenv("METRICS_TOKEN")
+	assert.Equal(t, http.StatusUnauthorized, serve(""))
+	assert.Equal(t, http.StatusUnauthorized, serve("Bearer wrong"))
+	assert.Equal(t, http.StatusOK, serve("Bearer scrape-secret"))
+}
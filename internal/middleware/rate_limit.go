@@ -1,120 +1,89 @@
 package middleware
 
 import (
+	"math"
 	"net/http"
-	"sync"
+	"strconv"
 	"time"
 
 	"mowsy-api/internal/utils"
+	"mowsy-api/pkg/logging"
+	"mowsy-api/pkg/ratelimit"
 
 	"github.com/gin-gonic/gin"
 )
 
-type RateLimiter struct {
-	visitors map[string]*Visitor
-	mu       sync.RWMutex
-	rate     int
-	window   time.Duration
-}
+// Policies applied by the routes. IP limits run before authentication and
+// only stop floods; per-user limits are what ordinary clients run into.
+var (
+	IPRateLimit     = ratelimit.Policy{Name: "ip", Limit: 300, Period: time.Minute}
+	UserRateLimit   = ratelimit.Policy{Name: "user", Limit: 100, Period: time.Minute}
+	LoginRateLimit  = ratelimit.Policy{Name: "login", Limit: 10, Period: 15 * time.Minute}
+	ApplyRateLimit  = ratelimit.Policy{Name: "apply", Limit: 20, Period: time.Hour}
+	UploadRateLimit = ratelimit.Policy{Name: "upload", Limit: 30, Period: 10 * time.Minute}
+)
 
-type Visitor struct {
-	requests []time.Time
-	mu       sync.RWMutex
-}
+// rateLimitRemainingKey holds the lowest remaining count reported so far, so
+// that when several policies apply the headers describe the tightest one.
+const rateLimitRemainingKey = "rate_limit_remaining"
 
-func NewRateLimiter(rate int, window time.Duration) *RateLimiter {
-	rl := &RateLimiter{
-		visitors: make(map[string]*Visitor),
-		rate:     rate,
-		window:   window,
-	}
+// RateLimitKey picks the bucket a request is counted against.
+type RateLimitKey func(c *gin.Context) string
 
-	go rl.cleanup()
-	return rl
+// KeyByIP counts requests per client address.
+func KeyByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
 }
 
-func (rl *RateLimiter) cleanup() {
-	ticker := time.NewTicker(time.Minute)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			rl.mu.Lock()
-			for key, visitor := range rl.visitors {
-				visitor.mu.Lock()
-				cutoff := time.Now().Add(-rl.window)
-				validRequests := []time.Time{}
-				
-				for _, t := range visitor.requests {
-					if t.After(cutoff) {
-						validRequests = append(validRequests, t)
-					}
-				}
-				
-				if len(validRequests) == 0 {
-					delete(rl.visitors, key)
-				} else {
-					visitor.requests = validRequests
-				}
-				visitor.mu.Unlock()
-			}
-			rl.mu.Unlock()
-		}
+// KeyByUser counts requests per authenticated user, falling back to the
+// client address. It only sees the user when it runs after the auth
+// middleware.
+func KeyByUser(c *gin.Context) string {
+	if userID, ok := c.Get("user_id"); ok {
+		return "user:" + strconv.FormatUint(uint64(userID.(uint)), 10)
 	}
+	return KeyByIP(c)
 }
 
-func (rl *RateLimiter) Allow(key string) bool {
-	rl.mu.RLock()
-	visitor, exists := rl.visitors[key]
-	rl.mu.RUnlock()
-
-	if !exists {
-		rl.mu.Lock()
-		visitor = &Visitor{
-			requests: []time.Time{},
+// RateLimitMiddleware counts each request against policy and refuses it with
+// 429 once the bucket is empty. Responses carry RateLimit-Limit,
+// RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy, and refusals a
+// Retry-After. If the store fails the request is let through: a limiter
+// outage should not become an API outage.
+func RateLimitMiddleware(store ratelimit.Store, policy ratelimit.Policy, key RateLimitKey) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		result, err := store.Take(c.Request.Context(), key(c), policy, time.Now())
+		if err != nil {
+			logging.FromContext(c.Request.Context()).Warn("rate limiter unavailable", "policy", policy.Name, "error", err)
+			c.Next()
+			return
 		}
-		rl.visitors[key] = visitor
-		rl.mu.Unlock()
-	}
 
-	visitor.mu.Lock()
-	defer visitor.mu.Unlock()
+		setRateLimitHeaders(c, policy, result)
 
-	now := time.Now()
-	cutoff := now.Add(-rl.window)
-	
-	validRequests := []time.Time{}
-	for _, t := range visitor.requests {
-		if t.After(cutoff) {
-			validRequests = append(validRequests, t)
-		}
-	}
-	
-	if len(validRequests) >= rl.rate {
-		return false
-	}
-	
-	visitor.requests = append(validRequests, now)
-	return true
-}
-
-func RateLimitMiddleware(rate int, window time.Duration) gin.HandlerFunc {
-	limiter := NewRateLimiter(rate, window)
-	
-	return func(c *gin.Context) {
-		key := c.ClientIP()
-		
-		if userID, exists := c.Get("user_id"); exists {
-			key = "user_" + string(rune(userID.(uint)))
-		}
-		
-		if !limiter.Allow(key) {
+		if !result.Allowed {
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
 			utils.ErrorResponse(c, http.StatusTooManyRequests, "Rate limit exceeded")
 			c.Abort()
 			return
 		}
-		
+
 		c.Next()
 	}
-}
\ No newline at end of file
+}
+
+func setRateLimitHeaders(c *gin.Context, policy ratelimit.Policy, result ratelimit.Result) {
+	if lowest, ok := c.Get(rateLimitRemainingKey); ok && lowest.(int) < result.Remaining && result.Allowed {
+		return
+	}
+	c.Set(rateLimitRemainingKey, result.Remaining)
+
+	c.Header("RateLimit-Limit", strconv.Itoa(result.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+	c.Header("RateLimit-Policy", strconv.Itoa(policy.Limit)+";w="+strconv.Itoa(ceilSeconds(policy.Period)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mowsy-api/pkg/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, policy ratelimit.Policy, now time.Time) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("connection refused")
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	policy := ratelimit.Policy{Name: "test", Limit: 2, Period: time.Minute}
+
+	serve := func(r *gin.Engine, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		return w
+	}
+
+	t.Run("RefusesOnceBucketIsEmpty", func(t *testing.T) {
+		r := setupGin()
+		r.GET("/jobs", RateLimitMiddleware(ratelimit.NewMemoryStore(), policy, KeyByIP), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		w := serve(r, "/jobs")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "30", w.Header().Get("RateLimit-Reset"))
+		assert.Equal(t, "2;w=60", w.Header().Get("RateLimit-Policy"))
+		assert.Empty(t, w.Header().Get("Retry-After"))
+
+		serve(r, "/jobs")
+		w = serve(r, "/jobs")
+		assert.Equal(t, http.StatusTooManyRequests, w.Code)
+		assert.Equal(t, "0", w.Header().Get("RateLimit-Remaining"))
+		assert.Equal(t, "30", w.Header().Get("Retry-After"))
+	})
+
+	t.Run("KeysByAuthenticatedUser", func(t *testing.T) {
+		store := ratelimit.NewMemoryStore()
+		r := setupGin()
+		setUser := func(id uint) gin.HandlerFunc {
+			return func(c *gin.Context) { c.Set("user_id", id) }
+		}
+		ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+		r.GET("/a", setUser(1), RateLimitMiddleware(store, policy, KeyByUser), ok)
+		r.GET("/b", setUser(2), RateLimitMiddleware(store, policy, KeyByUser), ok)
+
+		serve(r, "/a")
+		serve(r, "/a")
+		assert.Equal(t, http.StatusTooManyRequests, serve(r, "/a").Code)
+
+		// Same address, different user: a separate bucket. User IDs that
+		// differed only past the first rune used to collide.
+		assert.Equal(t, http.StatusOK, serve(r, "/b").Code)
+	})
+
+	t.Run("ReportsTightestPolicy", func(t *testing.T) {
+		store := ratelimit.NewMemoryStore()
+		loose := ratelimit.Policy{Name: "loose", Limit: 100, Period: time.Minute}
+		r := setupGin()
+		r.GET("/apply", RateLimitMiddleware(store, policy, KeyByIP), RateLimitMiddleware(store, loose, KeyByIP), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		w := serve(r, "/apply")
+		assert.Equal(t, "2", w.Header().Get("RateLimit-Limit"))
+		assert.Equal(t, "1", w.Header().Get("RateLimit-Remaining"))
+	})
+
+	t.Run("FailsOpen", func(t *testing.T) {
+		r := setupGin()
+		r.GET("/jobs", RateLimitMiddleware(failingStore{}, policy, KeyByIP), func(c *gin.Context) {
+			c.Status(http.StatusOK)
+		})
+
+		assert.Equal(t, http.StatusOK, serve(r, "/jobs").Code)
+	})
+}
+
+func TestKeyByUser(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	c.Request.RemoteAddr = "203.0.113.7:1234"
+
+	assert.Equal(t, "ip:203.0.113.7", KeyByUser(c))
+
+	c.Set("user_id", uint(70000))
+	assert.Equal(t, "user:70000", KeyByUser(c))
+}
@@ -0,0 +1,20 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+)
+
+const serviceName = "mowsy-api"
+
+// TracingMiddleware starts a server span for each request, named after its
+// route template and joined to the caller's trace when a traceparent header
+// is present. Health checks and scrapes are not traced.
+func TracingMiddleware() gin.HandlerFunc {
+	return otelgin.Middleware(serviceName, otelgin.WithFilter(func(r *http.Request) bool {
+		return !strings.HasPrefix(r.URL.Path, "/health") && r.URL.Path != "/metrics"
+	}))
+}
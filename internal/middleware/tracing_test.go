@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"mowsy-api/pkg/logging"
+	"mowsy-api/pkg/tracing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTracingMiddleware(t *testing.T) {
+	exporter, restore := tracing.InMemory()
+	defer restore()
+	logs := captureLogs(t)
+
+	r := setupGin()
+	r.Use(TracingMiddleware(), RequestIDMiddleware(), LoggingMiddleware())
+	r.GET("/jobs/:id", func(c *gin.Context) {
+		logging.FromContext(c.Request.Context()).Info("loading job")
+		c.Status(http.StatusOK)
+	})
+	r.GET("/health/live", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	t.Run("JoinsIncomingTrace", func(t *testing.T) {
+		exporter.Reset()
+		req := httptest.NewRequest(http.MethodGet, "/jobs/17", nil)
+		req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+
+		spans := exporter.GetSpans()
+		require.Len(t, spans, 1)
+		assert.Equal(t, "/jobs/:id", spans[0].Name)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext.TraceID().String())
+		assert.Equal(t, "00f067aa0ba902b7", spans[0].Parent.SpanID().String())
+
+		records := logRecords(t, logs)
+		require.NotEmpty(t, records)
+		assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", records[0]["trace_id"])
+	})
+
+	t.Run("SkipsHealthChecks", func(t *testing.T) {
+		exporter.Reset()
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health/live", nil))
+
+		assert.Empty(t, exporter.GetSpans())
+	})
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type ConversationType string
+
+const (
+	ConversationTypeJobApplication  ConversationType = "job_application"
+	ConversationTypeEquipmentRental ConversationType = "equipment_rental"
+)
+
+// Conversation is a message thread between the two sides of a job application
+// (poster and applicant) or an equipment rental (owner and renter).
+type Conversation struct {
+	ID                uint             `json:"id" gorm:"primaryKey"`
+	Type              ConversationType `json:"type" gorm:"not null;uniqueIndex:idx_conversation_scope"`
+	RelatedID         uint             `json:"related_id" gorm:"not null;uniqueIndex:idx_conversation_scope"`
+	OwnerUserID       uint             `json:"owner_user_id" gorm:"not null;index"`
+	ParticipantUserID uint             `json:"participant_user_id" gorm:"not null;index"`
+	LastMessageAt     *time.Time       `json:"last_message_at"`
+	CreatedAt         time.Time        `json:"created_at"`
+	UpdatedAt         time.Time        `json:"updated_at"`
+
+	// Relationships
+	Owner       User      `json:"owner,omitempty" gorm:"foreignKey:OwnerUserID"`
+	Participant User      `json:"participant,omitempty" gorm:"foreignKey:ParticipantUserID"`
+	Messages    []Message `json:"messages,omitempty" gorm:"foreignKey:ConversationID"`
+}
+
+func (c *Conversation) BeforeCreate(tx *gorm.DB) error {
+	c.CreatedAt = time.Now()
+	c.UpdatedAt = time.Now()
+	return nil
+}
+
+func (c *Conversation) BeforeUpdate(tx *gorm.DB) error {
+	c.UpdatedAt = time.Now()
+	return nil
+}
+
+// HasParticipant reports whether the user is one of the two sides of the thread.
+func (c *Conversation) HasParticipant(userID uint) bool {
+	return c.OwnerUserID == userID || c.ParticipantUserID == userID
+}
+
+type Message struct {
+	ID             uint        `json:"id" gorm:"primaryKey"`
+	ConversationID uint        `json:"conversation_id" gorm:"not null;index"`
+	SenderUserID   uint        `json:"sender_user_id" gorm:"not null;index"`
+	Body           string      `json:"body"`
+	Attachments    StringArray `json:"attachments" gorm:"type:jsonb"`
+	Redacted       bool        `json:"redacted" gorm:"default:false"`
+	ReadAt         *time.Time  `json:"read_at"`
+	CreatedAt      time.Time   `json:"created_at"`
+
+	// Relationships
+	Conversation Conversation `json:"conversation,omitempty" gorm:"foreignKey:ConversationID"`
+	Sender       User         `json:"sender,omitempty" gorm:"foreignKey:SenderUserID"`
+}
+
+func (m *Message) BeforeCreate(tx *gorm.DB) error {
+	m.CreatedAt = time.Now()
+	return nil
+}
+
+type ConversationResponse struct {
+	ID            uint              `json:"id"`
+	Type          ConversationType  `json:"type"`
+	RelatedID     uint              `json:"related_id"`
+	LastMessageAt *time.Time        `json:"last_message_at"`
+	UnreadCount   int64             `json:"unread_count"`
+	CreatedAt     time.Time         `json:"created_at"`
+	Owner         UserPublicProfile `json:"owner"`
+	Participant   UserPublicProfile `json:"participant"`
+}
+
+func (c *Conversation) ToResponse(unreadCount int64) ConversationResponse {
+	return ConversationResponse{
+		ID:            c.ID,
+		Type:          c.Type,
+		RelatedID:     c.RelatedID,
+		LastMessageAt: c.LastMessageAt,
+		UnreadCount:   unreadCount,
+		CreatedAt:     c.CreatedAt,
+		Owner:         c.Owner.ToPublicProfile(),
+		Participant:   c.Participant.ToPublicProfile(),
+	}
+}
+
+type MessageResponse struct {
+	ID             uint        `json:"id"`
+	ConversationID uint        `json:"conversation_id"`
+	SenderUserID   uint        `json:"sender_user_id"`
+	Body           string      `json:"body"`
+	Attachments    StringArray `json:"attachments"`
+	Redacted       bool        `json:"redacted"`
+	ReadAt         *time.Time  `json:"read_at"`
+	CreatedAt      time.Time   `json:"created_at"`
+}
+
+func (m *Message) ToResponse() MessageResponse {
+	return MessageResponse{
+		ID:             m.ID,
+		ConversationID: m.ConversationID,
+		SenderUserID:   m.SenderUserID,
+		Body:           m.Body,
+		Attachments:    m.Attachments,
+		Redacted:       m.Redacted,
+		ReadAt:         m.ReadAt,
+		CreatedAt:      m.CreatedAt,
+	}
+}
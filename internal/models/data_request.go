@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type DataRequestKind string
+
+const (
+	DataRequestKindExport  DataRequestKind = "export"
+	DataRequestKindErasure DataRequestKind = "erasure"
+)
+
+type DataRequestStatus string
+
+const (
+	DataRequestStatusPending   DataRequestStatus = "pending"
+	DataRequestStatusCompleted DataRequestStatus = "completed"
+	DataRequestStatusFailed    DataRequestStatus = "failed"
+	DataRequestStatusExpired   DataRequestStatus = "expired"
+)
+
+// DataRequest tracks a user's request to export or erase their data. Both
+// run in the background; FileKey and ExpiresAt are set once an export
+// bundle has been stored. There is deliberately no User relationship: the
+// record of an erasure outlives the account it erased.
+type DataRequest struct {
+	ID          uint              `json:"id" gorm:"primaryKey"`
+	UserID      uint              `json:"user_id" gorm:"not null;index"`
+	Kind        DataRequestKind   `json:"kind" gorm:"not null"`
+	Status      DataRequestStatus `json:"status" gorm:"not null;default:pending;index"`
+	FileKey     string            `json:"-"`
+	Error       string            `json:"error,omitempty"`
+	ExpiresAt   *time.Time        `json:"expires_at"`
+	CompletedAt *time.Time        `json:"completed_at"`
+	CreatedAt   time.Time         `json:"created_at"`
+	UpdatedAt   time.Time         `json:"updated_at"`
+}
+
+func (r *DataRequest) BeforeCreate(tx *gorm.DB) error {
+	r.CreatedAt = time.Now()
+	r.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *DataRequest) BeforeUpdate(tx *gorm.DB) error {
+	r.UpdatedAt = time.Now()
+	return nil
+}
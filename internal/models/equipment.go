@@ -52,6 +52,7 @@ type Equipment struct {
 	Visibility                   Visibility        `json:"visibility" gorm:"not null"`
 	CreatedAt                    time.Time         `json:"created_at"`
 	UpdatedAt                    time.Time         `json:"updated_at"`
+	DeletedAt                    gorm.DeletedAt    `json:"-" gorm:"index"`
 
 	// Relationships
 	User    User                `json:"user,omitempty" gorm:"foreignKey:UserID"`
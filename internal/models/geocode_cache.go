@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// GeocodeCacheEntry stores a resolved address under its normalized form.
+// Entries past ExpiresAt are refreshed on the next lookup.
+type GeocodeCacheEntry struct {
+	ID                uint      `json:"id" gorm:"primaryKey"`
+	NormalizedAddress string    `json:"normalized_address" gorm:"uniqueIndex;not null"`
+	FormattedAddress  string    `json:"formatted_address"`
+	Latitude          float64   `json:"latitude"`
+	Longitude         float64   `json:"longitude"`
+	ZipCode           string    `json:"zip_code"`
+	DistrictName      string    `json:"district_name"`
+	DistrictCode      string    `json:"district_code"`
+	ExpiresAt         time.Time `json:"expires_at" gorm:"not null;index"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+func (e *GeocodeCacheEntry) BeforeCreate(tx *gorm.DB) error {
+	e.CreatedAt = time.Now()
+	e.UpdatedAt = time.Now()
+	return nil
+}
+
+func (e *GeocodeCacheEntry) BeforeUpdate(tx *gorm.DB) error {
+	e.UpdatedAt = time.Now()
+	return nil
+}
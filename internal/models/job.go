@@ -75,6 +75,7 @@ type Job struct {
 	CreatedAt                    time.Time    `json:"created_at"`
 	UpdatedAt                    time.Time    `json:"updated_at"`
 	CompletionImageUrls          StringArray  `json:"completion_image_urls" gorm:"type:jsonb"`
+	DeletedAt                    gorm.DeletedAt `json:"-" gorm:"index"`
 
 	// Relationships
 	User         User             `json:"user,omitempty" gorm:"foreignKey:UserID"`
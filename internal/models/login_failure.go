@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// LoginFailure counts failed sign-ins for one account or one client IP. Key
+// is "account:<user id>" or "ip:<address>"; UserID is only set for accounts.
+// Failures restart from zero once a window passes without a new one.
+type LoginFailure struct {
+	Key             string     `json:"key" gorm:"primaryKey;size:255"`
+	UserID          *uint      `json:"user_id" gorm:"index"`
+	Failures        int        `json:"failures" gorm:"not null;default:0"`
+	LastFailureAt   time.Time  `json:"last_failure_at" gorm:"not null"`
+	LockedUntil     *time.Time `json:"locked_until" gorm:"index"`
+	UnlockTokenHash string     `json:"-" gorm:"index"`
+}
@@ -0,0 +1,13 @@
+package models
+
+import "time"
+
+// MFARecoveryCode is a one-time code that stands in for a TOTP code when the
+// user has lost their authenticator. Only a hash of the code is stored.
+type MFARecoveryCode struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	CodeHash  string     `json:"-" gorm:"not null;uniqueIndex"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type NotificationStatus string
+
+const (
+	NotificationStatusPending NotificationStatus = "pending"
+	NotificationStatusSent    NotificationStatus = "sent"
+	NotificationStatusFailed  NotificationStatus = "failed"
+)
+
+// NotificationPreference holds a user's channel choices. Quiet hours are
+// "HH:MM" wall-clock times in Timezone; a window may wrap past midnight.
+type NotificationPreference struct {
+	ID              uint      `json:"-" gorm:"primaryKey"`
+	UserID          uint      `json:"-" gorm:"uniqueIndex;not null"`
+	EmailEnabled    bool      `json:"email_enabled"`
+	SMSEnabled      bool      `json:"sms_enabled"`
+	PushEnabled     bool      `json:"push_enabled"`
+	PushToken       string    `json:"push_token"`
+	QuietHoursStart string    `json:"quiet_hours_start"`
+	QuietHoursEnd   string    `json:"quiet_hours_end"`
+	Timezone        string    `json:"timezone"`
+	CreatedAt       time.Time `json:"-"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// DefaultNotificationPreference is used until a user saves their own. SMS is
+// opt-in.
+func DefaultNotificationPreference(userID uint) NotificationPreference {
+	return NotificationPreference{
+		UserID:       userID,
+		EmailEnabled: true,
+		SMSEnabled:   false,
+		PushEnabled:  true,
+		Timezone:     "UTC",
+	}
+}
+
+func (p *NotificationPreference) BeforeCreate(tx *gorm.DB) error {
+	p.CreatedAt = time.Now()
+	p.UpdatedAt = time.Now()
+	return nil
+}
+
+func (p *NotificationPreference) BeforeUpdate(tx *gorm.DB) error {
+	p.UpdatedAt = time.Now()
+	return nil
+}
+
+// Notification is one rendered message for one channel. It is written in the
+// same transaction as its delivery task and records the outcome.
+type Notification struct {
+	ID        uint               `json:"id" gorm:"primaryKey"`
+	UserID    uint               `json:"user_id" gorm:"not null;index"`
+	Event     string             `json:"event" gorm:"not null"`
+	Channel   string             `json:"channel" gorm:"not null"`
+	Recipient string             `json:"-" gorm:"not null"`
+	Subject   string             `json:"subject"`
+	Body      string             `json:"body" gorm:"type:text"`
+	Status    NotificationStatus `json:"status" gorm:"not null;default:pending"`
+	Attempts  int                `json:"attempts" gorm:"not null;default:0"`
+	LastError string             `json:"last_error,omitempty"`
+	SentAt    *time.Time         `json:"sent_at"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+func (n *Notification) BeforeCreate(tx *gorm.DB) error {
+	n.CreatedAt = time.Now()
+	n.UpdatedAt = time.Now()
+	return nil
+}
+
+func (n *Notification) BeforeUpdate(tx *gorm.DB) error {
+	n.UpdatedAt = time.Now()
+	return nil
+}
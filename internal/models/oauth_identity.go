@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// OAuthIdentity links a user to an account with an outside identity
+// provider. Subject is the provider's stable ID for that account; the email
+// is kept as the provider last reported it.
+type OAuthIdentity struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	UserID      uint      `json:"user_id" gorm:"not null;index"`
+	Provider    string    `json:"provider" gorm:"not null;uniqueIndex:idx_oauth_identity_subject"`
+	Subject     string    `json:"-" gorm:"not null;uniqueIndex:idx_oauth_identity_subject"`
+	Email       string    `json:"email"`
+	CreatedAt   time.Time `json:"created_at"`
+	LastLoginAt time.Time `json:"last_login_at"`
+}
+
+// TableName avoids GORM's default of "o_auth_identities".
+func (OAuthIdentity) TableName() string {
+	return "oauth_identities"
+}
@@ -16,10 +16,12 @@ const (
 type PaymentStatus string
 
 const (
-	PaymentStatusPending   PaymentStatus = "pending"
-	PaymentStatusSucceeded PaymentStatus = "succeeded"
-	PaymentStatusFailed    PaymentStatus = "failed"
-	PaymentStatusCancelled PaymentStatus = "cancelled"
+	PaymentStatusPending    PaymentStatus = "pending"
+	PaymentStatusAuthorized PaymentStatus = "authorized"
+	PaymentStatusSucceeded  PaymentStatus = "succeeded"
+	PaymentStatusFailed     PaymentStatus = "failed"
+	PaymentStatusCancelled  PaymentStatus = "cancelled"
+	PaymentStatusRefunded   PaymentStatus = "refunded"
 )
 
 type Payment struct {
@@ -31,6 +33,9 @@ type Payment struct {
 	Type                  PaymentType   `json:"type" gorm:"not null"`
 	RelatedID             uint          `json:"related_id" gorm:"not null;index"`
 	Status                PaymentStatus `json:"status" gorm:"default:pending"`
+	FailureReason         string        `json:"failure_reason,omitempty"`
+	StripeRefundID        string        `json:"stripe_refund_id,omitempty"`
+	StripeTransferID      string        `json:"stripe_transfer_id,omitempty"`
 	CreatedAt             time.Time     `json:"created_at"`
 	UpdatedAt             time.Time     `json:"updated_at"`
 
@@ -57,6 +62,7 @@ type PaymentResponse struct {
 	Type                  PaymentType   `json:"type"`
 	RelatedID             uint          `json:"related_id"`
 	Status                PaymentStatus `json:"status"`
+	FailureReason         string        `json:"failure_reason,omitempty"`
 	CreatedAt             time.Time     `json:"created_at"`
 	UpdatedAt             time.Time     `json:"updated_at"`
 }
@@ -70,6 +76,7 @@ func (p *Payment) ToResponse() PaymentResponse {
 		Type:                  p.Type,
 		RelatedID:             p.RelatedID,
 		Status:                p.Status,
+		FailureReason:         p.FailureReason,
 		CreatedAt:             p.CreatedAt,
 		UpdatedAt:             p.UpdatedAt,
 	}
@@ -0,0 +1,42 @@
+package models
+
+import "time"
+
+// TokenScope limits what a personal access token may do.
+type TokenScope string
+
+const (
+	ScopeJobsRead       TokenScope = "jobs:read"
+	ScopeJobsWrite      TokenScope = "jobs:write"
+	ScopeEquipmentWrite TokenScope = "equipment:write"
+	ScopePaymentsRead   TokenScope = "payments:read"
+)
+
+// TokenScopes lists every scope a token can be given.
+var TokenScopes = []TokenScope{ScopeJobsRead, ScopeJobsWrite, ScopeEquipmentWrite, ScopePaymentsRead}
+
+// PersonalAccessToken lets a user's integrations call the API without
+// signing in. Only a hash of the token is stored; Prefix is its first few
+// characters, so users can tell their tokens apart.
+type PersonalAccessToken struct {
+	ID         uint        `json:"id" gorm:"primaryKey"`
+	UserID     uint        `json:"user_id" gorm:"not null;index"`
+	Name       string      `json:"name" gorm:"not null"`
+	TokenHash  string      `json:"-" gorm:"not null;uniqueIndex"`
+	Prefix     string      `json:"prefix" gorm:"not null"`
+	Scopes     StringArray `json:"scopes" gorm:"type:jsonb"`
+	ExpiresAt  time.Time   `json:"expires_at"`
+	LastUsedAt *time.Time  `json:"last_used_at"`
+	RevokedAt  *time.Time  `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+}
+
+// HasScope reports whether the token was granted scope.
+func (t *PersonalAccessToken) HasScope(scope TokenScope) bool {
+	for _, granted := range t.Scopes {
+		if TokenScope(granted) == scope {
+			return true
+		}
+	}
+	return false
+}
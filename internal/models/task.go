@@ -0,0 +1,48 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type TaskStatus string
+
+const (
+	TaskStatusPending   TaskStatus = "pending"
+	TaskStatusRunning   TaskStatus = "running"
+	TaskStatusSucceeded TaskStatus = "succeeded"
+	TaskStatusDead      TaskStatus = "dead"
+)
+
+// Task is a unit of background work. While a task is running, RunAt holds the
+// end of the worker's lease; if the worker dies the task becomes due again.
+type Task struct {
+	ID          uint       `json:"id" gorm:"primaryKey"`
+	Kind        string     `json:"kind" gorm:"not null;index"`
+	Payload     string     `json:"payload" gorm:"type:text"`
+	Status      TaskStatus `json:"status" gorm:"not null;default:pending;index:idx_task_due,priority:1"`
+	Attempts    int        `json:"attempts" gorm:"not null;default:0"`
+	MaxAttempts int        `json:"max_attempts" gorm:"not null"`
+	RunAt       time.Time  `json:"run_at" gorm:"not null;index:idx_task_due,priority:2"`
+	LastError   string     `json:"last_error,omitempty" gorm:"type:text"`
+	CompletedAt *time.Time `json:"completed_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// IsFinalAttempt reports whether a failure now would dead-letter the task.
+func (t *Task) IsFinalAttempt() bool {
+	return t.Attempts >= t.MaxAttempts
+}
+
+func (t *Task) BeforeCreate(tx *gorm.DB) error {
+	t.CreatedAt = time.Now()
+	t.UpdatedAt = time.Now()
+	return nil
+}
+
+func (t *Task) BeforeUpdate(tx *gorm.DB) error {
+	t.UpdatedAt = time.Now()
+	return nil
+}
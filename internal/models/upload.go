@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Upload records who stored a file and what, if anything, uses it.
+// RelatedType and RelatedID point at the record that last referenced the
+// file, e.g. "equipment" and the equipment ID.
+type Upload struct {
+	ID          uint   `json:"id" gorm:"primaryKey"`
+	Key         string `json:"key" gorm:"uniqueIndex;not null"`
+	UserID      uint   `json:"user_id" gorm:"not null;index"`
+	Size        int64  `json:"size"`
+	MimeType    string `json:"mime_type"`
+	Category    string `json:"category"`
+	Visibility  string `json:"visibility"`
+	RelatedType string `json:"related_type,omitempty" gorm:"index:idx_upload_related,priority:1"`
+	RelatedID   *uint  `json:"related_id,omitempty" gorm:"index:idx_upload_related,priority:2"`
+
+	// Where a presigned upload is received, in the private prefix. The file
+	// only appears at Key once it has been processed.
+	StagingKey string `json:"-"`
+
+	// Filled in once an image has been re-encoded into its variants, or a
+	// PDF has been checked.
+	Blurhash    string     `json:"blurhash,omitempty"`
+	Width       int        `json:"width,omitempty"`
+	Height      int        `json:"height,omitempty"`
+	ProcessedAt *time.Time `json:"processed_at"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// Relationships
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
+
+func (u *Upload) BeforeCreate(tx *gorm.DB) error {
+	u.CreatedAt = time.Now()
+	u.UpdatedAt = time.Now()
+	return nil
+}
+
+func (u *Upload) BeforeUpdate(tx *gorm.DB) error {
+	u.UpdatedAt = time.Now()
+	return nil
+}
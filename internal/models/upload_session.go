@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type UploadSessionStatus string
+
+const (
+	UploadSessionStatusPending   UploadSessionStatus = "pending"
+	UploadSessionStatusCompleted UploadSessionStatus = "completed"
+	UploadSessionStatusFailed    UploadSessionStatus = "failed"
+	UploadSessionStatusExpired   UploadSessionStatus = "expired"
+)
+
+// UploadSession tracks a direct upload to storage from the presigned PUT
+// until the client reports it finished. Size is what the client declared
+// and the PUT was signed for; UploadID is set once the file is registered.
+type UploadSession struct {
+	ID          uint                `json:"id" gorm:"primaryKey"`
+	UserID      uint                `json:"user_id" gorm:"not null;index"`
+	Key         string              `json:"key" gorm:"uniqueIndex;not null"`
+	FileName    string              `json:"file_name"`
+	MimeType    string              `json:"mime_type"`
+	Size        int64               `json:"size"`
+	Category    string              `json:"category"`
+	Visibility  string              `json:"visibility"`
+	Status      UploadSessionStatus `json:"status" gorm:"default:pending;index"`
+	Error       string              `json:"error,omitempty"`
+	UploadID    *uint               `json:"upload_id,omitempty"`
+	ExpiresAt   time.Time           `json:"expires_at"`
+	CompletedAt *time.Time          `json:"completed_at"`
+	CreatedAt   time.Time           `json:"created_at"`
+	UpdatedAt   time.Time           `json:"updated_at"`
+
+	// Relationships
+	User User `json:"-" gorm:"foreignKey:UserID"`
+}
+
+func (s *UploadSession) BeforeCreate(tx *gorm.DB) error {
+	s.CreatedAt = time.Now()
+	s.UpdatedAt = time.Now()
+	return nil
+}
+
+func (s *UploadSession) BeforeUpdate(tx *gorm.DB) error {
+	s.UpdatedAt = time.Now()
+	return nil
+}
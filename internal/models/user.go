@@ -6,6 +6,13 @@ import (
 	"gorm.io/gorm"
 )
 
+type UserRole string
+
+const (
+	UserRoleUser  UserRole = "user"
+	UserRoleAdmin UserRole = "admin"
+)
+
 type User struct {
 	ID                           uint      `json:"id" gorm:"primaryKey"`
 	Email                        string    `json:"email" gorm:"uniqueIndex;not null"`
@@ -25,9 +32,19 @@ type User struct {
 	UpdatedAt                    time.Time `json:"updated_at"`
 	IsActive                     bool      `json:"is_active"`
 	StripeCustomerID             string    `json:"stripe_customer_id"`
+	StripeAccountID              string    `json:"stripe_account_id"`
 	InsuranceDocumentURL         string    `json:"insurance_document_url"`
+	InsuranceDocumentKey         string    `json:"insurance_document_key"`
 	InsuranceVerified            bool      `json:"insurance_verified" gorm:"default:false"`
 	InsuranceVerifiedAt          *time.Time `json:"insurance_verified_at"`
+	Role                         UserRole  `json:"role" gorm:"not null;default:user"`
+	MFAEnabled                   bool      `json:"mfa_enabled" gorm:"default:false"`
+	MFASecret                    string    `json:"-"`
+	// MFAPendingSecret holds a secret between enrollment and its first code
+	MFAPendingSecret             string    `json:"-"`
+	// MFALastStep is the TOTP time step of the last accepted code
+	MFALastStep                  int64     `json:"-"`
+	DeletedAt                    gorm.DeletedAt `json:"-" gorm:"index"`
 
 	// Relationships
 	PostedJobs         []Job              `json:"posted_jobs,omitempty" gorm:"foreignKey:UserID"`
@@ -53,6 +70,8 @@ type UserResponse struct {
 	CreatedAt                    time.Time `json:"created_at"`
 	InsuranceVerified            bool      `json:"insurance_verified"`
 	InsuranceVerifiedAt          *time.Time `json:"insurance_verified_at"`
+	Role                         UserRole  `json:"role"`
+	MFAEnabled                   bool      `json:"mfa_enabled"`
 }
 
 type UserPublicProfile struct {
@@ -90,6 +109,8 @@ func (u *User) ToResponse() UserResponse {
 		CreatedAt:                    u.CreatedAt,
 		InsuranceVerified:            u.InsuranceVerified,
 		InsuranceVerifiedAt:          u.InsuranceVerifiedAt,
+		Role:                         u.Role,
+		MFAEnabled:                   u.MFAEnabled,
 	}
 }
 
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WebhookEndpoint receives signed event payloads. Endpoints with a UserID
+// belong to that user and hear about the events they are party to; endpoints
+// without one are registered by an admin for an app and hear about every
+// event. Both hear about new listings.
+type WebhookEndpoint struct {
+	ID          uint        `json:"id" gorm:"primaryKey"`
+	UserID      *uint       `json:"user_id,omitempty" gorm:"index"`
+	AppName     string      `json:"app_name,omitempty"`
+	Description string      `json:"description"`
+	URL         string      `json:"url" gorm:"not null"`
+	Secret      string      `json:"-" gorm:"not null"`
+	Events      StringArray `json:"events" gorm:"type:jsonb"`
+	// ZipCodes narrows listing events to these zip codes. Empty means all.
+	ZipCodes  StringArray `json:"zip_codes" gorm:"type:jsonb"`
+	CreatedAt time.Time   `json:"created_at"`
+	UpdatedAt time.Time   `json:"updated_at"`
+}
+
+func (e *WebhookEndpoint) BeforeCreate(tx *gorm.DB) error {
+	e.CreatedAt = time.Now()
+	e.UpdatedAt = time.Now()
+	return nil
+}
+
+func (e *WebhookEndpoint) BeforeUpdate(tx *gorm.DB) error {
+	e.UpdatedAt = time.Now()
+	return nil
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliverySucceeded WebhookDeliveryStatus = "succeeded"
+	WebhookDeliveryFailed    WebhookDeliveryStatus = "failed"
+)
+
+// WebhookDelivery is one attempt to get one event to one endpoint, retried by
+// its task until it succeeds or the queue gives up. A replay is a new
+// delivery with the same EventID and payload.
+type WebhookDelivery struct {
+	ID             uint                  `json:"id" gorm:"primaryKey"`
+	EndpointID     uint                  `json:"endpoint_id" gorm:"not null;index"`
+	EventID        string                `json:"event_id" gorm:"not null;index"`
+	Event          string                `json:"event" gorm:"not null"`
+	Payload        string                `json:"payload" gorm:"type:text"`
+	Status         WebhookDeliveryStatus `json:"status" gorm:"not null;default:pending"`
+	Attempts       int                   `json:"attempts" gorm:"not null;default:0"`
+	ResponseStatus int                   `json:"response_status,omitempty"`
+	ResponseBody   string                `json:"response_body,omitempty" gorm:"type:text"`
+	LastError      string                `json:"last_error,omitempty"`
+	ReplayOf       *uint                 `json:"replay_of,omitempty"`
+	DeliveredAt    *time.Time            `json:"delivered_at"`
+	CreatedAt      time.Time             `json:"created_at"`
+	UpdatedAt      time.Time             `json:"updated_at"`
+}
+
+func (d *WebhookDelivery) BeforeCreate(tx *gorm.DB) error {
+	d.CreatedAt = time.Now()
+	d.UpdatedAt = time.Now()
+	return nil
+}
+
+func (d *WebhookDelivery) BeforeUpdate(tx *gorm.DB) error {
+	d.UpdatedAt = time.Now()
+	return nil
+}
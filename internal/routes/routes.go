@@ -1,41 +1,87 @@
 package routes
 
 import (
-	"time"
+	"database/sql"
+	"log/slog"
 
 	"mowsy-api/internal/handlers"
 	"mowsy-api/internal/middleware"
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/services"
+	"mowsy-api/pkg/database"
+	"mowsy-api/pkg/metrics"
+	"mowsy-api/pkg/ratelimit"
+	"mowsy-api/pkg/storage"
 
 	"github.com/gin-gonic/gin"
 	// swaggerFiles "github.com/swaggo/files"
 	// ginSwagger "github.com/swaggo/gin-swagger"
 )
 
+// SetupRoutes routes the API to services built from the environment.
 func SetupRoutes() *gin.Engine {
+	return NewRouter(services.NewContainer(services.DependenciesFromEnv()))
+}
+
+// NewRouter routes the API to the services in c.
+func NewRouter(c *services.Container) *gin.Engine {
 	r := gin.New()
+	if err := middleware.ConfigureClientIP(r); err != nil {
+		slog.Warn("ignoring forwarded client addresses", "error", err)
+	}
 
 	// Global middleware
-	r.Use(middleware.RecoveryMiddleware())
+	r.Use(middleware.RemoteAddrMiddleware())
+	r.Use(middleware.TracingMiddleware())
+	r.Use(middleware.RequestIDMiddleware())
 	r.Use(middleware.LoggingMiddleware())
+	r.Use(middleware.MetricsMiddleware())
+	r.Use(middleware.RecoveryMiddleware())
 	r.Use(middleware.CORSMiddleware())
-	r.Use(middleware.RateLimitMiddleware(100, time.Minute))
+
+	// Rate limits are shared through the database when there is one
+	rateLimits, err := ratelimit.NewStoreFromEnv(c.DB)
+	if err != nil {
+		slog.Warn("falling back to in-memory rate limits", "error", err)
+		rateLimits = ratelimit.NewMemoryStore()
+	}
+	r.Use(middleware.RateLimitMiddleware(rateLimits, middleware.IPRateLimit, middleware.KeyByIP))
+	perUser := middleware.RateLimitMiddleware(rateLimits, middleware.UserRateLimit, middleware.KeyByUser)
 
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler()
-	userHandler := handlers.NewUserHandler()
-	jobHandler := handlers.NewJobHandler()
-	equipmentHandler := handlers.NewEquipmentHandler()
-	paymentHandler := handlers.NewPaymentHandler()
-	uploadHandler := handlers.NewUploadHandler()
-	adminHandler := handlers.NewAdminHandler()
-
-	// Health check
-	r.GET("/health", func(c *gin.Context) {
-		c.JSON(200, gin.H{
-			"status": "healthy",
-			"timestamp": time.Now().Unix(),
-		})
-	})
+	authHandler := handlers.NewAuthHandler(c.Users, c.OAuth)
+	userHandler := handlers.NewUserHandler(c.Users)
+	jobHandler := handlers.NewJobHandler(c.Jobs)
+	equipmentHandler := handlers.NewEquipmentHandler(c.Equipment)
+	paymentHandler := handlers.NewPaymentHandler(c.Payments)
+	uploadHandler := handlers.NewUploadHandler(c.Uploads)
+	adminHandler := handlers.NewAdminHandler(c.Admin)
+	messageHandler := handlers.NewMessageHandler(c.Messages, c.Uploads)
+	notificationHandler := handlers.NewNotificationHandler(c.Notifications)
+	privacyHandler := handlers.NewPrivacyHandler(c.Privacy)
+	mfaHandler := handlers.NewMFAHandler(c.Users)
+	accessTokenHandler := handlers.NewAccessTokenHandler(c.AccessTokens)
+	webhookHandler := handlers.NewWebhookHandler(c.Webhooks)
+
+	// Health checks and metrics
+	healthHandler := handlers.NewHealthHandler(c.DB)
+	r.GET("/health", healthHandler.Ready)
+	r.GET("/health/live", healthHandler.Live)
+	r.GET("/health/ready", healthHandler.Ready)
+
+	// Public keys for verifying our access tokens
+	r.GET("/.well-known/jwks.json", authHandler.JWKS)
+
+	metrics.RegisterDB(func() *sql.DB { return database.SQLDB(c.DB) })
+	r.GET("/metrics", middleware.MetricsAuthMiddleware(), gin.WrapH(metrics.Handler()))
+
+	// Files for the local storage backend; S3 serves them itself
+	if local, ok := c.Storage.(*storage.LocalBackend); ok {
+		fileHandler := handlers.NewFileHandler(local)
+		r.GET(storage.LocalFilesPath+"/*key", fileHandler.GetFile)
+		r.HEAD(storage.LocalFilesPath+"/*key", fileHandler.GetFile)
+		r.PUT(storage.LocalFilesPath+"/*key", fileHandler.PutFile)
+	}
 
 	// Swagger documentation endpoint (commented out for Go 1.20 compatibility)
 	// r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
@@ -47,14 +93,18 @@ func SetupRoutes() *gin.Engine {
 		auth := api.Group("/auth")
 		{
 			auth.POST("/register", authHandler.Register)
-			auth.POST("/login", authHandler.Login)
+			auth.POST("/login", middleware.RateLimitMiddleware(rateLimits, middleware.LoginRateLimit, middleware.KeyByIP), authHandler.Login)
 			auth.POST("/refresh", authHandler.RefreshToken)
 			auth.POST("/logout", authHandler.Logout)
+			auth.POST("/oauth/:provider", middleware.RateLimitMiddleware(rateLimits, middleware.LoginRateLimit, middleware.KeyByIP), authHandler.OAuthLogin)
+			auth.POST("/unlock", middleware.RateLimitMiddleware(rateLimits, middleware.LoginRateLimit, middleware.KeyByIP), authHandler.UnlockAccount)
+			auth.POST("/mfa/verify", middleware.RateLimitMiddleware(rateLimits, middleware.LoginRateLimit, middleware.KeyByIP), mfaHandler.VerifyMFA)
+			auth.POST("/mfa/enroll", mfaHandler.EnrollWithChallenge)
 		}
 
 		// Public job listings (with optional auth for user-specific features)
 		jobs := api.Group("/jobs")
-		jobs.Use(middleware.OptionalAuthMiddleware())
+		jobs.Use(middleware.OptionalAuthMiddleware(c.AccessTokens, models.ScopeJobsRead), perUser)
 		{
 			jobs.GET("", jobHandler.GetJobs)
 			jobs.GET("/:id", jobHandler.GetJobByID)
@@ -62,7 +112,7 @@ func SetupRoutes() *gin.Engine {
 
 		// Public equipment listings (with optional auth for user-specific features)
 		equipment := api.Group("/equipment")
-		equipment.Use(middleware.OptionalAuthMiddleware())
+		equipment.Use(middleware.OptionalAuthMiddleware(c.AccessTokens), perUser)
 		{
 			equipment.GET("", equipmentHandler.GetEquipment)
 			equipment.GET("/:id", equipmentHandler.GetEquipmentByID)
@@ -78,40 +128,32 @@ func SetupRoutes() *gin.Engine {
 
 	// Protected routes (require authentication)
 	protected := api.Group("")
-	protected.Use(middleware.AuthMiddleware())
+	protected.Use(middleware.AuthMiddleware(c.AccessTokens), perUser)
 	{
 		// User management
 		users := protected.Group("/users")
 		{
 			users.GET("/me", userHandler.GetCurrentUser)
 			users.PUT("/me", userHandler.UpdateCurrentUser)
+			users.DELETE("/me", privacyHandler.DeleteAccount)
+			users.GET("/me/export", privacyHandler.ExportData)
+			users.GET("/me/data-requests/:id", privacyHandler.GetDataRequest)
 			users.POST("/me/insurance", userHandler.UploadInsuranceDocument)
-		}
-
-		// Job management
-		jobs := protected.Group("/jobs")
-		{
-			jobs.GET("/my", jobHandler.GetMyJobs)
-			jobs.POST("", jobHandler.CreateJob)
-			jobs.PUT("/:id", jobHandler.UpdateJob)
-			jobs.DELETE("/:id", jobHandler.DeleteJob)
-			jobs.POST("/:id/apply", jobHandler.ApplyForJob)
-			jobs.GET("/:id/applications", jobHandler.GetJobApplications)
-			jobs.PUT("/:id/applications/:app_id", jobHandler.UpdateApplicationStatus)
-			jobs.POST("/:id/complete", middleware.InsuranceRequiredMiddleware(), jobHandler.CompleteJob)
-		}
-
-		// Equipment management
-		equipment := protected.Group("/equipment")
-		{
-			equipment.GET("/my", equipmentHandler.GetMyEquipment)
-			equipment.POST("", equipmentHandler.CreateEquipment)
-			equipment.PUT("/:id", equipmentHandler.UpdateEquipment)
-			equipment.DELETE("/:id", equipmentHandler.DeleteEquipment)
-			equipment.POST("/:id/rent", equipmentHandler.RequestRental)
-			equipment.GET("/:id/rentals", equipmentHandler.GetEquipmentRentals)
-			equipment.PUT("/:id/rentals/:rental_id", equipmentHandler.UpdateRentalStatus)
-			equipment.POST("/rentals/:rental_id/complete", middleware.InsuranceRequiredMiddleware(), equipmentHandler.CompleteRental)
+			users.POST("/me/oauth/:provider", authHandler.LinkOAuth)
+			users.GET("/me/notification-preferences", notificationHandler.GetPreferences)
+			users.PUT("/me/notification-preferences", notificationHandler.UpdatePreferences)
+			users.POST("/me/mfa/enroll", mfaHandler.Enroll)
+			users.POST("/me/mfa/confirm", mfaHandler.Confirm)
+			users.DELETE("/me/mfa", mfaHandler.Disable)
+			users.POST("/me/mfa/recovery-codes", mfaHandler.RegenerateRecoveryCodes)
+			users.POST("/me/tokens", accessTokenHandler.CreateToken)
+			users.GET("/me/tokens", accessTokenHandler.GetTokens)
+			users.DELETE("/me/tokens/:id", accessTokenHandler.RevokeToken)
+			users.POST("/me/webhooks", webhookHandler.CreateWebhook)
+			users.GET("/me/webhooks", webhookHandler.GetWebhooks)
+			users.DELETE("/me/webhooks/:id", webhookHandler.DeleteWebhook)
+			users.GET("/me/webhooks/:id/deliveries", webhookHandler.GetDeliveries)
+			users.POST("/me/webhooks/:id/deliveries/:delivery_id/replay", webhookHandler.ReplayDelivery)
 		}
 
 		// Payment processing
@@ -119,19 +161,68 @@ func SetupRoutes() *gin.Engine {
 		{
 			payments.POST("/create-intent", paymentHandler.CreatePaymentIntent)
 			payments.POST("/confirm", paymentHandler.ConfirmPayment)
-			payments.GET("/history", paymentHandler.GetPaymentHistory)
-			payments.GET("/:id", paymentHandler.GetPaymentByID)
 		}
 
 		// File upload
 		upload := protected.Group("/upload")
+		uploadLimit := middleware.RateLimitMiddleware(rateLimits, middleware.UploadRateLimit, middleware.KeyByUser)
 		{
-			upload.POST("/image", uploadHandler.UploadImage)
-			upload.POST("/presigned-url", uploadHandler.GetPresignedUploadURL)
+			upload.POST("/image", uploadLimit, uploadHandler.UploadImage)
+			upload.POST("/presigned-url", uploadLimit, uploadHandler.GetPresignedUploadURL)
+			upload.POST("/sessions", uploadLimit, uploadHandler.CreateUploadSession)
+			upload.GET("/sessions/:id", uploadHandler.GetUploadSession)
+			upload.POST("/sessions/:id/complete", uploadHandler.CompleteUploadSession)
 			upload.DELETE("/file", uploadHandler.DeleteFile)
+			upload.GET("/file-url", uploadHandler.GetFileURL)
+		}
+
+		// Messaging between job posters/applicants and equipment owners/renters
+		conversations := protected.Group("/conversations")
+		{
+			conversations.GET("", messageHandler.GetConversations)
+			conversations.POST("", messageHandler.StartConversation)
+			conversations.GET("/:id/messages", messageHandler.GetMessages)
+			conversations.POST("/:id/messages", messageHandler.SendMessage)
+			conversations.PUT("/:id/read", messageHandler.MarkConversationRead)
 		}
 	}
 
+	// Routes integrations can also call with a personal access token that has
+	// the scope
+	jobsRead := api.Group("/jobs", middleware.AuthMiddleware(c.AccessTokens, models.ScopeJobsRead), perUser)
+	{
+		jobsRead.GET("/my", jobHandler.GetMyJobs)
+		jobsRead.GET("/:id/applications", jobHandler.GetJobApplications)
+	}
+
+	jobsWrite := api.Group("/jobs", middleware.AuthMiddleware(c.AccessTokens, models.ScopeJobsWrite), perUser)
+	{
+		jobsWrite.POST("", jobHandler.CreateJob)
+		jobsWrite.PUT("/:id", jobHandler.UpdateJob)
+		jobsWrite.DELETE("/:id", jobHandler.DeleteJob)
+		jobsWrite.POST("/:id/apply", middleware.RateLimitMiddleware(rateLimits, middleware.ApplyRateLimit, middleware.KeyByUser), jobHandler.ApplyForJob)
+		jobsWrite.PUT("/:id/applications/:app_id", jobHandler.UpdateApplicationStatus)
+		jobsWrite.POST("/:id/complete", middleware.InsuranceRequiredMiddleware(c.Users), jobHandler.CompleteJob)
+	}
+
+	equipmentWrite := api.Group("/equipment", middleware.AuthMiddleware(c.AccessTokens, models.ScopeEquipmentWrite), perUser)
+	{
+		equipmentWrite.GET("/my", equipmentHandler.GetMyEquipment)
+		equipmentWrite.POST("", equipmentHandler.CreateEquipment)
+		equipmentWrite.PUT("/:id", equipmentHandler.UpdateEquipment)
+		equipmentWrite.DELETE("/:id", equipmentHandler.DeleteEquipment)
+		equipmentWrite.POST("/:id/rent", equipmentHandler.RequestRental)
+		equipmentWrite.GET("/:id/rentals", equipmentHandler.GetEquipmentRentals)
+		equipmentWrite.PUT("/:id/rentals/:rental_id", equipmentHandler.UpdateRentalStatus)
+		equipmentWrite.POST("/rentals/:rental_id/complete", middleware.InsuranceRequiredMiddleware(c.Users), equipmentHandler.CompleteRental)
+	}
+
+	paymentsRead := api.Group("/payments", middleware.AuthMiddleware(c.AccessTokens, models.ScopePaymentsRead), perUser)
+	{
+		paymentsRead.GET("/history", paymentHandler.GetPaymentHistory)
+		paymentsRead.GET("/:id", paymentHandler.GetPaymentByID)
+	}
+
 	// Admin routes (require admin API key)
 	admin := api.Group("/admin")
 	admin.Use(middleware.AdminMiddleware())
@@ -141,9 +232,42 @@ func SetupRoutes() *gin.Engine {
 		admin.PUT("/users/:id/deactivate", adminHandler.DeactivateUser)
 		admin.PUT("/users/:id/activate", adminHandler.ActivateUser)
 		admin.PUT("/users/:id/verify-insurance", adminHandler.VerifyInsurance)
+		admin.PUT("/users/:id/role", adminHandler.SetUserRole)
+		admin.PUT("/users/:id/payout-account", adminHandler.SetPayoutAccount)
+		admin.GET("/locked-accounts", adminHandler.GetLockedAccounts)
+		admin.POST("/users/:id/unlock", adminHandler.UnlockUser)
+		admin.DELETE("/users/:id", adminHandler.RemoveUser)
+		admin.POST("/users/:id/restore", adminHandler.RestoreUser)
 		admin.DELETE("/jobs/:id", adminHandler.RemoveJob)
+		admin.POST("/jobs/:id/restore", adminHandler.RestoreJob)
 		admin.DELETE("/equipment/:id", adminHandler.RemoveEquipment)
+		admin.POST("/equipment/:id/restore", adminHandler.RestoreEquipment)
+		admin.POST("/purge-deleted", adminHandler.PurgeDeleted)
+		admin.GET("/conversations/:id/messages", messageHandler.GetConversationMessagesAdmin)
+		admin.GET("/files/url", uploadHandler.GetFileURLAdmin)
+		admin.DELETE("/files", uploadHandler.DeleteFileAdmin)
+		admin.POST("/uploads/gc", uploadHandler.CollectGarbage)
+		admin.GET("/tasks", adminHandler.GetTasks)
+		admin.POST("/tasks/:id/retry", adminHandler.RetryTask)
+		admin.POST("/webhooks", webhookHandler.CreateAppWebhook)
+		admin.GET("/webhooks", webhookHandler.GetAppWebhooks)
+		admin.DELETE("/webhooks/:id", webhookHandler.DeleteAppWebhook)
+		admin.GET("/webhooks/:id/deliveries", webhookHandler.GetAppDeliveries)
+		admin.POST("/webhooks/:id/deliveries/:delivery_id/replay", webhookHandler.ReplayAppDelivery)
 	}
 
 	return r
+}
+
+// RegisterStreamingRoutes adds long-lived streaming endpoints. They need a
+// persistent connection, so only the local server registers them; API Gateway
+// proxied Lambda responses cannot stream.
+func RegisterStreamingRoutes(r *gin.Engine, c *services.Container) {
+	eventHandler := handlers.NewEventHandler(c.Bus)
+
+	stream := r.Group("/v1/events")
+	stream.Use(middleware.AuthMiddleware(c.AccessTokens))
+	{
+		stream.GET("/stream", eventHandler.Stream)
+	}
 }
\ No newline at end of file
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/utils"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// PersonalAccessTokenPrefix starts every personal access token, so they
+	// can be told apart from JWTs and spotted by secret scanners.
+	PersonalAccessTokenPrefix = "mowsy_pat_"
+
+	DefaultAccessTokenDays = 90
+	MaxAccessTokenDays     = 365
+
+	// maxAccessTokens caps the unrevoked tokens one user can hold.
+	maxAccessTokens = 20
+
+	// accessTokenPrefixLength is how much of the token is kept in the clear.
+	accessTokenPrefixLength = len(PersonalAccessTokenPrefix) + 6
+
+	// accessTokenUseInterval limits how often last_used_at is written, so a
+	// busy integration doesn't update the row on every request.
+	accessTokenUseInterval = time.Minute
+)
+
+var (
+	ErrInvalidAccessToken  = errors.New("invalid or expired access token")
+	ErrAccessTokenNotFound = errors.New("access token not found")
+	ErrTooManyAccessTokens = fmt.Errorf("a user can have at most %d access tokens", maxAccessTokens)
+	ErrInvalidTokenScope   = errors.New("unknown token scope")
+	ErrInvalidTokenExpiry  = fmt.Errorf("expires_in_days must be between 1 and %d", MaxAccessTokenDays)
+)
+
+type AccessTokenService struct {
+	db  *gorm.DB
+	now func() time.Time
+}
+
+type CreateAccessTokenRequest struct {
+	Name   string              `json:"name" binding:"required,max=100"`
+	Scopes []models.TokenScope `json:"scopes" binding:"required,min=1"`
+	// ExpiresInDays defaults to 90
+	ExpiresInDays int `json:"expires_in_days"`
+}
+
+// CreatedAccessToken is returned once, when the token is created. The
+// token itself cannot be retrieved later.
+type CreatedAccessToken struct {
+	models.PersonalAccessToken
+	Token string `json:"token"`
+}
+
+// IsPersonalAccessToken reports whether a bearer credential is a personal
+// access token rather than a JWT.
+func IsPersonalAccessToken(credential string) bool {
+	return strings.HasPrefix(credential, PersonalAccessTokenPrefix)
+}
+
+func hashAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *AccessTokenService) CreateToken(userID uint, req CreateAccessTokenRequest) (*CreatedAccessToken, error) {
+	days := req.ExpiresInDays
+	if days == 0 {
+		days = DefaultAccessTokenDays
+	}
+	if days < 1 || days > MaxAccessTokenDays {
+		return nil, ErrInvalidTokenExpiry
+	}
+
+	scopes := models.StringArray{}
+	for _, scope := range req.Scopes {
+		if !slices.Contains(models.TokenScopes, scope) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidTokenScope, scope)
+		}
+		if !slices.Contains(scopes, string(scope)) {
+			scopes = append(scopes, string(scope))
+		}
+	}
+
+	var active int64
+	if err := s.db.Model(&models.PersonalAccessToken{}).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, s.now()).
+		Count(&active).Error; err != nil {
+		return nil, fmt.Errorf("failed to count access tokens: %w", err)
+	}
+	if active >= maxAccessTokens {
+		return nil, ErrTooManyAccessTokens
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate access token: %w", err)
+	}
+	token := PersonalAccessTokenPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	record := models.PersonalAccessToken{
+		UserID:    userID,
+		Name:      utils.SanitizeString(req.Name),
+		TokenHash: hashAccessToken(token),
+		Prefix:    token[:accessTokenPrefixLength],
+		Scopes:    scopes,
+		ExpiresAt: s.now().AddDate(0, 0, days),
+	}
+	if err := s.db.Create(&record).Error; err != nil {
+		return nil, fmt.Errorf("failed to create access token: %w", err)
+	}
+
+	return &CreatedAccessToken{PersonalAccessToken: record, Token: token}, nil
+}
+
+// GetTokens lists the user's unrevoked tokens, including expired ones, newest
+// first.
+func (s *AccessTokenService) GetTokens(userID uint) ([]models.PersonalAccessToken, error) {
+	var tokens []models.PersonalAccessToken
+	if err := s.db.Where("user_id = ? AND revoked_at IS NULL", userID).
+		Order("created_at DESC, id DESC").Find(&tokens).Error; err != nil {
+		return nil, fmt.Errorf("failed to get access tokens: %w", err)
+	}
+	return tokens, nil
+}
+
+func (s *AccessTokenService) RevokeToken(userID, tokenID uint) error {
+	result := s.db.Model(&models.PersonalAccessToken{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", tokenID, userID).
+		Update("revoked_at", s.now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke access token: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrAccessTokenNotFound
+	}
+	return nil
+}
+
+// Authenticate finds the active token and its owner. Tokens of deactivated
+// or removed users stop working with them.
+func (s *AccessTokenService) Authenticate(credential string) (*models.PersonalAccessToken, *models.User, error) {
+	if s.db == nil || !IsPersonalAccessToken(credential) {
+		return nil, nil, ErrInvalidAccessToken
+	}
+
+	now := s.now()
+	var token models.PersonalAccessToken
+	err := s.db.Where("token_hash = ? AND revoked_at IS NULL AND expires_at > ?", hashAccessToken(credential), now).
+		First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidAccessToken
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find access token: %w", err)
+	}
+
+	var user models.User
+	err = s.db.Where("id = ? AND is_active = ?", token.UserID, true).First(&user).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidAccessToken
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find token owner: %w", err)
+	}
+
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= accessTokenUseInterval {
+		if err := s.db.Model(&token).Update("last_used_at", now).Error; err != nil {
+			// Not worth failing the request over
+			slog.Warn("failed to record access token use", "token_id", token.ID, "error", err)
+		}
+	}
+
+	return &token, &user, nil
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/testutils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupAccessTokenService(t *testing.T) (*AccessTokenService, *gorm.DB, *time.Time) {
+	db := testutils.SetupTestDB()
+	t.Cleanup(func() { testutils.CleanupTestDB(db) })
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	service := &AccessTokenService{db: db, now: func() time.Time { return now }}
+	return service, db, &now
+}
+
+func TestAccessTokenService_CreateToken(t *testing.T) {
+	service, db, now := setupAccessTokenService(t)
+	user := testutils.CreateTestUser(db)
+
+	created, err := service.CreateToken(user.ID, CreateAccessTokenRequest{
+		Name:   "Scheduler",
+		Scopes: []models.TokenScope{models.ScopeJobsRead, models.ScopeJobsWrite, models.ScopeJobsRead},
+	})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(created.Token, PersonalAccessTokenPrefix))
+	assert.True(t, strings.HasPrefix(created.Token, created.Prefix))
+	assert.Equal(t, models.StringArray{"jobs:read", "jobs:write"}, created.Scopes)
+	assert.Equal(t, now.AddDate(0, 0, DefaultAccessTokenDays), created.ExpiresAt)
+
+	var stored models.PersonalAccessToken
+	require.NoError(t, db.First(&stored, created.ID).Error)
+	assert.NotContains(t, stored.TokenHash, created.Token)
+	assert.Equal(t, hashAccessToken(created.Token), stored.TokenHash)
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := service.CreateToken(user.ID, CreateAccessTokenRequest{Name: "x", Scopes: []models.TokenScope{"admin"}})
+		assert.ErrorIs(t, err, ErrInvalidTokenScope)
+		_, err = service.CreateToken(user.ID, CreateAccessTokenRequest{Name: "x", Scopes: []models.TokenScope{models.ScopeJobsRead}, ExpiresInDays: MaxAccessTokenDays + 1})
+		assert.ErrorIs(t, err, ErrInvalidTokenExpiry)
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		for i := 1; i < maxAccessTokens; i++ {
+			_, err := service.CreateToken(user.ID, CreateAccessTokenRequest{Name: "bulk", Scopes: []models.TokenScope{models.ScopeJobsRead}})
+			require.NoError(t, err)
+		}
+		_, err := service.CreateToken(user.ID, CreateAccessTokenRequest{Name: "one too many", Scopes: []models.TokenScope{models.ScopeJobsRead}})
+		assert.ErrorIs(t, err, ErrTooManyAccessTokens)
+	})
+}
+
+func TestAccessTokenService_Authenticate(t *testing.T) {
+	service, db, now := setupAccessTokenService(t)
+	user := testutils.CreateTestUser(db)
+
+	created, err := service.CreateToken(user.ID, CreateAccessTokenRequest{
+		Name: "Scheduler", Scopes: []models.TokenScope{models.ScopePaymentsRead}, ExpiresInDays: 30,
+	})
+	require.NoError(t, err)
+
+	token, owner, err := service.Authenticate(created.Token)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, owner.ID)
+	assert.True(t, token.HasScope(models.ScopePaymentsRead))
+	assert.False(t, token.HasScope(models.ScopeJobsWrite))
+	require.NotNil(t, token.LastUsedAt)
+	assert.True(t, now.Equal(*token.LastUsedAt))
+
+	t.Run("LastUsedIsCoarse", func(t *testing.T) {
+		first := *now
+		*now = now.Add(10 * time.Second)
+		service.Authenticate(created.Token)
+
+		var stored models.PersonalAccessToken
+		require.NoError(t, db.First(&stored, created.ID).Error)
+		assert.True(t, first.Equal(*stored.LastUsedAt))
+
+		*now = now.Add(accessTokenUseInterval)
+		service.Authenticate(created.Token)
+		require.NoError(t, db.First(&stored, created.ID).Error)
+		assert.True(t, now.Equal(*stored.LastUsedAt))
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		_, _, err := service.Authenticate(PersonalAccessTokenPrefix + "nope")
+		assert.ErrorIs(t, err, ErrInvalidAccessToken)
+	})
+
+	t.Run("DeactivatedUser", func(t *testing.T) {
+		require.NoError(t, db.Model(user).Update("is_active", false).Error)
+		defer db.Model(user).Update("is_active", true)
+
+		_, _, err := service.Authenticate(created.Token)
+		assert.ErrorIs(t, err, ErrInvalidAccessToken)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		saved := *now
+		defer func() { *now = saved }()
+		*now = created.ExpiresAt
+
+		_, _, err := service.Authenticate(created.Token)
+		assert.ErrorIs(t, err, ErrInvalidAccessToken)
+	})
+
+	t.Run("Revoked", func(t *testing.T) {
+		assert.ErrorIs(t, service.RevokeToken(user.ID+1, created.ID), ErrAccessTokenNotFound, "only the owner can revoke")
+
+		require.NoError(t, service.RevokeToken(user.ID, created.ID))
+		_, _, err := service.Authenticate(created.Token)
+		assert.ErrorIs(t, err, ErrInvalidAccessToken)
+		assert.ErrorIs(t, service.RevokeToken(user.ID, created.ID), ErrAccessTokenNotFound)
+
+		tokens, err := service.GetTokens(user.ID)
+		require.NoError(t, err)
+		assert.Empty(t, tokens)
+	})
+}
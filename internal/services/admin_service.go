@@ -3,10 +3,11 @@ package services
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"mowsy-api/internal/models"
-	"mowsy-api/pkg/database"
+	"mowsy-api/internal/tasks"
 
 	"gorm.io/gorm"
 )
@@ -15,12 +16,6 @@ type AdminService struct {
 	db *gorm.DB
 }
 
-func NewAdminService() *AdminService {
-	return &AdminService{
-		db: database.GetDB(),
-	}
-}
-
 type AdminUserListFilters struct {
 	IsActive            *bool  `form:"is_active"`
 	InsuranceVerified   *bool  `form:"insurance_verified"`
@@ -103,6 +98,59 @@ func (s *AdminService) ActivateUser(userID uint) error {
 	return nil
 }
 
+type SetUserRoleRequest struct {
+	Role models.UserRole `json:"role" binding:"required"`
+}
+
+// SetUserRole changes a user's role. Giving someone a role that requires 2FA
+// takes effect at their next sign-in, when they are asked to enroll.
+func (s *AdminService) SetUserRole(userID uint, role models.UserRole) error {
+	if role != models.UserRoleUser && role != models.UserRoleAdmin {
+		return errors.New("invalid role")
+	}
+
+	var user models.User
+	if err := s.db.Where("id = ?", userID).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("user not found")
+		}
+		return fmt.Errorf("failed to find user: %w", err)
+	}
+
+	if err := s.db.Model(&user).Update("role", role).Error; err != nil {
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+
+	return nil
+}
+
+type SetPayoutAccountRequest struct {
+	StripeAccountID string `json:"stripe_account_id"`
+}
+
+// SetPayoutAccount records the Stripe connected account an equipment owner
+// is paid out to once their rentals complete. An empty ID stops payouts.
+func (s *AdminService) SetPayoutAccount(userID uint, accountID string) error {
+	accountID = strings.TrimSpace(accountID)
+	if accountID != "" && !strings.HasPrefix(accountID, "acct_") {
+		return errors.New("invalid Stripe account ID")
+	}
+
+	var user models.User
+	if err := s.db.Where("id = ?", userID).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("user not found")
+		}
+		return fmt.Errorf("failed to find user: %w", err)
+	}
+
+	if err := s.db.Model(&user).Update("stripe_account_id", accountID).Error; err != nil {
+		return fmt.Errorf("failed to update payout account: %w", err)
+	}
+
+	return nil
+}
+
 func (s *AdminService) VerifyInsurance(userID uint) error {
 	var user models.User
 	if err := s.db.Where("id = ?", userID).First(&user).Error; err != nil {
@@ -112,7 +160,7 @@ func (s *AdminService) VerifyInsurance(userID uint) error {
 		return fmt.Errorf("failed to find user: %w", err)
 	}
 
-	if user.InsuranceDocumentURL == "" {
+	if user.InsuranceDocumentURL == "" && user.InsuranceDocumentKey == "" {
 		return errors.New("user has not uploaded insurance document")
 	}
 
@@ -172,6 +220,150 @@ func (s *AdminService) RemoveEquipment(equipmentID uint) error {
 	return nil
 }
 
+// RestoreJob undoes RemoveJob or DeleteJob. A job cannot come back while
+// its owner is deleted.
+func (s *AdminService) RestoreJob(jobID uint) error {
+	var job models.Job
+	if err := s.db.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", jobID).First(&job).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("deleted job not found")
+		}
+		return fmt.Errorf("failed to find job: %w", err)
+	}
+
+	if err := requireLiveOwner(s.db, job.UserID); err != nil {
+		return err
+	}
+
+	if err := s.db.Unscoped().Model(&job).Update("deleted_at", nil).Error; err != nil {
+		return fmt.Errorf("failed to restore job: %w", err)
+	}
+
+	return nil
+}
+
+func (s *AdminService) RestoreEquipment(equipmentID uint) error {
+	var equipment models.Equipment
+	if err := s.db.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", equipmentID).First(&equipment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("deleted equipment not found")
+		}
+		return fmt.Errorf("failed to find equipment: %w", err)
+	}
+
+	if err := requireLiveOwner(s.db, equipment.UserID); err != nil {
+		return err
+	}
+
+	if err := s.db.Unscoped().Model(&equipment).Update("deleted_at", nil).Error; err != nil {
+		return fmt.Errorf("failed to restore equipment: %w", err)
+	}
+
+	return nil
+}
+
+func requireLiveOwner(db *gorm.DB, userID uint) error {
+	var count int64
+	if err := db.Model(&models.User{}).Where("id = ?", userID).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to check owner: %w", err)
+	}
+	if count == 0 {
+		return errors.New("owner is deleted; restore the user first")
+	}
+	return nil
+}
+
+// RemoveUser soft-deletes a user together with their job postings and
+// equipment listings, all stamped with the same time so RestoreUser can
+// bring back exactly what was removed with them. Users in the middle of a
+// job or rental cannot be removed.
+func (s *AdminService) RemoveUser(userID uint) error {
+	var user models.User
+	if err := s.db.Where("id = ?", userID).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("user not found")
+		}
+		return fmt.Errorf("failed to find user: %w", err)
+	}
+
+	busy, err := hasActiveWork(s.db, userID)
+	if err != nil {
+		return err
+	}
+	if busy {
+		return errors.New("cannot remove user with jobs in progress or active rentals")
+	}
+
+	now := time.Now()
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Job{}).Where("user_id = ?", userID).Update("deleted_at", now).Error; err != nil {
+			return fmt.Errorf("failed to remove user's jobs: %w", err)
+		}
+		if err := tx.Model(&models.Equipment{}).Where("user_id = ?", userID).Update("deleted_at", now).Error; err != nil {
+			return fmt.Errorf("failed to remove user's equipment: %w", err)
+		}
+		if err := tx.Model(&user).Update("deleted_at", now).Error; err != nil {
+			return fmt.Errorf("failed to remove user: %w", err)
+		}
+		return nil
+	})
+}
+
+// hasActiveWork reports whether a user has a job in progress, or an approved
+// or active rental as either renter or owner.
+func hasActiveWork(db *gorm.DB, userID uint) (bool, error) {
+	var inProgress int64
+	if err := db.Model(&models.Job{}).
+		Where("user_id = ? AND status = ?", userID, models.JobStatusInProgress).
+		Count(&inProgress).Error; err != nil {
+		return false, fmt.Errorf("failed to check jobs: %w", err)
+	}
+
+	var activeRentals int64
+	if err := db.Model(&models.EquipmentRental{}).
+		Where("status IN ?", []models.RentalStatus{models.RentalStatusApproved, models.RentalStatusActive}).
+		Where("renter_user_id = ? OR equipment_id IN (?)", userID,
+			db.Model(&models.Equipment{}).Select("id").Where("user_id = ?", userID)).
+		Count(&activeRentals).Error; err != nil {
+		return false, fmt.Errorf("failed to check active rentals: %w", err)
+	}
+
+	return inProgress > 0 || activeRentals > 0, nil
+}
+
+// RestoreUser undoes RemoveUser. Listings deleted separately, before or
+// after the user, stay deleted.
+func (s *AdminService) RestoreUser(userID uint) error {
+	var user models.User
+	if err := s.db.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", userID).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("deleted user not found")
+		}
+		return fmt.Errorf("failed to find user: %w", err)
+	}
+	if user.Email == erasedEmail(user.ID) {
+		return errors.New("user erased their data and cannot be restored")
+	}
+
+	deletedAt := user.DeletedAt.Time
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&models.Job{}).
+			Where("user_id = ? AND deleted_at = ?", userID, deletedAt).
+			Update("deleted_at", nil).Error; err != nil {
+			return fmt.Errorf("failed to restore user's jobs: %w", err)
+		}
+		if err := tx.Unscoped().Model(&models.Equipment{}).
+			Where("user_id = ? AND deleted_at = ?", userID, deletedAt).
+			Update("deleted_at", nil).Error; err != nil {
+			return fmt.Errorf("failed to restore user's equipment: %w", err)
+		}
+		if err := tx.Unscoped().Model(&user).Update("deleted_at", nil).Error; err != nil {
+			return fmt.Errorf("failed to restore user: %w", err)
+		}
+		return nil
+	})
+}
+
 type AdminStats struct {
 	TotalUsers              int64 `json:"total_users"`
 	ActiveUsers             int64 `json:"active_users"`
@@ -244,4 +436,78 @@ func (s *AdminService) GetStats() (*AdminStats, error) {
 	}
 
 	return stats, nil
-}
\ No newline at end of file
+}
+type AdminTaskListFilters struct {
+	Status models.TaskStatus `form:"status"`
+	Kind   string            `form:"kind"`
+	Page   int               `form:"page"`
+	Limit  int               `form:"limit"`
+}
+
+// GetTasks lists background tasks, newest first. Filter by status=dead to see
+// the dead-letter queue.
+func (s *AdminService) GetTasks(filters AdminTaskListFilters) ([]models.Task, error) {
+	query := s.db.Model(&models.Task{})
+
+	if filters.Status != "" {
+		query = query.Where("status = ?", filters.Status)
+	}
+
+	if filters.Kind != "" {
+		query = query.Where("kind = ?", filters.Kind)
+	}
+
+	if filters.Page <= 0 {
+		filters.Page = 1
+	}
+	if filters.Limit <= 0 || filters.Limit > 100 {
+		filters.Limit = 20
+	}
+
+	offset := (filters.Page - 1) * filters.Limit
+
+	var taskList []models.Task
+	if err := query.Order("id DESC").Offset(offset).Limit(filters.Limit).Find(&taskList).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch tasks: %w", err)
+	}
+
+	return taskList, nil
+}
+
+func (s *AdminService) RetryTask(taskID uint) error {
+	return tasks.NewQueue(s.db).Retry(taskID)
+}
+
+// LockedAccount is an account that is locked out after failed sign-ins.
+type LockedAccount struct {
+	UserID        uint      `json:"user_id"`
+	Email         string    `json:"email"`
+	Failures      int       `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+	LockedUntil   time.Time `json:"locked_until"`
+}
+
+// GetLockedAccounts lists accounts whose lockout has not yet expired, the
+// longest-locked first.
+func (s *AdminService) GetLockedAccounts() ([]LockedAccount, error) {
+	var accounts []LockedAccount
+	err := s.db.Table("login_failures").
+		Select("login_failures.user_id, users.email, login_failures.failures, login_failures.last_failure_at, login_failures.locked_until").
+		Joins("JOIN users ON users.id = login_failures.user_id").
+		Where("login_failures.locked_until > ?", time.Now()).
+		Order("login_failures.locked_until DESC").
+		Scan(&accounts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch locked accounts: %w", err)
+	}
+
+	return accounts, nil
+}
+
+// UnlockUser clears the user's failed sign-in count, lifting any lockout.
+func (s *AdminService) UnlockUser(userID uint) error {
+	if err := s.db.Where("key = ?", accountLoginKey(userID)).Delete(&models.LoginFailure{}).Error; err != nil {
+		return fmt.Errorf("failed to unlock user: %w", err)
+	}
+	return nil
+}
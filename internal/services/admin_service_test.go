@@ -0,0 +1,264 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/testutils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupAdminService() (*AdminService, *gorm.DB) {
+	db := testutils.SetupTestDB()
+	return &AdminService{db: db}, db
+}
+
+func TestAdminService_RemoveAndRestoreJob(t *testing.T) {
+	service, db := setupAdminService()
+	defer testutils.CleanupTestDB(db)
+	jobService := &JobService{db: db}
+
+	poster := testutils.CreateTestUser(db)
+	applicant := createApplicant(t, db, "applicant@example.com")
+	job := testutils.CreateTestJob(db, poster.ID)
+	application := &models.JobApplication{JobID: job.ID, UserID: applicant.ID, Status: models.ApplicationStatusPending}
+	require.NoError(t, db.Create(application).Error)
+
+	require.NoError(t, service.RemoveJob(job.ID))
+
+	_, err := jobService.GetJobByID(job.ID)
+	assert.Error(t, err)
+
+	// The application goes with its job
+	err = jobService.UpdateApplicationStatus(job.ID, application.ID, poster.ID, models.ApplicationStatusAccepted)
+	assert.Error(t, err)
+	_, _, err = (&MessageService{db: db}).resolveParticipants(models.ConversationTypeJobApplication, application.ID)
+	assert.Error(t, err)
+
+	require.NoError(t, service.RestoreJob(job.ID))
+
+	applications, err := jobService.GetJobApplications(job.ID, poster.ID)
+	require.NoError(t, err)
+	assert.Len(t, applications, 1)
+
+	assert.Error(t, service.RestoreJob(job.ID), "job is no longer deleted")
+}
+
+func TestAdminService_RemoveAndRestoreEquipment(t *testing.T) {
+	service, db := setupAdminService()
+	defer testutils.CleanupTestDB(db)
+	equipmentService := &EquipmentService{db: db}
+
+	owner := testutils.CreateTestUser(db)
+	renter := createApplicant(t, db, "renter@example.com")
+	equipment := testutils.CreateTestEquipment(db, owner.ID)
+	rental := &models.EquipmentRental{
+		EquipmentID:  equipment.ID,
+		RenterUserID: renter.ID,
+		StartDate:    time.Now(),
+		EndDate:      time.Now().Add(24 * time.Hour),
+		Status:       models.RentalStatusRequested,
+	}
+	require.NoError(t, db.Create(rental).Error)
+
+	require.NoError(t, service.RemoveEquipment(equipment.ID))
+
+	err := equipmentService.UpdateRentalStatus(equipment.ID, rental.ID, owner.ID, models.RentalStatusApproved)
+	assert.Error(t, err)
+
+	require.NoError(t, service.RestoreEquipment(equipment.ID))
+
+	rentals, err := equipmentService.GetEquipmentRentals(equipment.ID, owner.ID)
+	require.NoError(t, err)
+	assert.Len(t, rentals, 1)
+}
+
+func TestAdminService_RemoveAndRestoreUser(t *testing.T) {
+	service, db := setupAdminService()
+	defer testutils.CleanupTestDB(db)
+
+	user := testutils.CreateTestUser(db)
+	job := testutils.CreateTestJob(db, user.ID)
+	equipment := testutils.CreateTestEquipment(db, user.ID)
+	earlierJob := testutils.CreateTestJob(db, user.ID)
+	require.NoError(t, service.RemoveJob(earlierJob.ID))
+
+	t.Run("RefusedWhileJobInProgress", func(t *testing.T) {
+		busy := testutils.CreateTestJob(db, user.ID)
+		db.Model(busy).Update("status", models.JobStatusInProgress)
+		defer db.Unscoped().Delete(busy)
+
+		err := service.RemoveUser(user.ID)
+
+		assert.ErrorContains(t, err, "jobs in progress")
+	})
+
+	t.Run("RemoveCascades", func(t *testing.T) {
+		require.NoError(t, service.RemoveUser(user.ID))
+
+		var count int64
+		db.Model(&models.User{}).Where("id = ?", user.ID).Count(&count)
+		assert.Equal(t, int64(0), count)
+		db.Model(&models.Job{}).Where("user_id = ?", user.ID).Count(&count)
+		assert.Equal(t, int64(0), count)
+		db.Model(&models.Equipment{}).Where("user_id = ?", user.ID).Count(&count)
+		assert.Equal(t, int64(0), count)
+
+		// Listings can't come back without their owner
+		assert.ErrorContains(t, service.RestoreJob(job.ID), "restore the user first")
+
+		// The email stays taken
+		_, err := (&UserService{db: db}).Register(RegisterRequest{
+			Email: user.Email, Password: "mower-shed-42", FirstName: "New", LastName: "User",
+		})
+		assert.ErrorContains(t, err, "already exists")
+	})
+
+	t.Run("RestoreBringsBackListingsRemovedWithUser", func(t *testing.T) {
+		require.NoError(t, service.RestoreUser(user.ID))
+
+		var restored models.User
+		require.NoError(t, db.First(&restored, user.ID).Error)
+		require.NoError(t, db.First(&models.Job{}, job.ID).Error)
+		require.NoError(t, db.First(&models.Equipment{}, equipment.ID).Error)
+
+		// Removed on its own before the user, so it stays removed
+		assert.ErrorIs(t, db.First(&models.Job{}, earlierJob.ID).Error, gorm.ErrRecordNotFound)
+	})
+}
+
+func TestAdminService_PurgeDeleted(t *testing.T) {
+	service, db := setupAdminService()
+	defer testutils.CleanupTestDB(db)
+
+	user := testutils.CreateTestUser(db)
+	applicant := createApplicant(t, db, "applicant@example.com")
+
+	stale := testutils.CreateTestJob(db, user.ID)
+	application := &models.JobApplication{JobID: stale.ID, UserID: applicant.ID}
+	require.NoError(t, db.Create(application).Error)
+	conversation := &models.Conversation{
+		Type:              models.ConversationTypeJobApplication,
+		RelatedID:         application.ID,
+		OwnerUserID:       user.ID,
+		ParticipantUserID: applicant.ID,
+	}
+	require.NoError(t, db.Create(conversation).Error)
+	require.NoError(t, db.Create(&models.Message{ConversationID: conversation.ID, SenderUserID: applicant.ID, Body: "hi"}).Error)
+
+	paid := testutils.CreateTestJob(db, user.ID)
+	require.NoError(t, db.Create(&models.Payment{
+		UserID: user.ID, StripePaymentIntentID: "pi_1", Type: models.PaymentTypeJobPayment, RelatedID: paid.ID,
+	}).Error)
+
+	recent := testutils.CreateTestJob(db, user.ID)
+	staleEquipment := testutils.CreateTestEquipment(db, user.ID)
+
+	for _, id := range []uint{stale.ID, paid.ID, recent.ID} {
+		require.NoError(t, service.RemoveJob(id))
+	}
+	require.NoError(t, service.RemoveEquipment(staleEquipment.ID))
+	old := time.Now().Add(-60 * 24 * time.Hour)
+	db.Unscoped().Model(&models.Job{}).Where("id IN ?", []uint{stale.ID, paid.ID}).Update("deleted_at", old)
+	db.Unscoped().Model(&models.Equipment{}).Where("id = ?", staleEquipment.ID).Update("deleted_at", old)
+
+	t.Run("DryRun", func(t *testing.T) {
+		report, err := service.PurgeDeleted(context.Background(), PurgeOptions{DryRun: true})
+		require.NoError(t, err)
+
+		assert.Equal(t, []uint{stale.ID}, report.Jobs.Purged)
+		assert.Equal(t, []uint{paid.ID}, report.Jobs.Kept)
+		assert.Equal(t, []uint{staleEquipment.ID}, report.Equipment.Purged)
+
+		var count int64
+		db.Unscoped().Model(&models.Job{}).Where("id = ?", stale.ID).Count(&count)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("Apply", func(t *testing.T) {
+		report, err := service.PurgeDeleted(context.Background(), PurgeOptions{})
+		require.NoError(t, err)
+
+		assert.Equal(t, []uint{stale.ID}, report.Jobs.Purged)
+
+		var count int64
+		db.Unscoped().Model(&models.Job{}).Where("id = ?", stale.ID).Count(&count)
+		assert.Equal(t, int64(0), count)
+		db.Model(&models.JobApplication{}).Where("id = ?", application.ID).Count(&count)
+		assert.Equal(t, int64(0), count)
+		db.Model(&models.Message{}).Where("conversation_id = ?", conversation.ID).Count(&count)
+		assert.Equal(t, int64(0), count)
+
+		// Paid and recently deleted jobs survive
+		db.Unscoped().Model(&models.Job{}).Where("id IN ?", []uint{paid.ID, recent.ID}).Count(&count)
+		assert.Equal(t, int64(2), count)
+	})
+
+	t.Run("UserPurgedOnceNothingRefersToIt", func(t *testing.T) {
+		db.Unscoped().Model(&models.User{}).Where("id = ?", applicant.ID).Update("deleted_at", time.Now().Add(-60*24*time.Hour))
+		require.NoError(t, db.Create(&models.LoginFailure{
+			Key: accountLoginKey(applicant.ID), UserID: &applicant.ID, Failures: 3, LastFailureAt: time.Now(),
+		}).Error)
+		require.NoError(t, db.Create(&models.MFARecoveryCode{UserID: applicant.ID, CodeHash: "purged-code"}).Error)
+		require.NoError(t, db.Create(&models.OAuthIdentity{UserID: applicant.ID, Provider: "google", Subject: "purged-subject"}).Error)
+		require.NoError(t, db.Create(&models.PersonalAccessToken{
+			UserID: applicant.ID, Name: "cli", TokenHash: "purged-token", Prefix: "mwsy_pat", ExpiresAt: time.Now().Add(time.Hour),
+		}).Error)
+		endpoint := &models.WebhookEndpoint{UserID: &applicant.ID, URL: "https://hooks.example.com", Secret: "whsec_purged"}
+		require.NoError(t, db.Create(endpoint).Error)
+		require.NoError(t, db.Create(&models.WebhookDelivery{EndpointID: endpoint.ID, EventID: "evt_purged", Event: "job.posted"}).Error)
+
+		report, err := service.PurgeDeleted(context.Background(), PurgeOptions{})
+		require.NoError(t, err)
+
+		assert.Equal(t, []uint{applicant.ID}, report.Users.Purged)
+
+		// Nothing keyed by the user is left behind
+		for _, model := range []interface{}{
+			&models.LoginFailure{},
+			&models.MFARecoveryCode{},
+			&models.OAuthIdentity{},
+			&models.PersonalAccessToken{},
+			&models.WebhookEndpoint{},
+		} {
+			var count int64
+			db.Model(model).Where("user_id = ?", applicant.ID).Count(&count)
+			assert.Zerof(t, count, "%T", model)
+		}
+		var deliveries int64
+		db.Model(&models.WebhookDelivery{}).Where("endpoint_id = ?", endpoint.ID).Count(&deliveries)
+		assert.Zero(t, deliveries)
+	})
+}
+
+func TestAdminService_LockedAccounts(t *testing.T) {
+	service, db := setupAdminService()
+	defer testutils.CleanupTestDB(db)
+
+	locked := testutils.CreateTestUser(db)
+	slowed := createApplicant(t, db, "slowed@example.com")
+	lockedUntil := time.Now().Add(accountLockoutDuration)
+	require.NoError(t, db.Create(&models.LoginFailure{
+		Key: accountLoginKey(locked.ID), UserID: &locked.ID, Failures: 10, LastFailureAt: time.Now(), LockedUntil: &lockedUntil,
+	}).Error)
+	require.NoError(t, db.Create(&models.LoginFailure{
+		Key: accountLoginKey(slowed.ID), UserID: &slowed.ID, Failures: 5, LastFailureAt: time.Now(),
+	}).Error)
+
+	accounts, err := service.GetLockedAccounts()
+	require.NoError(t, err)
+	require.Len(t, accounts, 1)
+	assert.Equal(t, locked.ID, accounts[0].UserID)
+	assert.Equal(t, locked.Email, accounts[0].Email)
+	assert.Equal(t, 10, accounts[0].Failures)
+
+	require.NoError(t, service.UnlockUser(locked.ID))
+	accounts, err = service.GetLockedAccounts()
+	require.NoError(t, err)
+	assert.Empty(t, accounts)
+}
@@ -0,0 +1,145 @@
+package services
+
+import (
+	"log/slog"
+	"time"
+
+	"mowsy-api/internal/events"
+	"mowsy-api/internal/tasks"
+	"mowsy-api/pkg/database"
+	"mowsy-api/pkg/notify"
+	"mowsy-api/pkg/oidc"
+	"mowsy-api/pkg/payments"
+	"mowsy-api/pkg/storage"
+
+	"gorm.io/gorm"
+)
+
+// Dependencies are the outside systems the services talk to. Tests fill them
+// in with SQLite and fakes; the binaries use DependenciesFromEnv.
+type Dependencies struct {
+	DB       *gorm.DB
+	Geocoder Geocoder
+	Payments payments.Gateway
+
+	// Storage holds uploaded files. When nil, uploads, attachments, data
+	// exports and erasure are disabled.
+	Storage storage.Backend
+
+	Notifiers notify.Drivers
+	Providers oidc.Providers
+	Bus       *events.Bus
+	Clock     func() time.Time
+}
+
+// DependenciesFromEnv connects to everything the environment configures.
+func DependenciesFromEnv() Dependencies {
+	db := database.GetDB()
+
+	// A misconfigured backend disables uploads rather than the whole API
+	var backend storage.Backend
+	if configured, err := storage.Default(); err != nil {
+		slog.Warn("file storage disabled", "error", err)
+	} else {
+		backend = configured
+	}
+
+	return Dependencies{
+		DB:        db,
+		Geocoder:  NewGeocoder(db),
+		Payments:  payments.FromEnv(),
+		Storage:   backend,
+		Notifiers: notify.DriversFromEnv(),
+		Providers: oidc.ProvidersFromEnv(),
+		Bus:       events.Default(),
+		Clock:     time.Now,
+	}
+}
+
+// Container holds one of each service, built from a single set of
+// dependencies. Uploads and Privacy are nil when there is no storage.
+type Container struct {
+	Dependencies
+
+	Users         *UserService
+	OAuth         *OAuthService
+	AccessTokens  *AccessTokenService
+	Jobs          *JobService
+	Equipment     *EquipmentService
+	Payments      *PaymentService
+	Messages      *MessageService
+	Notifications *NotificationService
+	Webhooks      *WebhookService
+	Admin         *AdminService
+	Uploads       *UploadService
+	Privacy       *PrivacyService
+}
+
+func NewContainer(deps Dependencies) *Container {
+	if deps.Bus == nil {
+		deps.Bus = events.NewBus()
+	}
+	if deps.Clock == nil {
+		deps.Clock = time.Now
+	}
+	db := deps.DB
+
+	users := &UserService{
+		db:       db,
+		geocoder: deps.Geocoder,
+		drivers:  deps.Notifiers,
+		now:      deps.Clock,
+
+		mfaRequiredRoles: mfaRequiredRolesFromEnv(),
+	}
+
+	c := &Container{
+		Dependencies: deps,
+
+		Users:         users,
+		OAuth:         &OAuthService{db: db, users: users, providers: deps.Providers},
+		AccessTokens:  &AccessTokenService{db: db, now: deps.Clock},
+		Jobs:          &JobService{db: db, bus: deps.Bus, geocoder: deps.Geocoder, users: users},
+		Equipment:     &EquipmentService{db: db, bus: deps.Bus, geocoder: deps.Geocoder, users: users},
+		Payments:      &PaymentService{db: db, bus: deps.Bus, users: users, gateway: deps.Payments},
+		Messages:      &MessageService{db: db, bus: deps.Bus},
+		Notifications: &NotificationService{db: db, drivers: deps.Notifiers, now: deps.Clock},
+		Webhooks:      &WebhookService{db: db, client: newWebhookClient(), now: deps.Clock},
+		Admin:         &AdminService{db: db},
+	}
+
+	if deps.Storage != nil {
+		c.Uploads = &UploadService{db: db, backend: deps.Storage, now: deps.Clock}
+		c.Privacy = &PrivacyService{db: db, uploads: c.Uploads, now: deps.Clock}
+		c.Messages.uploads = c.Uploads
+	}
+
+	return c
+}
+
+// RegisterTaskHandlers wires every background task kind to its handler.
+func (c *Container) RegisterTaskHandlers(queue *tasks.Queue) {
+	queue.Register(TaskGeocodeJob, c.Jobs.geocodeJobTask)
+	queue.Register(TaskGeocodeEquipment, c.Equipment.geocodeEquipmentTask)
+	queue.Register(TaskGeocodeUser, c.Users.geocodeUserTask)
+	queue.Register(TaskSendUnlockEmail, c.Users.sendUnlockEmailTask)
+
+	queue.Register(TaskNotifyEvent, c.Notifications.eventTask)
+	queue.Register(TaskDeliverNotification, c.Notifications.deliverTask)
+	queue.Register(TaskDispatchWebhooks, c.Webhooks.eventTask)
+	queue.Register(TaskDeliverWebhook, c.Webhooks.deliverTask)
+	queue.Register(TaskSyncStripeCustomer, c.Payments.syncStripeCustomerTask)
+	queue.Register(TaskCaptureRentalPayment, c.Payments.captureRentalPaymentTask)
+	queue.Register(TaskSettleRentalPayment, c.Payments.settleRentalPaymentTask)
+	queue.Register(TaskReleaseRentalPayment, c.Payments.releaseRentalPaymentTask)
+
+	if c.Uploads == nil {
+		slog.Warn("upload processing disabled: no file storage configured")
+		return
+	}
+	queue.Register(TaskProcessUpload, c.Uploads.processUploadTask)
+	queue.Register(TaskExpireUploadSession, c.Uploads.expireUploadSessionTask)
+	queue.Register(TaskExportUserData, c.Privacy.exportUserDataTask)
+	queue.Register(TaskExpireDataExport, c.Privacy.expireDataExportTask)
+	queue.Register(TaskEraseUserData, c.Privacy.eraseUserDataTask)
+}
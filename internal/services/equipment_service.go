@@ -1,25 +1,36 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
 
+	"mowsy-api/internal/events"
 	"mowsy-api/internal/models"
+	"mowsy-api/internal/tasks"
 	"mowsy-api/internal/utils"
-	"mowsy-api/pkg/database"
+	"mowsy-api/pkg/metrics"
 
 	"gorm.io/gorm"
 )
 
-type EquipmentService struct {
-	db *gorm.DB
+// ErrInvalidRentalTransition is returned when an owner asks for a rental
+// status its current status can't move to.
+var ErrInvalidRentalTransition = errors.New("rental cannot move to that status")
+
+// rentalTransitions lists the statuses an owner can set from each status.
+// Rentals become active when paid for and complete through CompleteRental.
+var rentalTransitions = map[models.RentalStatus][]models.RentalStatus{
+	models.RentalStatusRequested: {models.RentalStatusApproved, models.RentalStatusCancelled},
+	models.RentalStatusApproved:  {models.RentalStatusCancelled},
 }
 
-func NewEquipmentService() *EquipmentService {
-	return &EquipmentService{
-		db: database.GetDB(),
-	}
+type EquipmentService struct {
+	db       *gorm.DB
+	bus      *events.Bus
+	geocoder Geocoder
+	users    *UserService
 }
 
 type CreateEquipmentRequest struct {
@@ -67,7 +78,7 @@ type EquipmentFilters struct {
 }
 
 func (s *EquipmentService) CreateEquipment(userID uint, req CreateEquipmentRequest) (*models.EquipmentResponse, error) {
-	user, err := NewUserService().GetUserByID(userID)
+	user, err := s.users.GetUserByID(userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
@@ -92,25 +103,34 @@ func (s *EquipmentService) CreateEquipment(userID uint, req CreateEquipmentReque
 		IsAvailable:      true,
 	}
 
-	if equipment.Address != "" {
-		geocodioService := NewGeocodioService()
-		if err := geocodioService.GeocodeEquipment(&equipment); err != nil {
-			fmt.Printf("Warning: Failed to geocode equipment address: %v\n", err)
-			// Fallback to user's location when geocoding fails
-			equipment.Latitude = user.Latitude
-			equipment.Longitude = user.Longitude
-			equipment.ZipCode = user.ZipCode
-			equipment.ElementarySchoolDistrictName = user.ElementarySchoolDistrictName
+	// Start from the owner's location; the geocoding task refines it.
+	equipment.Latitude = user.Latitude
+	equipment.Longitude = user.Longitude
+	equipment.ZipCode = user.ZipCode
+	equipment.ElementarySchoolDistrictName = user.ElementarySchoolDistrictName
+
+	// equipment.listed is filtered by location, so equipment with an address
+	// is only announced once the geocoding task has settled where it is.
+	var listed map[string]interface{}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&equipment).Error; err != nil {
+			return fmt.Errorf("failed to create equipment: %w", err)
+		}
+		if err := linkUploads(tx, userID, UploadRelatedEquipment, equipment.ID, req.ImageUrls...); err != nil {
+			return err
+		}
+		if equipment.Address != "" {
+			return tasks.Enqueue(tx, TaskGeocodeEquipment, geocodeTaskPayload{ID: equipment.ID, Announce: true})
 		}
-	} else {
-		equipment.Latitude = user.Latitude
-		equipment.Longitude = user.Longitude
-		equipment.ZipCode = user.ZipCode
-		equipment.ElementarySchoolDistrictName = user.ElementarySchoolDistrictName
-	}
 
-	if err := s.db.Create(&equipment).Error; err != nil {
-		return nil, fmt.Errorf("failed to create equipment: %w", err)
+		listed = equipmentListedData(&equipment)
+		return recordEvent(tx, events.EquipmentListed, nil, listed)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if listed != nil {
+		s.bus.Publish(events.EquipmentListed, nil, listed)
 	}
 
 	if err := s.db.Preload("User").First(&equipment, equipment.ID).Error; err != nil {
@@ -121,6 +141,71 @@ func (s *EquipmentService) CreateEquipment(userID uint, req CreateEquipmentReque
 	return &response, nil
 }
 
+func equipmentListedData(equipment *models.Equipment) map[string]interface{} {
+	return map[string]interface{}{
+		"equipment_id":                    equipment.ID,
+		"equipment_name":                  equipment.Name,
+		"category":                        equipment.Category,
+		"daily_rental_price":              equipment.DailyRentalPrice,
+		"zip_code":                        equipment.ZipCode,
+		"elementary_school_district_name": equipment.ElementarySchoolDistrictName,
+		"visibility":                      equipment.Visibility,
+	}
+}
+
+// geocodeEquipmentTask resolves the address saved by a create or update. For
+// new equipment it then announces equipment.listed; if the address can't be
+// resolved it is announced at the owner's location once retries are
+// exhausted.
+func (s *EquipmentService) geocodeEquipmentTask(ctx context.Context, task *models.Task) error {
+	var equipment models.Equipment
+	db := s.db.WithContext(ctx)
+	if found, err := loadTaskRecord(db, task, &equipment); !found || err != nil {
+		return err
+	}
+	var payload geocodeTaskPayload
+	if err := tasks.Decode(task, &payload); err != nil {
+		return err
+	}
+
+	var taskErr error
+	geocodeErr := s.geocoder.GeocodeEquipment(ctx, &equipment)
+	if geocodeErr != nil {
+		taskErr = geocodeTaskError(task, geocodeErr)
+		if !payload.Announce || !geocodeSettled(task, taskErr) {
+			return taskErr
+		}
+	}
+
+	var listed map[string]interface{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if geocodeErr == nil {
+			if err := tx.Model(&equipment).Updates(map[string]interface{}{
+				"latitude":                        equipment.Latitude,
+				"longitude":                       equipment.Longitude,
+				"zip_code":                        equipment.ZipCode,
+				"elementary_school_district_name": equipment.ElementarySchoolDistrictName,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		if !payload.Announce {
+			return nil
+		}
+
+		listed = equipmentListedData(&equipment)
+		return recordEvent(tx, events.EquipmentListed, nil, listed)
+	})
+	if err != nil {
+		return err
+	}
+	if listed != nil {
+		s.bus.Publish(events.EquipmentListed, nil, listed)
+	}
+
+	return taskErr
+}
+
 func (s *EquipmentService) GetEquipment(filters EquipmentFilters) ([]models.EquipmentResponse, error) {
 	return s.GetEquipmentWithUser(filters, nil)
 }
@@ -270,26 +355,20 @@ func (s *EquipmentService) UpdateEquipment(equipmentID, userID uint, req UpdateE
 		updates["is_available"] = *req.IsAvailable
 	}
 
-	if err := s.db.Model(&equipment).Updates(updates).Error; err != nil {
-		return nil, fmt.Errorf("failed to update equipment: %w", err)
-	}
-
-	if req.Address != "" {
-		geocodioService := NewGeocodioService()
-		if err := geocodioService.GeocodeEquipment(&equipment); err != nil {
-			fmt.Printf("Warning: Failed to geocode equipment address: %v\n", err)
-			// Fallback to user's location when geocoding fails
-			user, err := NewUserService().GetUserByID(equipment.UserID)
-			if err == nil {
-				equipment.Latitude = user.Latitude
-				equipment.Longitude = user.Longitude
-				equipment.ZipCode = user.ZipCode
-				equipment.ElementarySchoolDistrictName = user.ElementarySchoolDistrictName
-				s.db.Save(&equipment)
-			}
-		} else {
-			s.db.Save(&equipment)
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&equipment).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update equipment: %w", err)
+		}
+		if err := linkUploads(tx, userID, UploadRelatedEquipment, equipment.ID, req.ImageUrls...); err != nil {
+			return err
 		}
+		if req.Address != "" {
+			return tasks.Enqueue(tx, TaskGeocodeEquipment, recordTaskPayload{ID: equipment.ID})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	if err := s.db.Preload("User").First(&equipment, equipment.ID).Error; err != nil {
@@ -371,13 +450,30 @@ func (s *EquipmentService) RequestRental(equipmentID, userID uint, startDate, en
 		Status:       models.RentalStatusRequested,
 	}
 
-	if err := s.db.Create(&rental).Error; err != nil {
-		return nil, fmt.Errorf("failed to create rental request: %w", err)
-	}
+	var requested map[string]interface{}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&rental).Error; err != nil {
+			return fmt.Errorf("failed to create rental request: %w", err)
+		}
+		if err := tx.Preload("Equipment").Preload("Equipment.User").Preload("Renter").First(&rental, rental.ID).Error; err != nil {
+			return fmt.Errorf("failed to load rental with details: %w", err)
+		}
 
-	if err := s.db.Preload("Equipment").Preload("Equipment.User").Preload("Renter").First(&rental, rental.ID).Error; err != nil {
-		return nil, fmt.Errorf("failed to load rental with details: %w", err)
+		requested = map[string]interface{}{
+			"equipment_id":   equipment.ID,
+			"rental_id":      rental.ID,
+			"equipment_name": equipment.Name,
+			"renter_name":    rental.Renter.FirstName,
+			"start_date":     rental.StartDate.Format("Jan 2, 2006"),
+			"end_date":       rental.EndDate.Format("Jan 2, 2006"),
+		}
+		return recordEvent(tx, events.RentalRequested, []uint{equipment.UserID}, requested)
+	})
+	if err != nil {
+		return nil, err
 	}
+	metrics.RentalRequests.Inc()
+	s.bus.Publish(events.RentalRequested, []uint{equipment.UserID}, requested)
 
 	response := rental.ToResponse()
 	return &response, nil
@@ -393,7 +489,7 @@ func (s *EquipmentService) GetEquipmentRentals(equipmentID, userID uint) ([]mode
 	}
 
 	var rentals []models.EquipmentRental
-	if err := s.db.Where("equipment_id = ?", equipmentID).
+	if err := s.db.Scopes(liveRentals).Where("equipment_id = ?", equipmentID).
 		Preload("Equipment").
 		Preload("Equipment.User").
 		Preload("Renter").
@@ -420,27 +516,62 @@ func (s *EquipmentService) UpdateRentalStatus(equipmentID, rentalID, userID uint
 	}
 
 	var rental models.EquipmentRental
-	if err := s.db.Where("id = ? AND equipment_id = ?", rentalID, equipmentID).First(&rental).Error; err != nil {
+	if err := s.db.Scopes(liveRentals).Where("id = ? AND equipment_id = ?", rentalID, equipmentID).First(&rental).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return errors.New("rental not found")
 		}
 		return fmt.Errorf("failed to fetch rental: %w", err)
 	}
 
-	if rental.Status != models.RentalStatusRequested && status == models.RentalStatusApproved {
-		return errors.New("can only approve requested rentals")
+	allowed := false
+	for _, next := range rentalTransitions[rental.Status] {
+		allowed = allowed || next == status
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s to %s", ErrInvalidRentalTransition, rental.Status, status)
 	}
 
-	if err := s.db.Model(&rental).Update("status", status).Error; err != nil {
-		return fmt.Errorf("failed to update rental status: %w", err)
+	eventType := events.RentalApproved
+	if status == models.RentalStatusCancelled {
+		eventType = events.RentalCancelled
+	}
+	data := map[string]interface{}{
+		"equipment_id":   equipment.ID,
+		"rental_id":      rental.ID,
+		"equipment_name": equipment.Name,
 	}
 
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		// Conditional on the status checked above, so a payment or a second
+		// request landing in between can't be overwritten.
+		result := tx.Model(&models.EquipmentRental{}).
+			Where("id = ? AND status = ?", rental.ID, rental.Status).
+			Update("status", status)
+		if result.Error != nil {
+			return fmt.Errorf("failed to update rental status: %w", result.Error)
+		}
+		if result.RowsAffected != 1 {
+			return fmt.Errorf("%w: rental status has changed", ErrInvalidRentalTransition)
+		}
+		if status == models.RentalStatusCancelled {
+			if err := tasks.Enqueue(tx, TaskReleaseRentalPayment, recordTaskPayload{ID: rental.ID}); err != nil {
+				return err
+			}
+		}
+		return recordEvent(tx, eventType, []uint{rental.RenterUserID}, data)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.bus.Publish(eventType, []uint{rental.RenterUserID}, data)
+
 	return nil
 }
 
 func (s *EquipmentService) CompleteRental(rentalID, userID uint, returnNotes string) error {
 	var rental models.EquipmentRental
-	if err := s.db.Preload("Equipment").Where("id = ?", rentalID).First(&rental).Error; err != nil {
+	if err := s.db.Scopes(liveRentals).Preload("Equipment").Where("id = ?", rentalID).First(&rental).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return errors.New("rental not found")
 		}
@@ -460,10 +591,28 @@ func (s *EquipmentService) CompleteRental(rentalID, userID uint, returnNotes str
 		"return_notes": utils.SanitizeString(returnNotes),
 	}
 
-	if err := s.db.Model(&rental).Updates(updates).Error; err != nil {
-		return fmt.Errorf("failed to complete rental: %w", err)
+	recipients := []uint{rental.Equipment.UserID, rental.RenterUserID}
+	completed := map[string]interface{}{
+		"equipment_id":   rental.EquipmentID,
+		"rental_id":      rental.ID,
+		"equipment_name": rental.Equipment.Name,
 	}
 
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&rental).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to complete rental: %w", err)
+		}
+		if err := tasks.Enqueue(tx, TaskSettleRentalPayment, recordTaskPayload{ID: rental.ID}); err != nil {
+			return err
+		}
+		return recordEvent(tx, events.RentalCompleted, recipients, completed)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.bus.Publish(events.RentalCompleted, recipients, completed)
+
 	return nil
 }
 
@@ -2,6 +2,7 @@ package services
 
 import (
 	"testing"
+	"time"
 
 	"mowsy-api/internal/models"
 	"mowsy-api/internal/testutils"
@@ -13,7 +14,7 @@ import (
 
 func setupEquipmentService() (*EquipmentService, *gorm.DB) {
 	db := testutils.SetupTestDB()
-	service := &EquipmentService{db: db}
+	service := &EquipmentService{db: db, users: &UserService{db: db}}
 	return service, db
 }
 
@@ -418,4 +419,66 @@ func TestEquipmentService_GetEquipmentWithFilter(t *testing.T) {
 		}
 		assert.NotContains(t, equipmentNames, "User2 Unavailable Equipment")
 	})
-}
\ No newline at end of file
+}
+func TestEquipmentService_UpdateRentalStatus(t *testing.T) {
+	service, db := setupEquipmentService()
+	defer testutils.CleanupTestDB(db)
+
+	owner := testutils.CreateTestUser(db)
+	renter := &models.User{Email: "renter@example.com", FirstName: "Renter", LastName: "Test", IsActive: true}
+	require.NoError(t, db.Create(renter).Error)
+	equipment := testutils.CreateTestEquipment(db, owner.ID)
+
+	rentalIn := func(status models.RentalStatus) *models.EquipmentRental {
+		start := time.Now().Add(24 * time.Hour)
+		rental := &models.EquipmentRental{EquipmentID: equipment.ID, RenterUserID: renter.ID,
+			StartDate: start, EndDate: start.Add(24 * time.Hour), TotalPrice: 50, Status: status}
+		require.NoError(t, db.Create(rental).Error)
+		return rental
+	}
+	statusOf := func(rental *models.EquipmentRental) models.RentalStatus {
+		var current models.EquipmentRental
+		require.NoError(t, db.First(&current, rental.ID).Error)
+		return current.Status
+	}
+
+	t.Run("Allowed", func(t *testing.T) {
+		rental := rentalIn(models.RentalStatusRequested)
+		require.NoError(t, service.UpdateRentalStatus(equipment.ID, rental.ID, owner.ID, models.RentalStatusApproved))
+		assert.Equal(t, models.RentalStatusApproved, statusOf(rental))
+		require.NoError(t, service.UpdateRentalStatus(equipment.ID, rental.ID, owner.ID, models.RentalStatusCancelled))
+		assert.Equal(t, models.RentalStatusCancelled, statusOf(rental))
+
+		requested := rentalIn(models.RentalStatusRequested)
+		require.NoError(t, service.UpdateRentalStatus(equipment.ID, requested.ID, owner.ID, models.RentalStatusCancelled))
+		assert.Equal(t, models.RentalStatusCancelled, statusOf(requested))
+	})
+
+	t.Run("Refused", func(t *testing.T) {
+		refused := []struct {
+			from, to models.RentalStatus
+		}{
+			{models.RentalStatusCancelled, models.RentalStatusCancelled},
+			{models.RentalStatusCancelled, models.RentalStatusApproved},
+			{models.RentalStatusApproved, models.RentalStatusApproved},
+			{models.RentalStatusActive, models.RentalStatusCancelled},
+			{models.RentalStatusCompleted, models.RentalStatusCancelled},
+			{models.RentalStatusRequested, models.RentalStatusActive},
+			{models.RentalStatusRequested, models.RentalStatusCompleted},
+			{models.RentalStatusApproved, models.RentalStatusRequested},
+			{models.RentalStatusRequested, "returned"},
+		}
+		for _, transition := range refused {
+			rental := rentalIn(transition.from)
+
+			err := service.UpdateRentalStatus(equipment.ID, rental.ID, owner.ID, transition.to)
+
+			assert.ErrorIs(t, err, ErrInvalidRentalTransition, "%s to %s", transition.from, transition.to)
+			assert.Equal(t, transition.from, statusOf(rental))
+		}
+
+		var queued int64
+		db.Model(&models.Task{}).Where("kind = ?", TaskReleaseRentalPayment).Count(&queued)
+		assert.Equal(t, int64(2), queued, "only the allowed cancellations release payments")
+	})
+}
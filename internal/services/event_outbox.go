@@ -0,0 +1,69 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"mowsy-api/internal/events"
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/tasks"
+
+	"gorm.io/gorm"
+)
+
+// eventTaskPayload is a domain event waiting to be turned into notifications
+// or webhook deliveries.
+type eventTaskPayload struct {
+	Type       events.Type     `json:"type"`
+	UserIDs    []uint          `json:"user_ids"`
+	Data       json.RawMessage `json:"data"`
+	OccurredAt time.Time       `json:"occurred_at"`
+}
+
+// recordEvent queues the notifications and webhook deliveries for an event in
+// tx, so they are kept or lost together with the change that caused it.
+// Callers still publish the event on the bus once tx has committed; that only
+// feeds live streams, which may miss an event.
+func recordEvent(tx *gorm.DB, eventType events.Type, userIDs []uint, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	payload := eventTaskPayload{
+		Type:       eventType,
+		UserIDs:    userIDs,
+		Data:       encoded,
+		OccurredAt: time.Now(),
+	}
+
+	if _, ok := notificationTemplates[eventType]; ok && len(userIDs) > 0 {
+		if err := tasks.Enqueue(tx, TaskNotifyEvent, payload); err != nil {
+			return err
+		}
+	}
+	return tasks.Enqueue(tx, TaskDispatchWebhooks, payload)
+}
+
+// decodeEventTask rebuilds the event recorded by recordEvent. Its data comes
+// back as decoded JSON, e.g. a map[string]interface{}.
+func decodeEventTask(task *models.Task) (events.Event, error) {
+	var payload eventTaskPayload
+	if err := tasks.Decode(task, &payload); err != nil {
+		return events.Event{}, err
+	}
+
+	var data interface{}
+	if len(payload.Data) > 0 {
+		if err := json.Unmarshal(payload.Data, &data); err != nil {
+			return events.Event{}, tasks.Permanent(fmt.Errorf("failed to decode event data: %w", err))
+		}
+	}
+
+	return events.Event{
+		Type:       payload.Type,
+		UserIDs:    payload.UserIDs,
+		Data:       data,
+		OccurredAt: payload.OccurredAt,
+	}, nil
+}
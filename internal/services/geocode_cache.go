@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"mowsy-api/internal/models"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// CachedLookup serves repeat addresses from the geocode_cache_entries table
+// and only asks the wrapped lookup on a miss or an expired entry.
+type CachedLookup struct {
+	db   *gorm.DB
+	next AddressLookup
+	ttl  time.Duration
+	now  func() time.Time
+}
+
+func NewCachedLookup(db *gorm.DB, next AddressLookup, ttl time.Duration) *CachedLookup {
+	return &CachedLookup{
+		db:   db,
+		next: next,
+		ttl:  ttl,
+		now:  time.Now,
+	}
+}
+
+func (l *CachedLookup) LookupAddress(ctx context.Context, address string) (*GeocodeResult, error) {
+	key := NormalizeAddress(address)
+	db := l.db.WithContext(ctx)
+
+	var entry models.GeocodeCacheEntry
+	err := db.Where("normalized_address = ? AND expires_at > ?", key, l.now()).First(&entry).Error
+	if err == nil {
+		return &GeocodeResult{
+			FormattedAddress: entry.FormattedAddress,
+			Latitude:         entry.Latitude,
+			Longitude:        entry.Longitude,
+			ZipCode:          entry.ZipCode,
+			DistrictName:     entry.DistrictName,
+			DistrictCode:     entry.DistrictCode,
+		}, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to read geocode cache: %w", err)
+	}
+
+	result, err := l.next.LookupAddress(ctx, address)
+	if err != nil {
+		return nil, err
+	}
+
+	entry = models.GeocodeCacheEntry{
+		NormalizedAddress: key,
+		FormattedAddress:  result.FormattedAddress,
+		Latitude:          result.Latitude,
+		Longitude:         result.Longitude,
+		ZipCode:           result.ZipCode,
+		DistrictName:      result.DistrictName,
+		DistrictCode:      result.DistrictCode,
+		ExpiresAt:         l.now().Add(l.ttl),
+	}
+	// A failed write only costs a future lookup, so it does not fail this one.
+	if err := db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "normalized_address"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"formatted_address", "latitude", "longitude", "zip_code",
+			"district_name", "district_code", "expires_at", "updated_at",
+		}),
+	}).Create(&entry).Error; err != nil {
+		slog.Warn("failed to write geocode cache entry", "error", err)
+	}
+
+	return result, nil
+}
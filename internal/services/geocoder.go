@@ -0,0 +1,256 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"mowsy-api/internal/models"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrAddressNotFound   = errors.New("no geocoding results found for address")
+	ErrGeocodingDisabled = errors.New("geocoding is not configured")
+)
+
+const defaultGeocodeCacheTTL = 30 * 24 * time.Hour
+
+// GeocodeResult is the part of a geocoding response that Mowsy keeps.
+type GeocodeResult struct {
+	FormattedAddress string  `json:"formatted_address"`
+	Latitude         float64 `json:"latitude"`
+	Longitude        float64 `json:"longitude"`
+	ZipCode          string  `json:"zip_code"`
+	DistrictName     string  `json:"district_name"`
+	DistrictCode     string  `json:"district_code"`
+}
+
+// Geocoder fills in coordinates, ZIP code and school district from a
+// record's address.
+type Geocoder interface {
+	GeocodeUser(ctx context.Context, user *models.User) error
+	GeocodeJob(ctx context.Context, job *models.Job) error
+	GeocodeEquipment(ctx context.Context, equipment *models.Equipment) error
+}
+
+// AddressLookup resolves a single free-form address. It is the seam between
+// a Geocoder and the provider behind it.
+type AddressLookup interface {
+	LookupAddress(ctx context.Context, address string) (*GeocodeResult, error)
+}
+
+// AddressGeocoder implements Geocoder on top of an AddressLookup.
+type AddressGeocoder struct {
+	lookup AddressLookup
+}
+
+func NewAddressGeocoder(lookup AddressLookup) *AddressGeocoder {
+	return &AddressGeocoder{lookup: lookup}
+}
+
+// NewGeocodioGeocoder geocodes through the Geocodio API.
+func NewGeocodioGeocoder() *AddressGeocoder {
+	return NewAddressGeocoder(NewGeocodioService())
+}
+
+// NewStaticGeocoder answers from a fixed set of addresses and never makes a
+// network call. Keys are normalized, so fixtures need not match exactly.
+func NewStaticGeocoder(fixtures map[string]GeocodeResult) *AddressGeocoder {
+	return NewAddressGeocoder(NewStaticLookup(fixtures))
+}
+
+// NewGeocoder builds the geocoder selected by GEOCODER_PROVIDER ("geocodio",
+// the default, or "static" with fixtures read from GEOCODER_FIXTURES). When a
+// database is available, lookups are cached for GEOCODE_CACHE_TTL (a Go
+// duration, default 720h; "0" disables the cache).
+func NewGeocoder(db *gorm.DB) Geocoder {
+	var lookup AddressLookup
+	switch provider := os.Getenv("GEOCODER_PROVIDER"); provider {
+	case "", "geocodio":
+		lookup = NewGeocodioService()
+	case "static":
+		static, err := LoadStaticLookup(os.Getenv("GEOCODER_FIXTURES"))
+		if err != nil {
+			slog.Warn("failed to load geocoder fixtures, geocoding will be disabled", "error", err)
+			static = NewStaticLookup(nil)
+		}
+		lookup = static
+	default:
+		slog.Warn("unknown GEOCODER_PROVIDER, falling back to geocodio", "provider", provider)
+		lookup = NewGeocodioService()
+	}
+
+	ttl := defaultGeocodeCacheTTL
+	if value := os.Getenv("GEOCODE_CACHE_TTL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			slog.Warn("invalid GEOCODE_CACHE_TTL", "value", value, "using", ttl.String())
+		} else {
+			ttl = parsed
+		}
+	}
+
+	if db != nil && ttl > 0 {
+		lookup = NewCachedLookup(db, lookup, ttl)
+	}
+
+	return NewAddressGeocoder(lookup)
+}
+
+func (g *AddressGeocoder) GeocodeUser(ctx context.Context, user *models.User) error {
+	if user.Address == "" {
+		return fmt.Errorf("user address is empty")
+	}
+
+	fullAddress := fmt.Sprintf("%s, %s, %s %s", user.Address, user.City, user.State, user.ZipCode)
+	result, err := g.lookup.LookupAddress(ctx, fullAddress)
+	if err != nil {
+		return fmt.Errorf("failed to geocode user address: %w", err)
+	}
+
+	user.Latitude = &result.Latitude
+	user.Longitude = &result.Longitude
+	if result.ZipCode != "" {
+		user.ZipCode = result.ZipCode
+	}
+	if result.DistrictName != "" {
+		user.ElementarySchoolDistrictName = result.DistrictName
+		user.ElementarySchoolDistrictCode = result.DistrictCode
+	}
+
+	return nil
+}
+
+func (g *AddressGeocoder) GeocodeJob(ctx context.Context, job *models.Job) error {
+	if job.Address == "" {
+		return fmt.Errorf("job address is empty")
+	}
+
+	result, err := g.lookup.LookupAddress(ctx, job.Address)
+	if err != nil {
+		return fmt.Errorf("failed to geocode job address: %w", err)
+	}
+
+	job.Latitude = &result.Latitude
+	job.Longitude = &result.Longitude
+	if result.ZipCode != "" {
+		job.ZipCode = result.ZipCode
+	}
+	if result.DistrictName != "" {
+		job.ElementarySchoolDistrictName = result.DistrictName
+	}
+
+	return nil
+}
+
+func (g *AddressGeocoder) GeocodeEquipment(ctx context.Context, equipment *models.Equipment) error {
+	if equipment.Address == "" {
+		return fmt.Errorf("equipment address is empty")
+	}
+
+	result, err := g.lookup.LookupAddress(ctx, equipment.Address)
+	if err != nil {
+		return fmt.Errorf("failed to geocode equipment address: %w", err)
+	}
+
+	equipment.Latitude = &result.Latitude
+	equipment.Longitude = &result.Longitude
+	if result.ZipCode != "" {
+		equipment.ZipCode = result.ZipCode
+	}
+	if result.DistrictName != "" {
+		equipment.ElementarySchoolDistrictName = result.DistrictName
+	}
+
+	return nil
+}
+
+// StaticLookup is an AddressLookup backed by an in-memory fixture map.
+type StaticLookup struct {
+	results map[string]GeocodeResult
+}
+
+func NewStaticLookup(fixtures map[string]GeocodeResult) *StaticLookup {
+	results := make(map[string]GeocodeResult, len(fixtures))
+	for address, result := range fixtures {
+		results[NormalizeAddress(address)] = result
+	}
+	return &StaticLookup{results: results}
+}
+
+// LoadStaticLookup reads fixtures from a JSON object mapping addresses to
+// results.
+func LoadStaticLookup(path string) (*StaticLookup, error) {
+	if path == "" {
+		return nil, errors.New("GEOCODER_FIXTURES is not set")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read geocoder fixtures: %w", err)
+	}
+
+	var fixtures map[string]GeocodeResult
+	if err := json.Unmarshal(data, &fixtures); err != nil {
+		return nil, fmt.Errorf("failed to parse geocoder fixtures: %w", err)
+	}
+
+	return NewStaticLookup(fixtures), nil
+}
+
+func (l *StaticLookup) LookupAddress(ctx context.Context, address string) (*GeocodeResult, error) {
+	result, ok := l.results[NormalizeAddress(address)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrAddressNotFound, address)
+	}
+	return &result, nil
+}
+
+var (
+	addressPunctuation = regexp.MustCompile(`[^a-z0-9#\s]+`)
+	addressWhitespace  = regexp.MustCompile(`\s+`)
+)
+
+var addressAbbreviations = map[string]string{
+	"street":    "st",
+	"avenue":    "ave",
+	"road":      "rd",
+	"drive":     "dr",
+	"boulevard": "blvd",
+	"lane":      "ln",
+	"court":     "ct",
+	"place":     "pl",
+	"highway":   "hwy",
+	"parkway":   "pkwy",
+	"north":     "n",
+	"south":     "s",
+	"east":      "e",
+	"west":      "w",
+	"apartment": "apt",
+	"suite":     "ste",
+}
+
+// NormalizeAddress reduces an address to a canonical cache key: lower case,
+// no punctuation, single spaces and common USPS abbreviations, so that
+// "123 Main Street." and "123 main st" share an entry.
+func NormalizeAddress(address string) string {
+	normalized := strings.ToLower(address)
+	normalized = addressPunctuation.ReplaceAllString(normalized, " ")
+	normalized = addressWhitespace.ReplaceAllString(strings.TrimSpace(normalized), " ")
+
+	words := strings.Split(normalized, " ")
+	for i, word := range words {
+		if abbreviation, ok := addressAbbreviations[word]; ok {
+			words[i] = abbreviation
+		}
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/testutils"
+	"mowsy-api/pkg/tracing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+)
+
+type countingLookup struct {
+	next  AddressLookup
+	calls int
+}
+
+func (l *countingLookup) LookupAddress(ctx context.Context, address string) (*GeocodeResult, error) {
+	l.calls++
+	return l.next.LookupAddress(ctx, address)
+}
+
+func TestNormalizeAddress(t *testing.T) {
+	assert.Equal(t, "123 main st springfield il 62701", NormalizeAddress("  123 Main Street.,  Springfield, IL 62701 "))
+	assert.Equal(t, NormalizeAddress("55 N Oak Ave Apt 4"), NormalizeAddress("55 North Oak Avenue, Apartment 4"))
+	assert.Equal(t, "10 elm st #2", NormalizeAddress("10 Elm St #2"))
+}
+
+func TestAddressGeocoder(t *testing.T) {
+	geocoder := NewStaticGeocoder(springfieldFixtures)
+
+	t.Run("GeocodeUserUsesFullAddress", func(t *testing.T) {
+		user := &models.User{Address: "1 Elm St", City: "Springfield", State: "IL"}
+
+		require.NoError(t, geocoder.GeocodeUser(context.Background(), user))
+
+		require.NotNil(t, user.Latitude)
+		assert.Equal(t, 39.8, *user.Latitude)
+		assert.Equal(t, "62701", user.ZipCode)
+		assert.Equal(t, "1737260", user.ElementarySchoolDistrictCode)
+	})
+
+	t.Run("UnknownAddress", func(t *testing.T) {
+		equipment := &models.Equipment{Address: "999 Nowhere Rd"}
+
+		err := geocoder.GeocodeEquipment(context.Background(), equipment)
+
+		assert.ErrorIs(t, err, ErrAddressNotFound)
+		assert.Nil(t, equipment.Latitude)
+	})
+
+	t.Run("EmptyAddress", func(t *testing.T) {
+		assert.Error(t, geocoder.GeocodeJob(context.Background(), &models.Job{}))
+	})
+}
+
+func TestCachedLookup(t *testing.T) {
+	db := testutils.SetupTestDB()
+	defer testutils.CleanupTestDB(db)
+
+	inner := &countingLookup{next: NewStaticLookup(springfieldFixtures)}
+	cache := NewCachedLookup(db, inner, time.Hour)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	t.Run("RepeatLookupIsServedFromCache", func(t *testing.T) {
+		first, err := cache.LookupAddress(context.Background(), "1 Elm Street, Springfield, IL")
+		require.NoError(t, err)
+		second, err := cache.LookupAddress(context.Background(), "1 elm st springfield il")
+		require.NoError(t, err)
+
+		assert.Equal(t, 1, inner.calls)
+		assert.Equal(t, first, second)
+
+		var count int64
+		db.Model(&models.GeocodeCacheEntry{}).Count(&count)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("ExpiredEntryIsRefreshed", func(t *testing.T) {
+		now = now.Add(2 * time.Hour)
+
+		_, err := cache.LookupAddress(context.Background(), "1 Elm Street, Springfield, IL")
+		require.NoError(t, err)
+
+		assert.Equal(t, 2, inner.calls)
+
+		var entry models.GeocodeCacheEntry
+		require.NoError(t, db.First(&entry).Error)
+		assert.True(t, entry.ExpiresAt.Equal(now.Add(time.Hour)))
+	})
+
+	t.Run("FailuresAreNotCached", func(t *testing.T) {
+		_, err := cache.LookupAddress(context.Background(), "999 Nowhere Rd")
+		assert.ErrorIs(t, err, ErrAddressNotFound)
+		_, err = cache.LookupAddress(context.Background(), "999 Nowhere Rd")
+		assert.ErrorIs(t, err, ErrAddressNotFound)
+
+		assert.Equal(t, 4, inner.calls)
+	})
+}
+
+func TestGeocodioService_LookupAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("q") {
+		case "1 Elm St, Springfield, IL":
+			w.Write([]byte(`{"results":[{
+				"formatted_address":"1 Elm St, Springfield, IL 62701",
+				"address_components":{"zip":"62701"},
+				"location":{"lat":39.8,"lng":-89.6},
+				"fields":{"school_districts":{"elementary":[{"name":"Springfield District 186","lea_code":"1737260"}]}}
+			}]}`))
+		case "outage":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.Write([]byte(`{"results":[]}`))
+		}
+	}))
+	defer server.Close()
+
+	service := &GeocodioService{apiKey: "test", baseURL: server.URL, client: server.Client()}
+
+	t.Run("Resolves", func(t *testing.T) {
+		result, err := service.LookupAddress(context.Background(), "1 Elm St, Springfield, IL")
+
+		require.NoError(t, err)
+		assert.Equal(t, "62701", result.ZipCode)
+		assert.Equal(t, "Springfield District 186", result.DistrictName)
+		assert.Equal(t, -89.6, result.Longitude)
+	})
+
+	t.Run("NoResults", func(t *testing.T) {
+		_, err := service.LookupAddress(context.Background(), "nowhere")
+
+		assert.ErrorIs(t, err, ErrAddressNotFound)
+	})
+
+	t.Run("ProviderError", func(t *testing.T) {
+		_, err := service.LookupAddress(context.Background(), "outage")
+
+		require.Error(t, err)
+		assert.False(t, errors.Is(err, ErrAddressNotFound))
+	})
+
+	t.Run("Traced", func(t *testing.T) {
+		exporter, restore := tracing.InMemory()
+		defer restore()
+
+		_, err := service.LookupAddress(context.Background(), "outage")
+		require.Error(t, err)
+
+		spans := exporter.GetSpans()
+		require.Len(t, spans, 1)
+		assert.Equal(t, "geocodio.geocode", spans[0].Name)
+		assert.Equal(t, codes.Error, spans[0].Status.Code)
+		for _, attr := range spans[0].Attributes {
+			assert.NotContains(t, attr.Value.Emit(), "api_key")
+		}
+	})
+
+	t.Run("MissingAPIKey", func(t *testing.T) {
+		_, err := (&GeocodioService{}).LookupAddress(context.Background(), "1 Elm St")
+
+		assert.ErrorIs(t, err, ErrGeocodingDisabled)
+	})
+}
@@ -1,17 +1,25 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
+	"sync"
 	"time"
 
-	"mowsy-api/internal/models"
+	"mowsy-api/pkg/metrics"
+	"mowsy-api/pkg/tracing"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
 )
 
+var missingKeyWarning sync.Once
+
 type GeocodioService struct {
 	apiKey  string
 	baseURL string
@@ -21,7 +29,9 @@ type GeocodioService struct {
 func NewGeocodioService() *GeocodioService {
 	apiKey := os.Getenv("GEOCODIO_API_KEY")
 	if apiKey == "" {
-		fmt.Println("Warning: GEOCODIO_API_KEY not set, geocoding will be disabled")
+		missingKeyWarning.Do(func() {
+			slog.Warn("GEOCODIO_API_KEY not set, geocoding will be disabled")
+		})
 	}
 
 	return &GeocodioService{
@@ -103,16 +113,42 @@ type TimezoneField struct {
 	AbbreviationDST    string `json:"abbreviation_dst"`
 }
 
-func (g *GeocodioService) geocodeAddress(address string) (*GeocodioResult, error) {
+// get calls the Geocodio API under a span and records how long it took. Any
+// status other than 200 counts as a failed call. The URL is kept off the
+// span because its query string carries the API key.
+func (g *GeocodioService) get(ctx context.Context, operation, requestURL string) (*http.Response, error) {
+	ctx, span := tracing.StartClient(ctx, metrics.ServiceGeocodio, operation)
+	start := time.Now()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+	resp, err := g.client.Do(req)
+
+	observed := err
+	if err == nil {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+		if resp.StatusCode != http.StatusOK {
+			observed = fmt.Errorf("status %d", resp.StatusCode)
+		}
+	}
+	metrics.ObserveOutbound(metrics.ServiceGeocodio, operation, start, observed)
+	tracing.End(span, observed)
+	return resp, err
+}
+
+func (g *GeocodioService) geocodeAddress(ctx context.Context, address string) (*GeocodioResult, error) {
 	if g.apiKey == "" {
-		return nil, fmt.Errorf("geocodio API key not configured")
+		return nil, ErrGeocodingDisabled
 	}
 
 	encodedAddress := url.QueryEscape(address)
 	requestURL := fmt.Sprintf("%s/geocode?q=%s&api_key=%s&fields=school_districts", 
 		g.baseURL, encodedAddress, g.apiKey)
 
-	resp, err := g.client.Get(requestURL)
+	resp, err := g.get(ctx, "geocode", requestURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make geocoding request: %w", err)
 	}
@@ -134,93 +170,37 @@ func (g *GeocodioService) geocodeAddress(address string) (*GeocodioResult, error
 	}
 
 	if len(geocodioResponse.Results) == 0 {
-		return nil, fmt.Errorf("no geocoding results found for address: %s", address)
+		return nil, fmt.Errorf("%w: %s", ErrAddressNotFound, address)
 	}
 
 	return &geocodioResponse.Results[0], nil
 }
 
-func (g *GeocodioService) GeocodeUser(user *models.User) error {
-	if user.Address == "" {
-		return fmt.Errorf("user address is empty")
-	}
-
-	fullAddress := fmt.Sprintf("%s, %s, %s %s", user.Address, user.City, user.State, user.ZipCode)
-	result, err := g.geocodeAddress(fullAddress)
-	if err != nil {
-		return fmt.Errorf("failed to geocode user address: %w", err)
-	}
-
-	user.Latitude = &result.Location.Lat
-	user.Longitude = &result.Location.Lng
-
-	if result.AddressComponents.Zip != "" {
-		user.ZipCode = result.AddressComponents.Zip
-	}
-
-	if result.Fields.School != nil {
-		if len(result.Fields.School.Elementary) > 0 {
-			user.ElementarySchoolDistrictName = result.Fields.School.Elementary[0].Name
-			user.ElementarySchoolDistrictCode = result.Fields.School.Elementary[0].Code
-		}
-	}
-
-	return nil
-}
-
-func (g *GeocodioService) GeocodeJob(job *models.Job) error {
-	if job.Address == "" {
-		return fmt.Errorf("job address is empty")
-	}
-
-	result, err := g.geocodeAddress(job.Address)
-	if err != nil {
-		return fmt.Errorf("failed to geocode job address: %w", err)
-	}
-
-	job.Latitude = &result.Location.Lat
-	job.Longitude = &result.Location.Lng
-
-	if result.AddressComponents.Zip != "" {
-		job.ZipCode = result.AddressComponents.Zip
-	}
-
-	if result.Fields.School != nil {
-		if len(result.Fields.School.Elementary) > 0 {
-			job.ElementarySchoolDistrictName = result.Fields.School.Elementary[0].Name
-		}
-	}
-
-	return nil
-}
-
-func (g *GeocodioService) GeocodeEquipment(equipment *models.Equipment) error {
-	if equipment.Address == "" {
-		return fmt.Errorf("equipment address is empty")
-	}
-
-	result, err := g.geocodeAddress(equipment.Address)
+// LookupAddress implements AddressLookup.
+func (g *GeocodioService) LookupAddress(ctx context.Context, address string) (*GeocodeResult, error) {
+	result, err := g.geocodeAddress(ctx, address)
 	if err != nil {
-		return fmt.Errorf("failed to geocode equipment address: %w", err)
+		return nil, err
 	}
 
-	equipment.Latitude = &result.Location.Lat
-	equipment.Longitude = &result.Location.Lng
-
-	if result.AddressComponents.Zip != "" {
-		equipment.ZipCode = result.AddressComponents.Zip
+	resolved := &GeocodeResult{
+		FormattedAddress: result.FormattedAddress,
+		Latitude:         result.Location.Lat,
+		Longitude:        result.Location.Lng,
+		ZipCode:          result.AddressComponents.Zip,
 	}
 
 	if result.Fields.School != nil {
 		if len(result.Fields.School.Elementary) > 0 {
-			equipment.ElementarySchoolDistrictName = result.Fields.School.Elementary[0].Name
+			resolved.DistrictName = result.Fields.School.Elementary[0].Name
+			resolved.DistrictCode = result.Fields.School.Elementary[0].Code
 		}
 	}
 
-	return nil
+	return resolved, nil
 }
 
-func (g *GeocodioService) ReverseGeocode(lat, lng float64) (*GeocodioResult, error) {
+func (g *GeocodioService) ReverseGeocode(ctx context.Context, lat, lng float64) (*GeocodioResult, error) {
 	if g.apiKey == "" {
 		return nil, fmt.Errorf("geocodio API key not configured")
 	}
@@ -228,7 +208,7 @@ func (g *GeocodioService) ReverseGeocode(lat, lng float64) (*GeocodioResult, err
 	requestURL := fmt.Sprintf("%s/reverse?q=%f,%f&api_key=%s&fields=school_districts", 
 		g.baseURL, lat, lng, g.apiKey)
 
-	resp, err := g.client.Get(requestURL)
+	resp, err := g.get(ctx, "reverse", requestURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to make reverse geocoding request: %w", err)
 	}
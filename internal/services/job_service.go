@@ -1,25 +1,25 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
 
+	"mowsy-api/internal/events"
 	"mowsy-api/internal/models"
+	"mowsy-api/internal/tasks"
 	"mowsy-api/internal/utils"
-	"mowsy-api/pkg/database"
+	"mowsy-api/pkg/metrics"
 
 	"gorm.io/gorm"
 )
 
 type JobService struct {
-	db *gorm.DB
-}
-
-func NewJobService() *JobService {
-	return &JobService{
-		db: database.GetDB(),
-	}
+	db       *gorm.DB
+	bus      *events.Bus
+	geocoder Geocoder
+	users    *UserService
 }
 
 type CreateJobRequest struct {
@@ -59,9 +59,9 @@ type JobFilters struct {
 	Limit        int                   `form:"limit"`
 }
 
-func (s *JobService) CreateJob(userID uint, req CreateJobRequest) (*models.JobResponse, error) {
-	userService := &UserService{db: s.db}
-	user, err := userService.GetUserByID(userID)
+func (s *JobService) CreateJob(ctx context.Context, userID uint, req CreateJobRequest) (*models.JobResponse, error) {
+	db := s.db.WithContext(ctx)
+	user, err := s.users.WithContext(ctx).GetUserByID(userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
@@ -88,28 +88,35 @@ func (s *JobService) CreateJob(userID uint, req CreateJobRequest) (*models.JobRe
 		Status:         models.JobStatusOpen,
 	}
 
-	if job.Address != "" {
-		geocodioService := NewGeocodioService()
-		if err := geocodioService.GeocodeJob(&job); err != nil {
-			fmt.Printf("Warning: Failed to geocode job address: %v\n", err)
-			// Fallback to user's location when geocoding fails
-			job.Latitude = user.Latitude
-			job.Longitude = user.Longitude
-			job.ZipCode = user.ZipCode
-			job.ElementarySchoolDistrictName = user.ElementarySchoolDistrictName
+	// Start from the poster's location; the geocoding task refines it.
+	job.Latitude = user.Latitude
+	job.Longitude = user.Longitude
+	job.ZipCode = user.ZipCode
+	job.ElementarySchoolDistrictName = user.ElementarySchoolDistrictName
+
+	// job.posted is filtered by location, so a job with an address is only
+	// announced once the geocoding task has settled where it is.
+	var posted map[string]interface{}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&job).Error; err != nil {
+			return fmt.Errorf("failed to create job: %w", err)
+		}
+		if job.Address != "" {
+			return tasks.Enqueue(tx, TaskGeocodeJob, geocodeTaskPayload{ID: job.ID, Announce: true})
 		}
-	} else {
-		job.Latitude = user.Latitude
-		job.Longitude = user.Longitude
-		job.ZipCode = user.ZipCode
-		job.ElementarySchoolDistrictName = user.ElementarySchoolDistrictName
-	}
 
-	if err := s.db.Create(&job).Error; err != nil {
-		return nil, fmt.Errorf("failed to create job: %w", err)
+		posted = jobPostedData(&job)
+		return recordEvent(tx, events.JobPosted, nil, posted)
+	})
+	if err != nil {
+		return nil, err
+	}
+	metrics.JobsCreated.Inc()
+	if posted != nil {
+		s.bus.Publish(events.JobPosted, nil, posted)
 	}
 
-	if err := s.db.Preload("User").First(&job, job.ID).Error; err != nil {
+	if err := db.Preload("User").First(&job, job.ID).Error; err != nil {
 		return nil, fmt.Errorf("failed to load job with user: %w", err)
 	}
 
@@ -117,6 +124,71 @@ func (s *JobService) CreateJob(userID uint, req CreateJobRequest) (*models.JobRe
 	return &response, nil
 }
 
+func jobPostedData(job *models.Job) map[string]interface{} {
+	return map[string]interface{}{
+		"job_id":                          job.ID,
+		"job_title":                       job.Title,
+		"category":                        job.Category,
+		"fixed_price":                     job.FixedPrice,
+		"zip_code":                        job.ZipCode,
+		"elementary_school_district_name": job.ElementarySchoolDistrictName,
+		"visibility":                      job.Visibility,
+		"scheduled_date":                  job.ScheduledDate,
+	}
+}
+
+// geocodeJobTask resolves the address saved by a create or update. For a
+// new job it then announces job.posted; if the address can't be resolved the
+// job is announced at the poster's location once retries are exhausted.
+func (s *JobService) geocodeJobTask(ctx context.Context, task *models.Task) error {
+	var job models.Job
+	db := s.db.WithContext(ctx)
+	if found, err := loadTaskRecord(db, task, &job); !found || err != nil {
+		return err
+	}
+	var payload geocodeTaskPayload
+	if err := tasks.Decode(task, &payload); err != nil {
+		return err
+	}
+
+	var taskErr error
+	geocodeErr := s.geocoder.GeocodeJob(ctx, &job)
+	if geocodeErr != nil {
+		taskErr = geocodeTaskError(task, geocodeErr)
+		if !payload.Announce || !geocodeSettled(task, taskErr) {
+			return taskErr
+		}
+	}
+
+	var posted map[string]interface{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if geocodeErr == nil {
+			if err := tx.Model(&job).Updates(map[string]interface{}{
+				"latitude":                        job.Latitude,
+				"longitude":                       job.Longitude,
+				"zip_code":                        job.ZipCode,
+				"elementary_school_district_name": job.ElementarySchoolDistrictName,
+			}).Error; err != nil {
+				return err
+			}
+		}
+		if !payload.Announce {
+			return nil
+		}
+
+		posted = jobPostedData(&job)
+		return recordEvent(tx, events.JobPosted, nil, posted)
+	})
+	if err != nil {
+		return err
+	}
+	if posted != nil {
+		s.bus.Publish(events.JobPosted, nil, posted)
+	}
+
+	return taskErr
+}
+
 func (s *JobService) GetJobs(filters JobFilters) ([]models.JobResponse, error) {
 	return s.GetJobsWithUser(filters, nil)
 }
@@ -254,27 +326,17 @@ func (s *JobService) UpdateJob(jobID, userID uint, req UpdateJobRequest) (*model
 		updates["scheduled_date"] = req.ScheduledDate
 	}
 
-	if err := s.db.Model(&job).Updates(updates).Error; err != nil {
-		return nil, fmt.Errorf("failed to update job: %w", err)
-	}
-
-	if req.Address != "" {
-		geocodioService := NewGeocodioService()
-		if err := geocodioService.GeocodeJob(&job); err != nil {
-			fmt.Printf("Warning: Failed to geocode job address: %v\n", err)
-			// Fallback to user's location when geocoding fails
-			userService := &UserService{db: s.db}
-			user, err := userService.GetUserByID(job.UserID)
-			if err == nil {
-				job.Latitude = user.Latitude
-				job.Longitude = user.Longitude
-				job.ZipCode = user.ZipCode
-				job.ElementarySchoolDistrictName = user.ElementarySchoolDistrictName
-				s.db.Save(&job)
-			}
-		} else {
-			s.db.Save(&job)
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&job).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update job: %w", err)
 		}
+		if req.Address != "" {
+			return tasks.Enqueue(tx, TaskGeocodeJob, recordTaskPayload{ID: job.ID})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	if err := s.db.Preload("User").First(&job, job.ID).Error; err != nil {
@@ -330,13 +392,28 @@ func (s *JobService) ApplyForJob(jobID, userID uint, message string) (*models.Jo
 		Status:  models.ApplicationStatusPending,
 	}
 
-	if err := s.db.Create(&application).Error; err != nil {
-		return nil, fmt.Errorf("failed to create application: %w", err)
-	}
+	var received map[string]interface{}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&application).Error; err != nil {
+			return fmt.Errorf("failed to create application: %w", err)
+		}
+		if err := tx.Preload("User").First(&application, application.ID).Error; err != nil {
+			return fmt.Errorf("failed to load application with user: %w", err)
+		}
 
-	if err := s.db.Preload("User").First(&application, application.ID).Error; err != nil {
-		return nil, fmt.Errorf("failed to load application with user: %w", err)
+		received = map[string]interface{}{
+			"job_id":         job.ID,
+			"application_id": application.ID,
+			"job_title":      job.Title,
+			"applicant_name": application.User.FirstName,
+		}
+		return recordEvent(tx, events.ApplicationReceived, []uint{job.UserID}, received)
+	})
+	if err != nil {
+		return nil, err
 	}
+	metrics.JobApplications.Inc()
+	s.bus.Publish(events.ApplicationReceived, []uint{job.UserID}, received)
 
 	response := application.ToResponse()
 	return &response, nil
@@ -352,7 +429,7 @@ func (s *JobService) GetJobApplications(jobID, userID uint) ([]models.JobApplica
 	}
 
 	var applications []models.JobApplication
-	if err := s.db.Where("job_id = ?", jobID).
+	if err := s.db.Scopes(liveApplications).Where("job_id = ?", jobID).
 		Preload("User").
 		Order("applied_at DESC").
 		Find(&applications).Error; err != nil {
@@ -377,21 +454,48 @@ func (s *JobService) UpdateApplicationStatus(jobID, applicationID, userID uint,
 	}
 
 	var application models.JobApplication
-	if err := s.db.Where("id = ? AND job_id = ?", applicationID, jobID).First(&application).Error; err != nil {
+	if err := s.db.Scopes(liveApplications).Where("id = ? AND job_id = ?", applicationID, jobID).First(&application).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return errors.New("application not found")
 		}
 		return fmt.Errorf("failed to fetch application: %w", err)
 	}
 
-	if err := s.db.Model(&application).Update("status", status).Error; err != nil {
-		return fmt.Errorf("failed to update application status: %w", err)
+	var eventType events.Type
+	switch status {
+	case models.ApplicationStatusAccepted:
+		eventType = events.ApplicationAccepted
+	case models.ApplicationStatusRejected:
+		eventType = events.ApplicationRejected
+	}
+	data := map[string]interface{}{
+		"job_id":         job.ID,
+		"application_id": application.ID,
+		"job_title":      job.Title,
 	}
 
-	if status == models.ApplicationStatusAccepted {
-		if err := s.db.Model(&job).Update("status", models.JobStatusInProgress).Error; err != nil {
-			return fmt.Errorf("failed to update job status: %w", err)
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&application).Update("status", status).Error; err != nil {
+			return fmt.Errorf("failed to update application status: %w", err)
+		}
+
+		if status == models.ApplicationStatusAccepted {
+			if err := tx.Model(&job).Update("status", models.JobStatusInProgress).Error; err != nil {
+				return fmt.Errorf("failed to update job status: %w", err)
+			}
 		}
+
+		if eventType != "" {
+			return recordEvent(tx, eventType, []uint{application.UserID}, data)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if eventType != "" {
+		s.bus.Publish(eventType, []uint{application.UserID}, data)
 	}
 
 	return nil
@@ -415,10 +519,37 @@ func (s *JobService) CompleteJob(jobID, userID uint, imageUrls []string) error {
 		"completion_image_urls":  models.StringArray(imageUrls),
 	}
 
-	if err := s.db.Model(&job).Updates(updates).Error; err != nil {
-		return fmt.Errorf("failed to complete job: %w", err)
+	completed := map[string]interface{}{
+		"job_id":    job.ID,
+		"job_title": job.Title,
+	}
+	var recipients []uint
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&job).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to complete job: %w", err)
+		}
+		if err := linkUploads(tx, userID, UploadRelatedJob, job.ID, imageUrls...); err != nil {
+			return err
+		}
+
+		// The worker is whoever's application was accepted.
+		var workerIDs []uint
+		if err := tx.Model(&models.JobApplication{}).Scopes(liveApplications).
+			Where("job_id = ? AND status = ?", job.ID, models.ApplicationStatusAccepted).
+			Pluck("user_id", &workerIDs).Error; err != nil {
+			return fmt.Errorf("failed to find job worker: %w", err)
+		}
+
+		recipients = append([]uint{job.UserID}, workerIDs...)
+		return recordEvent(tx, events.JobCompleted, recipients, completed)
+	})
+	if err != nil {
+		return err
 	}
 
+	s.bus.Publish(events.JobCompleted, recipients, completed)
+
 	return nil
 }
 
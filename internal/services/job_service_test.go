@@ -1,10 +1,13 @@
 package services
 
 import (
+	"context"
 	"testing"
 
+	"mowsy-api/internal/events"
 	"mowsy-api/internal/models"
 	"mowsy-api/internal/testutils"
+	"mowsy-api/pkg/tracing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -13,7 +16,7 @@ import (
 
 func setupJobService() (*JobService, *gorm.DB) {
 	db := testutils.SetupTestDB()
-	service := &JobService{db: db}
+	service := &JobService{db: db, users: &UserService{db: db}}
 	return service, db
 }
 
@@ -35,7 +38,7 @@ func TestJobService_CreateJob(t *testing.T) {
 			Visibility:     models.VisibilityZipCode,
 		}
 
-		job, err := service.CreateJob(user.ID, req)
+		job, err := service.CreateJob(context.Background(), user.ID, req)
 
 		require.NoError(t, err)
 		assert.NotNil(t, job)
@@ -55,7 +58,7 @@ func TestJobService_CreateJob(t *testing.T) {
 			Visibility:  models.VisibilityZipCode,
 		}
 
-		job, err := service.CreateJob(user.ID, req)
+		job, err := service.CreateJob(context.Background(), user.ID, req)
 
 		assert.Error(t, err)
 		assert.Nil(t, job)
@@ -71,7 +74,7 @@ func TestJobService_CreateJob(t *testing.T) {
 			Visibility:     models.VisibilityZipCode,
 		}
 
-		job, err := service.CreateJob(user.ID, req)
+		job, err := service.CreateJob(context.Background(), user.ID, req)
 
 		assert.Error(t, err)
 		assert.Nil(t, job)
@@ -86,7 +89,7 @@ func TestJobService_CreateJob(t *testing.T) {
 			Visibility: models.VisibilityZipCode,
 		}
 
-		job, err := service.CreateJob(99999, req)
+		job, err := service.CreateJob(context.Background(), 99999, req)
 
 		assert.Error(t, err)
 		assert.Nil(t, job)
@@ -359,10 +362,19 @@ func TestJobService_UpdateApplicationStatus(t *testing.T) {
 	require.NoError(t, err)
 
 	t.Run("AcceptApplication", func(t *testing.T) {
+		service.bus = events.NewBus()
+		sub := service.bus.SubscribeUser(applicant.ID, 1)
+		defer sub.Close()
+
 		err := service.UpdateApplicationStatus(job.ID, application.ID, jobOwner.ID, models.ApplicationStatusAccepted)
 
 		require.NoError(t, err)
 
+		// Verify the applicant is notified
+		require.Len(t, sub.C, 1)
+		event := <-sub.C
+		assert.Equal(t, events.ApplicationAccepted, event.Type)
+
 		// Verify application status is updated
 		var updatedApp models.JobApplication
 		err = db.First(&updatedApp, application.ID).Error
@@ -463,6 +475,10 @@ func TestJobService_DeleteJob(t *testing.T) {
 		err = db.First(&deletedJob, job.ID).Error
 		assert.Error(t, err)
 		assert.Equal(t, gorm.ErrRecordNotFound, err)
+
+		// but kept so an admin can restore it
+		require.NoError(t, db.Unscoped().First(&deletedJob, job.ID).Error)
+		assert.True(t, deletedJob.DeletedAt.Valid)
 	})
 
 	t.Run("UnauthorizedDeletion", func(t *testing.T) {
@@ -776,4 +792,47 @@ func TestJobService_GetJobsWithFilter(t *testing.T) {
 		require.NoError(t, err)
 		assert.Len(t, jobs, 4) // Should see all jobs when no userID provided
 	})
-}
\ No newline at end of file
+}
+
+func TestJobService_CreateJobTracing(t *testing.T) {
+	exporter, restore := tracing.InMemory()
+	defer restore()
+
+	service, db := setupJobService()
+	defer testutils.CleanupTestDB(db)
+	require.NoError(t, db.Use(tracing.GORMPlugin{}))
+	user := testutils.CreateTestUser(db)
+
+	ctx, request := tracing.Start(context.Background(), "POST /v1/jobs")
+	_, err := service.CreateJob(ctx, user.ID, CreateJobRequest{
+		Title:      "Edge the driveway",
+		Category:   models.JobCategoryMowing,
+		FixedPrice: 40,
+		Address:    "1 Elm St",
+		Visibility: models.VisibilityZipCode,
+	})
+	require.NoError(t, err)
+	request.End()
+
+	// Every statement, including the Preload reload, hangs off the request.
+	// Spans are exported as they end, and a preload ends inside its query.
+	var tables []string
+	for _, span := range exporter.GetSpans() {
+		if span.Name == "POST /v1/jobs" {
+			continue
+		}
+		assert.Equal(t, request.SpanContext().SpanID(), span.Parent.SpanID(), span.Name)
+		for _, attr := range span.Attributes {
+			if attr.Key == "db.sql.table" {
+				tables = append(tables, span.Name+" "+attr.Value.AsString())
+			}
+		}
+	}
+	assert.Equal(t, []string{
+		"gorm.query users",
+		"gorm.create jobs",
+		"gorm.create tasks", // geocoding, which then announces the job
+		"gorm.query users",
+		"gorm.query jobs",
+	}, tables)
+}
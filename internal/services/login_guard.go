@@ -0,0 +1,277 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/tasks"
+	"mowsy-api/pkg/logging"
+	"mowsy-api/pkg/notify"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Sign-in throttling. Each counter allows a few free failures, then makes
+// the client wait twice as long after every further one. Counters reset
+// after loginFailureWindow without a failure.
+const (
+	loginFailureWindow = time.Hour
+
+	accountFreeFailures = 3
+	accountMaxDelay     = 30 * time.Second
+
+	// An account is locked, rather than just slowed down, after this many
+	// failures in a row. Its owner is emailed a link that lifts the lock.
+	accountLockoutFailures = 10
+	accountLockoutDuration = 30 * time.Minute
+
+	ipFreeFailures = 20
+	ipMaxDelay     = 15 * time.Minute
+)
+
+var (
+	ErrAccountLocked      = errors.New("account is temporarily locked after too many failed sign-in attempts; check your email for an unlock link")
+	ErrInvalidUnlockToken = errors.New("invalid or expired unlock token")
+)
+
+// LoginThrottledError is returned while a client must wait before trying to
+// sign in again.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("too many failed sign-in attempts; try again in %d seconds", int(math.Ceil(e.RetryAfter.Seconds())))
+}
+
+type loginThrottle struct {
+	freeFailures int
+	maxDelay     time.Duration
+}
+
+var (
+	accountLoginThrottle = loginThrottle{freeFailures: accountFreeFailures, maxDelay: accountMaxDelay}
+	ipLoginThrottle      = loginThrottle{freeFailures: ipFreeFailures, maxDelay: ipMaxDelay}
+)
+
+// delay is how long a client must wait after its latest failure.
+func (t loginThrottle) delay(failures int) time.Duration {
+	extra := failures - t.freeFailures
+	if extra <= 0 {
+		return 0
+	}
+	if extra > 30 {
+		return t.maxDelay
+	}
+	delay := time.Second << (extra - 1)
+	if delay > t.maxDelay {
+		return t.maxDelay
+	}
+	return delay
+}
+
+func accountLoginKey(userID uint) string {
+	return "account:" + strconv.FormatUint(uint64(userID), 10)
+}
+
+func ipLoginKey(ip string) string {
+	return "ip:" + ip
+}
+
+func hashUnlockToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// checkLoginThrottle returns an error if the counter under key says the
+// client must not try again yet.
+func (s *UserService) checkLoginThrottle(key string, throttle loginThrottle, now time.Time) error {
+	var failure models.LoginFailure
+	if err := s.db.Where("key = ?", key).First(&failure).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to check sign-in attempts: %w", err)
+	}
+
+	if failure.LockedUntil != nil && now.Before(*failure.LockedUntil) {
+		return ErrAccountLocked
+	}
+	if now.Sub(failure.LastFailureAt) >= loginFailureWindow {
+		return nil
+	}
+	if wait := failure.LastFailureAt.Add(throttle.delay(failure.Failures)).Sub(now); wait > 0 {
+		return &LoginThrottledError{RetryAfter: wait}
+	}
+	return nil
+}
+
+// recordLoginFailure adds one failure to the counter under key and returns
+// the updated row.
+func (s *UserService) recordLoginFailure(tx *gorm.DB, key string, userID *uint, now time.Time) (*models.LoginFailure, error) {
+	var failure models.LoginFailure
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&failure).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		failure = models.LoginFailure{Key: key, UserID: userID}
+	case err != nil:
+		return nil, fmt.Errorf("failed to read sign-in attempts: %w", err)
+	}
+
+	expiredLock := failure.LockedUntil != nil && !now.Before(*failure.LockedUntil)
+	if now.Sub(failure.LastFailureAt) >= loginFailureWindow || expiredLock {
+		failure.Failures = 0
+		failure.LockedUntil = nil
+		failure.UnlockTokenHash = ""
+	}
+	failure.Failures++
+	failure.LastFailureAt = now
+
+	if err := tx.Clauses(clause.OnConflict{UpdateAll: true}).Create(&failure).Error; err != nil {
+		return nil, fmt.Errorf("failed to record sign-in attempt: %w", err)
+	}
+	return &failure, nil
+}
+
+// recordFailedLogin counts a wrong password against the client IP and, when
+// the email matched an account, against that account. The account is locked
+// once it reaches accountLockoutFailures.
+func (s *UserService) recordFailedLogin(user *models.User, ip string, now time.Time) error {
+	locked := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if _, err := s.recordLoginFailure(tx, ipLoginKey(ip), nil, now); err != nil {
+			return err
+		}
+		if user == nil {
+			return nil
+		}
+
+		failure, err := s.recordLoginFailure(tx, accountLoginKey(user.ID), &user.ID, now)
+		if err != nil {
+			return err
+		}
+		if failure.Failures < accountLockoutFailures {
+			return nil
+		}
+
+		lockedUntil := now.Add(accountLockoutDuration)
+		if err := tx.Model(failure).Update("locked_until", lockedUntil).Error; err != nil {
+			return fmt.Errorf("failed to lock account: %w", err)
+		}
+		locked = true
+		return tasks.Enqueue(tx, TaskSendUnlockEmail, recordTaskPayload{ID: user.ID})
+	})
+	if err != nil {
+		return err
+	}
+	if locked {
+		return ErrAccountLocked
+	}
+	return nil
+}
+
+func (s *UserService) clearLoginFailures(userID uint) error {
+	if err := s.db.Where("key = ?", accountLoginKey(userID)).Delete(&models.LoginFailure{}).Error; err != nil {
+		return fmt.Errorf("failed to reset sign-in attempts: %w", err)
+	}
+	return nil
+}
+
+// UnlockAccount lifts a lockout using the token from the unlock email.
+func (s *UserService) UnlockAccount(token string) error {
+	if token == "" {
+		return ErrInvalidUnlockToken
+	}
+
+	var failure models.LoginFailure
+	err := s.db.Where("unlock_token_hash = ? AND locked_until > ?", hashUnlockToken(token), s.now()).
+		First(&failure).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrInvalidUnlockToken
+		}
+		return fmt.Errorf("failed to find locked account: %w", err)
+	}
+
+	if err := s.db.Delete(&failure).Error; err != nil {
+		return fmt.Errorf("failed to unlock account: %w", err)
+	}
+	return nil
+}
+
+// sendUnlockEmailTask emails a locked-out user a fresh unlock token. It goes
+// straight to the email driver instead of through notifications, so it is
+// sent whatever the user's preferences and the token is never stored.
+func (s *UserService) sendUnlockEmailTask(ctx context.Context, task *models.Task) error {
+	var user models.User
+	found, err := loadTaskRecord(s.db, task, &user)
+	if err != nil || !found {
+		return err
+	}
+
+	var failure models.LoginFailure
+	if err := s.db.Where("key = ?", accountLoginKey(user.ID)).First(&failure).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to fetch sign-in attempts: %w", err)
+	}
+	if failure.LockedUntil == nil || !s.now().Before(*failure.LockedUntil) {
+		logging.FromContext(ctx).Info("skipping unlock email: account is no longer locked", "user_id", user.ID)
+		return nil
+	}
+
+	token, err := newUnlockToken()
+	if err != nil {
+		return err
+	}
+	if err := s.db.Model(&failure).Update("unlock_token_hash", hashUnlockToken(token)).Error; err != nil {
+		return fmt.Errorf("failed to save unlock token: %w", err)
+	}
+
+	err = s.drivers.Send(ctx, notify.Message{
+		Channel: notify.ChannelEmail,
+		To:      user.Email,
+		Subject: "Your Mowsy account was locked",
+		Body:    unlockEmailBody(token, *failure.LockedUntil),
+		Data:    map[string]string{"event": "account.locked"},
+	})
+	if errors.Is(err, notify.ErrNoRecipient) {
+		return tasks.Permanent(err)
+	}
+	return err
+}
+
+func newUnlockToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate unlock token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// unlockEmailBody links to ACCOUNT_UNLOCK_URL when it is set, and otherwise
+// includes the token for the app to submit.
+func unlockEmailBody(token string, lockedUntil time.Time) string {
+	body := "We locked your account after several failed attempts to sign in. " +
+		"If this wasn't you, consider changing your password once you are back in.\n\n"
+
+	if base := os.Getenv("ACCOUNT_UNLOCK_URL"); base != "" {
+		body += "Unlock your account now: " + base + "?token=" + url.QueryEscape(token)
+	} else {
+		body += "Your unlock code: " + token
+	}
+
+	return body + "\n\nOtherwise the lock lifts automatically at " + lockedUntil.UTC().Format(time.RFC1123) + "."
+}
@@ -0,0 +1,191 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/tasks"
+	"mowsy-api/internal/testutils"
+	"mowsy-api/pkg/auth"
+	"mowsy-api/pkg/notify"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+const testClientIP = "203.0.113.7"
+
+type loginFixture struct {
+	service *UserService
+	db      *gorm.DB
+	email   *notify.MemoryDriver
+	now     time.Time
+	user    *models.User
+}
+
+func setupLoginFixture(t *testing.T) *loginFixture {
+	testutils.UseTestSigningKeys(t)
+	db := testutils.SetupTestDB()
+	t.Cleanup(func() { testutils.CleanupTestDB(db) })
+
+	f := &loginFixture{
+		db:    db,
+		email: notify.NewMemoryDriver(notify.ChannelEmail),
+		now:   time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+	}
+	f.service = &UserService{
+		db:      db,
+		drivers: notify.Drivers{notify.ChannelEmail: f.email},
+		now:     func() time.Time { return f.now },
+	}
+
+	hash, err := auth.HashPassword("mower-shed-42")
+	require.NoError(t, err)
+	f.user = &models.User{Email: "locked@example.com", PasswordHash: hash, FirstName: "Lee", LastName: "Owner", IsActive: true}
+	require.NoError(t, db.Create(f.user).Error)
+	return f
+}
+
+func (f *loginFixture) login(password string) error {
+	_, err := f.service.Login(LoginRequest{Email: f.user.Email, Password: password}, testClientIP)
+	return err
+}
+
+// failUntilLocked sends wrong passwords, waiting out each delay, until the
+// account locks.
+func (f *loginFixture) failUntilLocked(t *testing.T) {
+	for i := 0; i < accountLockoutFailures; i++ {
+		err := f.login("wrong-password-1")
+		var throttled *LoginThrottledError
+		require.False(t, errors.As(err, &throttled), "attempt %d should not be throttled", i+1)
+		if i < accountLockoutFailures-1 {
+			require.ErrorIs(t, err, ErrInvalidCredentials)
+		} else {
+			require.ErrorIs(t, err, ErrAccountLocked)
+		}
+		f.now = f.now.Add(accountMaxDelay)
+	}
+}
+
+func TestLoginThrottle_Delay(t *testing.T) {
+	assert.Equal(t, time.Duration(0), accountLoginThrottle.delay(3))
+	assert.Equal(t, time.Second, accountLoginThrottle.delay(4))
+	assert.Equal(t, 8*time.Second, accountLoginThrottle.delay(7))
+	assert.Equal(t, accountMaxDelay, accountLoginThrottle.delay(9))
+	assert.Equal(t, accountMaxDelay, accountLoginThrottle.delay(1000))
+	assert.Equal(t, ipMaxDelay, ipLoginThrottle.delay(1000))
+}
+
+func TestUserService_LoginProgressiveDelay(t *testing.T) {
+	f := setupLoginFixture(t)
+
+	for i := 0; i < accountFreeFailures+1; i++ {
+		assert.ErrorIs(t, f.login("wrong-password-1"), ErrInvalidCredentials)
+	}
+
+	// Even the right password has to wait
+	var throttled *LoginThrottledError
+	require.ErrorAs(t, f.login("mower-shed-42"), &throttled)
+	assert.Equal(t, time.Second, throttled.RetryAfter)
+
+	f.now = f.now.Add(time.Second)
+	assert.ErrorIs(t, f.login("wrong-password-1"), ErrInvalidCredentials)
+	require.ErrorAs(t, f.login("mower-shed-42"), &throttled)
+	assert.Equal(t, 2*time.Second, throttled.RetryAfter)
+
+	// A successful login resets the count
+	f.now = f.now.Add(2 * time.Second)
+	require.NoError(t, f.login("mower-shed-42"))
+	assert.ErrorIs(t, f.login("wrong-password-1"), ErrInvalidCredentials)
+	assert.ErrorIs(t, f.login("wrong-password-1"), ErrInvalidCredentials)
+
+	// So does a quiet hour
+	f.now = f.now.Add(loginFailureWindow)
+	for i := 0; i < accountFreeFailures; i++ {
+		assert.ErrorIs(t, f.login("wrong-password-1"), ErrInvalidCredentials)
+	}
+}
+
+func TestUserService_LoginLockout(t *testing.T) {
+	f := setupLoginFixture(t)
+	f.failUntilLocked(t)
+
+	assert.ErrorIs(t, f.login("mower-shed-42"), ErrAccountLocked)
+
+	var task models.Task
+	require.NoError(t, f.db.Where("kind = ?", TaskSendUnlockEmail).First(&task).Error)
+
+	t.Run("UnlockEmail", func(t *testing.T) {
+		require.NoError(t, f.service.sendUnlockEmailTask(context.Background(), &task))
+
+		messages := f.email.Messages()
+		require.Len(t, messages, 1)
+		assert.Equal(t, f.user.Email, messages[0].To)
+
+		_, token, found := strings.Cut(messages[0].Body, "Your unlock code: ")
+		require.True(t, found, messages[0].Body)
+		token, _, _ = strings.Cut(token, "\n")
+
+		var failure models.LoginFailure
+		require.NoError(t, f.db.Where("key = ?", accountLoginKey(f.user.ID)).First(&failure).Error)
+		assert.Equal(t, hashUnlockToken(token), failure.UnlockTokenHash)
+		assert.NotContains(t, failure.UnlockTokenHash, token)
+
+		require.NoError(t, f.service.UnlockAccount(token))
+		assert.ErrorIs(t, f.service.UnlockAccount(token), ErrInvalidUnlockToken)
+		require.NoError(t, f.login("mower-shed-42"))
+	})
+
+	t.Run("LockExpires", func(t *testing.T) {
+		f.failUntilLocked(t)
+		assert.ErrorIs(t, f.login("mower-shed-42"), ErrAccountLocked)
+
+		f.now = f.now.Add(accountLockoutDuration)
+		require.NoError(t, f.login("mower-shed-42"))
+	})
+
+	t.Run("EmailSkippedOnceUnlocked", func(t *testing.T) {
+		sent := len(f.email.Messages())
+		require.NoError(t, f.service.sendUnlockEmailTask(context.Background(), &task))
+		assert.Len(t, f.email.Messages(), sent)
+	})
+}
+
+func TestUserService_LoginThrottlesIP(t *testing.T) {
+	f := setupLoginFixture(t)
+
+	// Guessing at many accounts from one address is slowed down too
+	for i := 0; i < ipFreeFailures+1; i++ {
+		_, err := f.service.Login(LoginRequest{Email: "nobody@example.com", Password: "wrong-password-1"}, testClientIP)
+		require.ErrorIs(t, err, ErrInvalidCredentials)
+	}
+
+	var throttled *LoginThrottledError
+	require.ErrorAs(t, f.login("mower-shed-42"), &throttled)
+
+	_, err := f.service.Login(LoginRequest{Email: f.user.Email, Password: "mower-shed-42"}, "198.51.100.4")
+	assert.NoError(t, err, "other addresses are unaffected")
+}
+
+func TestUserService_UnlockAccountRejectsUnknownToken(t *testing.T) {
+	f := setupLoginFixture(t)
+
+	assert.ErrorIs(t, f.service.UnlockAccount(""), ErrInvalidUnlockToken)
+	assert.ErrorIs(t, f.service.UnlockAccount("not-a-token"), ErrInvalidUnlockToken)
+}
+
+func TestUserService_SendUnlockEmailTaskMissingUser(t *testing.T) {
+	f := setupLoginFixture(t)
+
+	require.NoError(t, tasks.Enqueue(f.db, TaskSendUnlockEmail, recordTaskPayload{ID: 999}))
+	var task models.Task
+	require.NoError(t, f.db.Where("kind = ?", TaskSendUnlockEmail).First(&task).Error)
+
+	require.NoError(t, f.service.sendUnlockEmailTask(context.Background(), &task))
+	assert.Empty(t, f.email.Messages())
+}
@@ -0,0 +1,419 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"mowsy-api/internal/events"
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/utils"
+	"mowsy-api/pkg/storage"
+
+	"gorm.io/gorm"
+)
+
+const maxMessageLength = 2000
+
+// MaxMessageAttachments caps the files on a single message.
+const MaxMessageAttachments = 5
+
+var (
+	ErrAttachmentsUnavailable = errors.New("attachments are not available")
+	ErrInvalidAttachment      = errors.New("attachments must be message attachments you uploaded")
+)
+
+type MessageService struct {
+	db  *gorm.DB
+	bus *events.Bus
+
+	// uploads signs attachment URLs; nil when storage is not configured,
+	// in which case messages cannot carry attachments.
+	uploads *UploadService
+}
+
+type StartConversationRequest struct {
+	Type      models.ConversationType `json:"type" binding:"required"`
+	RelatedID uint                    `json:"related_id" binding:"required"`
+}
+
+type SendMessageRequest struct {
+	Body string `json:"body"`
+	// Attachments are keys (or URLs) of message_attachment uploads made by
+	// the sender.
+	Attachments []string `json:"attachments"`
+}
+
+// StartConversation returns the thread for a job application or equipment
+// rental, creating it on first use. Only the two sides of the booking may open it.
+func (s *MessageService) StartConversation(userID uint, req StartConversationRequest) (*models.ConversationResponse, error) {
+	ownerID, participantID, err := s.resolveParticipants(req.Type, req.RelatedID)
+	if err != nil {
+		return nil, err
+	}
+
+	if userID != ownerID && userID != participantID {
+		return nil, errors.New("you are not a participant in this booking")
+	}
+
+	var conversation models.Conversation
+	err = s.db.Where("type = ? AND related_id = ?", req.Type, req.RelatedID).First(&conversation).Error
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("failed to fetch conversation: %w", err)
+		}
+
+		conversation = models.Conversation{
+			Type:              req.Type,
+			RelatedID:         req.RelatedID,
+			OwnerUserID:       ownerID,
+			ParticipantUserID: participantID,
+		}
+		if err := s.db.Create(&conversation).Error; err != nil {
+			return nil, fmt.Errorf("failed to create conversation: %w", err)
+		}
+	}
+
+	if err := s.db.Preload("Owner").Preload("Participant").First(&conversation, conversation.ID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load conversation: %w", err)
+	}
+
+	unread, err := s.countUnread(conversation.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	response := conversation.ToResponse(unread)
+	return &response, nil
+}
+
+func (s *MessageService) resolveParticipants(conversationType models.ConversationType, relatedID uint) (uint, uint, error) {
+	switch conversationType {
+	case models.ConversationTypeJobApplication:
+		var application models.JobApplication
+		if err := s.db.Scopes(liveApplications).Preload("Job").Where("id = ?", relatedID).First(&application).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return 0, 0, errors.New("job application not found")
+			}
+			return 0, 0, fmt.Errorf("failed to fetch job application: %w", err)
+		}
+		return application.Job.UserID, application.UserID, nil
+
+	case models.ConversationTypeEquipmentRental:
+		var rental models.EquipmentRental
+		if err := s.db.Scopes(liveRentals).Preload("Equipment").Where("id = ?", relatedID).First(&rental).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return 0, 0, errors.New("rental not found")
+			}
+			return 0, 0, fmt.Errorf("failed to fetch rental: %w", err)
+		}
+		return rental.Equipment.UserID, rental.RenterUserID, nil
+
+	default:
+		return 0, 0, errors.New("invalid conversation type")
+	}
+}
+
+// isBookingConfirmed reports whether the booking behind a conversation has
+// been accepted, after which contact details may be shared freely.
+func (s *MessageService) isBookingConfirmed(conversation *models.Conversation) (bool, error) {
+	switch conversation.Type {
+	case models.ConversationTypeJobApplication:
+		var application models.JobApplication
+		if err := s.db.Select("status").Where("id = ?", conversation.RelatedID).First(&application).Error; err != nil {
+			return false, fmt.Errorf("failed to fetch job application: %w", err)
+		}
+		return application.Status == models.ApplicationStatusAccepted, nil
+
+	case models.ConversationTypeEquipmentRental:
+		var rental models.EquipmentRental
+		if err := s.db.Select("status").Where("id = ?", conversation.RelatedID).First(&rental).Error; err != nil {
+			return false, fmt.Errorf("failed to fetch rental: %w", err)
+		}
+		switch rental.Status {
+		case models.RentalStatusApproved, models.RentalStatusActive, models.RentalStatusCompleted:
+			return true, nil
+		}
+		return false, nil
+	}
+
+	return false, nil
+}
+
+func (s *MessageService) getConversationForParticipant(conversationID, userID uint) (*models.Conversation, error) {
+	var conversation models.Conversation
+	if err := s.db.Where("id = ?", conversationID).First(&conversation).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("conversation not found")
+		}
+		return nil, fmt.Errorf("failed to fetch conversation: %w", err)
+	}
+
+	if !conversation.HasParticipant(userID) {
+		return nil, errors.New("conversation not found")
+	}
+
+	return &conversation, nil
+}
+
+func (s *MessageService) countUnread(conversationID, userID uint) (int64, error) {
+	var count int64
+	if err := s.db.Model(&models.Message{}).
+		Where("conversation_id = ? AND sender_user_id != ? AND read_at IS NULL", conversationID, userID).
+		Count(&count).Error; err != nil {
+		return 0, fmt.Errorf("failed to count unread messages: %w", err)
+	}
+	return count, nil
+}
+
+func (s *MessageService) GetConversations(userID uint) ([]models.ConversationResponse, error) {
+	var conversations []models.Conversation
+	if err := s.db.Where("owner_user_id = ? OR participant_user_id = ?", userID, userID).
+		Preload("Owner").
+		Preload("Participant").
+		Order("last_message_at DESC, created_at DESC").
+		Find(&conversations).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch conversations: %w", err)
+	}
+
+	if len(conversations) == 0 {
+		return []models.ConversationResponse{}, nil
+	}
+
+	ids := make([]uint, len(conversations))
+	for i, conversation := range conversations {
+		ids[i] = conversation.ID
+	}
+
+	var rows []struct {
+		ConversationID uint
+		Count          int64
+	}
+	if err := s.db.Model(&models.Message{}).
+		Select("conversation_id, COUNT(*) AS count").
+		Where("conversation_id IN ? AND sender_user_id != ? AND read_at IS NULL", ids, userID).
+		Group("conversation_id").
+		Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to count unread messages: %w", err)
+	}
+
+	unread := make(map[uint]int64, len(rows))
+	for _, row := range rows {
+		unread[row.ConversationID] = row.Count
+	}
+
+	responses := make([]models.ConversationResponse, len(conversations))
+	for i, conversation := range conversations {
+		responses[i] = conversation.ToResponse(unread[conversation.ID])
+	}
+
+	return responses, nil
+}
+
+// GetMessages returns a page of the thread, newest first, and marks messages
+// from the other participant as read.
+func (s *MessageService) GetMessages(conversationID, userID uint, page, limit int) ([]models.MessageResponse, error) {
+	if _, err := s.getConversationForParticipant(conversationID, userID); err != nil {
+		return nil, err
+	}
+
+	messages, err := s.listMessages(conversationID, page, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.MarkConversationRead(conversationID, userID); err != nil {
+		return nil, err
+	}
+
+	return messages, nil
+}
+
+// GetMessagesAsAdmin returns a page of any thread without affecting read receipts.
+func (s *MessageService) GetMessagesAsAdmin(conversationID uint, page, limit int) ([]models.MessageResponse, error) {
+	var conversation models.Conversation
+	if err := s.db.Where("id = ?", conversationID).First(&conversation).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("conversation not found")
+		}
+		return nil, fmt.Errorf("failed to fetch conversation: %w", err)
+	}
+
+	return s.listMessages(conversationID, page, limit)
+}
+
+func (s *MessageService) listMessages(conversationID uint, page, limit int) ([]models.MessageResponse, error) {
+	if page <= 0 {
+		page = 1
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+
+	offset := (page - 1) * limit
+
+	var messages []models.Message
+	if err := s.db.Where("conversation_id = ?", conversationID).
+		Order("created_at DESC, id DESC").
+		Offset(offset).
+		Limit(limit).
+		Find(&messages).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch messages: %w", err)
+	}
+
+	responses := make([]models.MessageResponse, len(messages))
+	for i := range messages {
+		response, err := s.messageResponse(&messages[i])
+		if err != nil {
+			return nil, err
+		}
+		responses[i] = response
+	}
+
+	return responses, nil
+}
+
+// messageResponse replaces the stored attachment keys with URLs the reader
+// can open. Attachments are private, so these are short-lived presigned URLs;
+// callers must only hand them to the thread's participants and admins.
+func (s *MessageService) messageResponse(message *models.Message) (models.MessageResponse, error) {
+	response := message.ToResponse()
+	if len(message.Attachments) == 0 || s.uploads == nil {
+		return response, nil
+	}
+
+	urls := make(models.StringArray, len(message.Attachments))
+	for i, ref := range message.Attachments {
+		key := storage.KeyFromURL(ref)
+		if key == "" {
+			urls[i] = ref
+			continue
+		}
+		url, err := s.uploads.fileURL(key, string(storage.KeyVisibility(key)))
+		if err != nil {
+			return response, fmt.Errorf("failed to sign attachment: %w", err)
+		}
+		urls[i] = url
+	}
+	response.Attachments = urls
+
+	return response, nil
+}
+
+// attachmentKeys checks that every attachment is a message attachment the
+// sender uploaded and returns the storage keys to keep on the message.
+func (s *MessageService) attachmentKeys(userID uint, refs []string) ([]string, error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+	if len(refs) > MaxMessageAttachments {
+		return nil, fmt.Errorf("a message can have at most %d attachments", MaxMessageAttachments)
+	}
+	if s.uploads == nil {
+		return nil, ErrAttachmentsUnavailable
+	}
+
+	keys := make([]string, len(refs))
+	for i, ref := range refs {
+		key := storage.KeyFromURL(ref)
+		if key == "" {
+			return nil, ErrInvalidAttachment
+		}
+
+		var count int64
+		if err := s.db.Model(&models.Upload{}).
+			Where("key = ? AND user_id = ? AND category = ?", key, userID, UploadCategoryMessageAttachment).
+			Count(&count).Error; err != nil {
+			return nil, fmt.Errorf("failed to check attachment: %w", err)
+		}
+		if count == 0 {
+			return nil, ErrInvalidAttachment
+		}
+		keys[i] = key
+	}
+
+	return keys, nil
+}
+
+func (s *MessageService) SendMessage(conversationID, userID uint, req SendMessageRequest) (*models.MessageResponse, error) {
+	conversation, err := s.getConversationForParticipant(conversationID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	body := utils.SanitizeString(req.Body)
+	if body == "" && len(req.Attachments) == 0 {
+		return nil, errors.New("message body or attachment is required")
+	}
+
+	if len(body) > maxMessageLength {
+		return nil, fmt.Errorf("message cannot exceed %d characters", maxMessageLength)
+	}
+
+	attachments, err := s.attachmentKeys(userID, req.Attachments)
+	if err != nil {
+		return nil, err
+	}
+
+	confirmed, err := s.isBookingConfirmed(conversation)
+	if err != nil {
+		return nil, err
+	}
+
+	redacted := false
+	if !confirmed {
+		body, redacted = utils.RedactContactInfo(body)
+	}
+
+	message := models.Message{
+		ConversationID: conversation.ID,
+		SenderUserID:   userID,
+		Body:           body,
+		Attachments:    models.StringArray(attachments),
+		Redacted:       redacted,
+	}
+
+	recipientID := conversation.OwnerUserID
+	if userID == conversation.OwnerUserID {
+		recipientID = conversation.ParticipantUserID
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&message).Error; err != nil {
+			return fmt.Errorf("failed to create message: %w", err)
+		}
+
+		if err := tx.Model(conversation).Update("last_message_at", message.CreatedAt).Error; err != nil {
+			return fmt.Errorf("failed to update conversation: %w", err)
+		}
+
+		// Queued notifications and webhooks outlive the signed URLs, so they
+		// get the attachment keys
+		return recordEvent(tx, events.MessageReceived, []uint{recipientID}, message.ToResponse())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := s.messageResponse(&message)
+	if err != nil {
+		return nil, err
+	}
+	s.bus.Publish(events.MessageReceived, []uint{recipientID}, response)
+
+	return &response, nil
+}
+
+func (s *MessageService) MarkConversationRead(conversationID, userID uint) error {
+	if _, err := s.getConversationForParticipant(conversationID, userID); err != nil {
+		return err
+	}
+
+	if err := s.db.Model(&models.Message{}).
+		Where("conversation_id = ? AND sender_user_id != ? AND read_at IS NULL", conversationID, userID).
+		Update("read_at", time.Now()).Error; err != nil {
+		return fmt.Errorf("failed to mark messages as read: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,241 @@
+package services
+
+import (
+	"bytes"
+	"testing"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/testutils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupMessageService() (*MessageService, *gorm.DB) {
+	db := testutils.SetupTestDB()
+	service := &MessageService{db: db}
+	return service, db
+}
+
+func createApplicant(t *testing.T, db *gorm.DB, email string) *models.User {
+	user := &models.User{
+		Email:     email,
+		FirstName: "Applicant",
+		LastName:  "User",
+		IsActive:  true,
+	}
+	require.NoError(t, db.Create(user).Error)
+	return user
+}
+
+func TestMessageService_StartConversation(t *testing.T) {
+	service, db := setupMessageService()
+	defer testutils.CleanupTestDB(db)
+
+	poster := testutils.CreateTestUser(db)
+	applicant := createApplicant(t, db, "applicant@example.com")
+	outsider := createApplicant(t, db, "outsider@example.com")
+	job := testutils.CreateTestJob(db, poster.ID)
+
+	application := &models.JobApplication{JobID: job.ID, UserID: applicant.ID, Status: models.ApplicationStatusPending}
+	require.NoError(t, db.Create(application).Error)
+
+	req := StartConversationRequest{Type: models.ConversationTypeJobApplication, RelatedID: application.ID}
+
+	t.Run("ParticipantCreatesConversation", func(t *testing.T) {
+		conversation, err := service.StartConversation(applicant.ID, req)
+
+		require.NoError(t, err)
+		assert.Equal(t, poster.ID, conversation.Owner.ID)
+		assert.Equal(t, applicant.ID, conversation.Participant.ID)
+	})
+
+	t.Run("ReusesExistingConversation", func(t *testing.T) {
+		first, err := service.StartConversation(poster.ID, req)
+		require.NoError(t, err)
+		second, err := service.StartConversation(applicant.ID, req)
+		require.NoError(t, err)
+
+		assert.Equal(t, first.ID, second.ID)
+	})
+
+	t.Run("OutsiderRejected", func(t *testing.T) {
+		conversation, err := service.StartConversation(outsider.ID, req)
+
+		assert.Error(t, err)
+		assert.Nil(t, conversation)
+		assert.Contains(t, err.Error(), "not a participant")
+	})
+
+	t.Run("InvalidType", func(t *testing.T) {
+		conversation, err := service.StartConversation(applicant.ID, StartConversationRequest{Type: "other", RelatedID: 1})
+
+		assert.Error(t, err)
+		assert.Nil(t, conversation)
+		assert.Contains(t, err.Error(), "invalid conversation type")
+	})
+}
+
+func TestMessageService_SendAndReadMessages(t *testing.T) {
+	service, db := setupMessageService()
+	defer testutils.CleanupTestDB(db)
+
+	owner := testutils.CreateTestUser(db)
+	renter := createApplicant(t, db, "renter@example.com")
+	outsider := createApplicant(t, db, "outsider@example.com")
+	equipment := testutils.CreateTestEquipment(db, owner.ID)
+
+	rental := &models.EquipmentRental{EquipmentID: equipment.ID, RenterUserID: renter.ID, Status: models.RentalStatusRequested}
+	require.NoError(t, db.Create(rental).Error)
+
+	conversation, err := service.StartConversation(renter.ID, StartConversationRequest{
+		Type:      models.ConversationTypeEquipmentRental,
+		RelatedID: rental.ID,
+	})
+	require.NoError(t, err)
+
+	t.Run("RedactsContactInfoBeforeApproval", func(t *testing.T) {
+		message, err := service.SendMessage(conversation.ID, renter.ID, SendMessageRequest{Body: "Text me at 555-123-4567"})
+
+		require.NoError(t, err)
+		assert.True(t, message.Redacted)
+		assert.NotContains(t, message.Body, "555-123-4567")
+	})
+
+	t.Run("UnreadCountForRecipient", func(t *testing.T) {
+		conversations, err := service.GetConversations(owner.ID)
+
+		require.NoError(t, err)
+		require.Len(t, conversations, 1)
+		assert.Equal(t, int64(1), conversations[0].UnreadCount)
+	})
+
+	t.Run("ReadingMarksMessagesRead", func(t *testing.T) {
+		messages, err := service.GetMessages(conversation.ID, owner.ID, 1, 50)
+		require.NoError(t, err)
+		assert.Len(t, messages, 1)
+
+		conversations, err := service.GetConversations(owner.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), conversations[0].UnreadCount)
+
+		// The sender sees the read receipt
+		messages, err = service.GetMessages(conversation.ID, renter.ID, 1, 50)
+		require.NoError(t, err)
+		assert.NotNil(t, messages[0].ReadAt)
+	})
+
+	t.Run("ContactInfoAllowedAfterApproval", func(t *testing.T) {
+		require.NoError(t, db.Model(rental).Update("status", models.RentalStatusApproved).Error)
+
+		message, err := service.SendMessage(conversation.ID, owner.ID, SendMessageRequest{Body: "Call me at 555-123-4567"})
+
+		require.NoError(t, err)
+		assert.False(t, message.Redacted)
+		assert.Contains(t, message.Body, "555-123-4567")
+	})
+
+	t.Run("EmptyMessageRejected", func(t *testing.T) {
+		message, err := service.SendMessage(conversation.ID, owner.ID, SendMessageRequest{Body: "   "})
+
+		assert.Error(t, err)
+		assert.Nil(t, message)
+	})
+
+	t.Run("OutsiderCannotReadOrSend", func(t *testing.T) {
+		_, err := service.GetMessages(conversation.ID, outsider.ID, 1, 50)
+		assert.Error(t, err)
+
+		_, err = service.SendMessage(conversation.ID, outsider.ID, SendMessageRequest{Body: "hi"})
+		assert.Error(t, err)
+	})
+
+	t.Run("AdminCanReadAnyConversation", func(t *testing.T) {
+		messages, err := service.GetMessagesAsAdmin(conversation.ID, 1, 50)
+
+		require.NoError(t, err)
+		assert.Len(t, messages, 2)
+	})
+}
+
+func TestMessageService_Attachments(t *testing.T) {
+	uploads, db := setupUploadService(t)
+	defer testutils.CleanupTestDB(db)
+	service := &MessageService{db: db, uploads: uploads}
+
+	owner := testutils.CreateTestUser(db)
+	renter := createApplicant(t, db, "renter@example.com")
+	equipment := testutils.CreateTestEquipment(db, owner.ID)
+	rental := &models.EquipmentRental{EquipmentID: equipment.ID, RenterUserID: renter.ID, Status: models.RentalStatusApproved}
+	require.NoError(t, db.Create(rental).Error)
+
+	conversation, err := service.StartConversation(renter.ID, StartConversationRequest{
+		Type:      models.ConversationTypeEquipmentRental,
+		RelatedID: rental.ID,
+	})
+	require.NoError(t, err)
+
+	upload := func(userID uint, category string) *UploadImageResponse {
+		t.Helper()
+		response, err := uploads.UploadFromReader(userID, bytes.NewReader(testutils.CreateTestImage(40, 30)), "scratch.jpg", "image/jpeg", category)
+		require.NoError(t, err)
+		return response
+	}
+
+	t.Run("OwnUploadIsPrivateAndSigned", func(t *testing.T) {
+		attachment := upload(renter.ID, UploadCategoryMessageAttachment)
+		assert.Equal(t, "private", attachment.Visibility)
+
+		message, err := service.SendMessage(conversation.ID, renter.ID, SendMessageRequest{Attachments: []string{attachment.Key}})
+		require.NoError(t, err)
+		require.Len(t, message.Attachments, 1)
+		assert.Contains(t, message.Attachments[0], "signature=")
+
+		var stored models.Message
+		require.NoError(t, db.First(&stored, message.ID).Error)
+		assert.Equal(t, models.StringArray{attachment.Key}, stored.Attachments, "keys are stored, not URLs")
+
+		messages, err := service.GetMessages(conversation.ID, owner.ID, 1, 50)
+		require.NoError(t, err)
+		assert.Contains(t, messages[0].Attachments[0], "signature=", "the other side gets a signed URL")
+	})
+
+	t.Run("SomeoneElsesUploadRejected", func(t *testing.T) {
+		attachment := upload(owner.ID, UploadCategoryMessageAttachment)
+
+		_, err := service.SendMessage(conversation.ID, renter.ID, SendMessageRequest{Attachments: []string{attachment.Key}})
+		assert.ErrorIs(t, err, ErrInvalidAttachment)
+	})
+
+	t.Run("OtherCategoryRejected", func(t *testing.T) {
+		attachment := upload(renter.ID, UploadCategoryListingPhoto)
+
+		_, err := service.SendMessage(conversation.ID, renter.ID, SendMessageRequest{Attachments: []string{attachment.URL}})
+		assert.ErrorIs(t, err, ErrInvalidAttachment)
+	})
+
+	t.Run("ExternalURLRejected", func(t *testing.T) {
+		_, err := service.SendMessage(conversation.ID, renter.ID, SendMessageRequest{Attachments: []string{"https://evil.example.com/x.jpg"}})
+		assert.ErrorIs(t, err, ErrInvalidAttachment)
+	})
+
+	t.Run("TooManyRejected", func(t *testing.T) {
+		attachment := upload(renter.ID, UploadCategoryMessageAttachment)
+		refs := make([]string, MaxMessageAttachments+1)
+		for i := range refs {
+			refs[i] = attachment.Key
+		}
+
+		_, err := service.SendMessage(conversation.ID, renter.ID, SendMessageRequest{Attachments: refs})
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "at most 5")
+	})
+
+	t.Run("UnavailableWithoutStorage", func(t *testing.T) {
+		withoutStorage := &MessageService{db: db}
+
+		_, err := withoutStorage.SendMessage(conversation.ID, renter.ID, SendMessageRequest{Attachments: []string{"private/2/1_scratch.jpg"}})
+		assert.ErrorIs(t, err, ErrAttachmentsUnavailable)
+	})
+}
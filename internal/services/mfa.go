@@ -0,0 +1,381 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/pkg/auth"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// mfaIssuer names the account in authenticator apps.
+	mfaIssuer = "Mowsy"
+
+	recoveryCodeCount = 10
+)
+
+var (
+	ErrMFAAlreadyEnabled       = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled           = errors.New("two-factor authentication is not enabled")
+	ErrMFAEnrollmentNotStarted = errors.New("start two-factor enrollment first")
+	ErrMFARequiredForRole      = errors.New("two-factor authentication is required for your account")
+	ErrInvalidMFACode          = errors.New("invalid verification code")
+	ErrInvalidMFAToken         = errors.New("invalid or expired MFA token")
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// MFAChallenge is what Login returns in place of tokens for users with 2FA.
+// The client sends MFAToken back with a code to finish signing in. When
+// EnrollmentRequired is set the user has no authenticator yet and must
+// enroll one first.
+type MFAChallenge struct {
+	MFARequired        bool      `json:"mfa_required"`
+	EnrollmentRequired bool      `json:"enrollment_required"`
+	MFAToken           string    `json:"mfa_token"`
+	ExpiresAt          time.Time `json:"expires_at"`
+}
+
+// MFARequiredError is returned by Login when the password was right but a
+// second factor is still needed.
+type MFARequiredError struct {
+	Challenge MFAChallenge
+}
+
+func (e *MFARequiredError) Error() string {
+	if e.Challenge.EnrollmentRequired {
+		return "two-factor enrollment required"
+	}
+	return "two-factor authentication required"
+}
+
+type MFAEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+type MFACodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type MFAVerifyRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// mfaRequiredRolesFromEnv reads MFA_REQUIRED_ROLES, a comma separated list
+// of roles. Admins need 2FA unless it is set, even to an empty string.
+func mfaRequiredRolesFromEnv() map[models.UserRole]bool {
+	value, ok := os.LookupEnv("MFA_REQUIRED_ROLES")
+	if !ok {
+		value = string(models.UserRoleAdmin)
+	}
+
+	roles := map[models.UserRole]bool{}
+	for _, role := range strings.Split(value, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			roles[models.UserRole(role)] = true
+		}
+	}
+	return roles
+}
+
+// requireSecondFactor returns a *MFARequiredError if user may not be issued
+// tokens on their password alone.
+func (s *UserService) requireSecondFactor(user *models.User) error {
+	enroll := !user.MFAEnabled
+	if enroll && !s.mfaRequiredRoles[user.Role] {
+		return nil
+	}
+
+	token, expiresAt, err := auth.GenerateMFAChallengeToken(user.ID, enroll)
+	if err != nil {
+		return fmt.Errorf("failed to generate MFA token: %w", err)
+	}
+
+	return &MFARequiredError{Challenge: MFAChallenge{
+		MFARequired:        true,
+		EnrollmentRequired: enroll,
+		MFAToken:           token,
+		ExpiresAt:          expiresAt,
+	}}
+}
+
+// VerifyMFA finishes a sign-in that Login answered with a challenge. The code
+// may be a TOTP code or an unused recovery code. For an enrollment challenge
+// it must be a code from the newly enrolled authenticator, and the response
+// carries the user's recovery codes.
+func (s *UserService) VerifyMFA(req MFAVerifyRequest, clientIP string) (*LoginResponse, error) {
+	claims, err := auth.ValidateMFAChallengeToken(req.MFAToken)
+	if err != nil {
+		return nil, ErrInvalidMFAToken
+	}
+
+	now := s.now()
+	if err := s.checkLoginThrottle(ipLoginKey(clientIP), ipLoginThrottle, now); err != nil {
+		return nil, err
+	}
+	if err := s.checkLoginThrottle(accountLoginKey(claims.UserID), accountLoginThrottle, now); err != nil {
+		return nil, err
+	}
+
+	user, err := s.GetUserByID(claims.UserID)
+	if err != nil {
+		return nil, ErrInvalidMFAToken
+	}
+
+	var recoveryCodes []string
+	if claims.Enroll {
+		recoveryCodes, err = s.ConfirmMFAEnrollment(user.ID, req.Code)
+		user.MFAEnabled = err == nil
+	} else if !user.MFAEnabled {
+		return nil, ErrInvalidMFAToken
+	} else {
+		err = s.verifySecondFactor(user, req.Code)
+	}
+
+	if errors.Is(err, ErrInvalidMFACode) {
+		if err := s.recordFailedLogin(user, clientIP, now); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidMFACode
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.clearLoginFailures(user.ID); err != nil {
+		return nil, err
+	}
+
+	response, err := newLoginResponse(user)
+	if err != nil {
+		return nil, err
+	}
+	response.RecoveryCodes = recoveryCodes
+	return response, nil
+}
+
+// BeginMFAEnrollment generates a new TOTP secret for the user. It takes
+// effect once ConfirmMFAEnrollment sees a code from it.
+func (s *UserService) BeginMFAEnrollment(userID uint) (*MFAEnrollment, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(user).Update("mfa_pending_secret", secret).Error; err != nil {
+		return nil, fmt.Errorf("failed to save MFA secret: %w", err)
+	}
+
+	return &MFAEnrollment{
+		Secret:          secret,
+		ProvisioningURI: auth.TOTPProvisioningURI(mfaIssuer, user.Email, secret),
+	}, nil
+}
+
+// BeginMFAEnrollmentWithChallenge lets a user who must enroll before
+// signing in start enrollment with their challenge token.
+func (s *UserService) BeginMFAEnrollmentWithChallenge(mfaToken string) (*MFAEnrollment, error) {
+	claims, err := auth.ValidateMFAChallengeToken(mfaToken)
+	if err != nil || !claims.Enroll {
+		return nil, ErrInvalidMFAToken
+	}
+	return s.BeginMFAEnrollment(claims.UserID)
+}
+
+// ConfirmMFAEnrollment turns on 2FA once the user proves their authenticator
+// works, and returns a fresh set of recovery codes.
+func (s *UserService) ConfirmMFAEnrollment(userID uint, code string) ([]string, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.MFAPendingSecret == "" {
+		return nil, ErrMFAEnrollmentNotStarted
+	}
+
+	step, ok := auth.ValidateTOTP(user.MFAPendingSecret, code, s.now())
+	if !ok {
+		return nil, ErrInvalidMFACode
+	}
+
+	var codes []string
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"mfa_enabled":        true,
+			"mfa_secret":         user.MFAPendingSecret,
+			"mfa_pending_secret": "",
+			"mfa_last_step":      step,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to enable MFA: %w", err)
+		}
+
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// DisableMFA turns 2FA off after checking a current code. Users whose role
+// requires 2FA cannot turn it off.
+func (s *UserService) DisableMFA(userID uint, code string) error {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if !user.MFAEnabled {
+		return ErrMFANotEnabled
+	}
+	if s.mfaRequiredRoles[user.Role] {
+		return ErrMFARequiredForRole
+	}
+	if err := s.verifySecondFactor(user, code); err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"mfa_enabled":   false,
+			"mfa_secret":    "",
+			"mfa_last_step": 0,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to disable MFA: %w", err)
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.MFARecoveryCode{}).Error; err != nil {
+			return fmt.Errorf("failed to delete recovery codes: %w", err)
+		}
+		return nil
+	})
+}
+
+// RegenerateRecoveryCodes replaces all of the user's recovery codes, used or
+// not, after checking a current code.
+func (s *UserService) RegenerateRecoveryCodes(userID uint, code string) ([]string, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.MFAEnabled {
+		return nil, ErrMFANotEnabled
+	}
+	if err := s.verifySecondFactor(user, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// verifySecondFactor accepts a TOTP code newer than the last one used, or an
+// unused recovery code, which is then spent.
+func (s *UserService) verifySecondFactor(user *models.User, code string) error {
+	if step, ok := auth.ValidateTOTP(user.MFASecret, code, s.now()); ok {
+		// The conditional update stops two requests racing with one code
+		result := s.db.Model(&models.User{}).Where("id = ? AND mfa_last_step < ?", user.ID, step).
+			Update("mfa_last_step", step)
+		if result.Error != nil {
+			return fmt.Errorf("failed to record MFA code: %w", result.Error)
+		}
+		if result.RowsAffected == 0 {
+			return ErrInvalidMFACode
+		}
+		return nil
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	if normalized == "" {
+		return ErrInvalidMFACode
+	}
+	result := s.db.Model(&models.MFARecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashRecoveryCode(normalized)).
+		Update("used_at", s.now())
+	if result.Error != nil {
+		return fmt.Errorf("failed to use recovery code: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.MFARecoveryCode{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	rows := make([]models.MFARecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		rows[i] = models.MFARecoveryCode{UserID: userID, CodeHash: hashRecoveryCode(normalizeRecoveryCode(code))}
+	}
+
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to save recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+// newRecoveryCode returns 80 random bits as four groups of four characters,
+// e.g. "k3xq-7mza-p2dw-r5tn".
+func newRecoveryCode() (string, error) {
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate recovery code: %w", err)
+	}
+
+	encoded := strings.ToLower(recoveryCodeEncoding.EncodeToString(buf))
+	return encoded[0:4] + "-" + encoded[4:8] + "-" + encoded[8:12] + "-" + encoded[12:16], nil
+}
+
+// normalizeRecoveryCode ignores case, dashes and spaces so codes can be typed
+// loosely.
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// hashRecoveryCode uses a plain SHA-256: the codes are random and long
+// enough that a slow hash adds nothing.
+func hashRecoveryCode(normalized string) string {
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
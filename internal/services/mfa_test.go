@@ -0,0 +1,211 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/pkg/auth"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupMFAFixture(t *testing.T) *loginFixture {
+	f := setupLoginFixture(t)
+	f.service.mfaRequiredRoles = map[models.UserRole]bool{models.UserRoleAdmin: true}
+	return f
+}
+
+// totp returns the current code for secret on the fixture's clock.
+func (f *loginFixture) totp(t *testing.T, secret string) string {
+	code, err := auth.TOTPCode(secret, auth.TOTPStep(f.now))
+	require.NoError(t, err)
+	return code
+}
+
+// enroll turns on 2FA for the fixture's user and returns the secret and
+// recovery codes.
+func (f *loginFixture) enroll(t *testing.T) (string, []string) {
+	enrollment, err := f.service.BeginMFAEnrollment(f.user.ID)
+	require.NoError(t, err)
+	codes, err := f.service.ConfirmMFAEnrollment(f.user.ID, f.totp(t, enrollment.Secret))
+	require.NoError(t, err)
+	f.now = f.now.Add(auth.TOTPPeriod)
+	return enrollment.Secret, codes
+}
+
+func (f *loginFixture) challenge(t *testing.T) MFAChallenge {
+	err := f.login("mower-shed-42")
+	var mfaRequired *MFARequiredError
+	require.ErrorAs(t, err, &mfaRequired)
+	return mfaRequired.Challenge
+}
+
+func TestUserService_MFAEnrollment(t *testing.T) {
+	f := setupMFAFixture(t)
+
+	enrollment, err := f.service.BeginMFAEnrollment(f.user.ID)
+	require.NoError(t, err)
+	assert.Contains(t, enrollment.ProvisioningURI, "secret="+enrollment.Secret)
+	assert.True(t, strings.HasPrefix(enrollment.ProvisioningURI, "otpauth://totp/Mowsy:locked@example.com?"))
+
+	// Not on until confirmed
+	require.NoError(t, f.login("mower-shed-42"))
+
+	_, err = f.service.ConfirmMFAEnrollment(f.user.ID, "000000")
+	assert.ErrorIs(t, err, ErrInvalidMFACode)
+
+	codes, err := f.service.ConfirmMFAEnrollment(f.user.ID, f.totp(t, enrollment.Secret))
+	require.NoError(t, err)
+	assert.Len(t, codes, recoveryCodeCount)
+
+	var user models.User
+	require.NoError(t, f.db.First(&user, f.user.ID).Error)
+	assert.True(t, user.MFAEnabled)
+	assert.Equal(t, enrollment.Secret, user.MFASecret)
+	assert.Empty(t, user.MFAPendingSecret)
+
+	var stored []models.MFARecoveryCode
+	require.NoError(t, f.db.Where("user_id = ?", f.user.ID).Find(&stored).Error)
+	require.Len(t, stored, recoveryCodeCount)
+	for _, row := range stored {
+		assert.NotContains(t, codes, row.CodeHash)
+	}
+
+	_, err = f.service.BeginMFAEnrollment(f.user.ID)
+	assert.ErrorIs(t, err, ErrMFAAlreadyEnabled)
+}
+
+func TestUserService_LoginWithMFA(t *testing.T) {
+	f := setupMFAFixture(t)
+	secret, recoveryCodes := f.enroll(t)
+
+	challenge := f.challenge(t)
+	assert.True(t, challenge.MFARequired)
+	assert.False(t, challenge.EnrollmentRequired)
+
+	_, err := auth.ValidateToken(challenge.MFAToken)
+	assert.Error(t, err, "a challenge is not an access token")
+
+	t.Run("TOTPCode", func(t *testing.T) {
+		code := f.totp(t, secret)
+		response, err := f.service.VerifyMFA(MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: code}, testClientIP)
+		require.NoError(t, err)
+		assert.NotEmpty(t, response.AccessToken)
+		assert.True(t, response.User.MFAEnabled)
+		assert.Empty(t, response.RecoveryCodes)
+
+		// The same code cannot be used twice
+		_, err = f.service.VerifyMFA(MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: code}, testClientIP)
+		assert.ErrorIs(t, err, ErrInvalidMFACode)
+	})
+
+	t.Run("RecoveryCode", func(t *testing.T) {
+		typed := strings.ToUpper(strings.ReplaceAll(recoveryCodes[0], "-", ""))
+		_, err := f.service.VerifyMFA(MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: typed}, testClientIP)
+		require.NoError(t, err)
+
+		_, err = f.service.VerifyMFA(MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: recoveryCodes[0]}, testClientIP)
+		assert.ErrorIs(t, err, ErrInvalidMFACode)
+	})
+
+	t.Run("BadToken", func(t *testing.T) {
+		accessToken, err := auth.GenerateToken(f.user.ID, f.user.Email)
+		require.NoError(t, err)
+		_, err = f.service.VerifyMFA(MFAVerifyRequest{MFAToken: accessToken, Code: f.totp(t, secret)}, testClientIP)
+		assert.ErrorIs(t, err, ErrInvalidMFAToken)
+	})
+
+	t.Run("WrongCodesLockTheAccount", func(t *testing.T) {
+		var err error
+		for i := 0; i < accountLockoutFailures; i++ {
+			_, err = f.service.VerifyMFA(MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: "000000"}, testClientIP)
+			var throttled *LoginThrottledError
+			require.False(t, errors.As(err, &throttled), "attempt %d should not be throttled", i+1)
+			f.now = f.now.Add(accountMaxDelay)
+		}
+		assert.ErrorIs(t, err, ErrAccountLocked)
+	})
+}
+
+func TestUserService_MFARequiredForRole(t *testing.T) {
+	f := setupMFAFixture(t)
+	require.NoError(t, f.db.Model(f.user).Update("role", models.UserRoleAdmin).Error)
+
+	challenge := f.challenge(t)
+	assert.True(t, challenge.EnrollmentRequired)
+
+	_, err := f.service.VerifyMFA(MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: "000000"}, testClientIP)
+	assert.ErrorIs(t, err, ErrMFAEnrollmentNotStarted)
+
+	enrollment, err := f.service.BeginMFAEnrollmentWithChallenge(challenge.MFAToken)
+	require.NoError(t, err)
+
+	response, err := f.service.VerifyMFA(MFAVerifyRequest{MFAToken: challenge.MFAToken, Code: f.totp(t, enrollment.Secret)}, testClientIP)
+	require.NoError(t, err)
+	assert.NotEmpty(t, response.AccessToken)
+	assert.True(t, response.User.MFAEnabled)
+	assert.Len(t, response.RecoveryCodes, recoveryCodeCount)
+
+	f.now = f.now.Add(auth.TOTPPeriod)
+	assert.ErrorIs(t, f.service.DisableMFA(f.user.ID, f.totp(t, enrollment.Secret)), ErrMFARequiredForRole)
+
+	// Once enrolled, the usual challenge cannot restart enrollment
+	challenge = f.challenge(t)
+	assert.False(t, challenge.EnrollmentRequired)
+	_, err = f.service.BeginMFAEnrollmentWithChallenge(challenge.MFAToken)
+	assert.ErrorIs(t, err, ErrInvalidMFAToken)
+}
+
+func TestUserService_DisableMFA(t *testing.T) {
+	f := setupMFAFixture(t)
+	secret, _ := f.enroll(t)
+
+	assert.ErrorIs(t, f.service.DisableMFA(f.user.ID, "000000"), ErrInvalidMFACode)
+	require.NoError(t, f.service.DisableMFA(f.user.ID, f.totp(t, secret)))
+
+	var count int64
+	f.db.Model(&models.MFARecoveryCode{}).Where("user_id = ?", f.user.ID).Count(&count)
+	assert.Zero(t, count)
+	require.NoError(t, f.login("mower-shed-42"))
+
+	assert.ErrorIs(t, f.service.DisableMFA(f.user.ID, f.totp(t, secret)), ErrMFANotEnabled)
+}
+
+func TestUserService_RegenerateRecoveryCodes(t *testing.T) {
+	f := setupMFAFixture(t)
+	_, oldCodes := f.enroll(t)
+
+	newCodes, err := f.service.RegenerateRecoveryCodes(f.user.ID, oldCodes[0])
+	require.NoError(t, err)
+	assert.Len(t, newCodes, recoveryCodeCount)
+
+	// Old codes stop working
+	_, err = f.service.RegenerateRecoveryCodes(f.user.ID, oldCodes[1])
+	assert.ErrorIs(t, err, ErrInvalidMFACode)
+	_, err = f.service.RegenerateRecoveryCodes(f.user.ID, newCodes[0])
+	assert.NoError(t, err)
+}
+
+func TestMFARequiredRolesFromEnv(t *testing.T) {
+	original, wasSet := os.LookupEnv("MFA_REQUIRED_ROLES")
+	t.Cleanup(func() {
+		if wasSet {
+			os.Setenv("MFA_REQUIRED_ROLES", original)
+		} else {
+			os.Unsetenv("MFA_REQUIRED_ROLES")
+		}
+	})
+
+	os.Unsetenv("MFA_REQUIRED_ROLES")
+	assert.Equal(t, map[models.UserRole]bool{models.UserRoleAdmin: true}, mfaRequiredRolesFromEnv())
+
+	os.Setenv("MFA_REQUIRED_ROLES", "")
+	assert.Empty(t, mfaRequiredRolesFromEnv())
+
+	os.Setenv("MFA_REQUIRED_ROLES", "admin, user")
+	assert.Equal(t, map[models.UserRole]bool{models.UserRoleAdmin: true, models.UserRoleUser: true}, mfaRequiredRolesFromEnv())
+}
@@ -0,0 +1,345 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"text/template"
+	"time"
+
+	"mowsy-api/internal/events"
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/tasks"
+	"mowsy-api/pkg/notify"
+
+	"gorm.io/gorm"
+)
+
+// maxNotificationAttempts is lower than the queue default: a notification
+// that is hours late is not worth sending.
+const maxNotificationAttempts = 5
+
+var quietHoursPattern = regexp.MustCompile(`^([01]\d|2[0-3]):[0-5]\d$`)
+
+type notificationTemplate struct {
+	subject *template.Template
+	body    *template.Template
+}
+
+func newNotificationTemplate(subject, body string) notificationTemplate {
+	return notificationTemplate{
+		subject: template.Must(template.New("subject").Parse(subject)),
+		body:    template.Must(template.New("body").Parse(body)),
+	}
+}
+
+// notificationTemplates lists the events users are notified about. Events
+// without a template are ignored.
+var notificationTemplates = map[events.Type]notificationTemplate{
+	events.ApplicationReceived: newNotificationTemplate(
+		"New applicant for {{.job_title}}",
+		"{{.applicant_name}} applied for your job \"{{.job_title}}\". Open Mowsy to review the application.",
+	),
+	events.ApplicationAccepted: newNotificationTemplate(
+		"You got the job: {{.job_title}}",
+		"Your application for \"{{.job_title}}\" was accepted. Open Mowsy to message the poster.",
+	),
+	events.RentalRequested: newNotificationTemplate(
+		"Rental request for {{.equipment_name}}",
+		"{{.renter_name}} wants to rent your {{.equipment_name}} from {{.start_date}} to {{.end_date}}.",
+	),
+	events.RentalApproved: newNotificationTemplate(
+		"Rental approved: {{.equipment_name}}",
+		"Your rental of {{.equipment_name}} was approved. Open Mowsy to arrange pickup.",
+	),
+	events.PaymentSucceeded: newNotificationTemplate(
+		"Payment received",
+		"Your payment of ${{printf \"%.2f\" .amount}} was successful.",
+	),
+	events.PaymentFailed: newNotificationTemplate(
+		"Payment failed",
+		"Your payment of ${{printf \"%.2f\" .amount}} didn't go through: {{.reason}} Open Mowsy to pay with another method.",
+	),
+	events.MessageReceived: newNotificationTemplate(
+		"New message on Mowsy",
+		"You have a new message. Open Mowsy to read it.",
+	),
+}
+
+type NotificationService struct {
+	db      *gorm.DB
+	drivers notify.Drivers
+	now     func() time.Time
+}
+
+type UpdateNotificationPreferencesRequest struct {
+	EmailEnabled    *bool   `json:"email_enabled"`
+	SMSEnabled      *bool   `json:"sms_enabled"`
+	PushEnabled     *bool   `json:"push_enabled"`
+	PushToken       *string `json:"push_token"`
+	QuietHoursStart *string `json:"quiet_hours_start"`
+	QuietHoursEnd   *string `json:"quiet_hours_end"`
+	Timezone        *string `json:"timezone"`
+}
+
+// eventTask queues the notifications for an event recorded by recordEvent.
+// All recipients are queued together so a retry does not notify anyone twice.
+func (s *NotificationService) eventTask(ctx context.Context, task *models.Task) error {
+	event, err := decodeEventTask(task)
+	if err != nil {
+		return err
+	}
+
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, userID := range event.UserIDs {
+			if err := s.enqueue(tx, userID, event.Type, event.Data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Enqueue renders the template for eventType and records one notification per
+// channel the user has enabled, each with a delivery task.
+func (s *NotificationService) Enqueue(userID uint, eventType events.Type, data interface{}) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		return s.enqueue(tx, userID, eventType, data)
+	})
+}
+
+func (s *NotificationService) enqueue(tx *gorm.DB, userID uint, eventType events.Type, data interface{}) error {
+	tmpl, ok := notificationTemplates[eventType]
+	if !ok {
+		return nil
+	}
+
+	var user models.User
+	if err := tx.Select("id, email, phone, is_active").Where("id = ?", userID).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to fetch user: %w", err)
+	}
+	if !user.IsActive {
+		return nil
+	}
+
+	prefs, err := notificationPreferences(tx, userID)
+	if err != nil {
+		return err
+	}
+
+	var subject, body bytes.Buffer
+	if err := tmpl.subject.Execute(&subject, data); err != nil {
+		return fmt.Errorf("failed to render notification subject: %w", err)
+	}
+	if err := tmpl.body.Execute(&body, data); err != nil {
+		return fmt.Errorf("failed to render notification body: %w", err)
+	}
+
+	now := s.now()
+	recipients := map[notify.Channel]string{}
+	if prefs.EmailEnabled {
+		recipients[notify.ChannelEmail] = user.Email
+	}
+	if prefs.SMSEnabled {
+		recipients[notify.ChannelSMS] = user.Phone
+	}
+	if prefs.PushEnabled {
+		recipients[notify.ChannelPush] = prefs.PushToken
+	}
+
+	var notifications []models.Notification
+	runAt := map[string]time.Time{}
+	for channel, recipient := range recipients {
+		if recipient == "" {
+			continue
+		}
+		notifications = append(notifications, models.Notification{
+			UserID:    userID,
+			Event:     string(eventType),
+			Channel:   string(channel),
+			Recipient: recipient,
+			Subject:   subject.String(),
+			Body:      body.String(),
+			Status:    models.NotificationStatusPending,
+		})
+		runAt[string(channel)] = deliveryTime(prefs, channel, now)
+	}
+
+	if len(notifications) == 0 {
+		return nil
+	}
+
+	if err := tx.Create(&notifications).Error; err != nil {
+		return fmt.Errorf("failed to queue notifications: %w", err)
+	}
+	for _, notification := range notifications {
+		if err := tasks.Enqueue(tx, TaskDeliverNotification, recordTaskPayload{ID: notification.ID},
+			tasks.RunAt(runAt[notification.Channel]),
+			tasks.MaxAttempts(maxNotificationAttempts)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliveryTime holds SMS and push until the end of the user's quiet hours.
+// Email is not interruptive, so it always goes out right away.
+func deliveryTime(prefs *models.NotificationPreference, channel notify.Channel, now time.Time) time.Time {
+	if channel == notify.ChannelEmail || prefs.QuietHoursStart == "" || prefs.QuietHoursEnd == "" {
+		return now
+	}
+
+	loc, err := time.LoadLocation(prefs.Timezone)
+	if err != nil {
+		loc = time.UTC
+	}
+
+	start := minutesOfDay(prefs.QuietHoursStart)
+	end := minutesOfDay(prefs.QuietHoursEnd)
+	local := now.In(loc)
+	current := local.Hour()*60 + local.Minute()
+
+	var quiet bool
+	if start <= end {
+		quiet = current >= start && current < end
+	} else {
+		quiet = current >= start || current < end
+	}
+	if !quiet {
+		return now
+	}
+
+	resume := time.Date(local.Year(), local.Month(), local.Day(), end/60, end%60, 0, 0, loc)
+	if !resume.After(local) {
+		resume = resume.AddDate(0, 0, 1)
+	}
+	return resume.In(now.Location())
+}
+
+func minutesOfDay(hhmm string) int {
+	hours, _ := strconv.Atoi(hhmm[:2])
+	minutes, _ := strconv.Atoi(hhmm[3:])
+	return hours*60 + minutes
+}
+
+// deliverTask sends one queued notification. Failures are returned so the
+// queue retries them; the row is marked failed once the queue gives up.
+func (s *NotificationService) deliverTask(ctx context.Context, task *models.Task) error {
+	var payload recordTaskPayload
+	if err := tasks.Decode(task, &payload); err != nil {
+		return err
+	}
+
+	var notification models.Notification
+	if err := s.db.Where("id = ?", payload.ID).First(&notification).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tasks.Permanent(errors.New("notification not found"))
+		}
+		return fmt.Errorf("failed to fetch notification: %w", err)
+	}
+	if notification.Status == models.NotificationStatusSent {
+		return nil
+	}
+
+	sendErr := s.drivers.Send(ctx, notify.Message{
+		Channel: notify.Channel(notification.Channel),
+		To:      notification.Recipient,
+		Subject: notification.Subject,
+		Body:    notification.Body,
+		Data:    map[string]string{"event": notification.Event},
+	})
+	if errors.Is(sendErr, notify.ErrNoRecipient) {
+		sendErr = tasks.Permanent(sendErr)
+	}
+
+	updates := map[string]interface{}{"attempts": notification.Attempts + 1}
+	switch {
+	case sendErr == nil:
+		updates["status"] = models.NotificationStatusSent
+		updates["sent_at"] = s.now()
+		updates["last_error"] = ""
+	case task.IsFinalAttempt() || tasks.IsPermanent(sendErr):
+		updates["status"] = models.NotificationStatusFailed
+		updates["last_error"] = sendErr.Error()
+	default:
+		updates["last_error"] = sendErr.Error()
+	}
+
+	if err := s.db.Model(&notification).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update notification: %w", err)
+	}
+
+	return sendErr
+}
+
+func (s *NotificationService) GetPreferences(userID uint) (*models.NotificationPreference, error) {
+	return notificationPreferences(s.db, userID)
+}
+
+func notificationPreferences(db *gorm.DB, userID uint) (*models.NotificationPreference, error) {
+	var prefs models.NotificationPreference
+	if err := db.Where("user_id = ?", userID).First(&prefs).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			defaults := models.DefaultNotificationPreference(userID)
+			return &defaults, nil
+		}
+		return nil, fmt.Errorf("failed to fetch notification preferences: %w", err)
+	}
+	return &prefs, nil
+}
+
+func (s *NotificationService) UpdatePreferences(userID uint, req UpdateNotificationPreferencesRequest) (*models.NotificationPreference, error) {
+	prefs, err := s.GetPreferences(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.EmailEnabled != nil {
+		prefs.EmailEnabled = *req.EmailEnabled
+	}
+	if req.SMSEnabled != nil {
+		prefs.SMSEnabled = *req.SMSEnabled
+	}
+	if req.PushEnabled != nil {
+		prefs.PushEnabled = *req.PushEnabled
+	}
+	if req.PushToken != nil {
+		prefs.PushToken = *req.PushToken
+	}
+	if req.QuietHoursStart != nil {
+		prefs.QuietHoursStart = *req.QuietHoursStart
+	}
+	if req.QuietHoursEnd != nil {
+		prefs.QuietHoursEnd = *req.QuietHoursEnd
+	}
+	if req.Timezone != nil {
+		prefs.Timezone = *req.Timezone
+	}
+
+	if (prefs.QuietHoursStart == "") != (prefs.QuietHoursEnd == "") {
+		return nil, errors.New("quiet hours need both a start and an end")
+	}
+	for _, value := range []string{prefs.QuietHoursStart, prefs.QuietHoursEnd} {
+		if value != "" && !quietHoursPattern.MatchString(value) {
+			return nil, errors.New("quiet hours must use HH:MM format")
+		}
+	}
+	if prefs.Timezone == "" {
+		prefs.Timezone = "UTC"
+	}
+	if _, err := time.LoadLocation(prefs.Timezone); err != nil {
+		return nil, errors.New("invalid timezone")
+	}
+
+	if err := s.db.Save(prefs).Error; err != nil {
+		return nil, fmt.Errorf("failed to save notification preferences: %w", err)
+	}
+
+	return prefs, nil
+}
@@ -0,0 +1,392 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"mowsy-api/internal/events"
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/tasks"
+	"mowsy-api/internal/testutils"
+	"mowsy-api/pkg/notify"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+type notificationFixture struct {
+	service *NotificationService
+	db      *gorm.DB
+	email   *notify.MemoryDriver
+	sms     *notify.MemoryDriver
+	push    *notify.MemoryDriver
+	now     time.Time
+}
+
+func setupNotificationService() *notificationFixture {
+	db := testutils.SetupTestDB()
+	f := &notificationFixture{
+		db:    db,
+		email: notify.NewMemoryDriver(notify.ChannelEmail),
+		sms:   notify.NewMemoryDriver(notify.ChannelSMS),
+		push:  notify.NewMemoryDriver(notify.ChannelPush),
+		now:   time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC),
+	}
+	f.service = &NotificationService{
+		db: db,
+		drivers: notify.Drivers{
+			notify.ChannelEmail: f.email,
+			notify.ChannelSMS:   f.sms,
+			notify.ChannelPush:  f.push,
+		},
+		now: func() time.Time { return f.now },
+	}
+	return f
+}
+
+func TestNotificationService_Enqueue(t *testing.T) {
+	f := setupNotificationService()
+	defer testutils.CleanupTestDB(f.db)
+
+	user := testutils.CreateTestUser(f.db)
+	data := map[string]interface{}{"job_title": "Mow my lawn", "applicant_name": "Sam"}
+
+	t.Run("DefaultPreferencesSendEmailOnly", func(t *testing.T) {
+		require.NoError(t, f.service.Enqueue(user.ID, events.ApplicationReceived, data))
+
+		var notifications []models.Notification
+		require.NoError(t, f.db.Where("user_id = ?", user.ID).Find(&notifications).Error)
+		require.Len(t, notifications, 1)
+		assert.Equal(t, string(notify.ChannelEmail), notifications[0].Channel)
+		assert.Equal(t, user.Email, notifications[0].Recipient)
+		assert.Equal(t, "New applicant for Mow my lawn", notifications[0].Subject)
+		assert.Contains(t, notifications[0].Body, "Sam applied")
+
+		var count int64
+		f.db.Model(&models.Task{}).Where("kind = ?", TaskDeliverNotification).Count(&count)
+		assert.Equal(t, int64(1), count)
+		f.db.Exec("DELETE FROM notifications")
+		f.db.Exec("DELETE FROM tasks")
+	})
+
+	t.Run("EnabledChannels", func(t *testing.T) {
+		enabled, token := true, "ExponentPushToken[abc]"
+		_, err := f.service.UpdatePreferences(user.ID, UpdateNotificationPreferencesRequest{
+			SMSEnabled: &enabled,
+			PushToken:  &token,
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, f.service.Enqueue(user.ID, events.ApplicationReceived, data))
+
+		var count int64
+		f.db.Model(&models.Notification{}).Where("user_id = ?", user.ID).Count(&count)
+		assert.Equal(t, int64(3), count)
+		f.db.Exec("DELETE FROM notifications")
+		f.db.Exec("DELETE FROM tasks")
+	})
+
+	t.Run("UnknownEventIgnored", func(t *testing.T) {
+		require.NoError(t, f.service.Enqueue(user.ID, events.Type("job.viewed"), nil))
+
+		var count int64
+		f.db.Model(&models.Notification{}).Count(&count)
+		assert.Zero(t, count)
+	})
+
+	t.Run("QuietHoursDelaySMSAndPush", func(t *testing.T) {
+		start, end, tz := "11:00", "13:30", "UTC"
+		_, err := f.service.UpdatePreferences(user.ID, UpdateNotificationPreferencesRequest{
+			QuietHoursStart: &start,
+			QuietHoursEnd:   &end,
+			Timezone:        &tz,
+		})
+		require.NoError(t, err)
+
+		require.NoError(t, f.service.Enqueue(user.ID, events.ApplicationReceived, data))
+
+		var notifications []models.Notification
+		require.NoError(t, f.db.Where("user_id = ?", user.ID).Find(&notifications).Error)
+		require.Len(t, notifications, 3)
+
+		runAt := map[uint]time.Time{}
+		var queued []models.Task
+		require.NoError(t, f.db.Where("kind = ?", TaskDeliverNotification).Find(&queued).Error)
+		require.Len(t, queued, 3)
+		for _, task := range queued {
+			var payload recordTaskPayload
+			require.NoError(t, tasks.Decode(&task, &payload))
+			runAt[payload.ID] = task.RunAt
+			assert.Equal(t, maxNotificationAttempts, task.MaxAttempts)
+		}
+
+		for _, n := range notifications {
+			if n.Channel == string(notify.ChannelEmail) {
+				assert.True(t, runAt[n.ID].Equal(f.now))
+			} else {
+				assert.True(t, runAt[n.ID].Equal(time.Date(2024, 6, 1, 13, 30, 0, 0, time.UTC)), n.Channel)
+			}
+		}
+		f.db.Exec("DELETE FROM notifications")
+		f.db.Exec("DELETE FROM tasks")
+	})
+}
+
+func TestNotificationService_RecordedEvents(t *testing.T) {
+	f := setupNotificationService()
+	defer testutils.CleanupTestDB(f.db)
+
+	queue := tasks.NewQueue(f.db)
+	queue.Register(TaskNotifyEvent, f.service.eventTask)
+	user := testutils.CreateTestUser(f.db)
+	data := map[string]interface{}{"job_title": "Mow my lawn", "applicant_name": "Sam"}
+
+	t.Run("RolledBackChangeNotifiesNobody", func(t *testing.T) {
+		err := f.db.Transaction(func(tx *gorm.DB) error {
+			require.NoError(t, recordEvent(tx, events.ApplicationReceived, []uint{user.ID}, data))
+			return errors.New("the change failed")
+		})
+		require.Error(t, err)
+
+		var count int64
+		f.db.Model(&models.Task{}).Count(&count)
+		assert.Zero(t, count)
+	})
+
+	t.Run("CommittedChangeIsNotified", func(t *testing.T) {
+		require.NoError(t, f.db.Transaction(func(tx *gorm.DB) error {
+			return recordEvent(tx, events.ApplicationReceived, []uint{user.ID}, data)
+		}))
+
+		var recorded models.Task
+		require.NoError(t, f.db.Where("kind = ?", TaskNotifyEvent).First(&recorded).Error)
+		_, err := queue.RunPending(context.Background(), 10)
+		require.NoError(t, err)
+
+		var notifications []models.Notification
+		require.NoError(t, f.db.Where("user_id = ?", user.ID).Find(&notifications).Error)
+		require.Len(t, notifications, 1)
+		assert.Equal(t, "New applicant for Mow my lawn", notifications[0].Subject)
+		f.db.Exec("DELETE FROM notifications")
+		f.db.Exec("DELETE FROM tasks")
+	})
+
+	t.Run("EventsWithoutTemplateQueueNoNotifications", func(t *testing.T) {
+		require.NoError(t, f.db.Transaction(func(tx *gorm.DB) error {
+			return recordEvent(tx, events.RentalCompleted, []uint{user.ID}, data)
+		}))
+
+		var count int64
+		f.db.Model(&models.Task{}).Where("kind = ?", TaskNotifyEvent).Count(&count)
+		assert.Zero(t, count)
+		f.db.Exec("DELETE FROM tasks")
+	})
+
+	t.Run("StateChangeRecordsItsEvent", func(t *testing.T) {
+		owner := createApplicant(t, f.db, "owner@example.com")
+		equipment := testutils.CreateTestEquipment(f.db, owner.ID)
+		rental := &models.EquipmentRental{EquipmentID: equipment.ID, RenterUserID: user.ID, Status: models.RentalStatusRequested}
+		require.NoError(t, f.db.Create(rental).Error)
+
+		// No bus subscriber: the task is written with the status change
+		equipmentService := &EquipmentService{db: f.db}
+		require.NoError(t, equipmentService.UpdateRentalStatus(equipment.ID, rental.ID, owner.ID, models.RentalStatusApproved))
+		_, err := queue.RunPending(context.Background(), 10)
+		require.NoError(t, err)
+
+		var notifications []models.Notification
+		require.NoError(t, f.db.Where("user_id = ? AND event = ?", user.ID, events.RentalApproved).Find(&notifications).Error)
+		assert.Len(t, notifications, 1)
+	})
+}
+
+func TestDeliveryTime(t *testing.T) {
+	prefs := &models.NotificationPreference{
+		QuietHoursStart: "22:00",
+		QuietHoursEnd:   "07:00",
+		Timezone:        "America/Chicago",
+	}
+
+	t.Run("OvernightWindowBeforeMidnight", func(t *testing.T) {
+		// 23:30 in Chicago (CDT, UTC-5)
+		now := time.Date(2024, 6, 2, 4, 30, 0, 0, time.UTC)
+
+		resume := deliveryTime(prefs, notify.ChannelSMS, now)
+
+		assert.True(t, resume.Equal(time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC)))
+	})
+
+	t.Run("OvernightWindowAfterMidnight", func(t *testing.T) {
+		// 06:00 in Chicago
+		now := time.Date(2024, 6, 2, 11, 0, 0, 0, time.UTC)
+
+		resume := deliveryTime(prefs, notify.ChannelPush, now)
+
+		assert.True(t, resume.Equal(time.Date(2024, 6, 2, 12, 0, 0, 0, time.UTC)))
+	})
+
+	t.Run("OutsideWindow", func(t *testing.T) {
+		now := time.Date(2024, 6, 2, 18, 0, 0, 0, time.UTC)
+
+		assert.True(t, deliveryTime(prefs, notify.ChannelSMS, now).Equal(now))
+	})
+}
+
+func TestNotificationService_Deliver(t *testing.T) {
+	f := setupNotificationService()
+	defer testutils.CleanupTestDB(f.db)
+
+	queue := tasks.NewQueue(f.db)
+	queue.Register(TaskDeliverNotification, f.service.deliverTask)
+
+	user := testutils.CreateTestUser(f.db)
+	data := map[string]interface{}{"equipment_name": "Push Mower"}
+
+	t.Run("SendsQueuedNotification", func(t *testing.T) {
+		require.NoError(t, f.service.Enqueue(user.ID, events.RentalApproved, data))
+
+		processed, err := queue.RunPending(context.Background(), 10)
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, processed)
+		require.Len(t, f.email.Messages(), 1)
+		assert.Equal(t, "Rental approved: Push Mower", f.email.Messages()[0].Subject)
+
+		var notification models.Notification
+		require.NoError(t, f.db.First(&notification).Error)
+		assert.Equal(t, models.NotificationStatusSent, notification.Status)
+		assert.NotNil(t, notification.SentAt)
+		f.db.Exec("DELETE FROM notifications")
+		f.db.Exec("DELETE FROM tasks")
+	})
+
+	t.Run("FailureIsRetriedByQueue", func(t *testing.T) {
+		f.email.Err = errors.New("smtp unavailable")
+		defer func() { f.email.Err = nil }()
+
+		require.NoError(t, f.service.Enqueue(user.ID, events.RentalApproved, data))
+
+		_, err := queue.RunPending(context.Background(), 10)
+		require.NoError(t, err)
+
+		var notification models.Notification
+		require.NoError(t, f.db.First(&notification).Error)
+		assert.Equal(t, models.NotificationStatusPending, notification.Status)
+		assert.Equal(t, 1, notification.Attempts)
+		assert.Equal(t, "smtp unavailable", notification.LastError)
+
+		var task models.Task
+		require.NoError(t, f.db.First(&task).Error)
+		assert.Equal(t, models.TaskStatusPending, task.Status)
+		assert.Equal(t, "smtp unavailable", task.LastError)
+		f.db.Exec("DELETE FROM notifications")
+		f.db.Exec("DELETE FROM tasks")
+	})
+
+	t.Run("FinalAttemptMarksFailed", func(t *testing.T) {
+		f.email.Err = errors.New("smtp unavailable")
+		defer func() { f.email.Err = nil }()
+
+		notification := models.Notification{
+			UserID:    user.ID,
+			Event:     string(events.RentalApproved),
+			Channel:   string(notify.ChannelEmail),
+			Recipient: user.Email,
+			Body:      "hi",
+			Status:    models.NotificationStatusPending,
+		}
+		require.NoError(t, f.db.Create(&notification).Error)
+
+		err := f.service.deliverTask(context.Background(), &models.Task{
+			Kind:        TaskDeliverNotification,
+			Payload:     fmt.Sprintf(`{"id":%d}`, notification.ID),
+			Attempts:    maxNotificationAttempts,
+			MaxAttempts: maxNotificationAttempts,
+		})
+		require.Error(t, err)
+
+		require.NoError(t, f.db.First(&notification, notification.ID).Error)
+		assert.Equal(t, models.NotificationStatusFailed, notification.Status)
+		f.db.Exec("DELETE FROM notifications")
+	})
+
+	t.Run("AlreadySentIsSkipped", func(t *testing.T) {
+		before := len(f.email.Messages())
+		notification := models.Notification{
+			UserID:    user.ID,
+			Event:     string(events.RentalApproved),
+			Channel:   string(notify.ChannelEmail),
+			Recipient: user.Email,
+			Body:      "hi",
+			Status:    models.NotificationStatusSent,
+		}
+		require.NoError(t, f.db.Create(&notification).Error)
+
+		err := f.service.deliverTask(context.Background(), &models.Task{
+			Kind:        TaskDeliverNotification,
+			Payload:     fmt.Sprintf(`{"id":%d}`, notification.ID),
+			Attempts:    1,
+			MaxAttempts: maxNotificationAttempts,
+		})
+
+		require.NoError(t, err)
+		assert.Len(t, f.email.Messages(), before)
+	})
+}
+
+func TestNotificationService_UpdatePreferences(t *testing.T) {
+	f := setupNotificationService()
+	defer testutils.CleanupTestDB(f.db)
+
+	user := testutils.CreateTestUser(f.db)
+
+	t.Run("Defaults", func(t *testing.T) {
+		prefs, err := f.service.GetPreferences(user.ID)
+
+		require.NoError(t, err)
+		assert.True(t, prefs.EmailEnabled)
+		assert.False(t, prefs.SMSEnabled)
+		assert.Equal(t, "UTC", prefs.Timezone)
+	})
+
+	t.Run("InvalidQuietHours", func(t *testing.T) {
+		start, end := "25:00", "07:00"
+		_, err := f.service.UpdatePreferences(user.ID, UpdateNotificationPreferencesRequest{
+			QuietHoursStart: &start,
+			QuietHoursEnd:   &end,
+		})
+
+		assert.EqualError(t, err, "quiet hours must use HH:MM format")
+	})
+
+	t.Run("QuietHoursNeedBothEnds", func(t *testing.T) {
+		start := "22:00"
+		_, err := f.service.UpdatePreferences(user.ID, UpdateNotificationPreferencesRequest{
+			QuietHoursStart: &start,
+		})
+
+		assert.EqualError(t, err, "quiet hours need both a start and an end")
+	})
+
+	t.Run("InvalidTimezone", func(t *testing.T) {
+		tz := "Mars/Olympus_Mons"
+		_, err := f.service.UpdatePreferences(user.ID, UpdateNotificationPreferencesRequest{Timezone: &tz})
+
+		assert.EqualError(t, err, "invalid timezone")
+	})
+
+	t.Run("SavesChanges", func(t *testing.T) {
+		disabled := false
+		_, err := f.service.UpdatePreferences(user.ID, UpdateNotificationPreferencesRequest{EmailEnabled: &disabled})
+		require.NoError(t, err)
+
+		prefs, err := f.service.GetPreferences(user.ID)
+		require.NoError(t, err)
+		assert.False(t, prefs.EmailEnabled)
+		assert.NotZero(t, prefs.ID)
+	})
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/tasks"
+	"mowsy-api/internal/utils"
+	"mowsy-api/pkg/oidc"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrOAuthEmailUnverified = errors.New("the provider has not verified this email address")
+	ErrOAuthAccountDisabled = errors.New("this account is disabled")
+	ErrOAuthLinkRequired    = errors.New("an account with this email already exists; sign in with your password to link it")
+	ErrOAuthIdentityInUse   = errors.New("this provider account is linked to another user")
+)
+
+// OAuthService signs users in with an ID token from Google or Apple.
+type OAuthService struct {
+	db        *gorm.DB
+	users     *UserService
+	providers oidc.Providers
+}
+
+type OAuthLoginRequest struct {
+	IDToken string `json:"id_token" binding:"required"`
+	// Nonce is the value the client passed to the provider, if any
+	Nonce string `json:"nonce"`
+	// Apple only gives the app the user's name on their first sign-in, and
+	// never puts it in the ID token, so clients pass it along here
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+}
+
+// OAuthLinkRequest carries the ID token of the provider account to link.
+type OAuthLinkRequest struct {
+	IDToken string `json:"id_token" binding:"required"`
+	Nonce   string `json:"nonce"`
+}
+
+// Login verifies the ID token and returns the same token pair as a password
+// login. A new provider account is linked to the passwordless user with the
+// same verified email, or a new user is created from the token's profile.
+// Users with a password get ErrOAuthLinkRequired and must link the account
+// with Link once signed in. Users with 2FA still get a *MFARequiredError.
+func (s *OAuthService) Login(ctx context.Context, provider string, req OAuthLoginRequest) (*LoginResponse, error) {
+	identity, err := s.providers.Verify(ctx, provider, req.IDToken, req.Nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		return s.resolveUser(tx, identity, req, &user)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.users.requireSecondFactor(&user); err != nil {
+		return nil, err
+	}
+	return newLoginResponse(&user)
+}
+
+// Link adds the provider account behind the ID token to a signed-in user, so
+// they can sign in with it from then on.
+func (s *OAuthService) Link(ctx context.Context, userID uint, provider string, req OAuthLinkRequest) error {
+	identity, err := s.providers.Verify(ctx, provider, req.IDToken, req.Nonce)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var link models.OAuthIdentity
+		err := tx.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&link).Error
+		switch {
+		case err == nil && link.UserID != userID:
+			return ErrOAuthIdentityInUse
+		case err == nil:
+			return nil
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return fmt.Errorf("failed to find linked account: %w", err)
+		}
+
+		link = models.OAuthIdentity{
+			UserID:      userID,
+			Provider:    identity.Provider,
+			Subject:     identity.Subject,
+			Email:       identity.Email,
+			LastLoginAt: time.Now(),
+		}
+		if err := tx.Create(&link).Error; err != nil {
+			return fmt.Errorf("failed to link account: %w", err)
+		}
+		return nil
+	})
+}
+
+// resolveUser loads or creates the user behind identity into user.
+func (s *OAuthService) resolveUser(tx *gorm.DB, identity *oidc.Identity, req OAuthLoginRequest, user *models.User) error {
+	now := time.Now()
+
+	var link models.OAuthIdentity
+	err := tx.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&link).Error
+	switch {
+	case err == nil:
+		if err := loadSignInUser(tx.Where("id = ?", link.UserID), user); err != nil {
+			return err
+		}
+		return tx.Model(&link).Updates(map[string]interface{}{"email": identity.Email, "last_login_at": now}).Error
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return fmt.Errorf("failed to find linked account: %w", err)
+	}
+
+	// Linking by email is only safe when the provider vouches for it
+	if identity.Email == "" || !identity.EmailVerified || !utils.IsValidEmail(identity.Email) {
+		return ErrOAuthEmailUnverified
+	}
+
+	err = loadSignInUser(tx.Where("LOWER(email) = LOWER(?)", identity.Email), user)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		if err := createOAuthUser(tx, identity, req, user); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	case user.PasswordHash != "":
+		// Whoever controls the email at the provider may not be the person
+		// who set the password, so only the password holder can link
+		return ErrOAuthLinkRequired
+	}
+
+	link = models.OAuthIdentity{
+		UserID:      user.ID,
+		Provider:    identity.Provider,
+		Subject:     identity.Subject,
+		Email:       identity.Email,
+		LastLoginAt: now,
+	}
+	if err := tx.Create(&link).Error; err != nil {
+		return fmt.Errorf("failed to link account: %w", err)
+	}
+	return nil
+}
+
+// loadSignInUser finds the user matching query, including removed users so
+// that their email cannot be taken over by a new account.
+func loadSignInUser(query *gorm.DB, user *models.User) error {
+	if err := query.Unscoped().First(user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		return fmt.Errorf("failed to find user: %w", err)
+	}
+	if user.DeletedAt.Valid || !user.IsActive {
+		return ErrOAuthAccountDisabled
+	}
+	return nil
+}
+
+func createOAuthUser(tx *gorm.DB, identity *oidc.Identity, req OAuthLoginRequest, user *models.User) error {
+	firstName, lastName := identity.GivenName, identity.FamilyName
+	if firstName == "" {
+		firstName = req.FirstName
+	}
+	if lastName == "" {
+		lastName = req.LastName
+	}
+
+	// No password: these users can only sign in through a provider
+	*user = models.User{
+		Email:     utils.SanitizeString(identity.Email),
+		FirstName: utils.SanitizeString(firstName),
+		LastName:  utils.SanitizeString(lastName),
+		Role:      models.UserRoleUser,
+		IsActive:  true,
+	}
+	if err := tx.Create(user).Error; err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+	return tasks.Enqueue(tx, TaskSyncStripeCustomer, recordTaskPayload{ID: user.ID})
+}
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/testutils"
+	"mowsy-api/pkg/auth"
+	"mowsy-api/pkg/oidc"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+const testGoogleClientID = "mowsy-web.apps.googleusercontent.com"
+
+type oauthFixture struct {
+	service *OAuthService
+	db      *gorm.DB
+	key     *rsa.PrivateKey
+}
+
+func setupOAuthFixture(t *testing.T) *oauthFixture {
+	testutils.UseTestSigningKeys(t)
+	db := testutils.SetupTestDB()
+	t.Cleanup(func() { testutils.CleanupTestDB(db) })
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	return &oauthFixture{
+		db:  db,
+		key: key,
+		service: &OAuthService{
+			db:    db,
+			users: &UserService{db: db, now: time.Now, mfaRequiredRoles: map[models.UserRole]bool{}},
+			providers: oidc.Providers{oidc.ProviderGoogle: {
+				Name:      oidc.ProviderGoogle,
+				Issuers:   []string{"https://accounts.google.com"},
+				Audiences: []string{testGoogleClientID},
+				Keys:      oidc.NewStaticKeySet(map[string]crypto.PublicKey{"test-key": &key.PublicKey}),
+			}},
+		},
+	}
+}
+
+func (f *oauthFixture) idToken(t *testing.T, subject, email string, verified bool) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            "https://accounts.google.com",
+		"aud":            testGoogleClientID,
+		"sub":            subject,
+		"email":          email,
+		"email_verified": verified,
+		"given_name":     "Grace",
+		"family_name":    "Green",
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = "test-key"
+	signed, err := token.SignedString(f.key)
+	require.NoError(t, err)
+	return signed
+}
+
+func (f *oauthFixture) login(t *testing.T, token string) (*LoginResponse, error) {
+	return f.service.Login(context.Background(), oidc.ProviderGoogle, OAuthLoginRequest{IDToken: token})
+}
+
+func TestOAuthService_LoginCreatesUser(t *testing.T) {
+	f := setupOAuthFixture(t)
+
+	response, err := f.login(t, f.idToken(t, "google-1", "grace@example.com", true))
+	require.NoError(t, err)
+	assert.Equal(t, "grace@example.com", response.User.Email)
+	assert.Equal(t, "Grace", response.User.FirstName)
+	assert.Equal(t, "Green", response.User.LastName)
+
+	claims, err := auth.ValidateToken(response.AccessToken)
+	require.NoError(t, err)
+	assert.Equal(t, response.User.ID, claims.UserID)
+
+	var user models.User
+	require.NoError(t, f.db.First(&user, response.User.ID).Error)
+	assert.Empty(t, user.PasswordHash)
+	assert.Equal(t, models.UserRoleUser, user.Role)
+
+	var queued int64
+	f.db.Model(&models.Task{}).Where("kind = ?", TaskSyncStripeCustomer).Count(&queued)
+	assert.Equal(t, int64(1), queued)
+
+	// Signing in again finds the same user through the linked identity
+	again, err := f.login(t, f.idToken(t, "google-1", "grace@example.com", true))
+	require.NoError(t, err)
+	assert.Equal(t, response.User.ID, again.User.ID)
+
+	var identities, users int64
+	f.db.Model(&models.OAuthIdentity{}).Count(&identities)
+	f.db.Model(&models.User{}).Count(&users)
+	assert.Equal(t, int64(1), identities)
+	assert.Equal(t, int64(1), users)
+}
+
+func TestOAuthService_LoginNameFromRequest(t *testing.T) {
+	f := setupOAuthFixture(t)
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            "https://accounts.google.com",
+		"aud":            testGoogleClientID,
+		"sub":            "google-2",
+		"email":          "private@example.com",
+		"email_verified": "true",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	})
+	token.Header["kid"] = "test-key"
+	signed, err := token.SignedString(f.key)
+	require.NoError(t, err)
+
+	response, err := f.service.Login(context.Background(), oidc.ProviderGoogle,
+		OAuthLoginRequest{IDToken: signed, FirstName: "Ada", LastName: "Lovelace"})
+	require.NoError(t, err)
+	assert.Equal(t, "Ada", response.User.FirstName)
+	assert.Equal(t, "Lovelace", response.User.LastName)
+}
+
+func TestOAuthService_LoginLinksExistingUser(t *testing.T) {
+	f := setupOAuthFixture(t)
+	existing := models.User{Email: "Grace@Example.com", FirstName: "Grace", LastName: "Hopper", IsActive: true}
+	require.NoError(t, f.db.Create(&existing).Error)
+
+	// An unverified email is never enough to take over an account
+	_, err := f.login(t, f.idToken(t, "google-1", "grace@example.com", false))
+	assert.ErrorIs(t, err, ErrOAuthEmailUnverified)
+
+	response, err := f.login(t, f.idToken(t, "google-1", "grace@example.com", true))
+	require.NoError(t, err)
+	assert.Equal(t, existing.ID, response.User.ID)
+	assert.Equal(t, "Hopper", response.User.LastName, "profile is not overwritten")
+
+	var link models.OAuthIdentity
+	require.NoError(t, f.db.Where("user_id = ?", existing.ID).First(&link).Error)
+	assert.Equal(t, oidc.ProviderGoogle, link.Provider)
+	assert.Equal(t, "google-1", link.Subject)
+}
+
+func TestOAuthService_LoginDoesNotLinkPasswordUser(t *testing.T) {
+	f := setupOAuthFixture(t)
+	existing := models.User{Email: "grace@example.com", PasswordHash: "hash", FirstName: "Grace", LastName: "Hopper", IsActive: true}
+	require.NoError(t, f.db.Create(&existing).Error)
+
+	// A verified email at the provider doesn't prove who set the password
+	_, err := f.login(t, f.idToken(t, "google-1", "grace@example.com", true))
+	assert.ErrorIs(t, err, ErrOAuthLinkRequired)
+
+	var identities int64
+	f.db.Model(&models.OAuthIdentity{}).Count(&identities)
+	assert.Equal(t, int64(0), identities)
+
+	// Once linked by the signed-in user, the provider account signs in
+	err = f.service.Link(context.Background(), existing.ID, oidc.ProviderGoogle, OAuthLinkRequest{IDToken: f.idToken(t, "google-1", "grace@example.com", true)})
+	require.NoError(t, err)
+
+	response, err := f.login(t, f.idToken(t, "google-1", "grace@example.com", true))
+	require.NoError(t, err)
+	assert.Equal(t, existing.ID, response.User.ID)
+}
+
+func TestOAuthService_Link(t *testing.T) {
+	f := setupOAuthFixture(t)
+	user := models.User{Email: "grace@example.com", PasswordHash: "hash", FirstName: "Grace", LastName: "Hopper", IsActive: true}
+	other := models.User{Email: "ada@example.com", PasswordHash: "hash", FirstName: "Ada", LastName: "Lovelace", IsActive: true}
+	require.NoError(t, f.db.Create(&user).Error)
+	require.NoError(t, f.db.Create(&other).Error)
+	link := func(userID uint, token string) error {
+		return f.service.Link(context.Background(), userID, oidc.ProviderGoogle, OAuthLinkRequest{IDToken: token})
+	}
+
+	// The provider's email doesn't have to match
+	require.NoError(t, link(user.ID, f.idToken(t, "google-1", "grace.personal@example.com", false)))
+	require.NoError(t, link(user.ID, f.idToken(t, "google-1", "grace.personal@example.com", false)), "linking again is a no-op")
+
+	assert.ErrorIs(t, link(other.ID, f.idToken(t, "google-1", "grace.personal@example.com", false)), ErrOAuthIdentityInUse)
+	assert.ErrorIs(t, link(user.ID, "not-a-token"), oidc.ErrInvalidToken)
+
+	var identities int64
+	f.db.Model(&models.OAuthIdentity{}).Where("user_id = ?", user.ID).Count(&identities)
+	assert.Equal(t, int64(1), identities)
+}
+
+func TestOAuthService_LoginRejected(t *testing.T) {
+	f := setupOAuthFixture(t)
+
+	_, err := f.service.Login(context.Background(), oidc.ProviderApple, OAuthLoginRequest{IDToken: "token"})
+	assert.ErrorIs(t, err, oidc.ErrUnknownProvider)
+
+	_, err = f.login(t, "not-a-token")
+	assert.ErrorIs(t, err, oidc.ErrInvalidToken)
+
+	disabled := models.User{Email: "gone@example.com", FirstName: "Gone", LastName: "User", IsActive: true}
+	require.NoError(t, f.db.Create(&disabled).Error)
+	require.NoError(t, f.db.Delete(&disabled).Error)
+	_, err = f.login(t, f.idToken(t, "google-9", "gone@example.com", true))
+	assert.ErrorIs(t, err, ErrOAuthAccountDisabled)
+}
+
+func TestOAuthService_LoginRequiresSecondFactor(t *testing.T) {
+	f := setupOAuthFixture(t)
+	user := models.User{Email: "grace@example.com", FirstName: "Grace", LastName: "Green", IsActive: true, MFAEnabled: true, MFASecret: "JBSWY3DPEHPK3PXP"}
+	require.NoError(t, f.db.Create(&user).Error)
+
+	_, err := f.login(t, f.idToken(t, "google-1", "grace@example.com", true))
+	var mfaRequired *MFARequiredError
+	require.ErrorAs(t, err, &mfaRequired)
+	assert.NotEmpty(t, mfaRequired.Challenge.MFAToken)
+}
@@ -1,36 +1,47 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"fmt"
-	"os"
+	"math"
+	"time"
 
+	"mowsy-api/internal/events"
 	"mowsy-api/internal/models"
-	"mowsy-api/pkg/database"
+	"mowsy-api/internal/tasks"
+	"mowsy-api/pkg/logging"
+	"mowsy-api/pkg/payments"
 
-	"github.com/stripe/stripe-go/v75"
-	"github.com/stripe/stripe-go/v75/customer"
-	"github.com/stripe/stripe-go/v75/paymentintent"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type PaymentService struct {
-	db *gorm.DB
+	db      *gorm.DB
+	bus     *events.Bus
+	users   *UserService
+	gateway payments.Gateway
 }
 
-func NewPaymentService() *PaymentService {
-	stripe.Key = os.Getenv("STRIPE_SECRET_KEY")
-	return &PaymentService{
-		db: database.GetDB(),
-	}
-}
+// rentalHoldPeriod is how long before a rental starts its payment may be a
+// hold. Stripe drops uncaptured holds after 7 days, so rentals starting later
+// than this are charged up front instead, and refunded if cancelled.
+const rentalHoldPeriod = 6 * 24 * time.Hour
+
+var (
+	ErrPaymentAmountMismatch = errors.New("amount does not match the price")
+	ErrPaymentInProgress     = errors.New("a payment for this is already in progress or made")
+)
 
 type CreatePaymentIntentRequest struct {
-	Amount      float64             `json:"amount" binding:"required"`
-	Currency    string              `json:"currency"`
-	Type        models.PaymentType  `json:"type" binding:"required"`
-	RelatedID   uint                `json:"related_id" binding:"required"`
-	Description string              `json:"description"`
+	// Amount is optional. The job's price or the rental's total is charged,
+	// and any other amount is refused.
+	Amount      float64            `json:"amount"`
+	Currency    string             `json:"currency"`
+	Type        models.PaymentType `json:"type" binding:"required"`
+	RelatedID   uint               `json:"related_id" binding:"required"`
+	Description string             `json:"description"`
 }
 
 type PaymentIntentResponse struct {
@@ -38,117 +49,206 @@ type PaymentIntentResponse struct {
 	PaymentID    uint   `json:"payment_id"`
 }
 
-func (s *PaymentService) CreatePaymentIntent(userID uint, req CreatePaymentIntentRequest) (*PaymentIntentResponse, error) {
-	user, err := NewUserService().GetUserByID(userID)
+func (s *PaymentService) CreatePaymentIntent(ctx context.Context, userID uint, req CreatePaymentIntentRequest) (*PaymentIntentResponse, error) {
+	user, err := s.users.GetUserByID(userID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
 
-	if req.Amount <= 0 {
-		return nil, errors.New("amount must be greater than 0")
-	}
-
 	if req.Currency == "" {
 		req.Currency = "usd"
 	}
 
-	if err := s.validatePaymentContext(req.Type, req.RelatedID, userID); err != nil {
+	amount, manualCapture, err := s.validatePaymentContext(req.Type, req.RelatedID, userID)
+	if err != nil {
 		return nil, err
 	}
+	if req.Amount != 0 && toMinorUnits(req.Amount) != toMinorUnits(amount) {
+		return nil, ErrPaymentAmountMismatch
+	}
 
-	if user.StripeCustomerID == "" {
-		customerParams := &stripe.CustomerParams{
-			Email: stripe.String(user.Email),
-			Name:  stripe.String(user.FirstName + " " + user.LastName),
-		}
-		if user.Phone != "" {
-			customerParams.Phone = stripe.String(user.Phone)
-		}
-
-		stripeCustomer, err := customer.New(customerParams)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create Stripe customer: %w", err)
+	if open, err := hasOpenPayment(s.db, req.Type, req.RelatedID); err != nil || open {
+		if err == nil {
+			err = ErrPaymentInProgress
 		}
+		return nil, err
+	}
 
-		if err := s.db.Model(user).Update("stripe_customer_id", stripeCustomer.ID).Error; err != nil {
-			return nil, fmt.Errorf("failed to update user with Stripe customer ID: %w", err)
+	// Normally created by the sync task at registration; fall back to doing it
+	// inline if that task has not run yet.
+	if user.StripeCustomerID == "" {
+		if err := s.syncStripeCustomer(ctx, user); err != nil {
+			return nil, err
 		}
-		user.StripeCustomerID = stripeCustomer.ID
 	}
 
-	amountCents := int64(req.Amount * 100)
-	paymentIntentParams := &stripe.PaymentIntentParams{
-		Amount:   stripe.Int64(amountCents),
-		Currency: stripe.String(req.Currency),
-		Customer: stripe.String(user.StripeCustomerID),
-		AutomaticPaymentMethods: &stripe.PaymentIntentAutomaticPaymentMethodsParams{
-			Enabled: stripe.Bool(true),
+	intent, err := s.gateway.CreatePaymentIntent(ctx, payments.PaymentIntentParams{
+		Amount:      toMinorUnits(amount),
+		Currency:    req.Currency,
+		CustomerID:  user.StripeCustomerID,
+		Description: req.Description,
+		Metadata: map[string]string{
+			"user_id":    fmt.Sprintf("%d", userID),
+			"type":       string(req.Type),
+			"related_id": fmt.Sprintf("%d", req.RelatedID),
 		},
-	}
-
-	if req.Description != "" {
-		paymentIntentParams.Description = stripe.String(req.Description)
-	}
-
-	paymentIntentParams.Metadata = map[string]string{
-		"user_id":    fmt.Sprintf("%d", userID),
-		"type":       string(req.Type),
-		"related_id": fmt.Sprintf("%d", req.RelatedID),
-	}
-
-	stripePaymentIntent, err := paymentintent.New(paymentIntentParams)
+		ManualCapture: manualCapture,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Stripe payment intent: %w", err)
 	}
 
 	payment := models.Payment{
 		UserID:                userID,
-		StripePaymentIntentID: stripePaymentIntent.ID,
-		Amount:                req.Amount,
+		StripePaymentIntentID: intent.ID,
+		Amount:                amount,
 		Currency:              req.Currency,
 		Type:                  req.Type,
 		RelatedID:             req.RelatedID,
 		Status:                models.PaymentStatusPending,
 	}
 
-	if err := s.db.Create(&payment).Error; err != nil {
-		return nil, fmt.Errorf("failed to create payment record: %w", err)
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Concurrent requests for the same job or rental wait here, so only
+		// the first one's payment is kept
+		if err := lockPaymentContext(tx, req.Type, req.RelatedID); err != nil {
+			return err
+		}
+		if open, err := hasOpenPayment(tx, req.Type, req.RelatedID); err != nil || open {
+			if err == nil {
+				err = ErrPaymentInProgress
+			}
+			return err
+		}
+		if err := tx.Create(&payment).Error; err != nil {
+			return fmt.Errorf("failed to create payment record: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		if _, cancelErr := s.gateway.CancelPaymentIntent(ctx, intent.ID); cancelErr != nil {
+			logging.FromContext(ctx).Warn("failed to cancel unused payment intent", "intent_id", intent.ID, "error", cancelErr)
+		}
+		return nil, err
 	}
 
 	return &PaymentIntentResponse{
-		ClientSecret: stripePaymentIntent.ClientSecret,
+		ClientSecret: intent.ClientSecret,
 		PaymentID:    payment.ID,
 	}, nil
 }
 
-func (s *PaymentService) validatePaymentContext(paymentType models.PaymentType, relatedID, userID uint) error {
+// syncStripeCustomer creates the user's Stripe customer, or updates its
+// contact details if one already exists.
+func (s *PaymentService) syncStripeCustomer(ctx context.Context, user *models.User) error {
+	params := payments.CustomerParams{
+		UserID: user.ID,
+		Email:  user.Email,
+		Name:   user.FirstName + " " + user.LastName,
+		Phone:  user.Phone,
+	}
+
+	if user.StripeCustomerID != "" {
+		if err := s.gateway.UpdateCustomer(ctx, user.StripeCustomerID, params); err != nil {
+			return fmt.Errorf("failed to update Stripe customer: %w", err)
+		}
+		return nil
+	}
+
+	customer, err := s.gateway.CreateCustomer(ctx, params)
+	if err != nil {
+		return fmt.Errorf("failed to create Stripe customer: %w", err)
+	}
+
+	if err := s.db.Model(user).Update("stripe_customer_id", customer.ID).Error; err != nil {
+		return fmt.Errorf("failed to update user with Stripe customer ID: %w", err)
+	}
+	user.StripeCustomerID = customer.ID
+
+	return nil
+}
+
+func (s *PaymentService) syncStripeCustomerTask(ctx context.Context, task *models.Task) error {
+	var payload recordTaskPayload
+	if err := tasks.Decode(task, &payload); err != nil {
+		return err
+	}
+
+	var user models.User
+	if err := s.db.Where("id = ?", payload.ID).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tasks.Permanent(errors.New("user not found"))
+		}
+		return fmt.Errorf("failed to fetch user: %w", err)
+	}
+
+	err := s.syncStripeCustomer(ctx, &user)
+	if errors.Is(err, payments.ErrNotConfigured) {
+		logging.FromContext(ctx).Info("skipping Stripe customer sync: STRIPE_SECRET_KEY not set")
+		return nil
+	}
+	return err
+}
+
+// validatePaymentContext checks that userID may pay for the job or rental,
+// and returns what they owe. A rental is only held on the card when it starts
+// soon enough for the hold to last until then.
+func (s *PaymentService) validatePaymentContext(paymentType models.PaymentType, relatedID, userID uint) (float64, bool, error) {
 	switch paymentType {
 	case models.PaymentTypeJobPayment:
 		var job models.Job
 		if err := s.db.Where("id = ? AND user_id = ? AND status = ?", relatedID, userID, models.JobStatusCompleted).First(&job).Error; err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
-				return errors.New("job not found, not owned by user, or not completed")
+				return 0, false, errors.New("job not found, not owned by user, or not completed")
 			}
-			return fmt.Errorf("failed to validate job: %w", err)
+			return 0, false, fmt.Errorf("failed to validate job: %w", err)
 		}
+		return job.FixedPrice, false, nil
 
 	case models.PaymentTypeEquipmentRental:
 		var rental models.EquipmentRental
-		if err := s.db.Preload("Equipment").Where("id = ? AND renter_user_id = ? AND status = ?", relatedID, userID, models.RentalStatusApproved).First(&rental).Error; err != nil {
+		if err := s.db.Scopes(liveRentals).Preload("Equipment").Where("id = ? AND renter_user_id = ? AND status = ?", relatedID, userID, models.RentalStatusApproved).First(&rental).Error; err != nil {
 			if errors.Is(err, gorm.ErrRecordNotFound) {
-				return errors.New("rental not found, not owned by user, or not approved")
+				return 0, false, errors.New("rental not found, not owned by user, or not approved")
 			}
-			return fmt.Errorf("failed to validate rental: %w", err)
+			return 0, false, fmt.Errorf("failed to validate rental: %w", err)
 		}
+		return rental.TotalPrice, time.Until(rental.StartDate) < rentalHoldPeriod, nil
 
 	default:
-		return errors.New("invalid payment type")
+		return 0, false, errors.New("invalid payment type")
 	}
+}
 
+// lockPaymentContext locks the job or rental being paid for until tx ends.
+func lockPaymentContext(tx *gorm.DB, paymentType models.PaymentType, relatedID uint) error {
+	var model interface{} = &models.Job{}
+	if paymentType == models.PaymentTypeEquipmentRental {
+		model = &models.EquipmentRental{}
+	}
+	var ids []uint
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Model(model).Where("id = ?", relatedID).Pluck("id", &ids).Error; err != nil {
+		return fmt.Errorf("failed to lock %s: %w", paymentType, err)
+	}
 	return nil
 }
 
-func (s *PaymentService) ConfirmPayment(paymentID uint, userID uint) (*models.PaymentResponse, error) {
+// hasOpenPayment reports whether the job or rental already has a payment
+// under way, held or made. Failed, cancelled and refunded ones don't count.
+func hasOpenPayment(db *gorm.DB, paymentType models.PaymentType, relatedID uint) (bool, error) {
+	var count int64
+	if err := db.Model(&models.Payment{}).Where("type = ? AND related_id = ? AND status IN ?", paymentType, relatedID,
+		[]models.PaymentStatus{models.PaymentStatusPending, models.PaymentStatusAuthorized, models.PaymentStatusSucceeded}).
+		Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check payments: %w", err)
+	}
+	return count > 0, nil
+}
+
+// ConfirmPayment refreshes a payment from its intent after the app has
+// confirmed it. A payment that needs 3DS or is still processing stays
+// pending, so the app calls this again once the customer or bank is done.
+func (s *PaymentService) ConfirmPayment(ctx context.Context, paymentID uint, userID uint) (*models.PaymentResponse, error) {
 	var payment models.Payment
 	if err := s.db.Where("id = ? AND user_id = ?", paymentID, userID).First(&payment).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
@@ -157,39 +257,101 @@ func (s *PaymentService) ConfirmPayment(paymentID uint, userID uint) (*models.Pa
 		return nil, fmt.Errorf("failed to fetch payment: %w", err)
 	}
 
-	stripePaymentIntent, err := paymentintent.Get(payment.StripePaymentIntentID, nil)
+	// Later states are driven by the rental, not by the app
+	if payment.Status != models.PaymentStatusPending && payment.Status != models.PaymentStatusFailed {
+		response := payment.ToResponse()
+		return &response, nil
+	}
+	// A failed payment stays failed once a new one has replaced it
+	if payment.Status == models.PaymentStatusFailed {
+		open, err := hasOpenPayment(s.db, payment.Type, payment.RelatedID)
+		if err != nil {
+			return nil, err
+		}
+		if open {
+			response := payment.ToResponse()
+			return &response, nil
+		}
+	}
+
+	intent, err := s.gateway.GetPaymentIntent(ctx, payment.StripePaymentIntentID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get Stripe payment intent: %w", err)
 	}
 
-	var status models.PaymentStatus
-	switch stripePaymentIntent.Status {
-	case stripe.PaymentIntentStatusSucceeded:
-		status = models.PaymentStatusSucceeded
-	case stripe.PaymentIntentStatusCanceled:
-		status = models.PaymentStatusCancelled
-	case stripe.PaymentIntentStatusProcessing, stripe.PaymentIntentStatusRequiresPaymentMethod, stripe.PaymentIntentStatusRequiresConfirmation:
-		status = models.PaymentStatusPending
-	default:
-		status = models.PaymentStatusFailed
+	previous := payment.Status
+	status, reason := paymentStatusFor(intent)
+	updates := map[string]interface{}{
+		"status":         status,
+		"failure_reason": reason,
 	}
-
-	if err := s.db.Model(&payment).Update("status", status).Error; err != nil {
-		return nil, fmt.Errorf("failed to update payment status: %w", err)
+	payment.Status = status
+	payment.FailureReason = reason
+
+	data := map[string]interface{}{
+		"payment_id": payment.ID,
+		"type":       payment.Type,
+		"related_id": payment.RelatedID,
+		"amount":     payment.Amount,
+		"status":     payment.Status,
+	}
+	var eventType events.Type
+	switch status {
+	case models.PaymentStatusSucceeded, models.PaymentStatusAuthorized:
+		eventType = events.PaymentSucceeded
+	case models.PaymentStatusFailed:
+		if previous != models.PaymentStatusFailed {
+			eventType = events.PaymentFailed
+			data["reason"] = reason
+		}
 	}
 
-	if status == models.PaymentStatusSucceeded {
-		if err := s.handleSuccessfulPayment(&payment); err != nil {
-			fmt.Printf("Warning: Failed to handle successful payment: %v\n", err)
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&payment).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update payment status: %w", err)
+		}
+		if eventType == events.PaymentSucceeded {
+			if err := s.handleSuccessfulPayment(tx, &payment); err != nil {
+				return err
+			}
 		}
+		if eventType != "" {
+			return recordEvent(tx, eventType, []uint{payment.UserID}, data)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if eventType != "" {
+		s.bus.Publish(eventType, []uint{payment.UserID}, data)
 	}
 
-	payment.Status = status
 	response := payment.ToResponse()
 	return &response, nil
 }
 
-func (s *PaymentService) handleSuccessfulPayment(payment *models.Payment) error {
+// paymentStatusFor maps an intent onto a payment status and, for a failed
+// attempt, the reason the gateway gave.
+func paymentStatusFor(intent *payments.PaymentIntent) (models.PaymentStatus, string) {
+	switch intent.Status {
+	case payments.IntentSucceeded:
+		return models.PaymentStatusSucceeded, ""
+	case payments.IntentRequiresCapture:
+		return models.PaymentStatusAuthorized, ""
+	case payments.IntentCanceled:
+		return models.PaymentStatusCancelled, ""
+	case payments.IntentRequiresPaymentMethod:
+		// Stripe sends a declined intent back for another payment method
+		if intent.LastError != "" {
+			return models.PaymentStatusFailed, intent.LastError
+		}
+	}
+	return models.PaymentStatusPending, ""
+}
+
+func (s *PaymentService) handleSuccessfulPayment(tx *gorm.DB, payment *models.Payment) error {
 	switch payment.Type {
 	case models.PaymentTypeJobPayment:
 		// Job payment is handled when job is marked as completed
@@ -197,16 +359,23 @@ func (s *PaymentService) handleSuccessfulPayment(payment *models.Payment) error
 
 	case models.PaymentTypeEquipmentRental:
 		var rental models.EquipmentRental
-		if err := s.db.Where("id = ?", payment.RelatedID).First(&rental).Error; err != nil {
+		if err := tx.Where("id = ?", payment.RelatedID).First(&rental).Error; err != nil {
 			return fmt.Errorf("failed to fetch rental: %w", err)
 		}
 
 		if rental.Status == models.RentalStatusApproved {
-			if err := s.db.Model(&rental).Update("status", models.RentalStatusActive).Error; err != nil {
+			if err := tx.Model(&rental).Update("status", models.RentalStatusActive).Error; err != nil {
 				return fmt.Errorf("failed to update rental status: %w", err)
 			}
 		}
 
+		// A hold is captured when the rental starts, before it can expire
+		if payment.Status == models.PaymentStatusAuthorized {
+			if err := tasks.Enqueue(tx, TaskCaptureRentalPayment, recordTaskPayload{ID: payment.ID}, tasks.RunAt(rental.StartDate)); err != nil {
+				return err
+			}
+		}
+
 	default:
 		return errors.New("unknown payment type")
 	}
@@ -214,6 +383,192 @@ func (s *PaymentService) handleSuccessfulPayment(payment *models.Payment) error
 	return nil
 }
 
+func (s *PaymentService) rentalPayments(rentalID uint, statuses ...models.PaymentStatus) ([]models.Payment, error) {
+	var found []models.Payment
+	if err := s.db.Where("type = ? AND related_id = ? AND status IN ?", models.PaymentTypeEquipmentRental, rentalID, statuses).
+		Order("id").Find(&found).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch rental payments: %w", err)
+	}
+	return found, nil
+}
+
+// settleRentalPaymentTask charges the renter for a completed rental and pays
+// the owner. Each step is saved as it succeeds, so a retry picks up where the
+// last attempt stopped.
+func (s *PaymentService) settleRentalPaymentTask(ctx context.Context, task *models.Task) error {
+	var rental models.EquipmentRental
+	found, err := loadTaskRecord(s.db.Preload("Equipment.User"), task, &rental)
+	if err != nil || !found {
+		return err
+	}
+
+	// Holds are normally captured when the rental starts, but the rental
+	// may have ended first
+	authorized, err := s.rentalPayments(rental.ID, models.PaymentStatusAuthorized)
+	if err != nil {
+		return err
+	}
+	for i := range authorized {
+		if err := s.capturePayment(ctx, &authorized[i]); err != nil {
+			return err
+		}
+	}
+
+	owner := rental.Equipment.User
+	if owner.StripeAccountID == "" {
+		logging.FromContext(ctx).Info("skipping rental payout: owner has no payout account", "rental_id", rental.ID, "owner_id", owner.ID)
+		return nil
+	}
+
+	captured, err := s.rentalPayments(rental.ID, models.PaymentStatusSucceeded)
+	if err != nil {
+		return err
+	}
+	// The owner is paid the rental's price, however many payments made it up
+	remaining := toMinorUnits(rental.TotalPrice)
+	for _, payment := range captured {
+		amount := min(toMinorUnits(payment.Amount), remaining)
+		remaining -= amount
+		if payment.StripeTransferID != "" || amount <= 0 {
+			continue
+		}
+		transfer, err := s.gateway.CreateTransfer(ctx, payments.TransferParams{
+			Amount:         amount,
+			Currency:       payment.Currency,
+			DestinationID:  owner.StripeAccountID,
+			TransferGroup:  fmt.Sprintf("rental-%d", rental.ID),
+			IdempotencyKey: fmt.Sprintf("payment-transfer-%d", payment.ID),
+		})
+		if err != nil {
+			return paymentTaskError(fmt.Errorf("failed to pay out payment %d: %w", payment.ID, err))
+		}
+		if err := s.db.Model(&payment).Update("stripe_transfer_id", transfer.ID).Error; err != nil {
+			return fmt.Errorf("failed to record payout: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// captureRentalPaymentTask charges a rental's hold when the rental starts.
+func (s *PaymentService) captureRentalPaymentTask(ctx context.Context, task *models.Task) error {
+	var payment models.Payment
+	found, err := loadTaskRecord(s.db, task, &payment)
+	if err != nil || !found {
+		return err
+	}
+	if payment.Status != models.PaymentStatusAuthorized {
+		return nil
+	}
+	return s.capturePayment(ctx, &payment)
+}
+
+// capturePayment charges a held payment. A hold the gateway refuses to
+// capture, most likely because it expired, fails the payment.
+func (s *PaymentService) capturePayment(ctx context.Context, payment *models.Payment) error {
+	_, err := s.gateway.CapturePaymentIntent(ctx, payment.StripePaymentIntentID, fmt.Sprintf("payment-capture-%d", payment.ID))
+	if errors.Is(err, payments.ErrInvalidRequest) {
+		return s.failPayment(payment, err.Error())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to capture payment %d: %w", payment.ID, err)
+	}
+	if err := s.db.Model(payment).Update("status", models.PaymentStatusSucceeded).Error; err != nil {
+		return fmt.Errorf("failed to update payment status: %w", err)
+	}
+	return nil
+}
+
+// releaseRentalPaymentTask gives the renter their money back after a rental
+// is cancelled: holds and unfinished payments are canceled, and anything
+// already charged is refunded.
+func (s *PaymentService) releaseRentalPaymentTask(ctx context.Context, task *models.Task) error {
+	var rental models.EquipmentRental
+	found, err := loadTaskRecord(s.db, task, &rental)
+	if err != nil || !found {
+		return err
+	}
+
+	open, err := s.rentalPayments(rental.ID, models.PaymentStatusPending, models.PaymentStatusAuthorized, models.PaymentStatusFailed, models.PaymentStatusSucceeded)
+	if err != nil {
+		return err
+	}
+
+	for i := range open {
+		payment := &open[i]
+		intent, err := s.gateway.GetPaymentIntent(ctx, payment.StripePaymentIntentID)
+		if err != nil {
+			return paymentTaskError(fmt.Errorf("failed to get payment intent: %w", err))
+		}
+
+		switch intent.Status {
+		case payments.IntentCanceled:
+			err = s.db.Model(payment).Update("status", models.PaymentStatusCancelled).Error
+
+		case payments.IntentSucceeded:
+			var refund *payments.Refund
+			refund, err = s.gateway.CreateRefund(ctx, payments.RefundParams{
+				PaymentIntentID: payment.StripePaymentIntentID,
+				IdempotencyKey:  fmt.Sprintf("payment-refund-%d", payment.ID),
+			})
+			if err != nil {
+				return paymentTaskError(fmt.Errorf("failed to refund payment %d: %w", payment.ID, err))
+			}
+			err = s.db.Model(payment).Updates(map[string]interface{}{
+				"status":           models.PaymentStatusRefunded,
+				"stripe_refund_id": refund.ID,
+			}).Error
+
+		default:
+			if _, err := s.gateway.CancelPaymentIntent(ctx, payment.StripePaymentIntentID); err != nil {
+				return paymentTaskError(fmt.Errorf("failed to cancel payment %d: %w", payment.ID, err))
+			}
+			err = s.db.Model(payment).Update("status", models.PaymentStatusCancelled).Error
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update payment status: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *PaymentService) failPayment(payment *models.Payment, reason string) error {
+	updates := map[string]interface{}{
+		"status":         models.PaymentStatusFailed,
+		"failure_reason": reason,
+	}
+	data := map[string]interface{}{
+		"payment_id": payment.ID,
+		"type":       payment.Type,
+		"related_id": payment.RelatedID,
+		"amount":     payment.Amount,
+		"status":     models.PaymentStatusFailed,
+		"reason":     reason,
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(payment).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update payment status: %w", err)
+		}
+		return recordEvent(tx, events.PaymentFailed, []uint{payment.UserID}, data)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.bus.Publish(events.PaymentFailed, []uint{payment.UserID}, data)
+	return nil
+}
+
+// paymentTaskError stops retrying calls the gateway has refused outright.
+func paymentTaskError(err error) error {
+	if errors.Is(err, payments.ErrInvalidRequest) {
+		return tasks.Permanent(err)
+	}
+	return err
+}
+
 func (s *PaymentService) GetPaymentHistory(userID uint, page, limit int) ([]models.PaymentResponse, error) {
 	if page <= 0 {
 		page = 1
@@ -252,4 +607,10 @@ func (s *PaymentService) GetPaymentByID(paymentID, userID uint) (*models.Payment
 
 	response := payment.ToResponse()
 	return &response, nil
-}
\ No newline at end of file
+}
+
+// toMinorUnits converts an amount to cents, rounding rather than truncating
+// so that e.g. 19.99 is not charged as 1998.
+func toMinorUnits(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
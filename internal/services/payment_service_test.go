@@ -0,0 +1,478 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"mowsy-api/internal/events"
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/tasks"
+	"mowsy-api/internal/testutils"
+	"mowsy-api/pkg/payments"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// rentalPaymentFixture is an approved rental waiting for the renter to pay,
+// with services wired to the fake gateway.
+type rentalPaymentFixture struct {
+	db       *gorm.DB
+	services *Container
+	gateway  *payments.Fake
+	queue    *tasks.Queue
+	events   chan events.Event
+
+	owner  *models.User
+	renter *models.User
+	rental *models.EquipmentRentalResponse
+}
+
+func newRentalPaymentFixture(t *testing.T) *rentalPaymentFixture {
+	t.Helper()
+	return newRentalPaymentFixtureStarting(t, time.Now().Add(24*time.Hour))
+}
+
+func newRentalPaymentFixtureStarting(t *testing.T, start time.Time) *rentalPaymentFixture {
+	t.Helper()
+	db := testutils.SetupTestDB()
+	t.Cleanup(func() { testutils.CleanupTestDB(db) })
+
+	f := &rentalPaymentFixture{
+		db:      db,
+		gateway: payments.NewFake(),
+		queue:   tasks.NewQueue(db),
+		events:  make(chan events.Event, 32),
+	}
+	bus := events.NewBus()
+	bus.Subscribe(func(event events.Event) { f.events <- event })
+	f.services = NewContainer(Dependencies{DB: db, Payments: f.gateway, Bus: bus})
+	f.services.RegisterTaskHandlers(f.queue)
+
+	f.owner = testutils.CreateTestUser(db)
+	f.renter = &models.User{Email: "renter@example.com", FirstName: "Renter", LastName: "Test", IsActive: true}
+	require.NoError(t, db.Create(f.renter).Error)
+
+	equipment := testutils.CreateTestEquipment(db, f.owner.ID)
+	rental, err := f.services.Equipment.RequestRental(equipment.ID, f.renter.ID, start, start.Add(48*time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, f.services.Equipment.UpdateRentalStatus(equipment.ID, rental.ID, f.owner.ID, models.RentalStatusApproved))
+	f.rental = rental
+
+	return f
+}
+
+// pay starts a payment for the rental and returns it with its intent ID.
+func (f *rentalPaymentFixture) pay(t *testing.T) (uint, string) {
+	t.Helper()
+	response, err := f.services.Payments.CreatePaymentIntent(context.Background(), f.renter.ID, CreatePaymentIntentRequest{
+		Amount:    f.rental.TotalPrice,
+		Type:      models.PaymentTypeEquipmentRental,
+		RelatedID: f.rental.ID,
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, response.ClientSecret)
+
+	var payment models.Payment
+	require.NoError(t, f.db.First(&payment, response.PaymentID).Error)
+	return payment.ID, payment.StripePaymentIntentID
+}
+
+func (f *rentalPaymentFixture) confirm(t *testing.T, paymentID uint) *models.PaymentResponse {
+	t.Helper()
+	payment, err := f.services.Payments.ConfirmPayment(context.Background(), paymentID, f.renter.ID)
+	require.NoError(t, err)
+	return payment
+}
+
+func (f *rentalPaymentFixture) runTasks(t *testing.T) {
+	t.Helper()
+	_, err := f.queue.Drain(context.Background(), 10)
+	require.NoError(t, err)
+}
+
+func (f *rentalPaymentFixture) rentalStatus(t *testing.T) models.RentalStatus {
+	t.Helper()
+	var rental models.EquipmentRental
+	require.NoError(t, f.db.First(&rental, f.rental.ID).Error)
+	return rental.Status
+}
+
+func (f *rentalPaymentFixture) payment(t *testing.T, paymentID uint) models.Payment {
+	t.Helper()
+	var payment models.Payment
+	require.NoError(t, f.db.First(&payment, paymentID).Error)
+	return payment
+}
+
+func (f *rentalPaymentFixture) published(eventType events.Type) bool {
+	for {
+		select {
+		case event := <-f.events:
+			if event.Type == eventType {
+				return true
+			}
+		case <-time.After(100 * time.Millisecond):
+			return false
+		}
+	}
+}
+
+func TestPaymentService_RentalLifecycle(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("ChargedOnCompletionAndPaidOut", func(t *testing.T) {
+		f := newRentalPaymentFixture(t)
+		require.NoError(t, f.services.Admin.SetPayoutAccount(f.owner.ID, "acct_owner"))
+
+		paymentID, intentID := f.pay(t)
+		_, err := f.gateway.Confirm(intentID, payments.TestCardSucceeds)
+		require.NoError(t, err)
+
+		payment := f.confirm(t, paymentID)
+		assert.Equal(t, models.PaymentStatusAuthorized, payment.Status)
+		assert.Equal(t, models.RentalStatusActive, f.rentalStatus(t))
+		assert.True(t, f.published(events.PaymentSucceeded))
+
+		intent, err := f.gateway.GetPaymentIntent(ctx, intentID)
+		require.NoError(t, err)
+		assert.Equal(t, payments.IntentRequiresCapture, intent.Status, "the card is only held while the rental runs")
+
+		require.NoError(t, f.services.Equipment.CompleteRental(f.rental.ID, f.renter.ID, "Returned clean"))
+		f.runTasks(t)
+
+		settled := f.payment(t, paymentID)
+		assert.Equal(t, models.PaymentStatusSucceeded, settled.Status)
+		assert.NotEmpty(t, settled.StripeTransferID)
+
+		intent, err = f.gateway.GetPaymentIntent(ctx, intentID)
+		require.NoError(t, err)
+		assert.Equal(t, payments.IntentSucceeded, intent.Status)
+
+		transfers := f.gateway.Transfers()
+		require.Len(t, transfers, 1)
+		assert.Equal(t, "acct_owner", transfers[0].DestinationID)
+		assert.Equal(t, int64(f.rental.TotalPrice*100), transfers[0].Amount)
+	})
+
+	t.Run("HoldIsCapturedWhenRentalStarts", func(t *testing.T) {
+		f := newRentalPaymentFixture(t)
+		require.NoError(t, f.services.Admin.SetPayoutAccount(f.owner.ID, "acct_owner"))
+		paymentID, intentID := f.pay(t)
+		_, err := f.gateway.Confirm(intentID, payments.TestCardSucceeds)
+		require.NoError(t, err)
+		f.confirm(t, paymentID)
+
+		var capture models.Task
+		require.NoError(t, f.db.Where("kind = ?", TaskCaptureRentalPayment).First(&capture).Error)
+		assert.WithinDuration(t, f.rental.StartDate, capture.RunAt, time.Second)
+
+		// The rental starts
+		require.NoError(t, f.db.Model(&capture).Update("run_at", time.Now()).Error)
+		f.runTasks(t)
+
+		assert.Equal(t, models.PaymentStatusSucceeded, f.payment(t, paymentID).Status)
+		intent, err := f.gateway.GetPaymentIntent(ctx, intentID)
+		require.NoError(t, err)
+		assert.Equal(t, payments.IntentSucceeded, intent.Status)
+		assert.Empty(t, f.gateway.Transfers(), "the owner is paid once the rental is over")
+
+		require.NoError(t, f.services.Equipment.CompleteRental(f.rental.ID, f.renter.ID, ""))
+		f.runTasks(t)
+
+		require.Len(t, f.gateway.Transfers(), 1)
+		assert.Equal(t, int64(f.rental.TotalPrice*100), f.gateway.Transfers()[0].Amount)
+	})
+
+	t.Run("LaterRentalIsChargedUpFront", func(t *testing.T) {
+		// Too far off for a hold to last until it starts
+		start := time.Now().Add(10 * 24 * time.Hour)
+		f := newRentalPaymentFixtureStarting(t, start)
+		paymentID, intentID := f.pay(t)
+		_, err := f.gateway.Confirm(intentID, payments.TestCardSucceeds)
+		require.NoError(t, err)
+
+		assert.Equal(t, models.PaymentStatusSucceeded, f.confirm(t, paymentID).Status)
+		assert.Equal(t, models.RentalStatusActive, f.rentalStatus(t))
+	})
+
+	t.Run("PayoutNeverExceedsRentalPrice", func(t *testing.T) {
+		f := newRentalPaymentFixture(t)
+		require.NoError(t, f.services.Admin.SetPayoutAccount(f.owner.ID, "acct_owner"))
+		paymentID, intentID := f.pay(t)
+		_, err := f.gateway.Confirm(intentID, payments.TestCardSucceeds)
+		require.NoError(t, err)
+		f.confirm(t, paymentID)
+
+		// A stray second charge for the same rental
+		require.NoError(t, f.db.Create(&models.Payment{
+			UserID: f.renter.ID, StripePaymentIntentID: "pi_stray", Amount: f.rental.TotalPrice, Currency: "usd",
+			Type: models.PaymentTypeEquipmentRental, RelatedID: f.rental.ID, Status: models.PaymentStatusSucceeded,
+		}).Error)
+
+		require.NoError(t, f.services.Equipment.CompleteRental(f.rental.ID, f.renter.ID, ""))
+		f.runTasks(t)
+
+		require.Len(t, f.gateway.Transfers(), 1)
+		assert.Equal(t, int64(f.rental.TotalPrice*100), f.gateway.Transfers()[0].Amount)
+	})
+
+	t.Run("OwnerWithoutPayoutAccount", func(t *testing.T) {
+		f := newRentalPaymentFixture(t)
+		paymentID, intentID := f.pay(t)
+		_, err := f.gateway.Confirm(intentID, payments.TestCardSucceeds)
+		require.NoError(t, err)
+		f.confirm(t, paymentID)
+
+		require.NoError(t, f.services.Equipment.CompleteRental(f.rental.ID, f.owner.ID, ""))
+		f.runTasks(t)
+
+		settled := f.payment(t, paymentID)
+		assert.Equal(t, models.PaymentStatusSucceeded, settled.Status)
+		assert.Empty(t, settled.StripeTransferID)
+		assert.Empty(t, f.gateway.Transfers())
+	})
+
+	t.Run("3DSStaysPendingUntilAuthenticated", func(t *testing.T) {
+		f := newRentalPaymentFixture(t)
+		paymentID, intentID := f.pay(t)
+		_, err := f.gateway.Confirm(intentID, payments.TestCardRequires3DS)
+		require.NoError(t, err)
+
+		payment := f.confirm(t, paymentID)
+		assert.Equal(t, models.PaymentStatusPending, payment.Status)
+		assert.Equal(t, models.RentalStatusApproved, f.rentalStatus(t))
+
+		_, err = f.gateway.Authenticate(intentID, true)
+		require.NoError(t, err)
+
+		payment = f.confirm(t, paymentID)
+		assert.Equal(t, models.PaymentStatusAuthorized, payment.Status)
+		assert.Equal(t, models.RentalStatusActive, f.rentalStatus(t))
+	})
+
+	t.Run("AsyncPaymentStaysPendingWhileProcessing", func(t *testing.T) {
+		f := newRentalPaymentFixture(t)
+		paymentID, intentID := f.pay(t)
+		_, err := f.gateway.Confirm(intentID, payments.TestBankDebit)
+		require.NoError(t, err)
+
+		assert.Equal(t, models.PaymentStatusPending, f.confirm(t, paymentID).Status)
+
+		_, err = f.gateway.Settle(intentID, true)
+		require.NoError(t, err)
+
+		assert.Equal(t, models.PaymentStatusAuthorized, f.confirm(t, paymentID).Status)
+		assert.Equal(t, models.RentalStatusActive, f.rentalStatus(t))
+	})
+
+	t.Run("DeclinedCardFailsAndCanBeRetried", func(t *testing.T) {
+		f := newRentalPaymentFixture(t)
+		paymentID, intentID := f.pay(t)
+		_, err := f.gateway.Confirm(intentID, payments.TestCardDeclined)
+		require.NoError(t, err)
+
+		payment := f.confirm(t, paymentID)
+		assert.Equal(t, models.PaymentStatusFailed, payment.Status)
+		assert.Equal(t, "Your card was declined.", payment.FailureReason)
+		assert.Equal(t, models.RentalStatusApproved, f.rentalStatus(t))
+		assert.True(t, f.published(events.PaymentFailed))
+
+		_, err = f.gateway.Confirm(intentID, payments.TestCardSucceeds)
+		require.NoError(t, err)
+
+		payment = f.confirm(t, paymentID)
+		assert.Equal(t, models.PaymentStatusAuthorized, payment.Status)
+		assert.Empty(t, payment.FailureReason)
+	})
+
+	// The owner can only cancel before the rental is paid for, but a payment
+	// may already be under way at the gateway.
+	t.Run("CancellationReleasesHold", func(t *testing.T) {
+		f := newRentalPaymentFixture(t)
+		paymentID, intentID := f.pay(t)
+		_, err := f.gateway.Confirm(intentID, payments.TestCardSucceeds)
+		require.NoError(t, err)
+
+		require.NoError(t, f.services.Equipment.UpdateRentalStatus(f.rental.Equipment.ID, f.rental.ID, f.owner.ID, models.RentalStatusCancelled))
+		f.runTasks(t)
+
+		assert.Equal(t, models.PaymentStatusCancelled, f.payment(t, paymentID).Status)
+		intent, err := f.gateway.GetPaymentIntent(ctx, intentID)
+		require.NoError(t, err)
+		assert.Equal(t, payments.IntentCanceled, intent.Status)
+		assert.Empty(t, f.gateway.Refunds(intentID), "a released hold needs no refund")
+	})
+
+	t.Run("CancellationRefundsCapturedPayment", func(t *testing.T) {
+		f := newRentalPaymentFixture(t)
+		paymentID, intentID := f.pay(t)
+		_, err := f.gateway.Confirm(intentID, payments.TestCardSucceeds)
+		require.NoError(t, err)
+		_, err = f.gateway.CapturePaymentIntent(ctx, intentID, "")
+		require.NoError(t, err)
+
+		require.NoError(t, f.services.Equipment.UpdateRentalStatus(f.rental.Equipment.ID, f.rental.ID, f.owner.ID, models.RentalStatusCancelled))
+		f.runTasks(t)
+
+		refunded := f.payment(t, paymentID)
+		assert.Equal(t, models.PaymentStatusRefunded, refunded.Status)
+		assert.NotEmpty(t, refunded.StripeRefundID)
+		require.Len(t, f.gateway.Refunds(intentID), 1)
+		assert.Equal(t, int64(f.rental.TotalPrice*100), f.gateway.Refunds(intentID)[0].Amount)
+	})
+
+	t.Run("CompletedRentalCannotBeCancelled", func(t *testing.T) {
+		f := newRentalPaymentFixture(t)
+		paymentID, intentID := f.pay(t)
+		_, err := f.gateway.Confirm(intentID, payments.TestCardSucceeds)
+		require.NoError(t, err)
+		f.confirm(t, paymentID)
+		require.NoError(t, f.services.Equipment.CompleteRental(f.rental.ID, f.renter.ID, ""))
+
+		err = f.services.Equipment.UpdateRentalStatus(f.rental.Equipment.ID, f.rental.ID, f.owner.ID, models.RentalStatusCancelled)
+		assert.Error(t, err)
+	})
+
+	t.Run("ExpiredHoldFailsPayment", func(t *testing.T) {
+		f := newRentalPaymentFixture(t)
+		paymentID, intentID := f.pay(t)
+		_, err := f.gateway.Confirm(intentID, payments.TestCardSucceeds)
+		require.NoError(t, err)
+		f.confirm(t, paymentID)
+
+		// Stripe cancels uncaptured intents after seven days
+		_, err = f.gateway.CancelPaymentIntent(ctx, intentID)
+		require.NoError(t, err)
+
+		require.NoError(t, f.services.Equipment.CompleteRental(f.rental.ID, f.renter.ID, ""))
+		f.runTasks(t)
+
+		failed := f.payment(t, paymentID)
+		assert.Equal(t, models.PaymentStatusFailed, failed.Status)
+		assert.NotEmpty(t, failed.FailureReason)
+		assert.True(t, f.published(events.PaymentFailed))
+
+		var task models.Task
+		require.NoError(t, f.db.Where("kind = ?", TaskSettleRentalPayment).First(&task).Error)
+		assert.Equal(t, models.TaskStatusSucceeded, task.Status)
+	})
+
+	t.Run("FailedCaptureInWorkerNotifiesRenter", func(t *testing.T) {
+		f := newRentalPaymentFixture(t)
+		paymentID, intentID := f.pay(t)
+		_, err := f.gateway.Confirm(intentID, payments.TestCardSucceeds)
+		require.NoError(t, err)
+		f.confirm(t, paymentID)
+		_, err = f.gateway.CancelPaymentIntent(ctx, intentID)
+		require.NoError(t, err)
+		require.NoError(t, f.services.Equipment.CompleteRental(f.rental.ID, f.renter.ID, ""))
+
+		// The worker is its own process, with its own container and bus
+		worker := NewContainer(Dependencies{DB: f.db, Payments: f.gateway})
+		queue := tasks.NewQueue(f.db)
+		worker.RegisterTaskHandlers(queue)
+		_, err = queue.Drain(ctx, 10)
+		require.NoError(t, err)
+
+		assert.Equal(t, models.PaymentStatusFailed, f.payment(t, paymentID).Status)
+		var notifications []models.Notification
+		require.NoError(t, f.db.Where("user_id = ? AND event = ?", f.renter.ID, events.PaymentFailed).Find(&notifications).Error)
+		require.Len(t, notifications, 1)
+		assert.Equal(t, "Payment failed", notifications[0].Subject)
+		assert.Contains(t, notifications[0].Body, fmt.Sprintf("$%.2f", f.rental.TotalPrice))
+	})
+
+	t.Run("PayoutIsRetriedWithoutChargingTwice", func(t *testing.T) {
+		f := newRentalPaymentFixture(t)
+		require.NoError(t, f.services.Admin.SetPayoutAccount(f.owner.ID, "acct_owner"))
+		paymentID, intentID := f.pay(t)
+		_, err := f.gateway.Confirm(intentID, payments.TestCardSucceeds)
+		require.NoError(t, err)
+		f.confirm(t, paymentID)
+
+		f.gateway.FailNext("transfer.create", errors.New("connection reset"))
+		require.NoError(t, f.services.Equipment.CompleteRental(f.rental.ID, f.renter.ID, ""))
+		f.runTasks(t)
+
+		captured := f.payment(t, paymentID)
+		assert.Equal(t, models.PaymentStatusSucceeded, captured.Status, "the capture is kept")
+		assert.Empty(t, captured.StripeTransferID)
+
+		// Run the retry now rather than after the backoff
+		require.NoError(t, f.db.Model(&models.Task{}).Where("kind = ?", TaskSettleRentalPayment).Update("run_at", time.Now()).Error)
+		f.runTasks(t)
+
+		assert.NotEmpty(t, f.payment(t, paymentID).StripeTransferID)
+		assert.Len(t, f.gateway.Transfers(), 1)
+	})
+}
+
+func TestPaymentService_CreatePaymentIntent(t *testing.T) {
+	f := newRentalPaymentFixture(t)
+
+	request := func(amount float64) CreatePaymentIntentRequest {
+		return CreatePaymentIntentRequest{Amount: amount, Type: models.PaymentTypeEquipmentRental, RelatedID: f.rental.ID}
+	}
+
+	t.Run("GatewayOutage", func(t *testing.T) {
+		f.gateway.FailNext("payment_intent.create", errors.New("connection reset"))
+
+		_, err := f.services.Payments.CreatePaymentIntent(context.Background(), f.renter.ID, request(f.rental.TotalPrice))
+		assert.Error(t, err)
+
+		var count int64
+		f.db.Model(&models.Payment{}).Count(&count)
+		assert.Zero(t, count)
+	})
+
+	t.Run("AmountMustMatchRental", func(t *testing.T) {
+		_, err := f.services.Payments.CreatePaymentIntent(context.Background(), f.renter.ID, request(1))
+		assert.ErrorIs(t, err, ErrPaymentAmountMismatch)
+	})
+
+	t.Run("SyncsCustomerFirst", func(t *testing.T) {
+		f.pay(t)
+
+		var renter models.User
+		require.NoError(t, f.db.First(&renter, f.renter.ID).Error)
+		require.NotEmpty(t, renter.StripeCustomerID)
+		customer, ok := f.gateway.Customer(renter.StripeCustomerID)
+		require.True(t, ok)
+		assert.Equal(t, "renter@example.com", customer.Email)
+	})
+
+	t.Run("OnePaymentAtATime", func(t *testing.T) {
+		var first models.Payment
+		require.NoError(t, f.db.Order("id").First(&first).Error)
+
+		_, err := f.services.Payments.CreatePaymentIntent(context.Background(), f.renter.ID, request(0))
+		assert.ErrorIs(t, err, ErrPaymentInProgress)
+
+		// A declined payment can be replaced, and then stays failed
+		_, err = f.gateway.Confirm(first.StripePaymentIntentID, payments.TestCardDeclined)
+		require.NoError(t, err)
+		f.confirm(t, first.ID)
+
+		response, err := f.services.Payments.CreatePaymentIntent(context.Background(), f.renter.ID, request(0))
+		require.NoError(t, err)
+		second := f.payment(t, response.PaymentID)
+		assert.Equal(t, f.rental.TotalPrice, second.Amount, "the rental's total is charged")
+		intent, err := f.gateway.GetPaymentIntent(context.Background(), second.StripePaymentIntentID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(f.rental.TotalPrice*100), intent.Amount)
+
+		_, err = f.gateway.Confirm(first.StripePaymentIntentID, payments.TestCardSucceeds)
+		require.NoError(t, err)
+		assert.Equal(t, models.PaymentStatusFailed, f.confirm(t, first.ID).Status)
+	})
+
+	t.Run("RentalMustBeApproved", func(t *testing.T) {
+		_, err := f.services.Payments.CreatePaymentIntent(context.Background(), f.owner.ID, request(f.rental.TotalPrice))
+		assert.Error(t, err, "only the renter pays")
+	})
+}
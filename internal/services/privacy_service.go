@@ -0,0 +1,519 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/tasks"
+	"mowsy-api/pkg/storage"
+
+	"gorm.io/gorm"
+)
+
+// dataExportTTL is how long a finished export bundle can be downloaded
+// before it is deleted.
+const dataExportTTL = 7 * 24 * time.Hour
+
+// exportKeyPrefix is where export bundles live inside a user's private
+// prefix. Exports are not uploads, so they are left out of later exports.
+const exportKeyPrefix = "exports/"
+
+var (
+	ErrDataRequestNotFound  = errors.New("data request not found")
+	ErrAccountHasActiveWork = errors.New("account has jobs in progress or active rentals")
+)
+
+// PrivacyService exports and erases a user's data on request. Both run as
+// background tasks; callers poll the DataRequest for the outcome.
+type PrivacyService struct {
+	db      *gorm.DB
+	uploads *UploadService
+	now     func() time.Time
+}
+
+func NewPrivacyServiceWithBackend(db *gorm.DB, backend storage.Backend) *PrivacyService {
+	return &PrivacyService{db: db, uploads: NewUploadServiceWithBackend(db, backend), now: time.Now}
+}
+
+type DataRequestResponse struct {
+	ID          uint                     `json:"id"`
+	Kind        models.DataRequestKind   `json:"kind"`
+	Status      models.DataRequestStatus `json:"status"`
+	Error       string                   `json:"error,omitempty"`
+	DownloadURL string                   `json:"download_url,omitempty"`
+	ExpiresAt   *time.Time               `json:"expires_at,omitempty"`
+	CompletedAt *time.Time               `json:"completed_at"`
+	CreatedAt   time.Time                `json:"created_at"`
+}
+
+// RequestExport queues a bundle of everything stored about the user. An
+// export that is still being built is returned instead of queuing another.
+func (s *PrivacyService) RequestExport(userID uint) (*DataRequestResponse, error) {
+	request, err := s.openRequest(userID, models.DataRequestKindExport, TaskExportUserData)
+	if err != nil {
+		return nil, err
+	}
+	return s.dataRequestResponse(request)
+}
+
+// RequestErasure queues the anonymization of the user's account. Users in
+// the middle of a job or rental have to finish it first.
+func (s *PrivacyService) RequestErasure(userID uint) (*DataRequestResponse, error) {
+	busy, err := hasActiveWork(s.db, userID)
+	if err != nil {
+		return nil, err
+	}
+	if busy {
+		return nil, ErrAccountHasActiveWork
+	}
+
+	request, err := s.openRequest(userID, models.DataRequestKindErasure, TaskEraseUserData)
+	if err != nil {
+		return nil, err
+	}
+	return s.dataRequestResponse(request)
+}
+
+func (s *PrivacyService) openRequest(userID uint, kind models.DataRequestKind, taskKind string) (*models.DataRequest, error) {
+	if err := s.db.Where("id = ?", userID).First(&models.User{}).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("user not found")
+		}
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+
+	var request models.DataRequest
+	err := s.db.Where("user_id = ? AND kind = ? AND status = ?", userID, kind, models.DataRequestStatusPending).
+		First(&request).Error
+	if err == nil {
+		return &request, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to fetch data request: %w", err)
+	}
+
+	request = models.DataRequest{
+		UserID: userID,
+		Kind:   kind,
+		Status: models.DataRequestStatusPending,
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&request).Error; err != nil {
+			return fmt.Errorf("failed to create data request: %w", err)
+		}
+		return tasks.Enqueue(tx, taskKind, recordTaskPayload{ID: request.ID})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// GetDataRequest returns one of the user's requests. A finished export comes
+// with a short-lived download URL.
+func (s *PrivacyService) GetDataRequest(userID, requestID uint) (*DataRequestResponse, error) {
+	var request models.DataRequest
+	if err := s.db.Where("id = ? AND user_id = ?", requestID, userID).First(&request).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDataRequestNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch data request: %w", err)
+	}
+	return s.dataRequestResponse(&request)
+}
+
+func (s *PrivacyService) dataRequestResponse(request *models.DataRequest) (*DataRequestResponse, error) {
+	response := &DataRequestResponse{
+		ID:          request.ID,
+		Kind:        request.Kind,
+		Status:      request.Status,
+		Error:       request.Error,
+		ExpiresAt:   request.ExpiresAt,
+		CompletedAt: request.CompletedAt,
+		CreatedAt:   request.CreatedAt,
+	}
+	if request.Status == models.DataRequestStatusCompleted && request.FileKey != "" {
+		url, err := s.uploads.backend.GetPresignedURL(request.FileKey, privateFileURLExpiry)
+		if err != nil {
+			return nil, err
+		}
+		response.DownloadURL = url
+	}
+	return response, nil
+}
+
+// userDataExport is data.json in an export bundle. Stored files are added
+// next to it under files/, keeping their storage keys.
+type userDataExport struct {
+	ExportedAt      time.Time                        `json:"exported_at"`
+	Profile         models.UserResponse              `json:"profile"`
+	Jobs            []models.JobResponse             `json:"jobs"`
+	Applications    []models.JobApplicationResponse  `json:"applications"`
+	Equipment       []models.EquipmentResponse       `json:"equipment"`
+	Rentals         []models.EquipmentRentalResponse `json:"rentals"`
+	ReviewsGiven    []models.ReviewResponse          `json:"reviews_given"`
+	ReviewsReceived []models.ReviewResponse          `json:"reviews_received"`
+	Payments        []models.PaymentResponse         `json:"payments"`
+	Messages        []models.MessageResponse         `json:"messages"`
+	Uploads         []models.Upload                  `json:"uploads"`
+	Files           []string                         `json:"files"`
+}
+
+// exportUserDataTask builds the export bundle, stores it privately and
+// schedules its deletion.
+func (s *PrivacyService) exportUserDataTask(ctx context.Context, task *models.Task) error {
+	var request models.DataRequest
+	if found, err := loadTaskRecord(s.db, task, &request); !found || err != nil {
+		return err
+	}
+	if request.Status != models.DataRequestStatusPending {
+		return nil
+	}
+
+	bundle, err := s.buildExport(ctx, request.UserID)
+	if err != nil {
+		return s.failDataRequest(task, &request, err)
+	}
+
+	key := storage.KeyPrefix(storage.VisibilityPrivate, request.UserID) +
+		fmt.Sprintf("%s%d.zip", exportKeyPrefix, request.ID)
+	if _, err := s.uploads.backend.PutFile(key, bytes.NewReader(bundle), "application/zip"); err != nil {
+		return s.failDataRequest(task, &request, err)
+	}
+
+	now := s.now()
+	expiresAt := now.Add(dataExportTTL)
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&request).Updates(map[string]interface{}{
+			"status":       models.DataRequestStatusCompleted,
+			"file_key":     key,
+			"expires_at":   &expiresAt,
+			"completed_at": &now,
+			"error":        "",
+		}).Error; err != nil {
+			return fmt.Errorf("failed to complete data request: %w", err)
+		}
+		return tasks.Enqueue(tx, TaskExpireDataExport, recordTaskPayload{ID: request.ID}, tasks.RunAt(expiresAt))
+	})
+}
+
+func (s *PrivacyService) buildExport(ctx context.Context, userID uint) ([]byte, error) {
+	var user models.User
+	if err := s.db.Where("id = ?", userID).First(&user).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch user: %w", err)
+	}
+
+	export := userDataExport{
+		ExportedAt: s.now(),
+		Profile:    user.ToResponse(),
+	}
+
+	// Listings an admin removed are still the user's data
+	var jobs []models.Job
+	if err := s.db.Unscoped().Preload("User").Where("user_id = ?", userID).Order("id").Find(&jobs).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch jobs: %w", err)
+	}
+	for _, job := range jobs {
+		export.Jobs = append(export.Jobs, job.ToResponse())
+	}
+
+	var applications []models.JobApplication
+	if err := s.db.Preload("User").Where("user_id = ?", userID).Order("id").Find(&applications).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch applications: %w", err)
+	}
+	for _, application := range applications {
+		export.Applications = append(export.Applications, application.ToResponse())
+	}
+
+	var equipment []models.Equipment
+	if err := s.db.Unscoped().Preload("User").Where("user_id = ?", userID).Order("id").Find(&equipment).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch equipment: %w", err)
+	}
+	for _, item := range equipment {
+		export.Equipment = append(export.Equipment, item.ToResponse())
+	}
+
+	var rentals []models.EquipmentRental
+	if err := s.db.Preload("Equipment", func(db *gorm.DB) *gorm.DB { return db.Unscoped() }).
+		Preload("Equipment.User").Preload("Renter").
+		Where("renter_user_id = ?", userID).Order("id").Find(&rentals).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch rentals: %w", err)
+	}
+	for _, rental := range rentals {
+		export.Rentals = append(export.Rentals, rental.ToResponse())
+	}
+
+	var given, received []models.Review
+	if err := s.db.Preload("Reviewer").Where("reviewer_user_id = ?", userID).Order("id").Find(&given).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch reviews: %w", err)
+	}
+	if err := s.db.Preload("Reviewer").Where("reviewed_user_id = ?", userID).Order("id").Find(&received).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch reviews: %w", err)
+	}
+	for _, review := range given {
+		export.ReviewsGiven = append(export.ReviewsGiven, review.ToResponse())
+	}
+	for _, review := range received {
+		export.ReviewsReceived = append(export.ReviewsReceived, review.ToResponse())
+	}
+
+	var payments []models.Payment
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&payments).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch payments: %w", err)
+	}
+	for _, payment := range payments {
+		export.Payments = append(export.Payments, payment.ToResponse())
+	}
+
+	var messages []models.Message
+	if err := s.db.Where("sender_user_id = ?", userID).Order("id").Find(&messages).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch messages: %w", err)
+	}
+	for _, message := range messages {
+		export.Messages = append(export.Messages, message.ToResponse())
+	}
+
+	if err := s.db.Where("user_id = ?", userID).Order("id").Find(&export.Uploads).Error; err != nil {
+		return nil, fmt.Errorf("failed to fetch uploads: %w", err)
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	err := s.eachUserFile(userID, func(file storage.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Variants are derived from the original, which is exported already
+		if sourceKey(file.Key) != file.Key || strings.Contains(file.Key, "/"+exportKeyPrefix) {
+			return nil
+		}
+
+		name := "files/" + file.Key
+		if err := s.copyToArchive(archive, name, file.Key); err != nil {
+			return err
+		}
+		export.Files = append(export.Files, name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode export: %w", err)
+	}
+	w, err := archive.Create("data.json")
+	if err != nil {
+		return nil, fmt.Errorf("failed to write export: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to write export: %w", err)
+	}
+	if err := archive.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write export: %w", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (s *PrivacyService) copyToArchive(archive *zip.Writer, name, key string) error {
+	file, err := s.uploads.backend.OpenFile(key)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	defer file.Close()
+
+	w, err := archive.Create(name)
+	if err != nil {
+		return fmt.Errorf("failed to write export: %w", err)
+	}
+	if _, err := io.Copy(w, file); err != nil {
+		return fmt.Errorf("failed to read %s: %w", key, err)
+	}
+	return nil
+}
+
+// eachUserFile lists every file stored under the user's public and private
+// prefixes, whether or not it has an uploads row.
+func (s *PrivacyService) eachUserFile(userID uint, fn func(storage.FileInfo) error) error {
+	for _, visibility := range []storage.Visibility{storage.VisibilityPublic, storage.VisibilityPrivate} {
+		if err := s.uploads.backend.ListFiles(storage.KeyPrefix(visibility, userID), fn); err != nil {
+			return fmt.Errorf("failed to list files: %w", err)
+		}
+	}
+	return nil
+}
+
+// expireDataExportTask deletes an export bundle once it can no longer be
+// downloaded.
+func (s *PrivacyService) expireDataExportTask(ctx context.Context, task *models.Task) error {
+	var request models.DataRequest
+	if found, err := loadTaskRecord(s.db, task, &request); !found || err != nil {
+		return err
+	}
+	if request.FileKey == "" {
+		return nil
+	}
+
+	if err := s.uploads.backend.DeleteFile(request.FileKey); err != nil {
+		return err
+	}
+	if err := s.db.Model(&request).Updates(map[string]interface{}{
+		"status":   models.DataRequestStatusExpired,
+		"file_key": "",
+	}).Error; err != nil {
+		return fmt.Errorf("failed to expire data export: %w", err)
+	}
+	return nil
+}
+
+// erasedEmail replaces the address of an erased account. It stays unique
+// and can never receive mail.
+func erasedEmail(userID uint) string {
+	return fmt.Sprintf("erased-%d@erased.invalid", userID)
+}
+
+// eraseUserDataTask anonymizes an account. Stored files go first, so a
+// retry after a partial failure finds whatever is left. The user row,
+// applications, rentals, reviews and payments are kept with the personal
+// details stripped, as payments and the records they belong to must be
+// retained; Stripe keeps its own copy under StripeCustomerID.
+func (s *PrivacyService) eraseUserDataTask(ctx context.Context, task *models.Task) error {
+	var request models.DataRequest
+	if found, err := loadTaskRecord(s.db, task, &request); !found || err != nil {
+		return err
+	}
+	if request.Status != models.DataRequestStatusPending {
+		return nil
+	}
+	userID := request.UserID
+
+	// Work may have started since the request was accepted
+	busy, err := hasActiveWork(s.db, userID)
+	if err != nil {
+		return err
+	}
+	if busy {
+		return s.failDataRequest(task, &request, tasks.Permanent(ErrAccountHasActiveWork))
+	}
+
+	err = s.eachUserFile(userID, func(file storage.FileInfo) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return s.uploads.backend.DeleteFile(file.Key)
+	})
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+			"email":                           erasedEmail(userID),
+			"password_hash":                   "",
+			"first_name":                      "Deleted",
+			"last_name":                       "User",
+			"phone":                           "",
+			"address":                         "",
+			"city":                            "",
+			"state":                           "",
+			"zip_code":                        "",
+			"latitude":                        nil,
+			"longitude":                       nil,
+			"elementary_school_district_name": "",
+			"elementary_school_district_code": "",
+			"is_active":                       false,
+			"insurance_document_url":          "",
+			"insurance_document_key":          "",
+			"mfa_enabled":                     false,
+			"mfa_secret":                      "",
+			"mfa_pending_secret":              "",
+			"deleted_at":                      now,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to anonymize user: %w", err)
+		}
+
+		if err := tx.Unscoped().Model(&models.Job{}).Where("user_id = ?", userID).
+			Updates(map[string]interface{}{"address": "", "latitude": nil, "longitude": nil}).Error; err != nil {
+			return fmt.Errorf("failed to anonymize jobs: %w", err)
+		}
+		if err := tx.Unscoped().Model(&models.Equipment{}).Where("user_id = ?", userID).
+			Updates(map[string]interface{}{"address": "", "latitude": nil, "longitude": nil, "image_urls": nil}).Error; err != nil {
+			return fmt.Errorf("failed to anonymize equipment: %w", err)
+		}
+		// Deleted listings keep their own deletion time
+		if err := tx.Model(&models.Job{}).Where("user_id = ?", userID).Update("deleted_at", now).Error; err != nil {
+			return fmt.Errorf("failed to remove jobs: %w", err)
+		}
+		if err := tx.Model(&models.Equipment{}).Where("user_id = ?", userID).Update("deleted_at", now).Error; err != nil {
+			return fmt.Errorf("failed to remove equipment: %w", err)
+		}
+
+		// The attachments they sent no longer exist
+		if err := tx.Model(&models.Message{}).Where("sender_user_id = ?", userID).
+			Update("attachments", nil).Error; err != nil {
+			return fmt.Errorf("failed to clear attachments: %w", err)
+		}
+
+		if err := tx.Where("endpoint_id IN (?)", tx.Model(&models.WebhookEndpoint{}).Select("id").Where("user_id = ?", userID)).
+			Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return fmt.Errorf("failed to delete webhook deliveries: %w", err)
+		}
+
+		for _, model := range []interface{}{
+			&models.Notification{},
+			&models.NotificationPreference{},
+			&models.UploadSession{},
+			&models.Upload{},
+			&models.MFARecoveryCode{},
+			&models.LoginFailure{},
+			&models.OAuthIdentity{},
+			&models.PersonalAccessToken{},
+			&models.WebhookEndpoint{},
+		} {
+			if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+				return fmt.Errorf("failed to delete user data: %w", err)
+			}
+		}
+
+		if err := tx.Model(&models.DataRequest{}).
+			Where("user_id = ? AND kind = ? AND file_key <> ''", userID, models.DataRequestKindExport).
+			Updates(map[string]interface{}{"status": models.DataRequestStatusExpired, "file_key": ""}).Error; err != nil {
+			return fmt.Errorf("failed to expire data exports: %w", err)
+		}
+
+		if err := tx.Model(&request).Updates(map[string]interface{}{
+			"status":       models.DataRequestStatusCompleted,
+			"completed_at": &now,
+			"error":        "",
+		}).Error; err != nil {
+			return fmt.Errorf("failed to complete data request: %w", err)
+		}
+		return nil
+	})
+}
+
+// failDataRequest records why a request failed once the task will not be
+// retried, and passes the error on to the queue.
+func (s *PrivacyService) failDataRequest(task *models.Task, request *models.DataRequest, err error) error {
+	if !task.IsFinalAttempt() && !tasks.IsPermanent(err) {
+		return err
+	}
+	if updateErr := s.db.Model(request).Updates(map[string]interface{}{
+		"status": models.DataRequestStatusFailed,
+		"error":  err.Error(),
+	}).Error; updateErr != nil {
+		return fmt.Errorf("failed to update data request: %w", updateErr)
+	}
+	return err
+}
@@ -0,0 +1,218 @@
+package services
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/tasks"
+	"mowsy-api/internal/testutils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupPrivacyService(t *testing.T) (*PrivacyService, *tasks.Queue, *gorm.DB) {
+	uploads, db := setupUploadService(t)
+	service := &PrivacyService{db: db, uploads: uploads, now: time.Now}
+
+	queue := tasks.NewQueue(db)
+	queue.Register(TaskExportUserData, service.exportUserDataTask)
+	queue.Register(TaskExpireDataExport, service.expireDataExportTask)
+	queue.Register(TaskEraseUserData, service.eraseUserDataTask)
+	return service, queue, db
+}
+
+// readExport opens the ZIP bundle a completed export points at.
+func readExport(t *testing.T, service *PrivacyService, requestID uint) map[string][]byte {
+	var request models.DataRequest
+	require.NoError(t, service.db.First(&request, requestID).Error)
+
+	file, err := service.uploads.backend.OpenFile(request.FileKey)
+	require.NoError(t, err)
+	defer file.Close()
+	data, err := io.ReadAll(file)
+	require.NoError(t, err)
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	require.NoError(t, err)
+	contents := map[string][]byte{}
+	for _, entry := range archive.File {
+		r, err := entry.Open()
+		require.NoError(t, err)
+		contents[entry.Name], err = io.ReadAll(r)
+		require.NoError(t, err)
+		r.Close()
+	}
+	return contents
+}
+
+func TestPrivacyService_Export(t *testing.T) {
+	service, queue, db := setupPrivacyService(t)
+	defer testutils.CleanupTestDB(db)
+
+	user := testutils.CreateTestUser(db)
+	job := testutils.CreateTestJob(db, user.ID)
+	equipment := testutils.CreateTestEquipment(db, user.ID)
+	require.NoError(t, db.Create(&models.Payment{
+		UserID: user.ID, StripePaymentIntentID: "pi_1", Amount: 40, Type: models.PaymentTypeJobPayment, RelatedID: job.ID,
+	}).Error)
+	photo, err := service.uploads.UploadFromReader(user.ID, bytes.NewReader(testutils.CreateTestImage(64, 48)), "mower.png", "image/png", UploadCategoryListingPhoto)
+	require.NoError(t, err)
+	policy, err := service.uploads.UploadFromReader(user.ID, strings.NewReader("%PDF-1.4"), "policy.pdf", "application/pdf", UploadCategoryInsurance)
+	require.NoError(t, err)
+
+	request, err := service.RequestExport(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.DataRequestStatusPending, request.Status)
+	assert.Empty(t, request.DownloadURL)
+
+	again, err := service.RequestExport(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, request.ID, again.ID, "a pending export is reused")
+
+	_, err = queue.RunPending(context.Background(), 10)
+	require.NoError(t, err)
+
+	status, err := service.GetDataRequest(user.ID, request.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.DataRequestStatusCompleted, status.Status)
+	assert.Contains(t, status.DownloadURL, "signature=")
+	require.NotNil(t, status.ExpiresAt)
+
+	contents := readExport(t, service, request.ID)
+	assert.Contains(t, contents, "files/"+photo.Key)
+	assert.Contains(t, contents, "files/"+policy.Key)
+	assert.Equal(t, "%PDF-1.4", string(contents["files/"+policy.Key]))
+	for name := range contents {
+		assert.NotContains(t, name, "_thumbnail", "variants are left out")
+	}
+
+	var export userDataExport
+	require.NoError(t, json.Unmarshal(contents["data.json"], &export))
+	assert.Equal(t, user.Email, export.Profile.Email)
+	require.Len(t, export.Jobs, 1)
+	assert.Equal(t, job.ID, export.Jobs[0].ID)
+	require.Len(t, export.Equipment, 1)
+	assert.Equal(t, equipment.ID, export.Equipment[0].ID)
+	require.Len(t, export.Payments, 1)
+	assert.Len(t, export.Uploads, 2)
+
+	t.Run("OnlyOwnerCanSeeRequest", func(t *testing.T) {
+		_, err := service.GetDataRequest(user.ID+1, request.ID)
+		assert.ErrorIs(t, err, ErrDataRequestNotFound)
+	})
+
+	t.Run("BundleIsNotOrphaned", func(t *testing.T) {
+		referenced, err := referencedUploadKeys(db)
+		require.NoError(t, err)
+
+		var stored models.DataRequest
+		require.NoError(t, db.First(&stored, request.ID).Error)
+		assert.True(t, referenced[stored.FileKey])
+	})
+
+	t.Run("ExpiryDeletesBundle", func(t *testing.T) {
+		var stored models.DataRequest
+		require.NoError(t, db.First(&stored, request.ID).Error)
+		db.Model(&models.Task{}).Where("kind = ?", TaskExpireDataExport).Update("run_at", time.Now())
+
+		_, err := queue.RunPending(context.Background(), 10)
+		require.NoError(t, err)
+
+		status, err := service.GetDataRequest(user.ID, request.ID)
+		require.NoError(t, err)
+		assert.Equal(t, models.DataRequestStatusExpired, status.Status)
+		assert.Empty(t, status.DownloadURL)
+		_, err = service.uploads.backend.StatFile(stored.FileKey)
+		assert.Error(t, err)
+	})
+}
+
+func TestPrivacyService_Erasure(t *testing.T) {
+	testutils.UseTestSigningKeys(t)
+	service, queue, db := setupPrivacyService(t)
+	defer testutils.CleanupTestDB(db)
+
+	user := testutils.CreateTestUser(db)
+	other := createApplicant(t, db, "other@example.com")
+	job := testutils.CreateTestJob(db, user.ID)
+	equipment := testutils.CreateTestEquipment(db, user.ID)
+	require.NoError(t, db.Create(&models.Payment{
+		UserID: user.ID, StripePaymentIntentID: "pi_1", Amount: 40, Type: models.PaymentTypeJobPayment, RelatedID: job.ID,
+	}).Error)
+	photo, err := service.uploads.UploadFromReader(user.ID, bytes.NewReader(testutils.CreateTestImage(64, 48)), "mower.png", "image/png", UploadCategoryListingPhoto)
+	require.NoError(t, err)
+	db.Model(equipment).Update("image_urls", models.StringArray{photo.URL})
+	otherPhoto, err := service.uploads.UploadFromReader(other.ID, bytes.NewReader(testutils.CreateTestImage(64, 48)), "rake.png", "image/png", UploadCategoryListingPhoto)
+	require.NoError(t, err)
+
+	t.Run("RefusedWithActiveWork", func(t *testing.T) {
+		db.Model(job).Update("status", models.JobStatusInProgress)
+		defer db.Model(job).Update("status", models.JobStatusOpen)
+
+		_, err := service.RequestErasure(user.ID)
+		assert.ErrorIs(t, err, ErrAccountHasActiveWork)
+	})
+
+	request, err := service.RequestErasure(user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.DataRequestKindErasure, request.Kind)
+
+	_, err = queue.RunPending(context.Background(), 10)
+	require.NoError(t, err)
+
+	status, err := service.GetDataRequest(user.ID, request.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.DataRequestStatusCompleted, status.Status)
+
+	var erased models.User
+	require.NoError(t, db.Unscoped().First(&erased, user.ID).Error)
+	assert.Equal(t, erasedEmail(user.ID), erased.Email)
+	assert.Equal(t, "Deleted", erased.FirstName)
+	assert.Empty(t, erased.Phone)
+	assert.Empty(t, erased.Address)
+	assert.Empty(t, erased.ZipCode)
+	assert.False(t, erased.IsActive)
+	assert.True(t, erased.DeletedAt.Valid)
+
+	// Listings are gone from the site and stripped of their location
+	var listing models.Equipment
+	require.NoError(t, db.Unscoped().First(&listing, equipment.ID).Error)
+	assert.True(t, listing.DeletedAt.Valid)
+	assert.Empty(t, listing.Address)
+	assert.Empty(t, listing.ImageUrls)
+
+	// Financial records stay
+	var payments int64
+	db.Model(&models.Payment{}).Where("user_id = ?", user.ID).Count(&payments)
+	assert.Equal(t, int64(1), payments)
+
+	// Their files are deleted, other users' are not
+	_, err = service.uploads.backend.StatFile(photo.Key)
+	assert.Error(t, err)
+	_, err = service.uploads.backend.StatFile(otherPhoto.Key)
+	assert.NoError(t, err)
+	var uploads int64
+	db.Model(&models.Upload{}).Where("user_id = ?", user.ID).Count(&uploads)
+	assert.Equal(t, int64(0), uploads)
+
+	t.Run("CannotBeRestored", func(t *testing.T) {
+		err := (&AdminService{db: db}).RestoreUser(user.ID)
+		assert.ErrorContains(t, err, "erased")
+	})
+
+	t.Run("EmailCanBeReused", func(t *testing.T) {
+		_, err := (&UserService{db: db}).Register(RegisterRequest{
+			Email: user.Email, Password: "mower-shed-42", FirstName: "New", LastName: "User",
+		})
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,281 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/pkg/logging"
+
+	"gorm.io/gorm"
+)
+
+// Jobs, equipment and users are soft-deleted. Applications and rentals have
+// no deleted_at of their own; they disappear with the job or equipment they
+// belong to, or with the user who applied or rented. Payments and reviews
+// stay visible to both parties as records of what happened.
+
+// liveApplications is a scope that hides job applications whose job or
+// applicant has been deleted.
+func liveApplications(db *gorm.DB) *gorm.DB {
+	return db.
+		Where("EXISTS (SELECT 1 FROM jobs WHERE jobs.id = job_applications.job_id AND jobs.deleted_at IS NULL)").
+		Where("EXISTS (SELECT 1 FROM users WHERE users.id = job_applications.user_id AND users.deleted_at IS NULL)")
+}
+
+// liveRentals is a scope that hides rentals whose equipment or renter has
+// been deleted.
+func liveRentals(db *gorm.DB) *gorm.DB {
+	return db.
+		Where("EXISTS (SELECT 1 FROM equipment WHERE equipment.id = equipment_rentals.equipment_id AND equipment.deleted_at IS NULL)").
+		Where("EXISTS (SELECT 1 FROM users WHERE users.id = equipment_rentals.renter_user_id AND users.deleted_at IS NULL)")
+}
+
+// DefaultDeletedRetention is how long a removed job, listing or user can be
+// restored before PurgeDeleted removes it for good.
+const DefaultDeletedRetention = 30 * 24 * time.Hour
+
+type PurgeOptions struct {
+	Retention time.Duration
+	DryRun    bool
+}
+
+type PurgeResult struct {
+	Purged []uint `json:"purged"`
+	// Kept lists rows past retention that payments, reviews or other users'
+	// records still refer to. They stay soft-deleted.
+	Kept []uint `json:"kept"`
+}
+
+type PurgeReport struct {
+	DryRun    bool        `json:"dry_run"`
+	Retention string      `json:"retention"`
+	Cutoff    time.Time   `json:"cutoff"`
+	Jobs      PurgeResult `json:"jobs"`
+	Equipment PurgeResult `json:"equipment"`
+	Users     PurgeResult `json:"users"`
+}
+
+// PurgeDeleted hard-deletes jobs, equipment and users that were soft-deleted
+// longer ago than the retention period, along with the applications,
+// rentals and conversations that only existed because of them. Anything
+// with a payment or review attached is kept, since those are financial and
+// reputational records. Users are purged last, so a user whose listings go
+// in the same run can go too; in a dry run they are reported as kept.
+func (s *AdminService) PurgeDeleted(ctx context.Context, opts PurgeOptions) (*PurgeReport, error) {
+	if opts.Retention <= 0 {
+		opts.Retention = DefaultDeletedRetention
+	}
+
+	report := &PurgeReport{
+		DryRun:    opts.DryRun,
+		Retention: opts.Retention.String(),
+		Cutoff:    time.Now().Add(-opts.Retention),
+		Jobs:      PurgeResult{Purged: []uint{}, Kept: []uint{}},
+		Equipment: PurgeResult{Purged: []uint{}, Kept: []uint{}},
+		Users:     PurgeResult{Purged: []uint{}, Kept: []uint{}},
+	}
+
+	steps := []struct {
+		model  interface{}
+		result *PurgeResult
+		purge  func(tx *gorm.DB, id uint) (bool, error)
+	}{
+		{&models.Job{}, &report.Jobs, purgeJob},
+		{&models.Equipment{}, &report.Equipment, purgeEquipment},
+		{&models.User{}, &report.Users, purgeUser},
+	}
+
+	for _, step := range steps {
+		var ids []uint
+		if err := s.db.Unscoped().Model(step.model).
+			Where("deleted_at IS NOT NULL AND deleted_at < ?", report.Cutoff).
+			Order("id").Pluck("id", &ids).Error; err != nil {
+			return report, fmt.Errorf("failed to find deleted rows: %w", err)
+		}
+
+		for _, id := range ids {
+			if err := ctx.Err(); err != nil {
+				return report, err
+			}
+
+			purged := false
+			err := s.db.Transaction(func(tx *gorm.DB) error {
+				var err error
+				purged, err = step.purge(tx, id)
+				if err != nil {
+					return err
+				}
+				if opts.DryRun {
+					return errDryRun
+				}
+				return nil
+			})
+			if err != nil && !errors.Is(err, errDryRun) {
+				return report, err
+			}
+
+			if purged {
+				step.result.Purged = append(step.result.Purged, id)
+			} else {
+				step.result.Kept = append(step.result.Kept, id)
+			}
+		}
+	}
+
+	logging.FromContext(ctx).Info("purged deleted records", "dry_run", opts.DryRun,
+		"jobs", len(report.Jobs.Purged), "equipment", len(report.Equipment.Purged), "users", len(report.Users.Purged))
+
+	return report, nil
+}
+
+// errDryRun rolls back a purge transaction after checking that it would
+// have succeeded.
+var errDryRun = errors.New("dry run")
+
+// hasRows reports whether query matches anything.
+func hasRows(query *gorm.DB) (bool, error) {
+	var count int64
+	if err := query.Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check references: %w", err)
+	}
+	return count > 0, nil
+}
+
+func purgeJob(tx *gorm.DB, jobID uint) (bool, error) {
+	for _, query := range []*gorm.DB{
+		tx.Model(&models.Payment{}).Where("type = ? AND related_id = ?", models.PaymentTypeJobPayment, jobID),
+		tx.Model(&models.Review{}).Where("job_id = ?", jobID),
+	} {
+		if found, err := hasRows(query); found || err != nil {
+			return false, err
+		}
+	}
+
+	var applicationIDs []uint
+	if err := tx.Model(&models.JobApplication{}).Where("job_id = ?", jobID).Pluck("id", &applicationIDs).Error; err != nil {
+		return false, fmt.Errorf("failed to load applications: %w", err)
+	}
+	if err := deleteConversations(tx, models.ConversationTypeJobApplication, applicationIDs); err != nil {
+		return false, err
+	}
+	if err := tx.Where("job_id = ?", jobID).Delete(&models.JobApplication{}).Error; err != nil {
+		return false, fmt.Errorf("failed to delete applications: %w", err)
+	}
+	if err := unlinkUploads(tx, UploadRelatedJob, jobID); err != nil {
+		return false, err
+	}
+	if err := tx.Unscoped().Delete(&models.Job{}, jobID).Error; err != nil {
+		return false, fmt.Errorf("failed to purge job: %w", err)
+	}
+
+	return true, nil
+}
+
+func purgeEquipment(tx *gorm.DB, equipmentID uint) (bool, error) {
+	var rentalIDs []uint
+	if err := tx.Model(&models.EquipmentRental{}).Where("equipment_id = ?", equipmentID).Pluck("id", &rentalIDs).Error; err != nil {
+		return false, fmt.Errorf("failed to load rentals: %w", err)
+	}
+
+	if len(rentalIDs) > 0 {
+		for _, query := range []*gorm.DB{
+			tx.Model(&models.Payment{}).Where("type = ? AND related_id IN ?", models.PaymentTypeEquipmentRental, rentalIDs),
+			tx.Model(&models.Review{}).Where("equipment_rental_id IN ?", rentalIDs),
+		} {
+			if found, err := hasRows(query); found || err != nil {
+				return false, err
+			}
+		}
+	}
+
+	if err := deleteConversations(tx, models.ConversationTypeEquipmentRental, rentalIDs); err != nil {
+		return false, err
+	}
+	if err := tx.Where("equipment_id = ?", equipmentID).Delete(&models.EquipmentRental{}).Error; err != nil {
+		return false, fmt.Errorf("failed to delete rentals: %w", err)
+	}
+	if err := unlinkUploads(tx, UploadRelatedEquipment, equipmentID); err != nil {
+		return false, err
+	}
+	if err := tx.Unscoped().Delete(&models.Equipment{}, equipmentID).Error; err != nil {
+		return false, fmt.Errorf("failed to purge equipment: %w", err)
+	}
+
+	return true, nil
+}
+
+// purgeUser only removes users nothing else points at any more. Their own
+// listings must have been purged first; applications, rentals, reviews,
+// payments and conversations involving them keep the user row alive.
+func purgeUser(tx *gorm.DB, userID uint) (bool, error) {
+	for _, query := range []*gorm.DB{
+		tx.Unscoped().Model(&models.Job{}).Where("user_id = ?", userID),
+		tx.Unscoped().Model(&models.Equipment{}).Where("user_id = ?", userID),
+		tx.Model(&models.JobApplication{}).Where("user_id = ?", userID),
+		tx.Model(&models.EquipmentRental{}).Where("renter_user_id = ?", userID),
+		tx.Model(&models.Review{}).Where("reviewer_user_id = ? OR reviewed_user_id = ?", userID, userID),
+		tx.Model(&models.Payment{}).Where("user_id = ?", userID),
+		tx.Model(&models.Conversation{}).Where("owner_user_id = ? OR participant_user_id = ?", userID, userID),
+	} {
+		if found, err := hasRows(query); found || err != nil {
+			return false, err
+		}
+	}
+
+	for _, model := range []interface{}{
+		&models.Notification{},
+		&models.NotificationPreference{},
+		&models.UploadSession{},
+		&models.Upload{},
+		&models.LoginFailure{},
+		&models.MFARecoveryCode{},
+		&models.OAuthIdentity{},
+		&models.PersonalAccessToken{},
+	} {
+		if err := tx.Where("user_id = ?", userID).Delete(model).Error; err != nil {
+			return false, fmt.Errorf("failed to delete user data: %w", err)
+		}
+	}
+	if err := tx.Where("endpoint_id IN (?)", tx.Model(&models.WebhookEndpoint{}).Select("id").Where("user_id = ?", userID)).
+		Delete(&models.WebhookDelivery{}).Error; err != nil {
+		return false, fmt.Errorf("failed to delete webhook deliveries: %w", err)
+	}
+	if err := tx.Where("user_id = ?", userID).Delete(&models.WebhookEndpoint{}).Error; err != nil {
+		return false, fmt.Errorf("failed to delete webhook endpoints: %w", err)
+	}
+	if err := tx.Unscoped().Delete(&models.User{}, userID).Error; err != nil {
+		return false, fmt.Errorf("failed to purge user: %w", err)
+	}
+
+	return true, nil
+}
+
+func deleteConversations(tx *gorm.DB, conversationType models.ConversationType, relatedIDs []uint) error {
+	if len(relatedIDs) == 0 {
+		return nil
+	}
+
+	conversations := tx.Model(&models.Conversation{}).Select("id").
+		Where("type = ? AND related_id IN ?", conversationType, relatedIDs)
+	if err := tx.Where("conversation_id IN (?)", conversations).Delete(&models.Message{}).Error; err != nil {
+		return fmt.Errorf("failed to delete messages: %w", err)
+	}
+	if err := tx.Where("type = ? AND related_id IN ?", conversationType, relatedIDs).Delete(&models.Conversation{}).Error; err != nil {
+		return fmt.Errorf("failed to delete conversations: %w", err)
+	}
+	return nil
+}
+
+// unlinkUploads forgets which record used a set of uploads. The files stay
+// until upload garbage collection finds nothing refers to them.
+func unlinkUploads(tx *gorm.DB, relatedType string, relatedID uint) error {
+	if err := tx.Model(&models.Upload{}).
+		Where("related_type = ? AND related_id = ?", relatedType, relatedID).
+		Updates(map[string]interface{}{"related_type": "", "related_id": nil}).Error; err != nil {
+		return fmt.Errorf("failed to unlink uploads: %w", err)
+	}
+	return nil
+}
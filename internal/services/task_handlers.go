@@ -0,0 +1,83 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/tasks"
+
+	"gorm.io/gorm"
+)
+
+// Background task kinds.
+const (
+	TaskGeocodeJob           = "geocode.job"
+	TaskGeocodeEquipment     = "geocode.equipment"
+	TaskGeocodeUser          = "geocode.user"
+	TaskNotifyEvent          = "notification.event"
+	TaskDeliverNotification  = "notification.deliver"
+	TaskDispatchWebhooks     = "webhook.event"
+	TaskDeliverWebhook       = "webhook.deliver"
+	TaskSyncStripeCustomer   = "stripe.sync_customer"
+	TaskCaptureRentalPayment = "payment.capture_rental"
+	TaskSettleRentalPayment  = "payment.settle_rental"
+	TaskReleaseRentalPayment = "payment.release_rental"
+	TaskProcessUpload        = "upload.process"
+	TaskExpireUploadSession  = "upload.expire_session"
+	TaskExportUserData       = "privacy.export"
+	TaskExpireDataExport     = "privacy.expire_export"
+	TaskEraseUserData        = "privacy.erase"
+	TaskSendUnlockEmail      = "auth.unlock_email"
+)
+
+// recordTaskPayload points a task at a single row. Handlers reload the row so
+// they always act on its latest state.
+type recordTaskPayload struct {
+	ID uint `json:"id"`
+}
+
+// geocodeTaskPayload points a geocoding task at a listing. Announce is set
+// for new listings, whose broadcast waits until their location is known.
+type geocodeTaskPayload struct {
+	ID       uint `json:"id"`
+	Announce bool `json:"announce,omitempty"`
+}
+
+// loadTaskRecord fetches the row a task points at. A missing row means it was
+// deleted after the task was queued, so there is nothing left to do.
+func loadTaskRecord(db *gorm.DB, task *models.Task, dest interface{}) (bool, error) {
+	var payload recordTaskPayload
+	if err := tasks.Decode(task, &payload); err != nil {
+		return false, err
+	}
+
+	if err := db.Where("id = ?", payload.ID).First(dest).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to fetch record: %w", err)
+	}
+	return true, nil
+}
+
+// geocodeTaskError decides how a geocoding failure is retried. An address the
+// provider cannot resolve will not improve on retry, and a missing API key is
+// a deployment choice rather than a failure.
+func geocodeTaskError(task *models.Task, err error) error {
+	switch {
+	case errors.Is(err, ErrGeocodingDisabled):
+		slog.Info("skipping task", "task_id", task.ID, "task_kind", task.Kind, "reason", err)
+		return nil
+	case errors.Is(err, ErrAddressNotFound):
+		return tasks.Permanent(err)
+	}
+	return err
+}
+
+// geocodeSettled reports whether this is the last geocoding run for a task,
+// successful or not, so a listing waiting on it can be announced.
+func geocodeSettled(task *models.Task, err error) bool {
+	return err == nil || tasks.IsPermanent(err) || task.IsFinalAttempt()
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"context"
+	"testing"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/tasks"
+	"mowsy-api/internal/testutils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var springfieldFixtures = map[string]GeocodeResult{
+	"1 Elm Street, Springfield, IL": {
+		Latitude:     39.8,
+		Longitude:    -89.6,
+		ZipCode:      "62701",
+		DistrictName: "Springfield District 186",
+		DistrictCode: "1737260",
+	},
+}
+
+func TestGeocodeTasks(t *testing.T) {
+	db := testutils.SetupTestDB()
+	defer testutils.CleanupTestDB(db)
+
+	geocoder := NewStaticGeocoder(springfieldFixtures)
+	jobService := &JobService{db: db, geocoder: geocoder, users: &UserService{db: db}}
+	queue := tasks.NewQueue(db)
+	queue.Register(TaskGeocodeJob, jobService.geocodeJobTask)
+
+	user := testutils.CreateTestUser(db)
+
+	t.Run("CreateJobQueuesGeocoding", func(t *testing.T) {
+		job, err := jobService.CreateJob(context.Background(), user.ID, CreateJobRequest{
+			Title:      "Mow",
+			Category:   models.JobCategoryMowing,
+			FixedPrice: 40,
+			Address:    "1 Elm St., Springfield IL",
+			Visibility: models.VisibilityZipCode,
+		})
+		require.NoError(t, err)
+		// Responds with the poster's location before geocoding runs
+		assert.Equal(t, user.ZipCode, job.ZipCode)
+		var queued int64
+		db.Model(&models.Task{}).Where("kind <> ?", TaskGeocodeJob).Count(&queued)
+		assert.Zero(t, queued, "job.posted waits for the job's own location")
+
+		processed, err := queue.RunPending(context.Background(), 10)
+		require.NoError(t, err)
+		assert.Equal(t, 1, processed)
+
+		var updated models.Job
+		require.NoError(t, db.First(&updated, job.ID).Error)
+		assert.Equal(t, "62701", updated.ZipCode)
+		assert.Equal(t, "Springfield District 186", updated.ElementarySchoolDistrictName)
+		require.NotNil(t, updated.Latitude)
+		assert.Equal(t, 39.8, *updated.Latitude)
+		db.Exec("DELETE FROM tasks")
+	})
+
+	t.Run("UnknownAddressIsDeadLettered", func(t *testing.T) {
+		job := testutils.CreateTestJob(db, user.ID)
+		require.NoError(t, tasks.Enqueue(db, TaskGeocodeJob, recordTaskPayload{ID: job.ID}))
+
+		_, err := queue.RunPending(context.Background(), 10)
+		require.NoError(t, err)
+
+		var task models.Task
+		require.NoError(t, db.First(&task).Error)
+		assert.Equal(t, models.TaskStatusDead, task.Status)
+		assert.Equal(t, 1, task.Attempts)
+		db.Exec("DELETE FROM tasks")
+	})
+
+	t.Run("DeletedRecordIsSkipped", func(t *testing.T) {
+		require.NoError(t, tasks.Enqueue(db, TaskGeocodeJob, recordTaskPayload{ID: 9999}))
+
+		_, err := queue.RunPending(context.Background(), 10)
+		require.NoError(t, err)
+
+		var task models.Task
+		require.NoError(t, db.First(&task).Error)
+		assert.Equal(t, models.TaskStatusSucceeded, task.Status)
+		db.Exec("DELETE FROM tasks")
+	})
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/pkg/imaging"
+	"mowsy-api/pkg/logging"
+	"mowsy-api/pkg/storage"
+
+	"gorm.io/gorm"
+)
+
+// DefaultUploadGracePeriod keeps new files safe from garbage collection while
+// the listing or message that will reference them is still being written.
+const DefaultUploadGracePeriod = 7 * 24 * time.Hour
+
+type UploadGCOptions struct {
+	GracePeriod time.Duration
+	DryRun      bool
+}
+
+type OrphanedUpload struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+type UploadGCReport struct {
+	DryRun      bool             `json:"dry_run"`
+	GracePeriod string           `json:"grace_period"`
+	Scanned     int              `json:"scanned"`
+	Referenced  int              `json:"referenced"`
+	TooRecent   int              `json:"too_recent"`
+	Orphans     []OrphanedUpload `json:"orphans"`
+	Deleted     int              `json:"deleted"`
+	FreedBytes  int64            `json:"freed_bytes"`
+	Errors      []string         `json:"errors,omitempty"`
+}
+
+// CollectGarbage lists every stored upload and deletes the ones nothing
+// refers to any more: photos removed from a listing, drafts that were never
+// saved, files of deleted equipment. Files newer than the grace period are
+// left alone. With DryRun set it only reports what it would delete.
+func (s *UploadService) CollectGarbage(ctx context.Context, opts UploadGCOptions) (*UploadGCReport, error) {
+	if opts.GracePeriod <= 0 {
+		opts.GracePeriod = DefaultUploadGracePeriod
+	}
+
+	referenced, err := referencedUploadKeys(s.db)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &UploadGCReport{
+		DryRun:      opts.DryRun,
+		GracePeriod: opts.GracePeriod.String(),
+		Orphans:     []OrphanedUpload{},
+	}
+	cutoff := time.Now().Add(-opts.GracePeriod)
+
+	for _, prefix := range storage.Prefixes() {
+		err := s.backend.ListFiles(prefix, func(file storage.FileInfo) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+
+			report.Scanned++
+			switch {
+			case referenced[sourceKey(file.Key)]:
+				report.Referenced++
+				return nil
+			case file.LastModified.After(cutoff):
+				report.TooRecent++
+				return nil
+			}
+
+			report.Orphans = append(report.Orphans, OrphanedUpload{
+				Key:          file.Key,
+				Size:         file.Size,
+				LastModified: file.LastModified,
+			})
+			if opts.DryRun {
+				return nil
+			}
+
+			if err := s.backend.DeleteFile(file.Key); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", file.Key, err))
+				return nil
+			}
+			if err := s.db.Where("key = ?", file.Key).Delete(&models.Upload{}).Error; err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s: %v", file.Key, err))
+			}
+			report.Deleted++
+			report.FreedBytes += file.Size
+			return nil
+		})
+		if err != nil {
+			return report, fmt.Errorf("failed to list uploads: %w", err)
+		}
+	}
+
+	logging.FromContext(ctx).Info("collected orphaned uploads", "dry_run", opts.DryRun,
+		"scanned", report.Scanned, "deleted", report.Deleted, "freed_bytes", report.FreedBytes)
+
+	return report, nil
+}
+
+// sourceKey maps an image variant back to the key of the file it was
+// generated from; other keys map to themselves.
+func sourceKey(key string) string {
+	for _, spec := range imaging.Specs {
+		suffix := "_" + spec.Name + ".jpg"
+		if spec.Name != imaging.VariantFull && strings.HasSuffix(key, suffix) {
+			return strings.TrimSuffix(key, suffix) + ".jpg"
+		}
+	}
+	return key
+}
+
+// retainedUploadCategories are kept however old they are, because no record
+// refers to them by key: dispute evidence is read by admins straight from
+// its upload.
+var retainedUploadCategories = []string{UploadCategoryDisputeEvidence}
+
+// referencedUploadKeys collects the key of every upload that a job, equipment
+// listing, insurance submission or message attachment points at, plus the
+// uploads linked to a record or in a retained category. Soft-deleted rows
+// count too, so an admin restore gets its photos back.
+func referencedUploadKeys(db *gorm.DB) (map[string]bool, error) {
+	referenced := map[string]bool{}
+	add := func(refs ...string) {
+		for _, ref := range refs {
+			if key := storage.KeyFromURL(ref); key != "" {
+				referenced[key] = true
+			}
+		}
+	}
+
+	arrays := []struct {
+		model  interface{}
+		column string
+	}{
+		{&models.Equipment{}, "image_urls"},
+		{&models.Job{}, "completion_image_urls"},
+		{&models.Message{}, "attachments"},
+	}
+	for _, source := range arrays {
+		var values []models.StringArray
+		if err := db.Unscoped().Model(source.model).Where(source.column+" IS NOT NULL").Pluck(source.column, &values).Error; err != nil {
+			return nil, fmt.Errorf("failed to load %s: %w", source.column, err)
+		}
+		for _, urls := range values {
+			add(urls...)
+		}
+	}
+
+	var users []models.User
+	if err := db.Unscoped().Select("insurance_document_key", "insurance_document_url").
+		Where("insurance_document_key <> '' OR insurance_document_url <> ''").
+		Find(&users).Error; err != nil {
+		return nil, fmt.Errorf("failed to load insurance documents: %w", err)
+	}
+	for _, user := range users {
+		add(user.InsuranceDocumentKey, user.InsuranceDocumentURL)
+	}
+
+	var kept []string
+	if err := db.Model(&models.Upload{}).
+		Where("related_type <> '' OR category IN ?", retainedUploadCategories).
+		Pluck("key", &kept).Error; err != nil {
+		return nil, fmt.Errorf("failed to load linked uploads: %w", err)
+	}
+	add(kept...)
+
+	// Export bundles are deleted by their own task when they expire
+	var exports []string
+	if err := db.Model(&models.DataRequest{}).Where("file_key <> ''").Pluck("file_key", &exports).Error; err != nil {
+		return nil, fmt.Errorf("failed to load data exports: %w", err)
+	}
+	add(exports...)
+
+	return referenced, nil
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/testutils"
+	"mowsy-api/pkg/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadService_CollectGarbage(t *testing.T) {
+	service, db := setupUploadService(t)
+	defer testutils.CleanupTestDB(db)
+	backend := service.backend.(*storage.LocalBackend)
+
+	user := testutils.CreateTestUser(db)
+	old := time.Now().Add(-30 * 24 * time.Hour)
+
+	// age backdates a file and its variants past the grace period
+	age := func(key string) {
+		for _, k := range []string{key, variantKey(key, "thumbnail"), variantKey(key, "medium")} {
+			path, err := backend.Path(k)
+			require.NoError(t, err)
+			if _, err := os.Stat(path); err == nil {
+				require.NoError(t, os.Chtimes(path, old, old))
+			}
+		}
+	}
+	uploadImage := func(name string) *UploadImageResponse {
+		response, err := service.UploadFromReader(user.ID, bytes.NewReader(testutils.CreateTestImage(40, 30)), name, "image/png", "")
+		require.NoError(t, err)
+		return response
+	}
+
+	listed := uploadImage("listed.png")
+	age(listed.Key)
+	equipment := testutils.CreateTestEquipment(db, user.ID)
+	require.NoError(t, db.Model(equipment).Update("image_urls", models.StringArray{listed.URL}).Error)
+
+	orphan := uploadImage("replaced.png")
+	age(orphan.Key)
+
+	recent := uploadImage("draft.png")
+
+	attachment := uploadImage("attachment.png")
+	age(attachment.Key)
+	conversation := models.Conversation{
+		Type:              models.ConversationTypeEquipmentRental,
+		RelatedID:         1,
+		OwnerUserID:       user.ID,
+		ParticipantUserID: user.ID,
+	}
+	require.NoError(t, db.Create(&conversation).Error)
+	require.NoError(t, db.Create(&models.Message{
+		ConversationID: conversation.ID,
+		SenderUserID:   user.ID,
+		Body:           "photo",
+		Attachments:    models.StringArray{attachment.URL},
+	}).Error)
+
+	insurance, err := service.UploadFromReader(user.ID, strings.NewReader("%PDF-1.4"), "policy.pdf", "application/pdf", UploadCategoryInsurance)
+	require.NoError(t, err)
+	age(insurance.Key)
+	require.NoError(t, (&UserService{db: db}).AttachInsuranceDocument(user.ID, insurance.Key))
+
+	// Nothing points at dispute evidence by key, but it is still needed
+	evidence, err := service.UploadFromReader(user.ID, strings.NewReader("%PDF-1.4"), "claim.pdf", "application/pdf", UploadCategoryDisputeEvidence)
+	require.NoError(t, err)
+	age(evidence.Key)
+
+	// Linked to a job whose photo list no longer mentions it
+	linked := uploadImage("linked.png")
+	age(linked.Key)
+	job := testutils.CreateTestJob(db, user.ID)
+	require.NoError(t, db.Model(&models.Upload{}).Where("key = ?", linked.Key).
+		Updates(map[string]interface{}{"related_type": UploadRelatedJob, "related_id": job.ID}).Error)
+
+	orphanKeys := []string{orphan.Key, variantKey(orphan.Key, "medium"), variantKey(orphan.Key, "thumbnail")}
+	sort.Strings(orphanKeys)
+	reportedKeys := func(report *UploadGCReport) []string {
+		var keys []string
+		for _, o := range report.Orphans {
+			keys = append(keys, o.Key)
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	t.Run("DryRunOnlyReports", func(t *testing.T) {
+		report, err := service.CollectGarbage(context.Background(), UploadGCOptions{DryRun: true})
+		require.NoError(t, err)
+
+		assert.Equal(t, orphanKeys, reportedKeys(report))
+		assert.Equal(t, 0, report.Deleted)
+		assert.Equal(t, 3, report.TooRecent) // the draft and its two variants
+		_, err = service.backend.OpenFile(orphan.Key)
+		assert.NoError(t, err)
+	})
+
+	t.Run("DeletesOrphans", func(t *testing.T) {
+		report, err := service.CollectGarbage(context.Background(), UploadGCOptions{})
+		require.NoError(t, err)
+
+		assert.Equal(t, orphanKeys, reportedKeys(report))
+		assert.Equal(t, 3, report.Deleted)
+		assert.Greater(t, report.FreedBytes, int64(0))
+
+		_, err = service.backend.OpenFile(orphan.Key)
+		assert.ErrorIs(t, err, storage.ErrFileNotFound)
+		var count int64
+		db.Model(&models.Upload{}).Where("key = ?", orphan.Key).Count(&count)
+		assert.Equal(t, int64(0), count)
+
+		for _, key := range []string{listed.Key, variantKey(listed.Key, "thumbnail"), recent.Key, attachment.Key, insurance.Key, evidence.Key, linked.Key} {
+			_, err := service.backend.OpenFile(key)
+			assert.NoError(t, err, key)
+		}
+	})
+
+	t.Run("ShortGracePeriodIncludesRecentFiles", func(t *testing.T) {
+		report, err := service.CollectGarbage(context.Background(), UploadGCOptions{DryRun: true, GracePeriod: time.Nanosecond})
+		require.NoError(t, err)
+
+		assert.Contains(t, reportedKeys(report), recent.Key)
+	})
+}
@@ -1,27 +1,85 @@
 package services
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/tasks"
+	"mowsy-api/pkg/imaging"
 	"mowsy-api/pkg/storage"
+
+	"gorm.io/gorm"
 )
 
-type UploadService struct {
-	s3Service *storage.S3Service
+const (
+	UploadCategoryListingPhoto      = "listing_photo"
+	UploadCategoryProfilePhoto      = "profile_photo"
+	UploadCategoryMessageAttachment = "message_attachment"
+	UploadCategoryInsurance         = "insurance"
+	UploadCategoryDisputeEvidence   = "dispute_evidence"
+)
+
+// uploadCategoryVisibility decides who can read each kind of upload. Photos
+// shown on listings and profiles are public; message attachments and
+// documents with policy numbers, addresses or dispute details are only
+// readable through short-lived presigned URLs handed to the owner, the
+// other side of the thread and admins.
+var uploadCategoryVisibility = map[string]storage.Visibility{
+	UploadCategoryListingPhoto:      storage.VisibilityPublic,
+	UploadCategoryProfilePhoto:      storage.VisibilityPublic,
+	UploadCategoryMessageAttachment: storage.VisibilityPrivate,
+	UploadCategoryInsurance:         storage.VisibilityPrivate,
+	UploadCategoryDisputeEvidence:   storage.VisibilityPrivate,
 }
 
-func NewUploadService() (*UploadService, error) {
-	s3Service, err := storage.NewS3Service()
-	if err != nil {
-		return nil, fmt.Errorf("failed to initialize S3 service: %w", err)
-	}
+// privateFileURLExpiry is how long a presigned GET for a private file lasts.
+const privateFileURLExpiry = 15 * time.Minute
+
+const maxUploadSize = 10 * 1024 * 1024
 
+// processUploadDelay gives a client time to PUT to a presigned URL before
+// the first attempt to process what it uploaded.
+const processUploadDelay = time.Minute
+
+// Values of models.Upload.RelatedType.
+const (
+	UploadRelatedJob       = "job"
+	UploadRelatedEquipment = "equipment"
+	UploadRelatedInsurance = "insurance"
+)
+
+var (
+	ErrInvalidUploadCategory = errors.New("invalid upload category")
+	ErrFileAccessDenied      = errors.New("you do not have access to this file")
+	ErrFileInUse             = errors.New("file is still in use")
+	ErrFileNotFound          = errors.New("file not found")
+	ErrUnsupportedFile       = errors.New("unsupported file type: only images and PDFs are allowed")
+	ErrFileTooLarge          = errors.New("file size exceeds 10MB limit")
+	ErrUploadNotOwned        = errors.New("uploaded files must belong to you")
+)
+
+type UploadService struct {
+	db      *gorm.DB
+	backend storage.Backend
+	now     func() time.Time
+}
+
+func NewUploadServiceWithBackend(db *gorm.DB, backend storage.Backend) *UploadService {
 	return &UploadService{
-		s3Service: s3Service,
-	}, nil
+		db:      db,
+		backend: backend,
+		now:     time.Now,
+	}
 }
 
 type UploadImageRequest struct {
@@ -30,15 +88,36 @@ type UploadImageRequest struct {
 }
 
 type UploadImageResponse struct {
-	URL      string `json:"url"`
-	Key      string `json:"key"`
-	Size     int64  `json:"size"`
-	MimeType string `json:"mime_type"`
+	URL        string `json:"url"`
+	Key        string `json:"key"`
+	Size       int64  `json:"size"`
+	MimeType   string `json:"mime_type"`
+	Visibility string `json:"visibility"`
+
+	// Set for images only. Variants maps imaging.Variant* names to URLs; the
+	// full variant is also returned as URL.
+	Variants map[string]string `json:"variants,omitempty"`
+	Blurhash string            `json:"blurhash,omitempty"`
+	Width    int               `json:"width,omitempty"`
+	Height   int               `json:"height,omitempty"`
+}
+
+// categoryVisibility resolves an upload category, defaulting to a public
+// listing photo when none is given.
+func categoryVisibility(category string) (storage.Visibility, error) {
+	if category == "" {
+		category = UploadCategoryListingPhoto
+	}
+	visibility, ok := uploadCategoryVisibility[category]
+	if !ok {
+		return "", ErrInvalidUploadCategory
+	}
+	return visibility, nil
 }
 
 func (s *UploadService) UploadImage(userID uint, file *multipart.FileHeader, category string) (*UploadImageResponse, error) {
 	if file.Size > 10*1024*1024 { // 10MB limit
-		return nil, fmt.Errorf("file size exceeds 10MB limit")
+		return nil, ErrFileTooLarge
 	}
 
 	src, err := file.Open()
@@ -47,46 +126,463 @@ func (s *UploadService) UploadImage(userID uint, file *multipart.FileHeader, cat
 	}
 	defer src.Close()
 
-	mimeType := file.Header.Get("Content-Type")
-	if mimeType == "" {
-		mimeType = "application/octet-stream"
+	return s.UploadFromReader(userID, src, file.Filename, file.Header.Get("Content-Type"), category)
+}
+
+// UploadFromReader stores a file after checking what it really is. The
+// client's mimeType is ignored in favour of the sniffed type: images are
+// re-encoded into stripped, resized variants and PDFs are stored as-is.
+func (s *UploadService) UploadFromReader(userID uint, reader io.Reader, fileName, mimeType, category string) (*UploadImageResponse, error) {
+	visibility, err := categoryVisibility(category)
+	if err != nil {
+		return nil, err
 	}
 
-	result, err := s.s3Service.UploadFile(src, file.Filename, mimeType, userID)
+	data, err := io.ReadAll(io.LimitReader(reader, maxUploadSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if len(data) > maxUploadSize {
+		return nil, ErrFileTooLarge
+	}
+
+	mimeType = imaging.DetectContentType(data)
+	var processed *imaging.Result
+	switch {
+	case imaging.IsImage(mimeType):
+		processed, err = imaging.Process(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		full := processed.Variant(imaging.VariantFull)
+		data, mimeType = full.Data, full.MimeType
+		fileName = strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ".jpg"
+	case mimeType == "application/pdf":
+		if !strings.EqualFold(filepath.Ext(fileName), ".pdf") {
+			fileName += ".pdf"
+		}
+	default:
+		return nil, ErrUnsupportedFile
+	}
+
+	result, err := s.backend.UploadFile(bytes.NewReader(data), fileName, mimeType, userID, visibility)
 	if err != nil {
 		return nil, fmt.Errorf("failed to upload file: %w", err)
 	}
 
-	return &UploadImageResponse{
-		URL:      result.URL,
-		Key:      result.Key,
-		Size:     file.Size,
-		MimeType: mimeType,
-	}, nil
+	upload := models.Upload{
+		Key:        result.Key,
+		UserID:     userID,
+		Size:       int64(len(data)),
+		MimeType:   mimeType,
+		Category:   category,
+		Visibility: string(visibility),
+	}
+	if processed != nil {
+		if err := s.storeVariants(result.Key, processed); err != nil {
+			s.deleteStoredFile(result.Key)
+			return nil, err
+		}
+		applyImageResult(&upload, processed)
+	} else {
+		// A PDF has been checked by the sniffing above
+		now := time.Now()
+		upload.ProcessedAt = &now
+	}
+
+	if err := createUploadRecord(s.db, &upload); err != nil {
+		// Without a record nobody could manage the file, so don't keep it
+		s.deleteStoredFile(result.Key)
+		return nil, err
+	}
+
+	return s.uploadResponse(&upload)
+}
+
+// storeVariants writes every variant except full, which lives at key itself.
+func (s *UploadService) storeVariants(key string, processed *imaging.Result) error {
+	for _, variant := range processed.Variants {
+		if variant.Name == imaging.VariantFull {
+			continue
+		}
+		if _, err := s.backend.PutFile(variantKey(key, variant.Name), bytes.NewReader(variant.Data), variant.MimeType); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyImageResult(upload *models.Upload, processed *imaging.Result) {
+	now := time.Now()
+	upload.Blurhash = processed.Blurhash
+	upload.Width = processed.Width
+	upload.Height = processed.Height
+	upload.ProcessedAt = &now
+}
+
+// variantKey derives where a resized variant of key is stored, e.g.
+// "uploads/1/123_lawn.jpg" -> "uploads/1/123_lawn_thumbnail.jpg".
+func variantKey(key, variant string) string {
+	if variant == imaging.VariantFull {
+		return key
+	}
+	return strings.TrimSuffix(key, filepath.Ext(key)) + "_" + variant + ".jpg"
 }
 
-func (s *UploadService) UploadFromReader(userID uint, reader io.Reader, fileName, mimeType string) (*UploadImageResponse, error) {
-	result, err := s.s3Service.UploadFile(reader, fileName, mimeType, userID)
+// deleteStoredFile removes a file and any variants generated from it.
+func (s *UploadService) deleteStoredFile(key string) error {
+	for _, spec := range imaging.Specs {
+		if spec.Name == imaging.VariantFull {
+			continue
+		}
+		if err := s.backend.DeleteFile(variantKey(key, spec.Name)); err != nil {
+			return err
+		}
+	}
+	return s.backend.DeleteFile(key)
+}
+
+// fileURL returns a URL the uploader can open right away. Private objects
+// are not readable at their stored location, so they get a presigned URL.
+func (s *UploadService) fileURL(key, visibility string) (string, error) {
+	if visibility == string(storage.VisibilityPrivate) {
+		return s.backend.GetPresignedURL(key, privateFileURLExpiry)
+	}
+	return s.backend.FileURL(key), nil
+}
+
+func (s *UploadService) uploadResponse(upload *models.Upload) (*UploadImageResponse, error) {
+	url, err := s.fileURL(upload.Key, upload.Visibility)
 	if err != nil {
-		return nil, fmt.Errorf("failed to upload file: %w", err)
+		return nil, err
+	}
+
+	response := &UploadImageResponse{
+		URL:        url,
+		Key:        upload.Key,
+		Size:       upload.Size,
+		MimeType:   upload.MimeType,
+		Visibility: upload.Visibility,
+		Blurhash:   upload.Blurhash,
+		Width:      upload.Width,
+		Height:     upload.Height,
+	}
+
+	if upload.ProcessedAt != nil && upload.Blurhash != "" {
+		response.Variants = map[string]string{}
+		for _, spec := range imaging.Specs {
+			variantURL, err := s.fileURL(variantKey(upload.Key, spec.Name), upload.Visibility)
+			if err != nil {
+				return nil, err
+			}
+			response.Variants[spec.Name] = variantURL
+		}
+	}
+
+	return response, nil
+}
+
+func createUploadRecord(db *gorm.DB, upload *models.Upload) error {
+	if upload.Category == "" {
+		upload.Category = UploadCategoryListingPhoto
+	}
+
+	if err := db.Create(upload).Error; err != nil {
+		return fmt.Errorf("failed to record upload: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteFile removes a file on behalf of its owner or an admin. Files still
+// used by a job, equipment listing or insurance submission are kept.
+func (s *UploadService) DeleteFile(key string, userID uint, isAdmin bool) error {
+	var upload models.Upload
+	err := s.db.Where("key = ?", key).First(&upload).Error
+	switch {
+	case err == nil:
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		// Files uploaded before uploads were recorded only carry their owner
+		// in the key
+		owner, ok := storage.KeyOwner(key)
+		if !ok {
+			return ErrFileNotFound
+		}
+		upload.UserID = owner
+	default:
+		return fmt.Errorf("failed to fetch upload: %w", err)
+	}
+
+	if !isAdmin && upload.UserID != userID {
+		return ErrFileAccessDenied
+	}
+
+	inUse, err := fileInUse(s.db, key)
+	if err != nil {
+		return err
+	}
+	if inUse {
+		return ErrFileInUse
+	}
+
+	if err := s.deleteStoredFile(key); err != nil {
+		return err
+	}
+
+	if upload.ID != 0 {
+		if err := s.db.Delete(&upload).Error; err != nil {
+			return fmt.Errorf("failed to delete upload record: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// fileInUse reports whether any job, equipment listing, insurance submission
+// or message still refers to the file behind key. References are stored as
+// URLs and may point at any variant of an image, so this matches every
+// variant of the source key anywhere in the stored value.
+func fileInUse(db *gorm.DB, key string) (bool, error) {
+	key = sourceKey(key)
+
+	var keys, patterns []interface{}
+	for _, spec := range imaging.Specs {
+		variant := variantKey(key, spec.Name)
+		keys = append(keys, variant)
+		patterns = append(patterns, "%"+escapeLike(variant)+"%")
+	}
+
+	matchAny := func(column string) string {
+		conditions := make([]string, len(patterns))
+		for i := range patterns {
+			conditions[i] = column + ` LIKE ? ESCAPE '\'`
+		}
+		return strings.Join(conditions, " OR ")
+	}
+
+	checks := []struct {
+		model interface{}
+		query string
+		args  []interface{}
+	}{
+		{&models.Equipment{}, matchAny("CAST(image_urls AS TEXT)"), patterns},
+		{&models.Job{}, matchAny("CAST(completion_image_urls AS TEXT)"), patterns},
+		{&models.Message{}, matchAny("CAST(attachments AS TEXT)"), patterns},
+		{&models.User{}, "insurance_document_key IN ? OR " + matchAny("insurance_document_url"), append([]interface{}{keys}, patterns...)},
+	}
+
+	for _, check := range checks {
+		var count int64
+		// Deleted jobs, listings and users can still be restored
+		if err := db.Unscoped().Model(check.model).Where(check.query, check.args...).Count(&count).Error; err != nil {
+			return false, fmt.Errorf("failed to check file references: %w", err)
+		}
+		if count > 0 {
+			return true, nil
+		}
 	}
 
-	return &UploadImageResponse{
-		URL:      result.URL,
-		Key:      result.Key,
-		MimeType: mimeType,
-	}, nil
+	return false, nil
 }
 
-func (s *UploadService) DeleteFile(key string) error {
-	return s.s3Service.DeleteFile(key)
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
 }
 
-func (s *UploadService) GetPresignedURL(key string) (string, error) {
-	return s.s3Service.GetPresignedURL(key, 1*time.Hour)
+// linkUploads marks userID's uploads behind refs (URLs or keys) as belonging
+// to the given record. Refs that are not uploads, such as external URLs, are
+// ignored; a ref to another user's upload is refused.
+func linkUploads(db *gorm.DB, userID uint, relatedType string, relatedID uint, refs ...string) error {
+	var keys []string
+	for _, ref := range refs {
+		key := storage.KeyFromURL(ref)
+		owner, ok := storage.KeyOwner(key)
+		if !ok {
+			continue
+		}
+		if owner != userID {
+			return ErrUploadNotOwned
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	if err := db.Model(&models.Upload{}).Where("key IN ? AND user_id = ?", keys, userID).Updates(map[string]interface{}{
+		"related_type": relatedType,
+		"related_id":   relatedID,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to link uploads: %w", err)
+	}
+
+	return nil
 }
 
-func (s *UploadService) GetPresignedUploadURL(userID uint, fileName, mimeType string) (string, error) {
-	key := fmt.Sprintf("uploads/%d/%d_%s", userID, time.Now().Unix(), fileName)
-	return s.s3Service.GetPresignedUploadURL(key, mimeType, 1*time.Hour)
-}
\ No newline at end of file
+// GetFileURL returns a short-lived URL for reading key. Private files are
+// only available to the user who uploaded them and to admins.
+func (s *UploadService) GetFileURL(key string, userID uint, isAdmin bool) (string, error) {
+	if storage.KeyVisibility(key) == storage.VisibilityPrivate && !isAdmin {
+		owner, ok := storage.KeyOwner(key)
+		if !ok || owner != userID {
+			return "", ErrFileAccessDenied
+		}
+	}
+
+	return s.backend.GetPresignedURL(key, privateFileURLExpiry)
+}
+
+// PresignedUploadResponse tells the client where to PUT its file and the
+// key to reference once it has been processed.
+type PresignedUploadResponse struct {
+	UploadURL string `json:"upload_url"`
+	Key       string `json:"key"`
+}
+
+// GetPresignedUploadURL lets a client upload straight to storage. The
+// upload is recorded up front so the caller owns the key, and a task
+// processes the file once it has landed, as UploadFromReader does inline.
+// The client uploads to a private staging key; only the processed file is
+// written to Key, so nothing unchecked is ever served from it.
+func (s *UploadService) GetPresignedUploadURL(userID uint, fileName, mimeType, category string) (*PresignedUploadResponse, error) {
+	if category == "" {
+		category = UploadCategoryListingPhoto
+	}
+	visibility, err := categoryVisibility(category)
+	if err != nil {
+		return nil, err
+	}
+	if !imaging.IsImage(mimeType) && mimeType != "application/pdf" {
+		return nil, ErrUnsupportedFile
+	}
+
+	key := directUploadKey(userID, visibility, fileName, mimeType)
+	upload := models.Upload{
+		Key:        key,
+		StagingKey: storage.KeyPrefix(storage.VisibilityPrivate, userID) + "staging_" + path.Base(key),
+		UserID:     userID,
+		MimeType:   mimeType,
+		Category:   category,
+		Visibility: string(visibility),
+	}
+	uploadURL, err := s.backend.GetPresignedUploadURL(upload.StagingKey, mimeType, 0, 1*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := createUploadRecord(tx, &upload); err != nil {
+			return err
+		}
+		return tasks.Enqueue(tx, TaskProcessUpload, recordTaskPayload{ID: upload.ID},
+			tasks.RunAt(time.Now().Add(processUploadDelay)))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &PresignedUploadResponse{UploadURL: uploadURL, Key: key}, nil
+}
+
+// directUploadKey picks the key a client uploads to directly. Only the base
+// of the client's file name is kept, escaped, and the extension follows the
+// type: images are re-encoded as JPEG once processed, so they get .jpg up
+// front.
+func directUploadKey(userID uint, visibility storage.Visibility, fileName, mimeType string) string {
+	baseName := path.Base(strings.ReplaceAll(fileName, "\\", "/"))
+	baseName = url.QueryEscape(strings.TrimSuffix(baseName, path.Ext(baseName)))
+	ext := ".pdf"
+	if imaging.IsImage(mimeType) {
+		ext = ".jpg"
+	}
+	return fmt.Sprintf("%s%d_%s%s", storage.KeyPrefix(visibility, userID), time.Now().UnixNano(), baseName, ext)
+}
+
+// processUploadTask checks and processes a file uploaded to a presigned URL.
+// Until the client has uploaded, the file is missing and the task is retried;
+// anything that is neither an image nor a PDF is deleted. A staged file is
+// published to the upload's key and the staging copy removed.
+func (s *UploadService) processUploadTask(ctx context.Context, task *models.Task) error {
+	var upload models.Upload
+	if found, err := loadTaskRecord(s.db, task, &upload); !found || err != nil {
+		return err
+	}
+	if upload.ProcessedAt != nil {
+		return nil
+	}
+
+	source := upload.Key
+	if upload.StagingKey != "" {
+		source = upload.StagingKey
+	}
+
+	file, err := s.backend.OpenFile(source)
+	if errors.Is(err, storage.ErrFileNotFound) {
+		return fmt.Errorf("upload %s has not been received yet", upload.Key)
+	}
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(file, maxUploadSize+1))
+	file.Close()
+	if err != nil {
+		return fmt.Errorf("failed to read upload: %w", err)
+	}
+
+	reject := func(reason error) error {
+		if err := s.deleteStoredFile(source); err != nil {
+			return err
+		}
+		if err := s.db.Delete(&upload).Error; err != nil {
+			return fmt.Errorf("failed to delete upload record: %w", err)
+		}
+		return tasks.Permanent(reason)
+	}
+
+	if len(data) > maxUploadSize {
+		return reject(ErrFileTooLarge)
+	}
+
+	mimeType := imaging.DetectContentType(data)
+	switch {
+	case imaging.IsImage(mimeType):
+		processed, err := imaging.Process(bytes.NewReader(data))
+		if err != nil {
+			return reject(err)
+		}
+		full := processed.Variant(imaging.VariantFull)
+		if _, err := s.backend.PutFile(upload.Key, bytes.NewReader(full.Data), full.MimeType); err != nil {
+			return err
+		}
+		if err := s.storeVariants(upload.Key, processed); err != nil {
+			return err
+		}
+		applyImageResult(&upload, processed)
+		upload.MimeType = full.MimeType
+		upload.Size = int64(len(full.Data))
+	case mimeType == "application/pdf":
+		if source != upload.Key {
+			if _, err := s.backend.PutFile(upload.Key, bytes.NewReader(data), mimeType); err != nil {
+				return err
+			}
+		}
+		now := time.Now()
+		upload.ProcessedAt = &now
+		upload.MimeType = mimeType
+		upload.Size = int64(len(data))
+	default:
+		return reject(ErrUnsupportedFile)
+	}
+
+	if source != upload.Key {
+		if err := s.backend.DeleteFile(source); err != nil {
+			return err
+		}
+		upload.StagingKey = ""
+	}
+	if err := s.db.Save(&upload).Error; err != nil {
+		return fmt.Errorf("failed to update upload: %w", err)
+	}
+
+	return nil
+}
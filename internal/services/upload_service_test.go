@@ -0,0 +1,423 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/tasks"
+	"mowsy-api/internal/testutils"
+	"mowsy-api/pkg/imaging"
+	"mowsy-api/pkg/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupUploadService(t *testing.T) (*UploadService, *gorm.DB) {
+	db := testutils.SetupTestDB()
+	backend, err := storage.NewLocalBackend(t.TempDir(), "http://localhost:8080/files", []byte("test-signing-key"))
+	require.NoError(t, err)
+	return NewUploadServiceWithBackend(db, backend), db
+}
+
+func TestUploadService_Visibility(t *testing.T) {
+	service, db := setupUploadService(t)
+	defer testutils.CleanupTestDB(db)
+
+	t.Run("ListingPhotoIsPublic", func(t *testing.T) {
+		response, err := service.UploadFromReader(1, bytes.NewReader(testutils.CreateTestImage(40, 30)), "lawn.jpg", "image/jpeg", "")
+
+		require.NoError(t, err)
+		assert.Equal(t, "public", response.Visibility)
+		assert.True(t, strings.HasPrefix(response.Key, "uploads/1/"))
+		assert.NotContains(t, response.URL, "signature=")
+
+		var upload models.Upload
+		require.NoError(t, db.Where("key = ?", response.Key).First(&upload).Error)
+		assert.Equal(t, uint(1), upload.UserID)
+		assert.Equal(t, UploadCategoryListingPhoto, upload.Category)
+		assert.Equal(t, response.Size, upload.Size)
+		assert.NotNil(t, upload.ProcessedAt)
+	})
+
+	t.Run("InsuranceIsPrivate", func(t *testing.T) {
+		response, err := service.UploadFromReader(1, strings.NewReader("%PDF-1.4"), "policy.pdf", "application/pdf", UploadCategoryInsurance)
+
+		require.NoError(t, err)
+		assert.Equal(t, "private", response.Visibility)
+		assert.True(t, strings.HasPrefix(response.Key, "private/1/"))
+		assert.Contains(t, response.URL, "signature=")
+	})
+
+	t.Run("UnknownCategory", func(t *testing.T) {
+		_, err := service.UploadFromReader(1, bytes.NewReader(testutils.CreateTestImage(40, 30)), "lawn.jpg", "image/jpeg", "selfie")
+
+		assert.ErrorIs(t, err, ErrInvalidUploadCategory)
+	})
+
+	t.Run("PresignedUploadUsesCategoryPrefix", func(t *testing.T) {
+		presigned, err := service.GetPresignedUploadURL(1, "claim.jpg", "image/jpeg", UploadCategoryDisputeEvidence)
+		require.NoError(t, err)
+
+		assert.True(t, strings.HasPrefix(presigned.Key, "private/1/"))
+	})
+
+	t.Run("PresignedUploadIsStagedPrivately", func(t *testing.T) {
+		presigned, err := service.GetPresignedUploadURL(1, "lawn.jpg", "image/jpeg", UploadCategoryListingPhoto)
+		require.NoError(t, err)
+
+		assert.True(t, strings.HasPrefix(presigned.Key, "uploads/1/"))
+		u, err := url.Parse(presigned.UploadURL)
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(u.Path, "/files/private/1/staging_"))
+	})
+
+	t.Run("PresignedUploadRefusesOtherTypes", func(t *testing.T) {
+		_, err := service.GetPresignedUploadURL(1, "index.html", "text/html", UploadCategoryListingPhoto)
+
+		assert.ErrorIs(t, err, ErrUnsupportedFile)
+		var count int64
+		db.Model(&models.Upload{}).Where("mime_type = ?", "text/html").Count(&count)
+		assert.Equal(t, int64(0), count)
+	})
+
+	t.Run("PresignedUploadKeepsBaseName", func(t *testing.T) {
+		presigned, err := service.GetPresignedUploadURL(1, "../../2/evil name.png", "image/png", UploadCategoryListingPhoto)
+		require.NoError(t, err)
+
+		assert.True(t, strings.HasPrefix(presigned.Key, "uploads/1/"))
+		assert.True(t, strings.HasSuffix(presigned.Key, "_evil+name.jpg"))
+		assert.NotContains(t, strings.TrimPrefix(presigned.Key, "uploads/1/"), "/")
+	})
+}
+
+func TestUploadService_GetFileURL(t *testing.T) {
+	service, db := setupUploadService(t)
+	defer testutils.CleanupTestDB(db)
+
+	t.Run("OwnerCanReadPrivateFile", func(t *testing.T) {
+		_, err := service.GetFileURL("private/1/123_policy.pdf", 1, false)
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("OtherUserIsRefused", func(t *testing.T) {
+		_, err := service.GetFileURL("private/1/123_policy.pdf", 2, false)
+
+		assert.ErrorIs(t, err, ErrFileAccessDenied)
+	})
+
+	t.Run("AdminCanReadPrivateFile", func(t *testing.T) {
+		_, err := service.GetFileURL("private/1/123_policy.pdf", 0, true)
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("AnyoneCanReadPublicFile", func(t *testing.T) {
+		_, err := service.GetFileURL("uploads/1/123_lawn.jpg", 2, false)
+
+		assert.NoError(t, err)
+	})
+}
+
+func TestUploadService_DeleteFile(t *testing.T) {
+	service, db := setupUploadService(t)
+	defer testutils.CleanupTestDB(db)
+
+	owner := testutils.CreateTestUser(db)
+	upload := func(name string) *UploadImageResponse {
+		response, err := service.UploadFromReader(owner.ID, bytes.NewReader(testutils.CreateTestImage(40, 30)), name, "image/jpeg", UploadCategoryListingPhoto)
+		require.NoError(t, err)
+		return response
+	}
+
+	t.Run("OwnerCanDelete", func(t *testing.T) {
+		response := upload("owner.jpg")
+
+		require.NoError(t, service.DeleteFile(response.Key, owner.ID, false))
+
+		var count int64
+		db.Model(&models.Upload{}).Where("key = ?", response.Key).Count(&count)
+		assert.Equal(t, int64(0), count)
+	})
+
+	t.Run("OtherUserIsRefused", func(t *testing.T) {
+		response := upload("other.jpg")
+
+		err := service.DeleteFile(response.Key, owner.ID+1, false)
+
+		assert.ErrorIs(t, err, ErrFileAccessDenied)
+	})
+
+	t.Run("AdminCanDelete", func(t *testing.T) {
+		response := upload("admin.jpg")
+
+		assert.NoError(t, service.DeleteFile(response.Key, 0, true))
+	})
+
+	t.Run("RefusedWhileEquipmentUsesFile", func(t *testing.T) {
+		response := upload("mower.jpg")
+		equipmentService := &EquipmentService{db: db}
+		equipment := testutils.CreateTestEquipment(db, owner.ID)
+		_, err := equipmentService.UpdateEquipment(equipment.ID, owner.ID, UpdateEquipmentRequest{ImageUrls: []string{response.URL}})
+		require.NoError(t, err)
+
+		var record models.Upload
+		require.NoError(t, db.Where("key = ?", response.Key).First(&record).Error)
+		assert.Equal(t, UploadRelatedEquipment, record.RelatedType)
+		require.NotNil(t, record.RelatedID)
+		assert.Equal(t, equipment.ID, *record.RelatedID)
+
+		err = service.DeleteFile(response.Key, owner.ID, false)
+		assert.ErrorIs(t, err, ErrFileInUse)
+
+		// Removing the photo from the listing frees the file
+		_, err = equipmentService.UpdateEquipment(equipment.ID, owner.ID, UpdateEquipmentRequest{ImageUrls: []string{}})
+		require.NoError(t, err)
+		assert.NoError(t, service.DeleteFile(response.Key, owner.ID, false))
+	})
+
+	t.Run("RefusedWhileInsuranceUsesFile", func(t *testing.T) {
+		response, err := service.UploadFromReader(owner.ID, strings.NewReader("%PDF-1.4"), "policy.pdf", "application/pdf", UploadCategoryInsurance)
+		require.NoError(t, err)
+		require.NoError(t, (&UserService{db: db}).AttachInsuranceDocument(owner.ID, response.Key))
+
+		err = service.DeleteFile(response.Key, 0, true)
+
+		assert.ErrorIs(t, err, ErrFileInUse)
+	})
+
+	t.Run("RefusedWhileListingUsesAVariant", func(t *testing.T) {
+		response := upload("edger.jpg")
+		equipment := testutils.CreateTestEquipment(db, owner.ID)
+		require.NoError(t, db.Model(equipment).Update("image_urls", models.StringArray{response.Variants[imaging.VariantThumbnail]}).Error)
+
+		err := service.DeleteFile(response.Key, owner.ID, false)
+		assert.ErrorIs(t, err, ErrFileInUse)
+
+		err = service.DeleteFile(variantKey(response.Key, imaging.VariantMedium), owner.ID, false)
+		assert.ErrorIs(t, err, ErrFileInUse, "deleting a variant deletes its siblings too")
+	})
+
+	t.Run("RefusedWhileMessageUsesFile", func(t *testing.T) {
+		response, err := service.UploadFromReader(owner.ID, bytes.NewReader(testutils.CreateTestImage(40, 30)), "scratch.jpg", "image/jpeg", UploadCategoryMessageAttachment)
+		require.NoError(t, err)
+		require.NoError(t, db.Create(&models.Message{ConversationID: 1, SenderUserID: owner.ID, Attachments: models.StringArray{response.Key}}).Error)
+
+		err = service.DeleteFile(response.Key, owner.ID, false)
+
+		assert.ErrorIs(t, err, ErrFileInUse)
+	})
+
+	t.Run("UnknownKey", func(t *testing.T) {
+		err := service.DeleteFile("not-a-key", owner.ID, false)
+
+		assert.ErrorIs(t, err, ErrFileNotFound)
+	})
+}
+
+func TestUploadService_ImagePipeline(t *testing.T) {
+	service, db := setupUploadService(t)
+	defer testutils.CleanupTestDB(db)
+
+	readStored := func(key string) []byte {
+		file, err := service.backend.OpenFile(key)
+		require.NoError(t, err)
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		require.NoError(t, err)
+		return data
+	}
+
+	t.Run("ImagesAreReencodedWithVariants", func(t *testing.T) {
+		response, err := service.UploadFromReader(1, bytes.NewReader(testutils.CreateTestImage(1600, 1200)), "yard.png", "image/png", "")
+		require.NoError(t, err)
+
+		assert.Equal(t, "image/jpeg", response.MimeType)
+		assert.True(t, strings.HasSuffix(response.Key, "_yard.jpg"))
+		assert.Equal(t, 1600, response.Width)
+		assert.Len(t, response.Blurhash, 28)
+		assert.Equal(t, response.URL, response.Variants["full"])
+		assert.Contains(t, response.Variants["thumbnail"], "_yard_thumbnail.jpg")
+
+		assert.Equal(t, []byte{0xFF, 0xD8}, readStored(response.Key)[:2])
+		assert.Equal(t, []byte{0xFF, 0xD8}, readStored(variantKey(response.Key, "medium"))[:2])
+	})
+
+	t.Run("ClientContentTypeIsNotTrusted", func(t *testing.T) {
+		_, err := service.UploadFromReader(1, strings.NewReader("<html><script>alert(1)</script></html>"), "x.png", "image/png", "")
+
+		assert.ErrorIs(t, err, ErrUnsupportedFile)
+	})
+
+	t.Run("PDFsAreStoredAsIs", func(t *testing.T) {
+		response, err := service.UploadFromReader(1, strings.NewReader("%PDF-1.4 policy"), "policy.pdf", "image/jpeg", UploadCategoryInsurance)
+		require.NoError(t, err)
+
+		assert.Equal(t, "application/pdf", response.MimeType)
+		assert.Empty(t, response.Variants)
+		assert.Equal(t, "%PDF-1.4 policy", string(readStored(response.Key)))
+	})
+
+	t.Run("DeleteRemovesVariants", func(t *testing.T) {
+		response, err := service.UploadFromReader(1, bytes.NewReader(testutils.CreateTestImage(40, 30)), "gone.png", "image/png", "")
+		require.NoError(t, err)
+
+		require.NoError(t, service.DeleteFile(response.Key, 1, false))
+
+		_, err = service.backend.OpenFile(variantKey(response.Key, "thumbnail"))
+		assert.ErrorIs(t, err, storage.ErrFileNotFound)
+	})
+}
+
+func TestUploadService_LinkOnlyOwnUploads(t *testing.T) {
+	service, db := setupUploadService(t)
+	defer testutils.CleanupTestDB(db)
+
+	owner := testutils.CreateTestUser(db)
+	intruder := &models.User{Email: "intruder@example.com", FirstName: "Other", LastName: "User", IsActive: true}
+	require.NoError(t, db.Create(intruder).Error)
+	users := &UserService{db: db}
+	equipmentService := &EquipmentService{db: db, users: users}
+	jobService := &JobService{db: db, users: users}
+
+	ownerUpload := func(t *testing.T, name string) *UploadImageResponse {
+		response, err := service.UploadFromReader(owner.ID, bytes.NewReader(testutils.CreateTestImage(40, 30)), name, "image/jpeg", UploadCategoryListingPhoto)
+		require.NoError(t, err)
+		return response
+	}
+	assertUnlinked := func(t *testing.T, key string) {
+		var record models.Upload
+		require.NoError(t, db.Where("key = ?", key).First(&record).Error)
+		assert.Empty(t, record.RelatedType)
+		assert.Nil(t, record.RelatedID)
+	}
+
+	t.Run("CreateEquipment", func(t *testing.T) {
+		response := ownerUpload(t, "create.jpg")
+
+		_, err := equipmentService.CreateEquipment(intruder.ID, CreateEquipmentRequest{
+			Name:             "Borrowed Mower",
+			Category:         models.EquipmentCategoryMower,
+			DailyRentalPrice: 20,
+			ImageUrls:        []string{response.URL},
+		})
+
+		assert.ErrorIs(t, err, ErrUploadNotOwned)
+		assertUnlinked(t, response.Key)
+		var count int64
+		db.Model(&models.Equipment{}).Where("user_id = ?", intruder.ID).Count(&count)
+		assert.Equal(t, int64(0), count)
+	})
+
+	t.Run("UpdateEquipment", func(t *testing.T) {
+		response := ownerUpload(t, "update.jpg")
+		equipment := testutils.CreateTestEquipment(db, intruder.ID)
+
+		_, err := equipmentService.UpdateEquipment(equipment.ID, intruder.ID, UpdateEquipmentRequest{ImageUrls: []string{response.Key}})
+
+		assert.ErrorIs(t, err, ErrUploadNotOwned)
+		assertUnlinked(t, response.Key)
+	})
+
+	t.Run("CompleteJob", func(t *testing.T) {
+		response := ownerUpload(t, "complete.jpg")
+		job := testutils.CreateTestJob(db, intruder.ID)
+		require.NoError(t, db.Model(job).Update("status", models.JobStatusInProgress).Error)
+
+		err := jobService.CompleteJob(job.ID, intruder.ID, []string{response.URL})
+
+		assert.ErrorIs(t, err, ErrUploadNotOwned)
+		assertUnlinked(t, response.Key)
+		var unchanged models.Job
+		require.NoError(t, db.First(&unchanged, job.ID).Error)
+		assert.Equal(t, models.JobStatusInProgress, unchanged.Status)
+	})
+}
+
+func TestUploadService_ProcessPresignedUpload(t *testing.T) {
+	service, db := setupUploadService(t)
+	defer testutils.CleanupTestDB(db)
+
+	presign := func(fileName, mimeType string) (models.Upload, *models.Task) {
+		_, err := service.GetPresignedUploadURL(1, fileName, mimeType, "")
+		require.NoError(t, err)
+
+		var upload models.Upload
+		require.NoError(t, db.Order("id DESC").First(&upload).Error)
+		var task models.Task
+		require.NoError(t, db.Where("kind = ?", TaskProcessUpload).Order("id DESC").First(&task).Error)
+		return upload, &task
+	}
+
+	t.Run("ProcessesAfterUploadLands", func(t *testing.T) {
+		upload, task := presign("lawn.png", "image/png")
+		assert.True(t, strings.HasSuffix(upload.Key, "_lawn.jpg"))
+
+		// Not uploaded yet: retried
+		err := service.processUploadTask(context.Background(), task)
+		require.Error(t, err)
+		assert.False(t, tasks.IsPermanent(err))
+
+		_, err = service.backend.PutFile(upload.StagingKey, bytes.NewReader(testutils.CreateTestImage(1200, 900)), "image/png")
+		require.NoError(t, err)
+
+		require.NoError(t, service.processUploadTask(context.Background(), task))
+
+		var processed models.Upload
+		require.NoError(t, db.First(&processed, upload.ID).Error)
+		assert.NotNil(t, processed.ProcessedAt)
+		assert.Empty(t, processed.StagingKey)
+		_, err = service.backend.OpenFile(upload.StagingKey)
+		assert.ErrorIs(t, err, storage.ErrFileNotFound)
+		file, err := service.backend.OpenFile(upload.Key)
+		require.NoError(t, err)
+		file.Close()
+		assert.Equal(t, "image/jpeg", processed.MimeType)
+		assert.Equal(t, 1200, processed.Width)
+		assert.NotEmpty(t, processed.Blurhash)
+
+		file, err = service.backend.OpenFile(variantKey(upload.Key, "thumbnail"))
+		require.NoError(t, err)
+		file.Close()
+	})
+
+	t.Run("PublishesCheckedPDF", func(t *testing.T) {
+		upload, task := presign("policy.pdf", "application/pdf")
+		_, err := service.backend.PutFile(upload.StagingKey, strings.NewReader("%PDF-1.4 policy"), "application/pdf")
+		require.NoError(t, err)
+
+		require.NoError(t, service.processUploadTask(context.Background(), task))
+
+		file, err := service.backend.OpenFile(upload.Key)
+		require.NoError(t, err)
+		data, _ := io.ReadAll(file)
+		file.Close()
+		assert.Equal(t, "%PDF-1.4 policy", string(data))
+		_, err = service.backend.OpenFile(upload.StagingKey)
+		assert.ErrorIs(t, err, storage.ErrFileNotFound)
+	})
+
+	t.Run("RejectsDisguisedFiles", func(t *testing.T) {
+		upload, task := presign("cat.png", "image/png")
+		_, err := service.backend.PutFile(upload.StagingKey, strings.NewReader("MZ\x90\x00 not an image"), "image/png")
+		require.NoError(t, err)
+
+		err = service.processUploadTask(context.Background(), task)
+
+		assert.True(t, tasks.IsPermanent(err))
+		_, err = service.backend.OpenFile(upload.StagingKey)
+		assert.ErrorIs(t, err, storage.ErrFileNotFound)
+		_, err = service.backend.OpenFile(upload.Key)
+		assert.ErrorIs(t, err, storage.ErrFileNotFound)
+		var count int64
+		db.Model(&models.Upload{}).Where("id = ?", upload.ID).Count(&count)
+		assert.Equal(t, int64(0), count)
+	})
+}
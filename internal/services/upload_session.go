@@ -0,0 +1,312 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/tasks"
+	"mowsy-api/pkg/imaging"
+	"mowsy-api/pkg/storage"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// uploadSessionURLExpiry is how long the presigned PUT of a session lasts.
+	uploadSessionURLExpiry = 15 * time.Minute
+	// uploadSessionTTL leaves time to call complete after a slow upload that
+	// started just before the URL expired. Pending sessions are swept after it.
+	uploadSessionTTL = time.Hour
+)
+
+var (
+	ErrUploadSessionNotFound = errors.New("upload session not found")
+	ErrUploadSessionClosed   = errors.New("upload session is no longer open")
+	ErrUploadNotReceived     = errors.New("file has not been uploaded yet")
+	ErrUploadSizeMismatch    = errors.New("uploaded file size does not match the declared size")
+	ErrInvalidUploadSize     = errors.New("size must be positive")
+)
+
+type CreateUploadSessionRequest struct {
+	FileName string `json:"file_name" binding:"required"`
+	MimeType string `json:"mime_type" binding:"required"`
+	Size     int64  `json:"size" binding:"required"`
+	Category string `json:"category"`
+}
+
+type UploadSessionResponse struct {
+	ID        uint                       `json:"id"`
+	Key       string                     `json:"key"`
+	Status    models.UploadSessionStatus `json:"status"`
+	Error     string                     `json:"error,omitempty"`
+	ExpiresAt time.Time                  `json:"expires_at"`
+
+	// Set when the session is created. The client must PUT the file to
+	// UploadURL with exactly these headers.
+	UploadURL string            `json:"upload_url,omitempty"`
+	Method    string            `json:"method,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+
+	// Set once the session is completed.
+	Upload *UploadImageResponse `json:"upload,omitempty"`
+}
+
+// CreateUploadSession starts a direct upload. The presigned PUT it returns
+// only accepts the declared type and exact size, and nothing is registered
+// as an upload until CompleteUploadSession has checked what arrived.
+func (s *UploadService) CreateUploadSession(userID uint, req CreateUploadSessionRequest) (*UploadSessionResponse, error) {
+	if req.Category == "" {
+		req.Category = UploadCategoryListingPhoto
+	}
+	visibility, err := categoryVisibility(req.Category)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Size <= 0 {
+		return nil, ErrInvalidUploadSize
+	}
+	if req.Size > maxUploadSize {
+		return nil, ErrFileTooLarge
+	}
+	if !imaging.IsImage(req.MimeType) && req.MimeType != "application/pdf" {
+		return nil, ErrUnsupportedFile
+	}
+
+	session := models.UploadSession{
+		UserID:     userID,
+		Key:        directUploadKey(userID, visibility, req.FileName, req.MimeType),
+		FileName:   req.FileName,
+		MimeType:   req.MimeType,
+		Size:       req.Size,
+		Category:   req.Category,
+		Visibility: string(visibility),
+		Status:     models.UploadSessionStatusPending,
+		ExpiresAt:  s.now().Add(uploadSessionTTL),
+	}
+
+	url, err := s.backend.GetPresignedUploadURL(session.Key, session.MimeType, session.Size, uploadSessionURLExpiry)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&session).Error; err != nil {
+			return fmt.Errorf("failed to create upload session: %w", err)
+		}
+		return tasks.Enqueue(tx, TaskExpireUploadSession, recordTaskPayload{ID: session.ID},
+			tasks.RunAt(session.ExpiresAt))
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response := uploadSessionResponse(&session)
+	response.UploadURL = url
+	response.Method = "PUT"
+	response.Headers = map[string]string{
+		"Content-Type":   session.MimeType,
+		"Content-Length": strconv.FormatInt(session.Size, 10),
+	}
+	return response, nil
+}
+
+// GetUploadSession returns one of the user's sessions.
+func (s *UploadService) GetUploadSession(userID, sessionID uint) (*UploadSessionResponse, error) {
+	session, err := s.findUploadSession(userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.completedSessionResponse(session)
+}
+
+// CompleteUploadSession registers the file a client uploaded for a session.
+// The object must exist with the declared size and really be an image or
+// PDF of the kind declared. A file that fails these checks is deleted and
+// the session fails; a file that has not arrived yet can be completed again
+// later. Completing twice returns the same upload.
+func (s *UploadService) CompleteUploadSession(userID, sessionID uint) (*UploadSessionResponse, error) {
+	session, err := s.findUploadSession(userID, sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch session.Status {
+	case models.UploadSessionStatusCompleted:
+		return s.completedSessionResponse(session)
+	case models.UploadSessionStatusPending:
+	default:
+		return nil, ErrUploadSessionClosed
+	}
+
+	if s.now().After(session.ExpiresAt) {
+		if err := s.expireUploadSession(session); err != nil {
+			return nil, err
+		}
+		return nil, ErrUploadSessionClosed
+	}
+
+	info, err := s.backend.StatFile(session.Key)
+	if errors.Is(err, storage.ErrFileNotFound) {
+		return nil, ErrUploadNotReceived
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if info.Size != session.Size {
+		return nil, s.failUploadSession(session, ErrUploadSizeMismatch)
+	}
+
+	mimeType, err := s.sniffStoredFile(session.Key)
+	if err != nil {
+		return nil, err
+	}
+	sameKind := (imaging.IsImage(mimeType) && imaging.IsImage(session.MimeType)) ||
+		(mimeType == "application/pdf" && session.MimeType == "application/pdf")
+	if !sameKind {
+		return nil, s.failUploadSession(session, ErrUnsupportedFile)
+	}
+
+	upload := models.Upload{
+		Key:        session.Key,
+		UserID:     session.UserID,
+		Size:       info.Size,
+		MimeType:   mimeType,
+		Category:   session.Category,
+		Visibility: session.Visibility,
+	}
+	now := s.now()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := createUploadRecord(tx, &upload); err != nil {
+			return err
+		}
+		if err := tx.Model(session).Updates(map[string]interface{}{
+			"status":       models.UploadSessionStatusCompleted,
+			"upload_id":    upload.ID,
+			"completed_at": &now,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to complete upload session: %w", err)
+		}
+		// Images still need their metadata stripped and variants generated
+		return tasks.Enqueue(tx, TaskProcessUpload, recordTaskPayload{ID: upload.ID})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response := uploadSessionResponse(session)
+	response.Upload, err = s.uploadResponse(&upload)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (s *UploadService) findUploadSession(userID, sessionID uint) (*models.UploadSession, error) {
+	var session models.UploadSession
+	if err := s.db.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUploadSessionNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch upload session: %w", err)
+	}
+	return &session, nil
+}
+
+// sniffStoredFile detects a stored file's type from its first bytes.
+func (s *UploadService) sniffStoredFile(key string) (string, error) {
+	file, err := s.backend.OpenFile(key)
+	if err != nil {
+		return "", fmt.Errorf("failed to read upload: %w", err)
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return "", fmt.Errorf("failed to read upload: %w", err)
+	}
+	return imaging.DetectContentType(head[:n]), nil
+}
+
+func (s *UploadService) failUploadSession(session *models.UploadSession, reason error) error {
+	if err := s.deleteStoredFile(session.Key); err != nil {
+		return err
+	}
+	if err := s.db.Model(session).Updates(map[string]interface{}{
+		"status": models.UploadSessionStatusFailed,
+		"error":  reason.Error(),
+	}).Error; err != nil {
+		return fmt.Errorf("failed to update upload session: %w", err)
+	}
+	return reason
+}
+
+// expireUploadSession closes a session that was never completed and deletes
+// whatever the client may have uploaded for it.
+func (s *UploadService) expireUploadSession(session *models.UploadSession) error {
+	if err := s.deleteStoredFile(session.Key); err != nil {
+		return err
+	}
+	if err := s.db.Model(session).Update("status", models.UploadSessionStatusExpired).Error; err != nil {
+		return fmt.Errorf("failed to expire upload session: %w", err)
+	}
+	return nil
+}
+
+// expireUploadSessionTask sweeps a session once its TTL has passed. It is
+// queued when the session is created, so completed sessions are skipped.
+func (s *UploadService) expireUploadSessionTask(ctx context.Context, task *models.Task) error {
+	var session models.UploadSession
+	if found, err := loadTaskRecord(s.db, task, &session); !found || err != nil {
+		return err
+	}
+	if session.Status != models.UploadSessionStatusPending {
+		return nil
+	}
+	if s.now().Before(session.ExpiresAt) {
+		return fmt.Errorf("upload session %d has not expired yet", session.ID)
+	}
+
+	return s.expireUploadSession(&session)
+}
+
+func (s *UploadService) completedSessionResponse(session *models.UploadSession) (*UploadSessionResponse, error) {
+	response := uploadSessionResponse(session)
+	if session.UploadID == nil {
+		return response, nil
+	}
+
+	var upload models.Upload
+	if err := s.db.Where("id = ?", *session.UploadID).First(&upload).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// The upload has since been deleted
+			return response, nil
+		}
+		return nil, fmt.Errorf("failed to fetch upload: %w", err)
+	}
+
+	var err error
+	response.Upload, err = s.uploadResponse(&upload)
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func uploadSessionResponse(session *models.UploadSession) *UploadSessionResponse {
+	return &UploadSessionResponse{
+		ID:        session.ID,
+		Key:       session.Key,
+		Status:    session.Status,
+		Error:     session.Error,
+		ExpiresAt: session.ExpiresAt,
+	}
+}
@@ -0,0 +1,151 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/testutils"
+	"mowsy-api/pkg/storage"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadService_UploadSessions(t *testing.T) {
+	service, db := setupUploadService(t)
+	defer testutils.CleanupTestDB(db)
+
+	image := testutils.CreateTestImage(64, 48)
+	start := func(userID uint, fileName, mimeType string, size int64) *UploadSessionResponse {
+		session, err := service.CreateUploadSession(userID, CreateUploadSessionRequest{
+			FileName: fileName,
+			MimeType: mimeType,
+			Size:     size,
+		})
+		require.NoError(t, err)
+		return session
+	}
+
+	t.Run("CreateAndComplete", func(t *testing.T) {
+		session := start(1, "lawn.png", "image/png", int64(len(image)))
+
+		assert.Equal(t, models.UploadSessionStatusPending, session.Status)
+		assert.Equal(t, "PUT", session.Method)
+		assert.Contains(t, session.UploadURL, "content_length=")
+		assert.Equal(t, "image/png", session.Headers["Content-Type"])
+		assert.True(t, strings.HasSuffix(session.Key, "_lawn.jpg"))
+
+		var expiry models.Task
+		require.NoError(t, db.Where("kind = ?", TaskExpireUploadSession).Order("id DESC").First(&expiry).Error)
+
+		// Nothing uploaded yet
+		_, err := service.CompleteUploadSession(1, session.ID)
+		assert.ErrorIs(t, err, ErrUploadNotReceived)
+
+		_, err = service.backend.PutFile(session.Key, bytes.NewReader(image), "image/png")
+		require.NoError(t, err)
+
+		completed, err := service.CompleteUploadSession(1, session.ID)
+		require.NoError(t, err)
+		assert.Equal(t, models.UploadSessionStatusCompleted, completed.Status)
+		require.NotNil(t, completed.Upload)
+		assert.Equal(t, session.Key, completed.Upload.Key)
+		assert.Equal(t, "image/png", completed.Upload.MimeType)
+
+		var upload models.Upload
+		require.NoError(t, db.Where("key = ?", session.Key).First(&upload).Error)
+		assert.Equal(t, uint(1), upload.UserID)
+		assert.Equal(t, int64(len(image)), upload.Size)
+		var processing int64
+		db.Model(&models.Task{}).Where("kind = ?", TaskProcessUpload).Count(&processing)
+		assert.Equal(t, int64(1), processing)
+
+		// Completing again returns the same upload
+		again, err := service.CompleteUploadSession(1, session.ID)
+		require.NoError(t, err)
+		assert.Equal(t, completed.Upload.Key, again.Upload.Key)
+
+		// The expiry sweep leaves completed sessions alone
+		db.Model(&models.UploadSession{}).Where("id = ?", session.ID).Update("expires_at", time.Now().Add(-time.Minute))
+		require.NoError(t, service.expireUploadSessionTask(context.Background(), &expiry))
+		_, err = service.backend.OpenFile(session.Key)
+		assert.NoError(t, err)
+	})
+
+	t.Run("OnlyOwnerCanComplete", func(t *testing.T) {
+		session := start(1, "lawn.png", "image/png", int64(len(image)))
+
+		_, err := service.CompleteUploadSession(2, session.ID)
+
+		assert.ErrorIs(t, err, ErrUploadSessionNotFound)
+	})
+
+	t.Run("SizeMismatch", func(t *testing.T) {
+		session := start(1, "lawn.png", "image/png", int64(len(image))+10)
+		_, err := service.backend.PutFile(session.Key, bytes.NewReader(image), "image/png")
+		require.NoError(t, err)
+
+		_, err = service.CompleteUploadSession(1, session.ID)
+
+		assert.ErrorIs(t, err, ErrUploadSizeMismatch)
+		_, err = service.backend.OpenFile(session.Key)
+		assert.ErrorIs(t, err, storage.ErrFileNotFound)
+
+		// The session is closed for good
+		_, err = service.CompleteUploadSession(1, session.ID)
+		assert.ErrorIs(t, err, ErrUploadSessionClosed)
+	})
+
+	t.Run("DisguisedFile", func(t *testing.T) {
+		body := "%PDF-1.4 pretending to be a photo"
+		session := start(1, "lawn.png", "image/png", int64(len(body)))
+		_, err := service.backend.PutFile(session.Key, strings.NewReader(body), "image/png")
+		require.NoError(t, err)
+
+		_, err = service.CompleteUploadSession(1, session.ID)
+
+		assert.ErrorIs(t, err, ErrUnsupportedFile)
+		got, err := service.GetUploadSession(1, session.ID)
+		require.NoError(t, err)
+		assert.Equal(t, models.UploadSessionStatusFailed, got.Status)
+		assert.Equal(t, ErrUnsupportedFile.Error(), got.Error)
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		_, err := service.CreateUploadSession(1, CreateUploadSessionRequest{FileName: "big.png", MimeType: "image/png", Size: maxUploadSize + 1})
+		assert.ErrorIs(t, err, ErrFileTooLarge)
+
+		_, err = service.CreateUploadSession(1, CreateUploadSessionRequest{FileName: "run.sh", MimeType: "text/x-shellscript", Size: 10})
+		assert.ErrorIs(t, err, ErrUnsupportedFile)
+
+		_, err = service.CreateUploadSession(1, CreateUploadSessionRequest{FileName: "a.png", MimeType: "image/png", Size: 10, Category: "selfie"})
+		assert.ErrorIs(t, err, ErrInvalidUploadCategory)
+	})
+
+	t.Run("ExpiredSessionsAreSwept", func(t *testing.T) {
+		session := start(1, "draft.png", "image/png", int64(len(image)))
+		_, err := service.backend.PutFile(session.Key, bytes.NewReader(image), "image/png")
+		require.NoError(t, err)
+		var expiry models.Task
+		require.NoError(t, db.Where("kind = ?", TaskExpireUploadSession).Order("id DESC").First(&expiry).Error)
+
+		// Too early: retried later
+		assert.Error(t, service.expireUploadSessionTask(context.Background(), &expiry))
+
+		db.Model(&models.UploadSession{}).Where("id = ?", session.ID).Update("expires_at", time.Now().Add(-time.Minute))
+		require.NoError(t, service.expireUploadSessionTask(context.Background(), &expiry))
+
+		got, err := service.GetUploadSession(1, session.ID)
+		require.NoError(t, err)
+		assert.Equal(t, models.UploadSessionStatusExpired, got.Status)
+		_, err = service.backend.OpenFile(session.Key)
+		assert.ErrorIs(t, err, storage.ErrFileNotFound)
+
+		_, err = service.CompleteUploadSession(1, session.ID)
+		assert.ErrorIs(t, err, ErrUploadSessionClosed)
+	})
+}
@@ -1,31 +1,31 @@
 package services
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"mowsy-api/internal/models"
+	"mowsy-api/internal/tasks"
 	"mowsy-api/pkg/auth"
-	"mowsy-api/pkg/database"
+	"mowsy-api/pkg/notify"
+	"mowsy-api/pkg/storage"
 	"mowsy-api/internal/utils"
 
 	"gorm.io/gorm"
 )
 
-type UserService struct {
-	db *gorm.DB
-}
+var ErrInvalidCredentials = errors.New("invalid credentials")
 
-func NewUserService() *UserService {
-	return &UserService{
-		db: database.GetDB(),
-	}
-}
+type UserService struct {
+	db       *gorm.DB
+	geocoder Geocoder
+	drivers  notify.Drivers
+	now      func() time.Time
 
-func NewUserServiceWithDB(db *gorm.DB) *UserService {
-	return &UserService{
-		db: db,
-	}
+	// mfaRequiredRoles lists roles that cannot sign in without 2FA
+	mfaRequiredRoles map[models.UserRole]bool
 }
 
 type RegisterRequest struct {
@@ -49,6 +49,8 @@ type LoginResponse struct {
 	AccessToken  string               `json:"access_token"`
 	RefreshToken string               `json:"refresh_token"`
 	User         models.UserResponse  `json:"user"`
+	// RecoveryCodes is only set when signing in completed 2FA enrollment
+	RecoveryCodes []string            `json:"recovery_codes,omitempty"`
 }
 
 type UpdateUserRequest struct {
@@ -66,8 +68,8 @@ func (s *UserService) Register(req RegisterRequest) (*LoginResponse, error) {
 		return nil, errors.New("invalid email format")
 	}
 
-	if !utils.IsValidPassword(req.Password) {
-		return nil, errors.New("password must be at least 8 characters long")
+	if err := utils.ValidatePassword(req.Password, req.Email); err != nil {
+		return nil, err
 	}
 
 	if req.Phone != "" && !utils.IsValidPhone(req.Phone) {
@@ -78,8 +80,9 @@ func (s *UserService) Register(req RegisterRequest) (*LoginResponse, error) {
 		return nil, errors.New("invalid zip code format")
 	}
 
+	// Removed accounts keep their email until purged, so they can be restored
 	var existingUser models.User
-	if err := s.db.Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
+	if err := s.db.Unscoped().Where("email = ?", req.Email).First(&existingUser).Error; err == nil {
 		return nil, errors.New("user with this email already exists")
 	}
 
@@ -98,71 +101,72 @@ func (s *UserService) Register(req RegisterRequest) (*LoginResponse, error) {
 		City:         utils.SanitizeString(req.City),
 		State:        utils.SanitizeString(req.State),
 		ZipCode:      utils.SanitizeString(req.ZipCode),
+		Role:         models.UserRoleUser,
 		IsActive:     true,
 	}
 
-	if err := s.db.Create(&user).Error; err != nil {
-		return nil, fmt.Errorf("failed to create user: %w", err)
-	}
-
-	if user.Address != "" {
-		geocodioService := NewGeocodioService()
-		if err := geocodioService.GeocodeUser(&user); err != nil {
-			fmt.Printf("Warning: Failed to geocode user address: %v\n", err)
-		} else {
-			s.db.Save(&user)
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&user).Error; err != nil {
+			return fmt.Errorf("failed to create user: %w", err)
 		}
-	}
-
-	accessToken, err := auth.GenerateToken(user.ID, user.Email)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate access token: %w", err)
-	}
-
-	refreshToken, err := auth.GenerateRefreshToken(user.ID, user.Email)
+		if user.Address != "" {
+			if err := tasks.Enqueue(tx, TaskGeocodeUser, recordTaskPayload{ID: user.ID}); err != nil {
+				return err
+			}
+		}
+		return tasks.Enqueue(tx, TaskSyncStripeCustomer, recordTaskPayload{ID: user.ID})
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+		return nil, err
 	}
 
-	return &LoginResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		User:         user.ToResponse(),
-	}, nil
+	return newLoginResponse(&user)
 }
 
-func (s *UserService) Login(req LoginRequest) (*LoginResponse, error) {
+// Login checks the credentials and issues a token pair. Failed attempts are
+// counted per account and per clientIP; see login_guard.go. Users with 2FA
+// get a *MFARequiredError carrying a challenge instead of tokens.
+func (s *UserService) Login(req LoginRequest, clientIP string) (*LoginResponse, error) {
 	if !utils.IsValidEmail(req.Email) {
 		return nil, errors.New("invalid email format")
 	}
 
+	now := s.now()
+	if err := s.checkLoginThrottle(ipLoginKey(clientIP), ipLoginThrottle, now); err != nil {
+		return nil, err
+	}
+
 	var user models.User
 	if err := s.db.Where("email = ? AND is_active = true", req.Email).First(&user).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("invalid credentials")
+			if err := s.recordFailedLogin(nil, clientIP, now); err != nil {
+				return nil, err
+			}
+			return nil, ErrInvalidCredentials
 		}
 		return nil, fmt.Errorf("failed to find user: %w", err)
 	}
 
+	if err := s.checkLoginThrottle(accountLoginKey(user.ID), accountLoginThrottle, now); err != nil {
+		return nil, err
+	}
+
 	if !auth.CheckPassword(req.Password, user.PasswordHash) {
-		return nil, errors.New("invalid credentials")
+		if err := s.recordFailedLogin(&user, clientIP, now); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidCredentials
 	}
 
-	accessToken, err := auth.GenerateToken(user.ID, user.Email)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate access token: %w", err)
+	if err := s.clearLoginFailures(user.ID); err != nil {
+		return nil, err
 	}
 
-	refreshToken, err := auth.GenerateRefreshToken(user.ID, user.Email)
-	if err != nil {
-		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	if err := s.requireSecondFactor(&user); err != nil {
+		return nil, err
 	}
 
-	return &LoginResponse{
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-		User:         user.ToResponse(),
-	}, nil
+	return newLoginResponse(&user)
 }
 
 func (s *UserService) RefreshToken(refreshToken string) (*LoginResponse, error) {
@@ -179,23 +183,36 @@ func (s *UserService) RefreshToken(refreshToken string) (*LoginResponse, error)
 		return nil, fmt.Errorf("failed to find user: %w", err)
 	}
 
+	return newLoginResponse(&user)
+}
+
+// newLoginResponse issues a fresh access and refresh token pair for user.
+func newLoginResponse(user *models.User) (*LoginResponse, error) {
 	accessToken, err := auth.GenerateToken(user.ID, user.Email)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate access token: %w", err)
 	}
 
-	newRefreshToken, err := auth.GenerateRefreshToken(user.ID, user.Email)
+	refreshToken, err := auth.GenerateRefreshToken(user.ID, user.Email)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
 	}
 
 	return &LoginResponse{
 		AccessToken:  accessToken,
-		RefreshToken: newRefreshToken,
+		RefreshToken: refreshToken,
 		User:         user.ToResponse(),
 	}, nil
 }
 
+// WithContext returns a copy of s whose queries run under ctx, so they are
+// traced and cancelled with the request.
+func (s *UserService) WithContext(ctx context.Context) *UserService {
+	scoped := *s
+	scoped.db = s.db.WithContext(ctx)
+	return &scoped
+}
+
 func (s *UserService) GetUserByID(userID uint) (*models.User, error) {
 	var user models.User
 	if err := s.db.Where("id = ? AND is_active = true", userID).First(&user).Error; err != nil {
@@ -244,17 +261,22 @@ func (s *UserService) UpdateUser(userID uint, req UpdateUserRequest) (*models.Us
 		updates["zip_code"] = utils.SanitizeString(req.ZipCode)
 	}
 
-	if err := s.db.Model(user).Updates(updates).Error; err != nil {
-		return nil, fmt.Errorf("failed to update user: %w", err)
-	}
-
-	if req.Address != "" {
-		geocodioService := NewGeocodioService()
-		if err := geocodioService.GeocodeUser(user); err != nil {
-			fmt.Printf("Warning: Failed to geocode user address: %v\n", err)
-		} else {
-			s.db.Save(user)
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(updates).Error; err != nil {
+			return fmt.Errorf("failed to update user: %w", err)
+		}
+		if req.Address != "" {
+			if err := tasks.Enqueue(tx, TaskGeocodeUser, recordTaskPayload{ID: user.ID}); err != nil {
+				return err
+			}
 		}
+		if user.StripeCustomerID != "" && (req.FirstName != "" || req.LastName != "" || req.Phone != "") {
+			return tasks.Enqueue(tx, TaskSyncStripeCustomer, recordTaskPayload{ID: user.ID})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	response := user.ToResponse()
@@ -297,12 +319,76 @@ func (s *UserService) UploadInsuranceDocument(userID uint, documentURL string) e
 		return err
 	}
 
-	if err := s.db.Model(user).Updates(map[string]interface{}{
-		"insurance_document_url": documentURL,
-		"insurance_verified":     false,
-	}).Error; err != nil {
-		return fmt.Errorf("failed to update insurance document: %w", err)
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"insurance_document_url": documentURL,
+			"insurance_document_key": "",
+			"insurance_verified":     false,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update insurance document: %w", err)
+		}
+		return linkUploads(tx, user.ID, UploadRelatedInsurance, user.ID, documentURL)
+	})
+}
+
+// AttachInsuranceDocument records a document uploaded with the private
+// insurance category. Only the key is stored; admins fetch the file through
+// a presigned URL when verifying it. The upload must have been checked, so a
+// presigned upload still waiting to be processed is refused.
+func (s *UserService) AttachInsuranceDocument(userID uint, key string) error {
+	owner, ok := storage.KeyOwner(key)
+	if !ok || owner != userID || storage.KeyVisibility(key) != storage.VisibilityPrivate {
+		return errors.New("insurance document must be a private upload owned by the user")
 	}
 
-	return nil
-}
\ No newline at end of file
+	var upload models.Upload
+	if err := s.db.Where("key = ? AND user_id = ?", key, userID).First(&upload).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("insurance document not found")
+		}
+		return fmt.Errorf("failed to find insurance document: %w", err)
+	}
+	if upload.Category != UploadCategoryInsurance {
+		return errors.New("insurance document must be uploaded with the insurance category")
+	}
+	if upload.ProcessedAt == nil {
+		return errors.New("insurance document has not been processed yet")
+	}
+
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Updates(map[string]interface{}{
+			"insurance_document_key": key,
+			"insurance_document_url": "",
+			"insurance_verified":     false,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to update insurance document: %w", err)
+		}
+		return linkUploads(tx, user.ID, UploadRelatedInsurance, user.ID, key)
+	})
+}
+
+// geocodeUserTask resolves the address saved by a create or update.
+func (s *UserService) geocodeUserTask(ctx context.Context, task *models.Task) error {
+	var user models.User
+	db := s.db.WithContext(ctx)
+	if found, err := loadTaskRecord(db, task, &user); !found || err != nil {
+		return err
+	}
+
+	if err := s.geocoder.GeocodeUser(ctx, &user); err != nil {
+		return geocodeTaskError(task, err)
+	}
+
+	return db.Model(&user).Updates(map[string]interface{}{
+		"latitude":                        user.Latitude,
+		"longitude":                       user.Longitude,
+		"zip_code":                        user.ZipCode,
+		"elementary_school_district_name": user.ElementarySchoolDistrictName,
+		"elementary_school_district_code": user.ElementarySchoolDistrictCode,
+	}).Error
+}
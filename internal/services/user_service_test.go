@@ -1,7 +1,9 @@
 package services
 
 import (
+	"fmt"
 	"testing"
+	"time"
 
 	"mowsy-api/internal/models"
 	"mowsy-api/internal/testutils"
@@ -16,19 +18,20 @@ func setupUserService() (*UserService, *gorm.DB) {
 	db := testutils.SetupTestDB()
 	
 	// Create a user service with the test database
-	service := NewUserServiceWithDB(db)
+	service := NewContainer(Dependencies{DB: db, Geocoder: NewStaticGeocoder(nil)}).Users
 	
 	return service, db
 }
 
 func TestUserService_Register(t *testing.T) {
+	testutils.UseTestSigningKeys(t)
 	service, db := setupUserService()
 	defer testutils.CleanupTestDB(db)
 
 	t.Run("ValidRegistration", func(t *testing.T) {
 		req := RegisterRequest{
 			Email:     "test@example.com",
-			Password:  "password123",
+			Password:  "mower-shed-42",
 			FirstName: "Test",
 			LastName:  "User",
 			Phone:     "555-123-4567",
@@ -52,7 +55,7 @@ func TestUserService_Register(t *testing.T) {
 	t.Run("InvalidEmail", func(t *testing.T) {
 		req := RegisterRequest{
 			Email:     "invalid-email",
-			Password:  "password123",
+			Password:  "mower-shed-42",
 			FirstName: "Test",
 			LastName:  "User",
 		}
@@ -76,13 +79,13 @@ func TestUserService_Register(t *testing.T) {
 
 		assert.Error(t, err)
 		assert.Nil(t, response)
-		assert.Contains(t, err.Error(), "password must be at least 8 characters")
+		assert.Contains(t, err.Error(), "password must be at least 10 characters")
 	})
 
 	t.Run("InvalidPhone", func(t *testing.T) {
 		req := RegisterRequest{
 			Email:     "test3@example.com",
-			Password:  "password123",
+			Password:  "mower-shed-42",
 			FirstName: "Test",
 			LastName:  "User",
 			Phone:     "invalid-phone",
@@ -98,7 +101,7 @@ func TestUserService_Register(t *testing.T) {
 	t.Run("InvalidZipCode", func(t *testing.T) {
 		req := RegisterRequest{
 			Email:     "test4@example.com",
-			Password:  "password123",
+			Password:  "mower-shed-42",
 			FirstName: "Test",
 			LastName:  "User",
 			ZipCode:   "invalid",
@@ -115,7 +118,7 @@ func TestUserService_Register(t *testing.T) {
 		// First registration
 		req1 := RegisterRequest{
 			Email:     "duplicate@example.com",
-			Password:  "password123",
+			Password:  "mower-shed-42",
 			FirstName: "Test",
 			LastName:  "User",
 		}
@@ -126,7 +129,7 @@ func TestUserService_Register(t *testing.T) {
 		// Second registration with same email
 		req2 := RegisterRequest{
 			Email:     "duplicate@example.com",
-			Password:  "password456",
+			Password:  "mower-shed-43",
 			FirstName: "Another",
 			LastName:  "User",
 		}
@@ -140,6 +143,7 @@ func TestUserService_Register(t *testing.T) {
 }
 
 func TestUserService_Login(t *testing.T) {
+	testutils.UseTestSigningKeys(t)
 	service, db := setupUserService()
 	defer testutils.CleanupTestDB(db)
 
@@ -164,7 +168,7 @@ func TestUserService_Login(t *testing.T) {
 			Password: password,
 		}
 
-		response, err := service.Login(req)
+		response, err := service.Login(req, "203.0.113.7")
 
 		require.NoError(t, err)
 		assert.NotNil(t, response)
@@ -179,7 +183,7 @@ func TestUserService_Login(t *testing.T) {
 			Password: password,
 		}
 
-		response, err := service.Login(req)
+		response, err := service.Login(req, "203.0.113.7")
 
 		assert.Error(t, err)
 		assert.Nil(t, response)
@@ -192,7 +196,7 @@ func TestUserService_Login(t *testing.T) {
 			Password: "wrongpassword",
 		}
 
-		response, err := service.Login(req)
+		response, err := service.Login(req, "203.0.113.7")
 
 		assert.Error(t, err)
 		assert.Nil(t, response)
@@ -205,7 +209,7 @@ func TestUserService_Login(t *testing.T) {
 			Password: password,
 		}
 
-		response, err := service.Login(req)
+		response, err := service.Login(req, "203.0.113.7")
 
 		assert.Error(t, err)
 		assert.Nil(t, response)
@@ -239,7 +243,7 @@ func TestUserService_Login(t *testing.T) {
 			Password: inactivePassword,
 		}
 
-		response, err := service.Login(req)
+		response, err := service.Login(req, "203.0.113.7")
 
 		assert.Error(t, err)
 		assert.Nil(t, response)
@@ -410,4 +414,67 @@ func TestUserService_UploadInsuranceDocument(t *testing.T) {
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "user not found")
 	})
+}
+
+func TestUserService_AttachInsuranceDocument(t *testing.T) {
+	service, db := setupUserService()
+	defer testutils.CleanupTestDB(db)
+
+	user := testutils.CreateTestUser(db)
+	processed := time.Now()
+	upload := func(key, category string, processedAt *time.Time) string {
+		require.NoError(t, db.Create(&models.Upload{Key: key, UserID: user.ID, MimeType: "application/pdf",
+			Category: category, Visibility: "private", ProcessedAt: processedAt}).Error)
+		return key
+	}
+
+	t.Run("PrivateKeyOwnedByUser", func(t *testing.T) {
+		key := upload(fmt.Sprintf("private/%d/1700000000_policy.pdf", user.ID), UploadCategoryInsurance, &processed)
+
+		err := service.AttachInsuranceDocument(user.ID, key)
+
+		require.NoError(t, err)
+		var updatedUser models.User
+		require.NoError(t, db.First(&updatedUser, user.ID).Error)
+		assert.Equal(t, key, updatedUser.InsuranceDocumentKey)
+		assert.Empty(t, updatedUser.InsuranceDocumentURL)
+		assert.False(t, updatedUser.InsuranceVerified)
+	})
+
+	t.Run("PublicKeyIsRejected", func(t *testing.T) {
+		err := service.AttachInsuranceDocument(user.ID, fmt.Sprintf("uploads/%d/1700000000_policy.pdf", user.ID))
+
+		assert.Error(t, err)
+	})
+
+	t.Run("OtherUsersKeyIsRejected", func(t *testing.T) {
+		err := service.AttachInsuranceDocument(user.ID, fmt.Sprintf("private/%d/1700000000_policy.pdf", user.ID+1))
+
+		assert.Error(t, err)
+	})
+
+	t.Run("UnknownUploadIsRejected", func(t *testing.T) {
+		err := service.AttachInsuranceDocument(user.ID, fmt.Sprintf("private/%d/1700000001_policy.pdf", user.ID))
+
+		assert.EqualError(t, err, "insurance document not found")
+	})
+
+	t.Run("OtherCategoryIsRejected", func(t *testing.T) {
+		key := upload(fmt.Sprintf("private/%d/1700000002_claim.pdf", user.ID), UploadCategoryDisputeEvidence, &processed)
+
+		err := service.AttachInsuranceDocument(user.ID, key)
+
+		assert.EqualError(t, err, "insurance document must be uploaded with the insurance category")
+	})
+
+	t.Run("UnprocessedUploadIsRejected", func(t *testing.T) {
+		key := upload(fmt.Sprintf("private/%d/1700000003_policy.pdf", user.ID), UploadCategoryInsurance, nil)
+
+		err := service.AttachInsuranceDocument(user.ID, key)
+
+		assert.EqualError(t, err, "insurance document has not been processed yet")
+		var unchanged models.User
+		require.NoError(t, db.First(&unchanged, user.ID).Error)
+		assert.NotEqual(t, key, unchanged.InsuranceDocumentKey)
+	})
 }
\ No newline at end of file
@@ -0,0 +1,536 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"mowsy-api/internal/events"
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/tasks"
+	"mowsy-api/internal/utils"
+	"mowsy-api/pkg/metrics"
+	"mowsy-api/pkg/tracing"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"gorm.io/gorm"
+)
+
+const (
+	// WebhookSignatureHeader carries "t=<unix time>,v1=<hex HMAC-SHA256>" of
+	// "<unix time>.<body>", keyed with the endpoint's secret.
+	WebhookSignatureHeader = "Mowsy-Signature"
+	WebhookEventHeader     = "Mowsy-Event"
+	WebhookDeliveryHeader  = "Mowsy-Delivery"
+
+	webhookSecretPrefix = "whsec_"
+
+	// maxWebhookEndpoints caps the endpoints one user can register.
+	maxWebhookEndpoints = 10
+
+	// maxWebhookAttempts spreads retries over about three hours with the
+	// queue's backoff, long enough to ride out a receiver's deploy or outage.
+	maxWebhookAttempts = 10
+
+	// webhookResponseLimit is how much of a receiver's reply is kept in the
+	// delivery log.
+	webhookResponseLimit = 1024
+)
+
+var (
+	ErrWebhookNotFound         = errors.New("webhook not found")
+	ErrWebhookDeliveryNotFound = errors.New("webhook delivery not found")
+	ErrTooManyWebhooks         = fmt.Errorf("a user can have at most %d webhooks", maxWebhookEndpoints)
+	ErrInvalidWebhookURL       = errors.New("webhook url must be a public https url")
+	ErrInvalidWebhookEvent     = errors.New("unknown webhook event")
+	ErrInvalidWebhookZipCode   = errors.New("zip codes must have 5 digits")
+
+	// ErrWebhookAddressNotAllowed is returned when a webhook host resolves to
+	// a loopback, private or link-local address, so endpoints cannot be used
+	// to reach our own network.
+	ErrWebhookAddressNotAllowed = errors.New("webhook host resolves to a non-public address")
+)
+
+var zipCodePattern = regexp.MustCompile(`^\d{5}$`)
+
+// privateWebhookEvents carry someone's messages or payments, so they only go
+// to the endpoints of the users they are addressed to, never to apps.
+var privateWebhookEvents = []events.Type{events.MessageReceived, events.PaymentSucceeded, events.PaymentFailed}
+
+type WebhookService struct {
+	db     *gorm.DB
+	client *http.Client
+	now    func() time.Time
+}
+
+// newWebhookClient refuses to connect to non-public addresses, checked after
+// DNS resolution, and does not follow redirects.
+func newWebhookClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 5 * time.Second,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return ErrWebhookAddressNotAllowed
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 5 * time.Second,
+			MaxIdleConnsPerHost: 2,
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func isPublicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsUnspecified() &&
+		!ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() && !ip.IsMulticast()
+}
+
+type CreateWebhookRequest struct {
+	URL         string        `json:"url" binding:"required,max=2048"`
+	Events      []events.Type `json:"events" binding:"required,min=1"`
+	ZipCodes    []string      `json:"zip_codes"`
+	Description string        `json:"description" binding:"max=200"`
+}
+
+type CreateAppWebhookRequest struct {
+	AppName string `json:"app_name" binding:"required,max=100"`
+	CreateWebhookRequest
+}
+
+// CreatedWebhook is returned once, when the endpoint is registered. The
+// signing secret cannot be retrieved later.
+type CreatedWebhook struct {
+	models.WebhookEndpoint
+	Secret string `json:"secret"`
+}
+
+// WebhookEvent is the JSON body posted to endpoints. ID is shared by every
+// delivery of the event, including replays, so receivers can drop
+// duplicates.
+type WebhookEvent struct {
+	ID        string      `json:"id"`
+	Type      events.Type `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// SignWebhookPayload returns the hex HMAC-SHA256 a receiver recomputes to
+// check the signature header.
+func SignWebhookPayload(secret string, timestamp int64, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// webhookOwner scopes endpoint queries to a user's endpoints, or to the
+// admin-registered app endpoints when owner is nil.
+func webhookOwner(owner *uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if owner == nil {
+			return db.Where("user_id IS NULL")
+		}
+		return db.Where("user_id = ?", *owner)
+	}
+}
+
+func (s *WebhookService) CreateEndpoint(userID uint, req CreateWebhookRequest) (*CreatedWebhook, error) {
+	var count int64
+	if err := s.db.Model(&models.WebhookEndpoint{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return nil, fmt.Errorf("failed to count webhooks: %w", err)
+	}
+	if count >= maxWebhookEndpoints {
+		return nil, ErrTooManyWebhooks
+	}
+	return s.createEndpoint(&userID, "", req)
+}
+
+func (s *WebhookService) CreateAppEndpoint(req CreateAppWebhookRequest) (*CreatedWebhook, error) {
+	return s.createEndpoint(nil, utils.SanitizeString(req.AppName), req.CreateWebhookRequest)
+}
+
+func (s *WebhookService) createEndpoint(owner *uint, appName string, req CreateWebhookRequest) (*CreatedWebhook, error) {
+	if err := validateWebhookURL(req.URL); err != nil {
+		return nil, err
+	}
+
+	subscribed := models.StringArray{}
+	for _, eventType := range req.Events {
+		if !slices.Contains(events.Types, eventType) {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidWebhookEvent, eventType)
+		}
+		if owner == nil && slices.Contains(privateWebhookEvents, eventType) {
+			return nil, fmt.Errorf("%w: %q is only sent to user webhooks", ErrInvalidWebhookEvent, eventType)
+		}
+		if !slices.Contains(subscribed, string(eventType)) {
+			subscribed = append(subscribed, string(eventType))
+		}
+	}
+
+	zipCodes := models.StringArray{}
+	for _, zipCode := range req.ZipCodes {
+		if !zipCodePattern.MatchString(zipCode) {
+			return nil, ErrInvalidWebhookZipCode
+		}
+		if !slices.Contains(zipCodes, zipCode) {
+			zipCodes = append(zipCodes, zipCode)
+		}
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+	secret := webhookSecretPrefix + base64.RawURLEncoding.EncodeToString(key)
+
+	endpoint := models.WebhookEndpoint{
+		UserID:      owner,
+		AppName:     appName,
+		Description: utils.SanitizeString(req.Description),
+		URL:         req.URL,
+		Secret:      secret,
+		Events:      subscribed,
+		ZipCodes:    zipCodes,
+	}
+	if err := s.db.Create(&endpoint).Error; err != nil {
+		return nil, fmt.Errorf("failed to create webhook: %w", err)
+	}
+
+	return &CreatedWebhook{WebhookEndpoint: endpoint, Secret: secret}, nil
+}
+
+// validateWebhookURL requires https and rejects hosts that are plainly not
+// public. Names are checked again when connecting, since DNS can change.
+func validateWebhookURL(raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Scheme != "https" || parsed.Hostname() == "" || parsed.User != nil {
+		return ErrInvalidWebhookURL
+	}
+	host := strings.ToLower(parsed.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".internal") {
+		return ErrInvalidWebhookURL
+	}
+	if ip := net.ParseIP(host); ip != nil && !isPublicIP(ip) {
+		return ErrInvalidWebhookURL
+	}
+	return nil
+}
+
+// GetEndpoints lists a user's endpoints, or the app endpoints when owner is
+// nil.
+func (s *WebhookService) GetEndpoints(owner *uint) ([]models.WebhookEndpoint, error) {
+	var endpoints []models.WebhookEndpoint
+	if err := s.db.Scopes(webhookOwner(owner)).Order("created_at DESC, id DESC").Find(&endpoints).Error; err != nil {
+		return nil, fmt.Errorf("failed to get webhooks: %w", err)
+	}
+	return endpoints, nil
+}
+
+func (s *WebhookService) getEndpoint(owner *uint, endpointID uint) (*models.WebhookEndpoint, error) {
+	var endpoint models.WebhookEndpoint
+	if err := s.db.Scopes(webhookOwner(owner)).Where("id = ?", endpointID).First(&endpoint).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch webhook: %w", err)
+	}
+	return &endpoint, nil
+}
+
+// DeleteEndpoint removes the endpoint and its delivery log. Deliveries still
+// queued find nothing to send.
+func (s *WebhookService) DeleteEndpoint(owner *uint, endpointID uint) error {
+	endpoint, err := s.getEndpoint(owner, endpointID)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("endpoint_id = ?", endpoint.ID).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return fmt.Errorf("failed to delete webhook deliveries: %w", err)
+		}
+		if err := tx.Delete(endpoint).Error; err != nil {
+			return fmt.Errorf("failed to delete webhook: %w", err)
+		}
+		return nil
+	})
+}
+
+// GetDeliveries pages through an endpoint's delivery log, newest first.
+func (s *WebhookService) GetDeliveries(owner *uint, endpointID uint, page, limit int) ([]models.WebhookDelivery, error) {
+	if _, err := s.getEndpoint(owner, endpointID); err != nil {
+		return nil, err
+	}
+
+	if page <= 0 {
+		page = 1
+	}
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	var deliveries []models.WebhookDelivery
+	if err := s.db.Where("endpoint_id = ?", endpointID).
+		Order("created_at DESC, id DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&deliveries).Error; err != nil {
+		return nil, fmt.Errorf("failed to get webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
+
+// ReplayDelivery sends a logged event again as a new delivery, signed afresh.
+func (s *WebhookService) ReplayDelivery(owner *uint, endpointID, deliveryID uint) (*models.WebhookDelivery, error) {
+	if _, err := s.getEndpoint(owner, endpointID); err != nil {
+		return nil, err
+	}
+
+	var original models.WebhookDelivery
+	if err := s.db.Where("id = ? AND endpoint_id = ?", deliveryID, endpointID).First(&original).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrWebhookDeliveryNotFound
+		}
+		return nil, fmt.Errorf("failed to fetch webhook delivery: %w", err)
+	}
+
+	replay := models.WebhookDelivery{
+		EndpointID: original.EndpointID,
+		EventID:    original.EventID,
+		Event:      original.Event,
+		Payload:    original.Payload,
+		Status:     models.WebhookDeliveryPending,
+		ReplayOf:   &original.ID,
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&replay).Error; err != nil {
+			return fmt.Errorf("failed to queue webhook replay: %w", err)
+		}
+		return tasks.Enqueue(tx, TaskDeliverWebhook, recordTaskPayload{ID: replay.ID},
+			tasks.MaxAttempts(maxWebhookAttempts))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &replay, nil
+}
+
+// eventTask queues the deliveries for an event recorded by recordEvent.
+func (s *WebhookService) eventTask(ctx context.Context, task *models.Task) error {
+	event, err := decodeEventTask(task)
+	if err != nil {
+		return err
+	}
+	return s.Enqueue(event)
+}
+
+// Enqueue records a delivery, with its task, for every endpoint subscribed to
+// the event. App endpoints hear about everything but messages and payments,
+// user endpoints about events addressed to their owner, and both about
+// broadcast listings.
+func (s *WebhookService) Enqueue(event events.Event) error {
+	query := s.db.Select("webhook_endpoints.*").
+		Joins("LEFT JOIN users ON users.id = webhook_endpoints.user_id").
+		Where("webhook_endpoints.user_id IS NULL OR (users.is_active = ? AND users.deleted_at IS NULL)", true)
+	switch {
+	case slices.Contains(privateWebhookEvents, event.Type):
+		query = query.Where("webhook_endpoints.user_id IN ?", event.UserIDs)
+	case !event.Type.Broadcast():
+		query = query.Where("webhook_endpoints.user_id IS NULL OR webhook_endpoints.user_id IN ?", event.UserIDs)
+	}
+
+	var endpoints []models.WebhookEndpoint
+	if err := query.Find(&endpoints).Error; err != nil {
+		return fmt.Errorf("failed to find webhooks: %w", err)
+	}
+
+	var subscribed []models.WebhookEndpoint
+	for _, endpoint := range endpoints {
+		if webhookWants(&endpoint, event) {
+			subscribed = append(subscribed, endpoint)
+		}
+	}
+	if len(subscribed) == 0 {
+		return nil
+	}
+
+	eventID, payload, err := newWebhookPayload(event)
+	if err != nil {
+		return err
+	}
+
+	deliveries := make([]models.WebhookDelivery, len(subscribed))
+	for i, endpoint := range subscribed {
+		deliveries[i] = models.WebhookDelivery{
+			EndpointID: endpoint.ID,
+			EventID:    eventID,
+			Event:      string(event.Type),
+			Payload:    string(payload),
+			Status:     models.WebhookDeliveryPending,
+		}
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&deliveries).Error; err != nil {
+			return fmt.Errorf("failed to queue webhook deliveries: %w", err)
+		}
+		for _, delivery := range deliveries {
+			if err := tasks.Enqueue(tx, TaskDeliverWebhook, recordTaskPayload{ID: delivery.ID},
+				tasks.MaxAttempts(maxWebhookAttempts)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// webhookWants applies the endpoint's filters. The zip code filter only
+// narrows broadcast listings; events addressed to the owner always match it.
+func webhookWants(endpoint *models.WebhookEndpoint, event events.Event) bool {
+	if !slices.Contains(endpoint.Events, string(event.Type)) {
+		return false
+	}
+	if len(endpoint.ZipCodes) == 0 || !event.Type.Broadcast() {
+		return true
+	}
+	data, _ := event.Data.(map[string]interface{})
+	zipCode, _ := data["zip_code"].(string)
+	return slices.Contains(endpoint.ZipCodes, zipCode)
+}
+
+func newWebhookPayload(event events.Event) (string, []byte, error) {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return "", nil, fmt.Errorf("failed to generate event id: %w", err)
+	}
+	eventID := "evt_" + hex.EncodeToString(id)
+
+	payload, err := json.Marshal(WebhookEvent{
+		ID:        eventID,
+		Type:      event.Type,
+		CreatedAt: event.OccurredAt.UTC(),
+		Data:      event.Data,
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+	return eventID, payload, nil
+}
+
+// deliverTask posts one queued delivery. Failures are returned so the queue
+// retries them with backoff; the delivery is marked failed once it gives up.
+func (s *WebhookService) deliverTask(ctx context.Context, task *models.Task) error {
+	var delivery models.WebhookDelivery
+	found, err := loadTaskRecord(s.db, task, &delivery)
+	if err != nil || !found {
+		return err
+	}
+	if delivery.Status != models.WebhookDeliveryPending {
+		return nil
+	}
+
+	var endpoint models.WebhookEndpoint
+	if err := s.db.Where("id = ?", delivery.EndpointID).First(&endpoint).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to fetch webhook: %w", err)
+	}
+
+	responseStatus, responseBody, sendErr := s.send(ctx, &endpoint, &delivery)
+
+	updates := map[string]interface{}{
+		"attempts":        delivery.Attempts + 1,
+		"response_status": responseStatus,
+		"response_body":   responseBody,
+	}
+	switch {
+	case sendErr == nil:
+		updates["status"] = models.WebhookDeliverySucceeded
+		updates["delivered_at"] = s.now()
+		updates["last_error"] = ""
+	case task.IsFinalAttempt() || tasks.IsPermanent(sendErr):
+		updates["status"] = models.WebhookDeliveryFailed
+		updates["last_error"] = sendErr.Error()
+	default:
+		updates["last_error"] = sendErr.Error()
+	}
+
+	if err := s.db.Model(&delivery).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+
+	return sendErr
+}
+
+// send signs and posts the payload. Any status outside 2xx is a failure.
+// The URL is kept off the span because it may carry a receiver's token.
+func (s *WebhookService) send(ctx context.Context, endpoint *models.WebhookEndpoint, delivery *models.WebhookDelivery) (int, string, error) {
+	ctx, span := tracing.StartClient(ctx, metrics.ServiceWebhook, "deliver")
+	start := time.Now()
+
+	status, body, err := func() (int, string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, strings.NewReader(delivery.Payload))
+		if err != nil {
+			return 0, "", tasks.Permanent(fmt.Errorf("invalid webhook request: %w", err))
+		}
+
+		timestamp := s.now().Unix()
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", "Mowsy-Webhooks/1.0")
+		req.Header.Set(WebhookEventHeader, delivery.Event)
+		req.Header.Set(WebhookDeliveryHeader, strconv.FormatUint(uint64(delivery.ID), 10))
+		req.Header.Set(WebhookSignatureHeader, fmt.Sprintf("t=%d,v1=%s",
+			timestamp, SignWebhookPayload(endpoint.Secret, timestamp, []byte(delivery.Payload))))
+
+		resp, err := s.client.Do(req)
+		if errors.Is(err, ErrWebhookAddressNotAllowed) {
+			return 0, "", tasks.Permanent(err)
+		}
+		if err != nil {
+			return 0, "", fmt.Errorf("failed to post webhook: %w", err)
+		}
+		defer resp.Body.Close()
+
+		reply, _ := io.ReadAll(io.LimitReader(resp.Body, webhookResponseLimit))
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return resp.StatusCode, string(reply), fmt.Errorf("webhook endpoint returned status %d", resp.StatusCode)
+		}
+		return resp.StatusCode, string(reply), nil
+	}()
+
+	if status != 0 {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	}
+	metrics.ObserveOutbound(metrics.ServiceWebhook, "deliver", start, err)
+	tracing.End(span, err)
+	return status, body, err
+}
@@ -0,0 +1,442 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mowsy-api/internal/events"
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/tasks"
+	"mowsy-api/internal/testutils"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+func setupWebhookService(t *testing.T) (*WebhookService, *gorm.DB) {
+	db := testutils.SetupTestDB()
+	t.Cleanup(func() { testutils.CleanupTestDB(db) })
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	return &WebhookService{db: db, client: newWebhookClient(), now: func() time.Time { return now }}, db
+}
+
+func createOtherUser(t *testing.T, db *gorm.DB) *models.User {
+	user := &models.User{Email: "other@example.com", FirstName: "Other", LastName: "User", ZipCode: "99999", IsActive: true}
+	require.NoError(t, db.Create(user).Error)
+	return user
+}
+
+func TestWebhookService_CreateEndpoint(t *testing.T) {
+	service, db := setupWebhookService(t)
+	user := testutils.CreateTestUser(db)
+
+	created, err := service.CreateEndpoint(user.ID, CreateWebhookRequest{
+		URL:      "https://hooks.example.com/mowsy",
+		Events:   []events.Type{events.JobPosted, events.RentalApproved, events.JobPosted},
+		ZipCodes: []string{"12345"},
+	})
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(created.Secret, webhookSecretPrefix))
+	assert.Equal(t, models.StringArray{"job.posted", "rental.approved"}, created.Events)
+	require.NotNil(t, created.UserID)
+	assert.Equal(t, user.ID, *created.UserID)
+
+	listed, err := json.Marshal(created.WebhookEndpoint)
+	require.NoError(t, err)
+	assert.NotContains(t, string(listed), created.Secret, "the secret is only shown at creation")
+
+	t.Run("Invalid", func(t *testing.T) {
+		for _, url := range []string{"http://hooks.example.com", "https://localhost/hook", "https://10.0.0.8/hook", "https://user:pw@example.com", "not a url"} {
+			_, err := service.CreateEndpoint(user.ID, CreateWebhookRequest{URL: url, Events: []events.Type{events.JobPosted}})
+			assert.ErrorIs(t, err, ErrInvalidWebhookURL, url)
+		}
+
+		_, err := service.CreateEndpoint(user.ID, CreateWebhookRequest{URL: "https://example.com", Events: []events.Type{"job.exploded"}})
+		assert.ErrorIs(t, err, ErrInvalidWebhookEvent)
+		_, err = service.CreateEndpoint(user.ID, CreateWebhookRequest{URL: "https://example.com", Events: []events.Type{events.JobPosted}, ZipCodes: []string{"1234"}})
+		assert.ErrorIs(t, err, ErrInvalidWebhookZipCode)
+
+		for _, eventType := range []events.Type{events.MessageReceived, events.PaymentSucceeded, events.PaymentFailed} {
+			_, err = service.CreateAppEndpoint(CreateAppWebhookRequest{
+				AppName:              "Dispatch",
+				CreateWebhookRequest: CreateWebhookRequest{URL: "https://dispatch.example.com", Events: []events.Type{eventType}},
+			})
+			assert.ErrorIs(t, err, ErrInvalidWebhookEvent, eventType)
+		}
+	})
+
+	t.Run("OwnersAreSeparate", func(t *testing.T) {
+		app, err := service.CreateAppEndpoint(CreateAppWebhookRequest{
+			AppName:              "Dispatch",
+			CreateWebhookRequest: CreateWebhookRequest{URL: "https://dispatch.example.com", Events: []events.Type{events.JobCompleted}},
+		})
+		require.NoError(t, err)
+		assert.Nil(t, app.UserID)
+
+		mine, err := service.GetEndpoints(&user.ID)
+		require.NoError(t, err)
+		require.Len(t, mine, 1)
+		assert.Equal(t, created.ID, mine[0].ID)
+
+		apps, err := service.GetEndpoints(nil)
+		require.NoError(t, err)
+		require.Len(t, apps, 1)
+		assert.Equal(t, app.ID, apps[0].ID)
+
+		assert.ErrorIs(t, service.DeleteEndpoint(&user.ID, app.ID), ErrWebhookNotFound)
+		assert.ErrorIs(t, service.DeleteEndpoint(nil, created.ID), ErrWebhookNotFound)
+		require.NoError(t, service.DeleteEndpoint(nil, app.ID))
+	})
+}
+
+func TestWebhookService_Enqueue(t *testing.T) {
+	service, db := setupWebhookService(t)
+	user := testutils.CreateTestUser(db)
+	other := createOtherUser(t, db)
+
+	endpoint := func(owner *uint, zipCodes []string, subscribed ...events.Type) uint {
+		list := models.StringArray{}
+		for _, eventType := range subscribed {
+			list = append(list, string(eventType))
+		}
+		record := models.WebhookEndpoint{UserID: owner, URL: "https://example.com", Secret: "whsec_test", Events: list, ZipCodes: zipCodes}
+		require.NoError(t, db.Create(&record).Error)
+		return record.ID
+	}
+	mine := endpoint(&user.ID, []string{"12345"}, events.JobPosted, events.RentalApproved)
+	theirs := endpoint(&other.ID, nil, events.JobPosted, events.RentalApproved)
+	app := endpoint(nil, nil, events.RentalApproved)
+	inbox := endpoint(&user.ID, nil, events.MessageReceived, events.PaymentFailed)
+	endpoint(&other.ID, nil, events.MessageReceived, events.PaymentFailed)
+	// Apps can no longer subscribe to these, but older endpoints might have
+	endpoint(nil, nil, events.MessageReceived, events.PaymentFailed)
+
+	deliveredTo := func(event events.Event) []uint {
+		db.Exec("DELETE FROM webhook_deliveries")
+		db.Exec("DELETE FROM tasks")
+		require.NoError(t, service.Enqueue(event))
+
+		var deliveries []models.WebhookDelivery
+		require.NoError(t, db.Order("endpoint_id").Find(&deliveries).Error)
+		var endpointIDs []uint
+		for _, delivery := range deliveries {
+			endpointIDs = append(endpointIDs, delivery.EndpointID)
+			assert.Equal(t, deliveries[0].EventID, delivery.EventID, "one event ID per event")
+		}
+
+		var queued int64
+		db.Model(&models.Task{}).Where("kind = ?", TaskDeliverWebhook).Count(&queued)
+		assert.Equal(t, int64(len(deliveries)), queued)
+		return endpointIDs
+	}
+
+	t.Run("AddressedEvents", func(t *testing.T) {
+		approved := events.Event{Type: events.RentalApproved, UserIDs: []uint{user.ID}, Data: map[string]interface{}{"rental_id": 7}}
+		assert.Equal(t, []uint{mine, app}, deliveredTo(approved), "the owner and apps, not other users")
+	})
+
+	t.Run("AppsDoNotGetMessagesOrPayments", func(t *testing.T) {
+		message := events.Event{Type: events.MessageReceived, UserIDs: []uint{user.ID}, Data: map[string]interface{}{"body": "gate code is 4521"}}
+		assert.Equal(t, []uint{inbox}, deliveredTo(message), "only the recipient's own endpoint")
+
+		failed := events.Event{Type: events.PaymentFailed, UserIDs: []uint{user.ID}, Data: map[string]interface{}{"payment_id": 3}}
+		assert.Equal(t, []uint{inbox}, deliveredTo(failed))
+	})
+
+	t.Run("BroadcastsAreFilteredByZipCode", func(t *testing.T) {
+		posted := events.Event{Type: events.JobPosted, Data: map[string]interface{}{"job_id": 1, "zip_code": "12345"}}
+		assert.Equal(t, []uint{mine, theirs}, deliveredTo(posted))
+
+		elsewhere := events.Event{Type: events.JobPosted, Data: map[string]interface{}{"job_id": 2, "zip_code": "54321"}}
+		assert.Equal(t, []uint{theirs}, deliveredTo(elsewhere))
+	})
+
+	t.Run("DeactivatedOwner", func(t *testing.T) {
+		require.NoError(t, db.Model(other).Update("is_active", false).Error)
+		defer db.Model(other).Update("is_active", true)
+
+		posted := events.Event{Type: events.JobPosted, Data: map[string]interface{}{"zip_code": "54321"}}
+		assert.Empty(t, deliveredTo(posted))
+	})
+
+	t.Run("Payload", func(t *testing.T) {
+		occurred := time.Date(2024, 6, 1, 9, 30, 0, 0, time.UTC)
+		deliveredTo(events.Event{Type: events.RentalApproved, UserIDs: []uint{user.ID}, OccurredAt: occurred,
+			Data: map[string]interface{}{"rental_id": 7}})
+
+		var delivery models.WebhookDelivery
+		require.NoError(t, db.First(&delivery).Error)
+		var body WebhookEvent
+		require.NoError(t, json.Unmarshal([]byte(delivery.Payload), &body))
+		assert.Equal(t, delivery.EventID, body.ID)
+		assert.True(t, strings.HasPrefix(body.ID, "evt_"))
+		assert.Equal(t, events.RentalApproved, body.Type)
+		assert.True(t, occurred.Equal(body.CreatedAt))
+		assert.Equal(t, map[string]interface{}{"rental_id": float64(7)}, body.Data)
+	})
+}
+
+func TestWebhookService_ListingsAnnouncedWhereTheyAre(t *testing.T) {
+	db := testutils.SetupTestDB()
+	defer testutils.CleanupTestDB(db)
+	services := NewContainer(Dependencies{DB: db, Geocoder: NewStaticGeocoder(springfieldFixtures)})
+	queue := tasks.NewQueue(db)
+	queue.Register(TaskGeocodeJob, services.Jobs.geocodeJobTask)
+	queue.Register(TaskGeocodeEquipment, services.Equipment.geocodeEquipmentTask)
+	queue.Register(TaskDispatchWebhooks, services.Webhooks.eventTask)
+
+	poster := testutils.CreateTestUser(db)
+	subscriber := createOtherUser(t, db)
+	endpoint := func(zipCode string) uint {
+		record := models.WebhookEndpoint{UserID: &subscriber.ID, URL: "https://example.com", Secret: "whsec_test",
+			Events: models.StringArray{string(events.JobPosted), string(events.EquipmentListed)}, ZipCodes: models.StringArray{zipCode}}
+		require.NoError(t, db.Create(&record).Error)
+		return record.ID
+	}
+	home := endpoint(poster.ZipCode)
+	springfield := endpoint("62701")
+
+	// Runs the queue until the listing's event has been dispatched, and
+	// returns the endpoints it was delivered to.
+	deliveredTo := func(t *testing.T) []uint {
+		for i := 0; i < 2; i++ {
+			_, err := queue.RunPending(context.Background(), 10)
+			require.NoError(t, err)
+		}
+		var endpointIDs []uint
+		require.NoError(t, db.Model(&models.WebhookDelivery{}).Order("endpoint_id").Pluck("endpoint_id", &endpointIDs).Error)
+		db.Exec("DELETE FROM webhook_deliveries")
+		db.Exec("DELETE FROM tasks")
+		return endpointIDs
+	}
+
+	t.Run("JobAtAnotherAddress", func(t *testing.T) {
+		_, err := services.Jobs.CreateJob(context.Background(), poster.ID, CreateJobRequest{
+			Title:      "Mow the rental",
+			Category:   models.JobCategoryMowing,
+			FixedPrice: 40,
+			Address:    "1 Elm St., Springfield IL",
+			Visibility: models.VisibilityZipCode,
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []uint{springfield}, deliveredTo(t))
+	})
+
+	t.Run("JobWithoutAddress", func(t *testing.T) {
+		_, err := services.Jobs.CreateJob(context.Background(), poster.ID, CreateJobRequest{
+			Title:      "Mow my lawn",
+			Category:   models.JobCategoryMowing,
+			FixedPrice: 40,
+			Visibility: models.VisibilityZipCode,
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []uint{home}, deliveredTo(t))
+	})
+
+	t.Run("EquipmentAtAnotherAddress", func(t *testing.T) {
+		_, err := services.Equipment.CreateEquipment(poster.ID, CreateEquipmentRequest{
+			Name:             "Mower",
+			Category:         models.EquipmentCategoryMower,
+			DailyRentalPrice: 20,
+			Address:          "1 Elm St., Springfield IL",
+			Visibility:       models.VisibilityZipCode,
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []uint{springfield}, deliveredTo(t))
+	})
+
+	t.Run("UnresolvedAddressFallsBackToOwner", func(t *testing.T) {
+		_, err := services.Jobs.CreateJob(context.Background(), poster.ID, CreateJobRequest{
+			Title:      "Mow somewhere",
+			Category:   models.JobCategoryMowing,
+			FixedPrice: 40,
+			Address:    "nowhere in particular",
+			Visibility: models.VisibilityZipCode,
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, []uint{home}, deliveredTo(t))
+	})
+}
+
+type webhookReceiver struct {
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   []string
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, _ := io.ReadAll(req.Body)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests = append(r.requests, req)
+	r.bodies = append(r.bodies, string(body))
+	w.WriteHeader(r.status)
+	fmt.Fprint(w, `{"ok":true}`)
+}
+
+func TestWebhookService_Deliver(t *testing.T) {
+	service, db := setupWebhookService(t)
+	user := testutils.CreateTestUser(db)
+
+	receiver := &webhookReceiver{status: http.StatusOK}
+	server := httptest.NewTLSServer(receiver)
+	defer server.Close()
+	service.client = server.Client()
+
+	endpoint := models.WebhookEndpoint{UserID: &user.ID, URL: server.URL + "/hook", Secret: "whsec_test",
+		Events: models.StringArray{string(events.RentalApproved)}}
+	require.NoError(t, db.Create(&endpoint).Error)
+
+	queue := tasks.NewQueue(db)
+	queue.Register(TaskDeliverWebhook, service.deliverTask)
+	approved := events.Event{Type: events.RentalApproved, UserIDs: []uint{user.ID}, Data: map[string]interface{}{"rental_id": 7}}
+
+	reset := func() {
+		db.Exec("DELETE FROM webhook_deliveries")
+		db.Exec("DELETE FROM tasks")
+		receiver.requests, receiver.bodies = nil, nil
+		receiver.status = http.StatusOK
+	}
+
+	t.Run("SignedDelivery", func(t *testing.T) {
+		defer reset()
+		require.NoError(t, service.Enqueue(approved))
+
+		processed, err := queue.RunPending(context.Background(), 10)
+		require.NoError(t, err)
+		assert.Equal(t, 1, processed)
+		require.Len(t, receiver.requests, 1)
+
+		var delivery models.WebhookDelivery
+		require.NoError(t, db.First(&delivery).Error)
+		assert.Equal(t, models.WebhookDeliverySucceeded, delivery.Status)
+		assert.Equal(t, 1, delivery.Attempts)
+		assert.Equal(t, http.StatusOK, delivery.ResponseStatus)
+		assert.Equal(t, `{"ok":true}`, delivery.ResponseBody)
+		assert.NotNil(t, delivery.DeliveredAt)
+
+		req := receiver.requests[0]
+		assert.Equal(t, delivery.Payload, receiver.bodies[0])
+		assert.Equal(t, "rental.approved", req.Header.Get(WebhookEventHeader))
+		assert.Equal(t, fmt.Sprint(delivery.ID), req.Header.Get(WebhookDeliveryHeader))
+		timestamp := service.now().Unix()
+		expected := fmt.Sprintf("t=%d,v1=%s", timestamp, SignWebhookPayload("whsec_test", timestamp, []byte(receiver.bodies[0])))
+		assert.Equal(t, expected, req.Header.Get(WebhookSignatureHeader))
+		assert.NotEqual(t, SignWebhookPayload("whsec_other", timestamp, []byte(receiver.bodies[0])), SignWebhookPayload("whsec_test", timestamp, []byte(receiver.bodies[0])))
+	})
+
+	t.Run("FailureIsRetriedWithBackoff", func(t *testing.T) {
+		defer reset()
+		receiver.status = http.StatusServiceUnavailable
+		require.NoError(t, service.Enqueue(approved))
+
+		_, err := queue.RunPending(context.Background(), 10)
+		require.NoError(t, err)
+
+		var delivery models.WebhookDelivery
+		require.NoError(t, db.First(&delivery).Error)
+		assert.Equal(t, models.WebhookDeliveryPending, delivery.Status)
+		assert.Equal(t, 1, delivery.Attempts)
+		assert.Equal(t, http.StatusServiceUnavailable, delivery.ResponseStatus)
+		assert.Contains(t, delivery.LastError, "status 503")
+
+		var task models.Task
+		require.NoError(t, db.First(&task).Error)
+		assert.Equal(t, models.TaskStatusPending, task.Status)
+		assert.Equal(t, maxWebhookAttempts, task.MaxAttempts)
+		assert.True(t, task.RunAt.After(time.Now()), "the retry waits")
+	})
+
+	t.Run("FinalAttemptMarksFailed", func(t *testing.T) {
+		defer reset()
+		receiver.status = http.StatusInternalServerError
+		delivery := models.WebhookDelivery{EndpointID: endpoint.ID, EventID: "evt_1", Event: "rental.approved", Payload: "{}", Status: models.WebhookDeliveryPending}
+		require.NoError(t, db.Create(&delivery).Error)
+
+		err := service.deliverTask(context.Background(), &models.Task{
+			Kind:        TaskDeliverWebhook,
+			Payload:     fmt.Sprintf(`{"id":%d}`, delivery.ID),
+			Attempts:    maxWebhookAttempts,
+			MaxAttempts: maxWebhookAttempts,
+		})
+		require.Error(t, err)
+
+		require.NoError(t, db.First(&delivery, delivery.ID).Error)
+		assert.Equal(t, models.WebhookDeliveryFailed, delivery.Status)
+	})
+
+	t.Run("PrivateAddressesAreRefused", func(t *testing.T) {
+		defer reset()
+		service.client = newWebhookClient()
+		defer func() { service.client = server.Client() }()
+
+		delivery := models.WebhookDelivery{EndpointID: endpoint.ID, EventID: "evt_1", Event: "rental.approved", Payload: "{}", Status: models.WebhookDeliveryPending}
+		require.NoError(t, db.Create(&delivery).Error)
+
+		err := service.deliverTask(context.Background(), &models.Task{
+			Kind: TaskDeliverWebhook, Payload: fmt.Sprintf(`{"id":%d}`, delivery.ID), Attempts: 1, MaxAttempts: maxWebhookAttempts,
+		})
+		assert.ErrorIs(t, err, ErrWebhookAddressNotAllowed)
+		assert.True(t, tasks.IsPermanent(err))
+		assert.Empty(t, receiver.requests)
+
+		require.NoError(t, db.First(&delivery, delivery.ID).Error)
+		assert.Equal(t, models.WebhookDeliveryFailed, delivery.Status)
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		defer reset()
+		original := models.WebhookDelivery{EndpointID: endpoint.ID, EventID: "evt_1", Event: "rental.approved", Payload: `{"id":"evt_1"}`,
+			Status: models.WebhookDeliveryFailed, Attempts: maxWebhookAttempts}
+		require.NoError(t, db.Create(&original).Error)
+
+		other := createOtherUser(t, db)
+		_, err := service.ReplayDelivery(&other.ID, endpoint.ID, original.ID)
+		assert.ErrorIs(t, err, ErrWebhookNotFound, "only the owner can replay")
+		_, err = service.ReplayDelivery(&user.ID, endpoint.ID, original.ID+100)
+		assert.ErrorIs(t, err, ErrWebhookDeliveryNotFound)
+
+		replay, err := service.ReplayDelivery(&user.ID, endpoint.ID, original.ID)
+		require.NoError(t, err)
+		assert.Equal(t, "evt_1", replay.EventID)
+		require.NotNil(t, replay.ReplayOf)
+		assert.Equal(t, original.ID, *replay.ReplayOf)
+
+		_, err = queue.RunPending(context.Background(), 10)
+		require.NoError(t, err)
+		require.Len(t, receiver.bodies, 1)
+		assert.Equal(t, `{"id":"evt_1"}`, receiver.bodies[0])
+
+		deliveries, err := service.GetDeliveries(&user.ID, endpoint.ID, 1, 20)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 2)
+		assert.Equal(t, replay.ID, deliveries[0].ID)
+		assert.Equal(t, models.WebhookDeliverySucceeded, deliveries[0].Status)
+		assert.Equal(t, models.WebhookDeliveryFailed, deliveries[1].Status)
+	})
+
+	t.Run("DeletedEndpoint", func(t *testing.T) {
+		defer reset()
+		require.NoError(t, service.Enqueue(approved))
+		require.NoError(t, service.DeleteEndpoint(&user.ID, endpoint.ID))
+
+		_, err := queue.RunPending(context.Background(), 10)
+		require.NoError(t, err)
+		assert.Empty(t, receiver.requests)
+	})
+}
@@ -0,0 +1,323 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/pkg/logging"
+	"mowsy-api/pkg/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"gorm.io/gorm"
+)
+
+const (
+	DefaultMaxAttempts = 8
+
+	defaultBaseBackoff = 30 * time.Second
+	defaultMaxBackoff  = time.Hour
+	defaultLease       = 5 * time.Minute
+)
+
+// Handler runs one task. Returning an error schedules a retry unless the error
+// is wrapped with Permanent or the task is on its final attempt.
+type Handler func(ctx context.Context, task *models.Task) error
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks an error as not worth retrying; the task is dead-lettered
+// immediately.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent reports whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
+
+type Option func(*models.Task)
+
+// RunAt delays the first attempt until t.
+func RunAt(t time.Time) Option {
+	return func(task *models.Task) {
+		task.RunAt = t
+	}
+}
+
+// MaxAttempts overrides DefaultMaxAttempts.
+func MaxAttempts(n int) Option {
+	return func(task *models.Task) {
+		task.MaxAttempts = n
+	}
+}
+
+// Enqueue inserts a task using db. Pass the transaction that writes the
+// triggering change so the task only exists if that change commits.
+func Enqueue(db *gorm.DB, kind string, payload interface{}, opts ...Option) error {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s task payload: %w", kind, err)
+	}
+
+	task := models.Task{
+		Kind:        kind,
+		Payload:     string(encoded),
+		Status:      models.TaskStatusPending,
+		MaxAttempts: DefaultMaxAttempts,
+		RunAt:       time.Now(),
+	}
+	for _, opt := range opts {
+		opt(&task)
+	}
+
+	if err := db.Create(&task).Error; err != nil {
+		return fmt.Errorf("failed to enqueue %s task: %w", kind, err)
+	}
+	return nil
+}
+
+// Decode unmarshals a task's payload into v.
+func Decode(task *models.Task, v interface{}) error {
+	if err := json.Unmarshal([]byte(task.Payload), v); err != nil {
+		return Permanent(fmt.Errorf("failed to decode %s task payload: %w", task.Kind, err))
+	}
+	return nil
+}
+
+// Queue claims due tasks from the tasks table and runs their handlers.
+type Queue struct {
+	db          *gorm.DB
+	now         func() time.Time
+	baseBackoff time.Duration
+	maxBackoff  time.Duration
+	lease       time.Duration
+
+	mu       sync.RWMutex
+	handlers map[string]Handler
+}
+
+func NewQueue(db *gorm.DB) *Queue {
+	return &Queue{
+		db:          db,
+		now:         time.Now,
+		baseBackoff: defaultBaseBackoff,
+		maxBackoff:  defaultMaxBackoff,
+		lease:       defaultLease,
+		handlers:    make(map[string]Handler),
+	}
+}
+
+func (q *Queue) Register(kind string, handler Handler) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handlers[kind] = handler
+}
+
+func (q *Queue) handler(kind string) (Handler, bool) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	handler, ok := q.handlers[kind]
+	return handler, ok
+}
+
+// RunPending runs up to limit due tasks and returns how many it processed,
+// whether or not they succeeded.
+func (q *Queue) RunPending(ctx context.Context, limit int) (int, error) {
+	now := q.now()
+
+	var due []models.Task
+	if err := q.db.Where("status IN ? AND run_at <= ?",
+		[]models.TaskStatus{models.TaskStatusPending, models.TaskStatusRunning}, now).
+		Order("run_at ASC").
+		Limit(limit).
+		Find(&due).Error; err != nil {
+		return 0, fmt.Errorf("failed to fetch due tasks: %w", err)
+	}
+
+	processed := 0
+	for i := range due {
+		if ctx.Err() != nil {
+			break
+		}
+
+		task := &due[i]
+		claimed, err := q.claim(task)
+		if err != nil {
+			return processed, err
+		}
+		if !claimed {
+			continue
+		}
+
+		taskCtx := logging.With(ctx, "task_id", task.ID, "task_kind", task.Kind)
+		taskCtx, span := tracing.Start(taskCtx, "task "+task.Kind,
+			attribute.Int64("task.id", int64(task.ID)),
+			attribute.Int("task.attempt", task.Attempts))
+		runErr := q.execute(taskCtx, task)
+		tracing.End(span, runErr)
+
+		if err := q.finish(taskCtx, task, runErr); err != nil {
+			return processed, err
+		}
+		processed++
+	}
+
+	return processed, nil
+}
+
+// claim takes a lease on the task, counted from the moment it is claimed
+// rather than from the start of the batch. Pushing run_at past now means a
+// second worker racing for the same row no longer matches the update, and
+// the attempt number it bumps identifies this lease to finish.
+func (q *Queue) claim(task *models.Task) (bool, error) {
+	now := q.now()
+	result := q.db.Model(&models.Task{}).
+		Where("id = ? AND status IN ? AND run_at <= ? AND attempts = ?", task.ID,
+			[]models.TaskStatus{models.TaskStatusPending, models.TaskStatusRunning}, now, task.Attempts).
+		Updates(map[string]interface{}{
+			"status":   models.TaskStatusRunning,
+			"attempts": gorm.Expr("attempts + 1"),
+			"run_at":   now.Add(q.lease),
+		})
+	if result.Error != nil {
+		return false, fmt.Errorf("failed to claim task: %w", result.Error)
+	}
+	if result.RowsAffected != 1 {
+		return false, nil
+	}
+
+	task.Status = models.TaskStatusRunning
+	task.Attempts++
+	return true, nil
+}
+
+func (q *Queue) execute(ctx context.Context, task *models.Task) (err error) {
+	handler, ok := q.handler(task.Kind)
+	if !ok {
+		return Permanent(fmt.Errorf("no handler registered for task kind %q", task.Kind))
+	}
+
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("task handler panicked: %v", recovered)
+		}
+	}()
+
+	return handler(ctx, task)
+}
+
+// finish records the outcome of a run. If the lease expired and another
+// worker has claimed the task since, its attempt number no longer matches
+// and the result is dropped rather than overwriting the newer run's.
+func (q *Queue) finish(ctx context.Context, task *models.Task, runErr error) error {
+	now := q.now()
+	updates := map[string]interface{}{}
+
+	switch {
+	case runErr == nil:
+		updates["status"] = models.TaskStatusSucceeded
+		updates["completed_at"] = now
+		updates["last_error"] = ""
+	case IsPermanent(runErr) || task.IsFinalAttempt():
+		updates["status"] = models.TaskStatusDead
+		updates["completed_at"] = now
+		updates["last_error"] = runErr.Error()
+		logging.FromContext(ctx).Error("task dead-lettered", "attempts", task.Attempts, "error", runErr)
+	default:
+		updates["status"] = models.TaskStatusPending
+		updates["run_at"] = now.Add(q.backoff(task.Attempts))
+		updates["last_error"] = runErr.Error()
+	}
+
+	result := q.db.Model(&models.Task{}).
+		Where("id = ? AND status = ? AND attempts = ?", task.ID, models.TaskStatusRunning, task.Attempts).
+		Updates(updates)
+	if result.Error != nil {
+		return fmt.Errorf("failed to record task result: %w", result.Error)
+	}
+	if result.RowsAffected != 1 {
+		logging.FromContext(ctx).Warn("task lease lost, result discarded", "attempts", task.Attempts, "error", runErr)
+	}
+	return nil
+}
+
+// backoff doubles the delay after each failed attempt, capped at maxBackoff.
+func (q *Queue) backoff(attempts int) time.Duration {
+	delay := q.baseBackoff
+	for i := 1; i < attempts && delay < q.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > q.maxBackoff {
+		delay = q.maxBackoff
+	}
+	return delay
+}
+
+// Drain keeps running batches until nothing is due or ctx is done. The Lambda
+// worker uses it to empty the queue on each scheduled invocation.
+func (q *Queue) Drain(ctx context.Context, batchSize int) (int, error) {
+	total := 0
+	for ctx.Err() == nil {
+		processed, err := q.RunPending(ctx, batchSize)
+		total += processed
+		if err != nil {
+			return total, err
+		}
+		if processed == 0 {
+			break
+		}
+	}
+	return total, nil
+}
+
+// Run polls for due tasks every interval until ctx is cancelled.
+func (q *Queue) Run(ctx context.Context, interval time.Duration, batchSize int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := q.Drain(ctx, batchSize); err != nil {
+			logging.FromContext(ctx).Error("task queue run failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Retry moves a dead task back to pending with a fresh set of attempts.
+func (q *Queue) Retry(taskID uint) error {
+	result := q.db.Model(&models.Task{}).
+		Where("id = ? AND status = ?", taskID, models.TaskStatusDead).
+		Updates(map[string]interface{}{
+			"status":       models.TaskStatusPending,
+			"attempts":     0,
+			"run_at":       q.now(),
+			"completed_at": nil,
+		})
+	if result.Error != nil {
+		return fmt.Errorf("failed to retry task: %w", result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("task not found or not dead-lettered")
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"mowsy-api/internal/models"
+	"mowsy-api/internal/testutils"
+	"mowsy-api/pkg/tracing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+type testPayload struct {
+	ID uint `json:"id"`
+}
+
+func setupQueue() (*Queue, *gorm.DB, *time.Time) {
+	db := testutils.SetupTestDB()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	queue := NewQueue(db)
+	queue.now = func() time.Time { return now }
+	return queue, db, &now
+}
+
+func loadTask(t *testing.T, db *gorm.DB) models.Task {
+	var task models.Task
+	require.NoError(t, db.First(&task).Error)
+	return task
+}
+
+func TestQueue_RunPending(t *testing.T) {
+	t.Run("RunsHandlerWithPayload", func(t *testing.T) {
+		queue, db, now := setupQueue()
+		defer testutils.CleanupTestDB(db)
+
+		var got testPayload
+		queue.Register("test.ok", func(ctx context.Context, task *models.Task) error {
+			return Decode(task, &got)
+		})
+		require.NoError(t, Enqueue(db, "test.ok", testPayload{ID: 42}, RunAt(*now)))
+
+		processed, err := queue.RunPending(context.Background(), 10)
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, processed)
+		assert.Equal(t, uint(42), got.ID)
+
+		task := loadTask(t, db)
+		assert.Equal(t, models.TaskStatusSucceeded, task.Status)
+		assert.Equal(t, 1, task.Attempts)
+		assert.NotNil(t, task.CompletedAt)
+	})
+
+	t.Run("RunsHandlerUnderASpan", func(t *testing.T) {
+		exporter, restore := tracing.InMemory()
+		defer restore()
+		queue, db, now := setupQueue()
+		defer testutils.CleanupTestDB(db)
+
+		var handlerSpan trace.SpanContext
+		queue.Register("test.traced", func(ctx context.Context, task *models.Task) error {
+			handlerSpan = trace.SpanContextFromContext(ctx)
+			return errors.New("provider unavailable")
+		})
+		require.NoError(t, Enqueue(db, "test.traced", nil, RunAt(*now)))
+
+		_, err := queue.RunPending(context.Background(), 10)
+		require.NoError(t, err)
+
+		spans := exporter.GetSpans()
+		require.Len(t, spans, 1)
+		assert.Equal(t, "task test.traced", spans[0].Name)
+		assert.Equal(t, spans[0].SpanContext.SpanID(), handlerSpan.SpanID())
+		assert.Equal(t, codes.Error, spans[0].Status.Code)
+	})
+
+	t.Run("RetriesWithExponentialBackoffThenDeadLetters", func(t *testing.T) {
+		queue, db, now := setupQueue()
+		defer testutils.CleanupTestDB(db)
+
+		queue.Register("test.flaky", func(ctx context.Context, task *models.Task) error {
+			return errors.New("provider unavailable")
+		})
+		require.NoError(t, Enqueue(db, "test.flaky", nil, RunAt(*now), MaxAttempts(3)))
+
+		expectedDelays := []time.Duration{30 * time.Second, time.Minute}
+		for _, delay := range expectedDelays {
+			_, err := queue.RunPending(context.Background(), 10)
+			require.NoError(t, err)
+
+			task := loadTask(t, db)
+			assert.Equal(t, models.TaskStatusPending, task.Status)
+			assert.Equal(t, "provider unavailable", task.LastError)
+			assert.True(t, task.RunAt.Equal(now.Add(delay)), "expected retry after %s", delay)
+
+			// Not due yet
+			processed, err := queue.RunPending(context.Background(), 10)
+			require.NoError(t, err)
+			assert.Zero(t, processed)
+
+			*now = task.RunAt
+		}
+
+		_, err := queue.RunPending(context.Background(), 10)
+		require.NoError(t, err)
+
+		task := loadTask(t, db)
+		assert.Equal(t, models.TaskStatusDead, task.Status)
+		assert.Equal(t, 3, task.Attempts)
+	})
+
+	t.Run("PermanentErrorDeadLettersImmediately", func(t *testing.T) {
+		queue, db, now := setupQueue()
+		defer testutils.CleanupTestDB(db)
+
+		queue.Register("test.bad", func(ctx context.Context, task *models.Task) error {
+			return Permanent(errors.New("record deleted"))
+		})
+		require.NoError(t, Enqueue(db, "test.bad", nil, RunAt(*now)))
+
+		_, err := queue.RunPending(context.Background(), 10)
+		require.NoError(t, err)
+
+		task := loadTask(t, db)
+		assert.Equal(t, models.TaskStatusDead, task.Status)
+		assert.Equal(t, 1, task.Attempts)
+	})
+
+	t.Run("UnknownKindDeadLetters", func(t *testing.T) {
+		queue, db, now := setupQueue()
+		defer testutils.CleanupTestDB(db)
+
+		require.NoError(t, Enqueue(db, "test.unknown", nil, RunAt(*now)))
+
+		_, err := queue.RunPending(context.Background(), 10)
+		require.NoError(t, err)
+
+		task := loadTask(t, db)
+		assert.Equal(t, models.TaskStatusDead, task.Status)
+		assert.Contains(t, task.LastError, "no handler registered")
+	})
+
+	t.Run("PanicIsRetried", func(t *testing.T) {
+		queue, db, now := setupQueue()
+		defer testutils.CleanupTestDB(db)
+
+		queue.Register("test.panic", func(ctx context.Context, task *models.Task) error {
+			panic("nil map")
+		})
+		require.NoError(t, Enqueue(db, "test.panic", nil, RunAt(*now)))
+
+		_, err := queue.RunPending(context.Background(), 10)
+		require.NoError(t, err)
+
+		task := loadTask(t, db)
+		assert.Equal(t, models.TaskStatusPending, task.Status)
+		assert.Contains(t, task.LastError, "panicked")
+	})
+
+	t.Run("ReclaimsExpiredLease", func(t *testing.T) {
+		queue, db, now := setupQueue()
+		defer testutils.CleanupTestDB(db)
+
+		ran := 0
+		queue.Register("test.ok", func(ctx context.Context, task *models.Task) error {
+			ran++
+			return nil
+		})
+		require.NoError(t, db.Create(&models.Task{
+			Kind:        "test.ok",
+			Payload:     "{}",
+			Status:      models.TaskStatusRunning,
+			Attempts:    1,
+			MaxAttempts: DefaultMaxAttempts,
+			RunAt:       now.Add(-time.Second),
+		}).Error)
+		require.NoError(t, db.Create(&models.Task{
+			Kind:        "test.ok",
+			Payload:     "{}",
+			Status:      models.TaskStatusRunning,
+			Attempts:    1,
+			MaxAttempts: DefaultMaxAttempts,
+			RunAt:       now.Add(time.Minute),
+		}).Error)
+
+		processed, err := queue.RunPending(context.Background(), 10)
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, processed)
+		assert.Equal(t, 1, ran)
+	})
+
+	t.Run("LeaseStartsWhenClaimed", func(t *testing.T) {
+		queue, db, now := setupQueue()
+		defer testutils.CleanupTestDB(db)
+
+		var leases []time.Time
+		queue.Register("test.slow", func(ctx context.Context, task *models.Task) error {
+			var claimed models.Task
+			require.NoError(t, db.First(&claimed, task.ID).Error)
+			leases = append(leases, claimed.RunAt)
+			*now = now.Add(4 * time.Minute)
+			return nil
+		})
+		start := *now
+		require.NoError(t, Enqueue(db, "test.slow", nil, RunAt(start)))
+		require.NoError(t, Enqueue(db, "test.slow", nil, RunAt(start)))
+
+		_, err := queue.RunPending(context.Background(), 10)
+
+		require.NoError(t, err)
+		require.Len(t, leases, 2)
+		assert.True(t, leases[0].Equal(start.Add(defaultLease)))
+		assert.True(t, leases[1].Equal(start.Add(4*time.Minute+defaultLease)))
+	})
+
+	t.Run("LostLeaseDoesNotOverwriteResult", func(t *testing.T) {
+		queue, db, now := setupQueue()
+		defer testutils.CleanupTestDB(db)
+
+		// The first run outlives its lease; a second worker reclaims the
+		// task and finishes it before the first run returns.
+		runs := 0
+		queue.Register("test.stuck", func(ctx context.Context, task *models.Task) error {
+			runs++
+			if runs > 1 {
+				return nil
+			}
+			*now = now.Add(defaultLease + time.Second)
+			processed, err := queue.RunPending(ctx, 10)
+			require.NoError(t, err)
+			require.Equal(t, 1, processed)
+			return errors.New("timed out")
+		})
+		require.NoError(t, Enqueue(db, "test.stuck", nil, RunAt(*now)))
+
+		_, err := queue.RunPending(context.Background(), 10)
+
+		require.NoError(t, err)
+		assert.Equal(t, 2, runs)
+		task := loadTask(t, db)
+		assert.Equal(t, models.TaskStatusSucceeded, task.Status)
+		assert.Equal(t, 2, task.Attempts)
+		assert.Empty(t, task.LastError)
+	})
+}
+
+func TestQueue_Drain(t *testing.T) {
+	queue, db, now := setupQueue()
+	defer testutils.CleanupTestDB(db)
+
+	queue.Register("test.ok", func(ctx context.Context, task *models.Task) error { return nil })
+	for i := 0; i < 5; i++ {
+		require.NoError(t, Enqueue(db, "test.ok", testPayload{ID: uint(i)}, RunAt(*now)))
+	}
+
+	processed, err := queue.Drain(context.Background(), 2)
+
+	require.NoError(t, err)
+	assert.Equal(t, 5, processed)
+}
+
+func TestQueue_Retry(t *testing.T) {
+	queue, db, now := setupQueue()
+	defer testutils.CleanupTestDB(db)
+
+	require.NoError(t, Enqueue(db, "test.unknown", nil, RunAt(*now)))
+	_, err := queue.RunPending(context.Background(), 10)
+	require.NoError(t, err)
+	task := loadTask(t, db)
+
+	t.Run("RequeuesDeadTask", func(t *testing.T) {
+		require.NoError(t, queue.Retry(task.ID))
+
+		task := loadTask(t, db)
+		assert.Equal(t, models.TaskStatusPending, task.Status)
+		assert.Zero(t, task.Attempts)
+	})
+
+	t.Run("RejectsLiveTask", func(t *testing.T) {
+		assert.EqualError(t, queue.Retry(task.ID), "task not found or not dead-lettered")
+	})
+}
+
+func TestEnqueue_RollsBackWithTransaction(t *testing.T) {
+	db := testutils.SetupTestDB()
+	defer testutils.CleanupTestDB(db)
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := Enqueue(tx, "test.ok", nil); err != nil {
+			return err
+		}
+		return errors.New("domain write failed")
+	})
+	require.Error(t, err)
+
+	var count int64
+	db.Model(&models.Task{}).Count(&count)
+	assert.Zero(t, count)
+}
@@ -4,6 +4,7 @@ import (
 	"log"
 
 	"mowsy-api/internal/models"
+	"mowsy-api/pkg/ratelimit"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -28,6 +29,22 @@ func SetupTestDB() *gorm.DB {
 		&models.EquipmentRental{},
 		&models.Review{},
 		&models.Payment{},
+		&models.Conversation{},
+		&models.Message{},
+		&models.NotificationPreference{},
+		&models.Notification{},
+		&models.Task{},
+		&models.GeocodeCacheEntry{},
+		&models.Upload{},
+		&models.UploadSession{},
+		&models.DataRequest{},
+		&ratelimit.PostgresBucket{},
+		&models.LoginFailure{},
+		&models.MFARecoveryCode{},
+		&models.OAuthIdentity{},
+		&models.PersonalAccessToken{},
+		&models.WebhookEndpoint{},
+		&models.WebhookDelivery{},
 	)
 	if err != nil {
 		log.Fatalf("Failed to migrate test database: %v", err)
@@ -38,6 +55,22 @@ func SetupTestDB() *gorm.DB {
 
 // CleanupTestDB cleans up all tables in the test database
 func CleanupTestDB(db *gorm.DB) {
+	db.Exec("DELETE FROM webhook_deliveries")
+	db.Exec("DELETE FROM webhook_endpoints")
+	db.Exec("DELETE FROM personal_access_tokens")
+	db.Exec("DELETE FROM oauth_identities")
+	db.Exec("DELETE FROM mfa_recovery_codes")
+	db.Exec("DELETE FROM login_failures")
+	db.Exec("DELETE FROM rate_limit_buckets")
+	db.Exec("DELETE FROM data_requests")
+	db.Exec("DELETE FROM upload_sessions")
+	db.Exec("DELETE FROM uploads")
+	db.Exec("DELETE FROM geocode_cache_entries")
+	db.Exec("DELETE FROM tasks")
+	db.Exec("DELETE FROM notifications")
+	db.Exec("DELETE FROM notification_preferences")
+	db.Exec("DELETE FROM messages")
+	db.Exec("DELETE FROM conversations")
 	db.Exec("DELETE FROM payments")
 	db.Exec("DELETE FROM reviews")
 	db.Exec("DELETE FROM equipment_rentals")
@@ -0,0 +1,25 @@
+package testutils
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+)
+
+// CreateTestImage returns a PNG of the given size with a simple gradient, for
+// tests that upload photos.
+func CreateTestImage(width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: 120, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
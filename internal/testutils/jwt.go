@@ -0,0 +1,29 @@
+package testutils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"sync"
+	"testing"
+)
+
+var testSigningKey = sync.OnceValue(func() string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		panic(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+})
+
+// UseTestSigningKeys configures a JWT signing key for the rest of the test
+func UseTestSigningKeys(t testing.TB) {
+	t.Setenv("JWT_SIGNING_KEYS_FILE", "")
+	t.Setenv("JWT_SIGNING_KEYS", testSigningKey())
+}
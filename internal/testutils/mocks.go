@@ -1,6 +1,7 @@
 package testutils
 
 import (
+	"context"
 	"io"
 	"time"
 
@@ -10,16 +11,43 @@ import (
 	"github.com/stretchr/testify/mock"
 )
 
-// MockS3Service is a mock implementation of the S3Service
+var _ storage.Backend = (*MockS3Service)(nil)
+
+// MockS3Service is a mock implementation of storage.Backend
 type MockS3Service struct {
 	mock.Mock
 }
 
-func (m *MockS3Service) UploadFile(file io.Reader, fileName, mimeType string, userID uint) (*storage.UploadResult, error) {
-	args := m.Called(file, fileName, mimeType, userID)
+func (m *MockS3Service) UploadFile(file io.Reader, fileName, mimeType string, userID uint, visibility storage.Visibility) (*storage.UploadResult, error) {
+	args := m.Called(file, fileName, mimeType, userID, visibility)
+	return args.Get(0).(*storage.UploadResult), args.Error(1)
+}
+
+func (m *MockS3Service) PutFile(key string, file io.Reader, mimeType string) (*storage.UploadResult, error) {
+	args := m.Called(key, file, mimeType)
 	return args.Get(0).(*storage.UploadResult), args.Error(1)
 }
 
+func (m *MockS3Service) OpenFile(key string) (io.ReadCloser, error) {
+	args := m.Called(key)
+	return args.Get(0).(io.ReadCloser), args.Error(1)
+}
+
+func (m *MockS3Service) FileURL(key string) string {
+	args := m.Called(key)
+	return args.String(0)
+}
+
+func (m *MockS3Service) ListFiles(prefix string, fn func(storage.FileInfo) error) error {
+	args := m.Called(prefix, fn)
+	return args.Error(0)
+}
+
+func (m *MockS3Service) StatFile(key string) (*storage.FileInfo, error) {
+	args := m.Called(key)
+	return args.Get(0).(*storage.FileInfo), args.Error(1)
+}
+
 func (m *MockS3Service) DeleteFile(key string) error {
 	args := m.Called(key)
 	return args.Error(0)
@@ -30,8 +58,8 @@ func (m *MockS3Service) GetPresignedURL(key string, expiration time.Duration) (s
 	return args.String(0), args.Error(1)
 }
 
-func (m *MockS3Service) GetPresignedUploadURL(key string, mimeType string, expiration time.Duration) (string, error) {
-	args := m.Called(key, mimeType, expiration)
+func (m *MockS3Service) GetPresignedUploadURL(key string, mimeType string, size int64, expiration time.Duration) (string, error) {
+	args := m.Called(key, mimeType, size, expiration)
 	return args.String(0), args.Error(1)
 }
 
@@ -40,17 +68,17 @@ type MockGeocodioService struct {
 	mock.Mock
 }
 
-func (m *MockGeocodioService) GeocodeUser(user *models.User) error {
+func (m *MockGeocodioService) GeocodeUser(ctx context.Context, user *models.User) error {
 	args := m.Called(user)
 	return args.Error(0)
 }
 
-func (m *MockGeocodioService) GeocodeJob(job *models.Job) error {
+func (m *MockGeocodioService) GeocodeJob(ctx context.Context, job *models.Job) error {
 	args := m.Called(job)
 	return args.Error(0)
 }
 
-func (m *MockGeocodioService) GeocodeEquipment(equipment *models.Equipment) error {
+func (m *MockGeocodioService) GeocodeEquipment(ctx context.Context, equipment *models.Equipment) error {
 	args := m.Called(equipment)
 	return args.Error(0)
 }
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+const (
+	MinPasswordLength = 10
+
+	// MaxPasswordLength is bcrypt's limit; longer passwords would be
+	// silently truncated when hashed.
+	MaxPasswordLength = 72
+)
+
+var (
+	ErrPasswordTooShort  = errors.New("password must be at least 10 characters long")
+	ErrPasswordTooLong   = errors.New("password must be at most 72 bytes long")
+	ErrPasswordTooSimple = errors.New("password must mix letters with numbers or symbols")
+	ErrPasswordPersonal  = errors.New("password must not contain your email address")
+	ErrPasswordBreached  = errors.New("password appears in a list of breached passwords; choose a different one")
+)
+
+//go:embed breached_passwords.txt
+var embeddedBreachedPasswords string
+
+var (
+	breachedPasswordsOnce sync.Once
+	breachedPasswords     map[string]struct{}
+)
+
+// ValidatePassword checks a new password against the password rules. personal
+// holds values the password must not contain, such as the user's email; only
+// the part of an email before the @ is compared.
+func ValidatePassword(password string, personal ...string) error {
+	if len(password) < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
+	if len(password) > MaxPasswordLength {
+		return ErrPasswordTooLong
+	}
+
+	var hasLetter, hasOther bool
+	for _, r := range password {
+		if unicode.IsLetter(r) {
+			hasLetter = true
+		} else if !unicode.IsSpace(r) {
+			hasOther = true
+		}
+	}
+	if !hasLetter || !hasOther {
+		return ErrPasswordTooSimple
+	}
+
+	lower := strings.ToLower(password)
+	for _, value := range personal {
+		value, _, _ = strings.Cut(strings.ToLower(value), "@")
+		if len(value) >= 3 && strings.Contains(lower, value) {
+			return ErrPasswordPersonal
+		}
+	}
+
+	if IsBreachedPassword(password) {
+		return ErrPasswordBreached
+	}
+	return nil
+}
+
+// IsBreachedPassword reports whether password is in the breached password
+// corpus. The comparison ignores case.
+func IsBreachedPassword(password string) bool {
+	breachedPasswordsOnce.Do(loadBreachedPasswords)
+	_, found := breachedPasswords[strings.ToLower(password)]
+	return found
+}
+
+// loadBreachedPasswords reads the embedded corpus plus the optional file
+// named by BREACHED_PASSWORDS_FILE. A missing file only logs a warning so a
+// bad path cannot stop sign-ups.
+func loadBreachedPasswords() {
+	breachedPasswords = make(map[string]struct{})
+	addBreachedPasswords(strings.NewReader(embeddedBreachedPasswords))
+
+	path := os.Getenv("BREACHED_PASSWORDS_FILE")
+	if path == "" {
+		return
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		slog.Warn("failed to open breached password list", "path", path, "error", err)
+		return
+	}
+	defer file.Close()
+	if err := addBreachedPasswords(file); err != nil {
+		slog.Warn("failed to read breached password list", "path", path, "error", err)
+	}
+}
+
+func addBreachedPasswords(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		breachedPasswords[strings.ToLower(line)] = struct{}{}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidatePassword(t *testing.T) {
+	testCases := []struct {
+		name     string
+		password string
+		personal []string
+		expected error
+	}{
+		{"Valid password", "mower-shed-42", nil, nil},
+		{"Too short", "ab1!", nil, ErrPasswordTooShort},
+		{"Too long", strings.Repeat("a1", 37), nil, ErrPasswordTooLong},
+		{"Letters only", "lawnandgarden", nil, ErrPasswordTooSimple},
+		{"Digits and spaces only", "1234 5678 90", nil, ErrPasswordTooSimple},
+		{"Contains email name", "JaneDoe-2024!", []string{"janedoe@example.com"}, ErrPasswordPersonal},
+		{"Short email name is ignored", "jo-mows-lawns-7", []string{"jo@example.com"}, nil},
+		{"Breached password", "qwerty12345", nil, ErrPasswordBreached},
+		{"Breached password ignores case", "PassWord1234", nil, ErrPasswordBreached},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, ValidatePassword(tc.password, tc.personal...))
+		})
+	}
+}
+
+func TestBreachedPasswordsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	assert.NoError(t, os.WriteFile(path, []byte("# local additions\nCorrect-Horse-9\n\n"), 0o600))
+	t.Setenv("BREACHED_PASSWORDS_FILE", path)
+
+	breachedPasswordsOnce = sync.Once{}
+	t.Cleanup(func() { breachedPasswordsOnce = sync.Once{} })
+
+	assert.True(t, IsBreachedPassword("correct-horse-9"))
+	assert.True(t, IsBreachedPassword("letmein123"), "embedded corpus is still loaded")
+	assert.False(t, IsBreachedPassword("# local additions"))
+}
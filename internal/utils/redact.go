@@ -0,0 +1,20 @@
+package utils
+
+import (
+	"regexp"
+)
+
+const RedactedContactPlaceholder = "[contact info hidden]"
+
+var (
+	contactEmailRegex = regexp.MustCompile(`[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}`)
+	contactPhoneRegex = regexp.MustCompile(`(\+?1[-.\s]?)?\(?[0-9]{3}\)?[-.\s]?[0-9]{3}[-.\s]?[0-9]{4}`)
+)
+
+// RedactContactInfo replaces email addresses and phone numbers in str with a
+// placeholder and reports whether anything was replaced.
+func RedactContactInfo(str string) (string, bool) {
+	redacted := contactEmailRegex.ReplaceAllString(str, RedactedContactPlaceholder)
+	redacted = contactPhoneRegex.ReplaceAllString(redacted, RedactedContactPlaceholder)
+	return redacted, redacted != str
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRedactContactInfo(t *testing.T) {
+	testCases := []struct {
+		name        string
+		input       string
+		expected    string
+		wasRedacted bool
+	}{
+		{"No contact info", "Can you come Saturday morning?", "Can you come Saturday morning?", false},
+		{"Email address", "Email me at jane.doe@example.com", "Email me at [contact info hidden]", true},
+		{"Phone with dashes", "Call 555-123-4567 anytime", "Call [contact info hidden] anytime", true},
+		{"Phone with parentheses", "My cell is (555) 123-4567", "My cell is [contact info hidden]", true},
+		{"Phone with country code", "Text +1 555 123 4567", "Text [contact info hidden]", true},
+		{"Email and phone", "a@b.co or 5551234567", "[contact info hidden] or [contact info hidden]", true},
+		{"Short number", "Gate code is 1234", "Gate code is 1234", false},
+		{"Empty string", "", "", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, redacted := RedactContactInfo(tc.input)
+			assert.Equal(t, tc.expected, result)
+			assert.Equal(t, tc.wasRedacted, redacted)
+		})
+	}
+}
@@ -18,8 +18,10 @@ func IsValidPhone(phone string) bool {
 	return phoneRegex.MatchString(phone)
 }
 
+// IsValidPassword reports whether password meets the rules in
+// ValidatePassword, without the personal information check.
 func IsValidPassword(password string) bool {
-	return len(password) >= 8
+	return ValidatePassword(password) == nil
 }
 
 func IsValidZipCode(zipCode string) bool {
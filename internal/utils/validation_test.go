@@ -71,13 +71,16 @@ func TestIsValidPassword(t *testing.T) {
 		password string
 		expected bool
 	}{
-		{"Valid 8 character password", "password", true},
-		{"Valid long password", "verylongpasswordwithmanycharacters", true},
-		{"Valid password with special chars", "Pass@123", true},
+		{"Valid password with digits", "mower-shed-42", true},
+		{"Valid long password", "verylongpasswordwithmanycharacters!", true},
+		{"Valid password with special chars", "Pass@123word", true},
 		{"Invalid short password", "pass", false},
-		{"Invalid 7 character password", "passwor", false},
+		{"Invalid 9 character password", "Pass@1234", false},
 		{"Empty password", "", false},
-		{"Exactly 8 characters", "12345678", true},
+		{"Exactly 10 characters", "gr4ss-cut!", true},
+		{"Letters only", "verylongpasswordwithmanycharacters", false},
+		{"Digits only", "8274619305", false},
+		{"Breached password", "Password123", false},
 	}
 
 	for _, tc := range testCases {
@@ -2,7 +2,7 @@ package auth
 
 import (
 	"errors"
-	"os"
+	"strconv"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -20,90 +20,130 @@ type RefreshClaims struct {
 	jwt.RegisteredClaims
 }
 
-func getJWTSecret() []byte {
-	return []byte(os.Getenv("JWT_SECRET"))
-}
+const (
+	tokenIssuer = "mowsy-api"
 
-func GenerateToken(userID uint, email string) (string, error) {
-	jwtSecret := getJWTSecret()
-	if len(jwtSecret) == 0 {
-		return "", errors.New("JWT_SECRET environment variable not set")
-	}
+	// Each kind of token has its own audience so one is never accepted in
+	// place of another.
+	accessTokenAudience  = "mowsy-api"
+	refreshTokenAudience = "mowsy-refresh"
 
-	claims := &Claims{
-		UserID: userID,
-		Email:  email,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(1 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "mowsy-api",
-		},
-	}
+	accessTokenTTL  = time.Hour
+	refreshTokenTTL = 7 * 24 * time.Hour
+)
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+// MFAChallengeTTL is how long a user has to enter their second factor after
+// their password was accepted.
+const MFAChallengeTTL = 5 * time.Minute
+
+// mfaChallengeAudience marks MFA challenge tokens.
+const mfaChallengeAudience = "mowsy-mfa-challenge"
+
+// MFAChallengeClaims identify a user who has passed the password check but
+// not yet the second factor. Enroll is set when the user must set up 2FA
+// before they can sign in.
+type MFAChallengeClaims struct {
+	UserID uint `json:"user_id"`
+	Enroll bool `json:"enroll,omitempty"`
+	jwt.RegisteredClaims
 }
 
-func GenerateRefreshToken(userID uint, email string) (string, error) {
-	jwtSecret := getJWTSecret()
-	if len(jwtSecret) == 0 {
-		return "", errors.New("JWT_SECRET environment variable not set")
+// signToken signs claims with the current signing key.
+func signToken(claims jwt.Claims) (string, error) {
+	keys, err := SigningKeys()
+	if err != nil {
+		return "", err
 	}
+	return keys.sign(claims)
+}
 
-	claims := &RefreshClaims{
-		UserID: userID,
-		Email:  email,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(7 * 24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "mowsy-api",
-		},
+// parseToken verifies tokenString into claims. Only our asymmetric
+// algorithms, our issuer and the given audience are accepted.
+func parseToken(tokenString string, claims jwt.Claims, audience string) error {
+	keys, err := SigningKeys()
+	if err != nil {
+		return err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	token, err := jwt.ParseWithClaims(tokenString, claims, keys.keyFunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodES256.Alg()}),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithAudience(audience),
+	)
+	if err != nil {
+		return err
+	}
+	if !token.Valid {
+		return errors.New("invalid token")
+	}
+	if exp, err := claims.GetExpirationTime(); err != nil || exp == nil {
+		return errors.New("token has no expiry")
+	}
+	return nil
 }
 
-func ValidateToken(tokenString string) (*Claims, error) {
-	jwtSecret := getJWTSecret()
-	if len(jwtSecret) == 0 {
-		return nil, errors.New("JWT_SECRET environment variable not set")
+func registeredClaims(userID uint, audience string, ttl time.Duration) jwt.RegisteredClaims {
+	now := time.Now()
+	return jwt.RegisteredClaims{
+		Subject:   strconv.FormatUint(uint64(userID), 10),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		IssuedAt:  jwt.NewNumericDate(now),
+		Issuer:    tokenIssuer,
+		Audience:  jwt.ClaimStrings{audience},
 	}
+}
+
+func GenerateToken(userID uint, email string) (string, error) {
+	return signToken(&Claims{
+		UserID:           userID,
+		Email:            email,
+		RegisteredClaims: registeredClaims(userID, accessTokenAudience, accessTokenTTL),
+	})
+}
 
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
+func GenerateRefreshToken(userID uint, email string) (string, error) {
+	return signToken(&RefreshClaims{
+		UserID:           userID,
+		Email:            email,
+		RegisteredClaims: registeredClaims(userID, refreshTokenAudience, refreshTokenTTL),
 	})
+}
 
-	if err != nil {
+func ValidateToken(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	if err := parseToken(tokenString, claims, accessTokenAudience); err != nil {
 		return nil, err
 	}
-
-	claims, ok := token.Claims.(*Claims)
-	if !ok || !token.Valid {
-		return nil, errors.New("invalid token")
-	}
-
 	return claims, nil
 }
 
 func ValidateRefreshToken(tokenString string) (*RefreshClaims, error) {
-	jwtSecret := getJWTSecret()
-	if len(jwtSecret) == 0 {
-		return nil, errors.New("JWT_SECRET environment variable not set")
+	claims := &RefreshClaims{}
+	if err := parseToken(tokenString, claims, refreshTokenAudience); err != nil {
+		return nil, err
 	}
+	return claims, nil
+}
 
-	token, err := jwt.ParseWithClaims(tokenString, &RefreshClaims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
-
+// GenerateMFAChallengeToken issues the short-lived token that stands in for
+// the password while the user enters their second factor.
+func GenerateMFAChallengeToken(userID uint, enroll bool) (string, time.Time, error) {
+	claims := &MFAChallengeClaims{
+		UserID:           userID,
+		Enroll:           enroll,
+		RegisteredClaims: registeredClaims(userID, mfaChallengeAudience, MFAChallengeTTL),
+	}
+	signed, err := signToken(claims)
 	if err != nil {
-		return nil, err
+		return "", time.Time{}, err
 	}
+	return signed, claims.ExpiresAt.Time, nil
+}
 
-	claims, ok := token.Claims.(*RefreshClaims)
-	if !ok || !token.Valid {
-		return nil, errors.New("invalid refresh token")
+func ValidateMFAChallengeToken(tokenString string) (*MFAChallengeClaims, error) {
+	claims := &MFAChallengeClaims{}
+	if err := parseToken(tokenString, claims, mfaChallengeAudience); err != nil {
+		return nil, err
 	}
-
 	return claims, nil
-}
\ No newline at end of file
+}
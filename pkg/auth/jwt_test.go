@@ -1,7 +1,6 @@
 package auth
 
 import (
-	"os"
 	"testing"
 	"time"
 
@@ -10,12 +9,7 @@ import (
 )
 
 func TestGenerateToken(t *testing.T) {
-	// Set up test environment
-	originalSecret := os.Getenv("JWT_SECRET")
-	defer os.Setenv("JWT_SECRET", originalSecret)
-	
-	testSecret := "test-secret-key-for-jwt-testing"
-	os.Setenv("JWT_SECRET", testSecret)
+	useTestSigningKeys(t)
 
 	t.Run("GenerateValidToken", func(t *testing.T) {
 		userID := uint(123)
@@ -28,9 +22,8 @@ func TestGenerateToken(t *testing.T) {
 		assert.Contains(t, token, ".")
 	})
 
-	t.Run("GenerateTokenWithoutSecret", func(t *testing.T) {
-		os.Setenv("JWT_SECRET", "")
-		defer os.Setenv("JWT_SECRET", testSecret) // Restore after test
+	t.Run("GenerateTokenWithoutKeys", func(t *testing.T) {
+		t.Setenv("JWT_SIGNING_KEYS", "")
 		
 		userID := uint(123)
 		email := "test@example.com"
@@ -39,17 +32,12 @@ func TestGenerateToken(t *testing.T) {
 
 		assert.Error(t, err)
 		assert.Empty(t, token)
-		assert.Contains(t, err.Error(), "JWT_SECRET environment variable not set")
+		assert.ErrorIs(t, err, ErrSigningKeysNotSet)
 	})
 }
 
 func TestGenerateRefreshToken(t *testing.T) {
-	// Set up test environment
-	originalSecret := os.Getenv("JWT_SECRET")
-	defer os.Setenv("JWT_SECRET", originalSecret)
-	
-	testSecret := "test-secret-key-for-jwt-testing"
-	os.Setenv("JWT_SECRET", testSecret)
+	useTestSigningKeys(t)
 
 	t.Run("GenerateValidRefreshToken", func(t *testing.T) {
 		userID := uint(123)
@@ -62,9 +50,8 @@ func TestGenerateRefreshToken(t *testing.T) {
 		assert.Contains(t, token, ".")
 	})
 
-	t.Run("GenerateRefreshTokenWithoutSecret", func(t *testing.T) {
-		os.Setenv("JWT_SECRET", "")
-		defer os.Setenv("JWT_SECRET", testSecret) // Restore after test
+	t.Run("GenerateRefreshTokenWithoutKeys", func(t *testing.T) {
+		t.Setenv("JWT_SIGNING_KEYS", "")
 		
 		userID := uint(123)
 		email := "test@example.com"
@@ -73,17 +60,12 @@ func TestGenerateRefreshToken(t *testing.T) {
 
 		assert.Error(t, err)
 		assert.Empty(t, token)
-		assert.Contains(t, err.Error(), "JWT_SECRET environment variable not set")
+		assert.ErrorIs(t, err, ErrSigningKeysNotSet)
 	})
 }
 
 func TestValidateToken(t *testing.T) {
-	// Set up test environment
-	originalSecret := os.Getenv("JWT_SECRET")
-	defer os.Setenv("JWT_SECRET", originalSecret)
-	
-	testSecret := "test-secret-key-for-jwt-testing"
-	os.Setenv("JWT_SECRET", testSecret)
+	useTestSigningKeys(t)
 
 	t.Run("ValidateValidToken", func(t *testing.T) {
 		userID := uint(123)
@@ -109,15 +91,14 @@ func TestValidateToken(t *testing.T) {
 		assert.Nil(t, claims)
 	})
 
-	t.Run("ValidateTokenWithoutSecret", func(t *testing.T) {
-		os.Setenv("JWT_SECRET", "")
-		defer os.Setenv("JWT_SECRET", testSecret) // Restore after test
+	t.Run("ValidateTokenWithoutKeys", func(t *testing.T) {
+		t.Setenv("JWT_SIGNING_KEYS", "")
 		
 		claims, err := ValidateToken("some.token.here")
 
 		assert.Error(t, err)
 		assert.Nil(t, claims)
-		assert.Contains(t, err.Error(), "JWT_SECRET environment variable not set")
+		assert.ErrorIs(t, err, ErrSigningKeysNotSet)
 	})
 
 	t.Run("ValidateExpiredToken", func(t *testing.T) {
@@ -133,12 +114,7 @@ func TestValidateToken(t *testing.T) {
 }
 
 func TestValidateRefreshToken(t *testing.T) {
-	// Set up test environment
-	originalSecret := os.Getenv("JWT_SECRET")
-	defer os.Setenv("JWT_SECRET", originalSecret)
-	
-	testSecret := "test-secret-key-for-jwt-testing"
-	os.Setenv("JWT_SECRET", testSecret)
+	useTestSigningKeys(t)
 
 	t.Run("ValidateValidRefreshToken", func(t *testing.T) {
 		userID := uint(123)
@@ -164,25 +140,19 @@ func TestValidateRefreshToken(t *testing.T) {
 		assert.Nil(t, claims)
 	})
 
-	t.Run("ValidateRefreshTokenWithoutSecret", func(t *testing.T) {
-		os.Setenv("JWT_SECRET", "")
-		defer os.Setenv("JWT_SECRET", testSecret) // Restore after test
+	t.Run("ValidateRefreshTokenWithoutKeys", func(t *testing.T) {
+		t.Setenv("JWT_SIGNING_KEYS", "")
 		
 		claims, err := ValidateRefreshToken("some.token.here")
 
 		assert.Error(t, err)
 		assert.Nil(t, claims)
-		assert.Contains(t, err.Error(), "JWT_SECRET environment variable not set")
+		assert.ErrorIs(t, err, ErrSigningKeysNotSet)
 	})
 }
 
 func TestTokenRoundTrip(t *testing.T) {
-	// Set up test environment
-	originalSecret := os.Getenv("JWT_SECRET")
-	defer os.Setenv("JWT_SECRET", originalSecret)
-	
-	testSecret := "test-secret-key-for-jwt-testing"
-	os.Setenv("JWT_SECRET", testSecret)
+	useTestSigningKeys(t)
 
 	t.Run("AccessTokenRoundTrip", func(t *testing.T) {
 		userID := uint(456)
@@ -221,4 +191,42 @@ func TestTokenRoundTrip(t *testing.T) {
 		assert.True(t, claims.ExpiresAt.Time.After(time.Now().Add(6*24*time.Hour)))
 		assert.True(t, claims.IssuedAt.Time.Before(time.Now().Add(time.Second)))
 	})
-}
\ No newline at end of file
+
+	t.Run("TokenTypesAreNotInterchangeable", func(t *testing.T) {
+		accessToken, err := GenerateToken(456, "roundtrip@example.com")
+		require.NoError(t, err)
+		refreshToken, err := GenerateRefreshToken(456, "roundtrip@example.com")
+		require.NoError(t, err)
+
+		_, err = ValidateToken(refreshToken)
+		assert.Error(t, err)
+		_, err = ValidateRefreshToken(accessToken)
+		assert.Error(t, err)
+	})
+}
+func TestMFAChallengeToken(t *testing.T) {
+	useTestSigningKeys(t)
+
+	token, expiresAt, err := GenerateMFAChallengeToken(789, true)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(MFAChallengeTTL), expiresAt, time.Second)
+
+	claims, err := ValidateMFAChallengeToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, uint(789), claims.UserID)
+	assert.True(t, claims.Enroll)
+
+	t.Run("NotAnAccessOrRefreshToken", func(t *testing.T) {
+		_, err := ValidateToken(token)
+		assert.Error(t, err)
+		_, err = ValidateRefreshToken(token)
+		assert.Error(t, err)
+	})
+
+	t.Run("AccessTokenIsNotAChallenge", func(t *testing.T) {
+		accessToken, err := GenerateToken(789, "mfa@example.com")
+		require.NoError(t, err)
+		_, err = ValidateMFAChallengeToken(accessToken)
+		assert.Error(t, err)
+	})
+}
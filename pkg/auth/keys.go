@@ -0,0 +1,252 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+
+	"mowsy-api/pkg/oidc"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrSigningKeysNotSet = errors.New("JWT_SIGNING_KEYS or JWT_SIGNING_KEYS_FILE must be set")
+
+// minRSAKeyBits is the smallest RSA key accepted for signing.
+const minRSAKeyBits = 2048
+
+// SigningKey is one of the keys tokens are signed with.
+type SigningKey struct {
+	// ID goes in the kid header. It is the key's RFC 7638 thumbprint, so it
+	// never needs configuring and cannot collide between keys.
+	ID     string
+	Method jwt.SigningMethod
+	key    crypto.Signer
+}
+
+// KeyRing holds the active signing keys. The first key signs new tokens;
+// every key verifies them. To rotate, add the new key second, deploy so its
+// public half is published, then move it first. Drop the old key once the
+// longest-lived token it signed (a refresh token) has expired.
+type KeyRing struct {
+	keys []SigningKey
+}
+
+// ParseKeyRing reads PEM encoded RSA (RS256) or P-256 (ES256) private keys.
+func ParseKeyRing(data []byte) (*KeyRing, error) {
+	ring := &KeyRing{}
+	seen := map[string]bool{}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		key, err := parsePrivateKey(block)
+		if err != nil {
+			return nil, err
+		}
+		signingKey, err := newSigningKey(key)
+		if err != nil {
+			return nil, err
+		}
+		if seen[signingKey.ID] {
+			continue
+		}
+		seen[signingKey.ID] = true
+		ring.keys = append(ring.keys, signingKey)
+	}
+
+	if len(ring.keys) == 0 {
+		return nil, errors.New("no private keys found in JWT signing keys")
+	}
+	return ring, nil
+}
+
+func parsePrivateKey(block *pem.Block) (crypto.Signer, error) {
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unexpected PEM block %q in JWT signing keys", block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse JWT signing key: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported JWT signing key type %T", key)
+	}
+	return signer, nil
+}
+
+func newSigningKey(key crypto.Signer) (SigningKey, error) {
+	jwk, err := publicJWK(key.Public())
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	var method jwt.SigningMethod
+	switch pub := key.Public().(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < minRSAKeyBits {
+			return SigningKey{}, fmt.Errorf("RSA signing keys must be at least %d bits", minRSAKeyBits)
+		}
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PublicKey:
+		method = jwt.SigningMethodES256
+	}
+
+	return SigningKey{ID: jwk.Kid, Method: method, key: key}, nil
+}
+
+// publicJWK describes pub as a JWK, with its thumbprint as the kid.
+func publicJWK(pub crypto.PublicKey) (oidc.JSONWebKey, error) {
+	var jwk oidc.JSONWebKey
+	var thumbprintInput interface{}
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		jwk = oidc.JSONWebKey{
+			Kty: "RSA", Use: "sig", Alg: "RS256",
+			N: base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}
+		thumbprintInput = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+
+	case *ecdsa.PublicKey:
+		if pub.Curve != elliptic.P256() {
+			return jwk, errors.New("EC signing keys must use the P-256 curve")
+		}
+		x, y := make([]byte, 32), make([]byte, 32)
+		pub.X.FillBytes(x)
+		pub.Y.FillBytes(y)
+		jwk = oidc.JSONWebKey{
+			Kty: "EC", Use: "sig", Alg: "ES256", Crv: "P-256",
+			X: base64.RawURLEncoding.EncodeToString(x),
+			Y: base64.RawURLEncoding.EncodeToString(y),
+		}
+		thumbprintInput = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{jwk.Crv, jwk.Kty, jwk.X, jwk.Y}
+
+	default:
+		return jwk, fmt.Errorf("unsupported JWT signing key type %T", pub)
+	}
+
+	// RFC 7638: the required members in lexicographic order, no whitespace
+	canonical, err := json.Marshal(thumbprintInput)
+	if err != nil {
+		return jwk, err
+	}
+	sum := sha256.Sum256(canonical)
+	jwk.Kid = base64.RawURLEncoding.EncodeToString(sum[:])
+	return jwk, nil
+}
+
+// Keys returns the active keys, the signing key first.
+func (r *KeyRing) Keys() []SigningKey {
+	return r.keys
+}
+
+// sign signs claims with the current key.
+func (r *KeyRing) sign(claims jwt.Claims) (string, error) {
+	current := r.keys[0]
+	token := jwt.NewWithClaims(current.Method, claims)
+	token.Header["kid"] = current.ID
+	return token.SignedString(current.key)
+}
+
+// keyFunc finds the public key for a token by its kid. The algorithm must be
+// the one that key signs with, so a token can't pick its own verification.
+func (r *KeyRing) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	for _, key := range r.keys {
+		if key.ID != kid {
+			continue
+		}
+		if token.Method.Alg() != key.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method %q", token.Method.Alg())
+		}
+		return key.key.Public(), nil
+	}
+	return nil, errors.New("unknown signing key")
+}
+
+// JWKS returns the public keys, for other services to verify our tokens.
+func (r *KeyRing) JWKS() oidc.JSONWebKeySet {
+	set := oidc.JSONWebKeySet{Keys: make([]oidc.JSONWebKey, 0, len(r.keys))}
+	for _, key := range r.keys {
+		// Keys were checked when the ring was parsed
+		jwk, _ := publicJWK(key.key.Public())
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+var keyRingCache struct {
+	sync.Mutex
+	source string
+	ring   *KeyRing
+	err    error
+}
+
+// SigningKeys returns the key ring configured by JWT_SIGNING_KEYS, a PEM
+// bundle, or JWT_SIGNING_KEYS_FILE, a path to one. It is parsed once and
+// again whenever the configuration changes.
+func SigningKeys() (*KeyRing, error) {
+	inline := os.Getenv("JWT_SIGNING_KEYS")
+	path := os.Getenv("JWT_SIGNING_KEYS_FILE")
+	if inline == "" && path == "" {
+		return nil, ErrSigningKeysNotSet
+	}
+
+	keyRingCache.Lock()
+	defer keyRingCache.Unlock()
+	source := inline + "\x00" + path
+	if keyRingCache.source == source {
+		return keyRingCache.ring, keyRingCache.err
+	}
+
+	var data []byte
+	if inline != "" {
+		// Some secret stores can only hold one line
+		data = []byte(strings.ReplaceAll(inline, `\n`, "\n"))
+	} else {
+		var err error
+		if data, err = os.ReadFile(path); err != nil {
+			return nil, fmt.Errorf("failed to read JWT_SIGNING_KEYS_FILE: %w", err)
+		}
+	}
+
+	keyRingCache.source = source
+	keyRingCache.ring, keyRingCache.err = ParseKeyRing(data)
+	return keyRingCache.ring, keyRingCache.err
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newECKeyPEM(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func newRSAKeyPEM(t *testing.T, bits int) []byte {
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+}
+
+// useTestSigningKeys configures a fresh signing key for the test.
+func useTestSigningKeys(t *testing.T) {
+	t.Setenv("JWT_SIGNING_KEYS_FILE", "")
+	t.Setenv("JWT_SIGNING_KEYS", string(newECKeyPEM(t)))
+}
+
+func TestParseKeyRing(t *testing.T) {
+	ecPEM, rsaPEM := newECKeyPEM(t), newRSAKeyPEM(t, 2048)
+
+	ring, err := ParseKeyRing(append(append([]byte{}, ecPEM...), rsaPEM...))
+	require.NoError(t, err)
+	keys := ring.Keys()
+	require.Len(t, keys, 2)
+	assert.Equal(t, "ES256", keys[0].Method.Alg())
+	assert.Equal(t, "RS256", keys[1].Method.Alg())
+	assert.NotEqual(t, keys[0].ID, keys[1].ID)
+
+	// The kid is derived from the key, so it is stable across restarts
+	again, err := ParseKeyRing(ecPEM)
+	require.NoError(t, err)
+	assert.Equal(t, keys[0].ID, again.Keys()[0].ID)
+
+	jwks := ring.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	for i, jwk := range jwks.Keys {
+		assert.Equal(t, keys[i].ID, jwk.Kid)
+		assert.Equal(t, keys[i].Method.Alg(), jwk.Alg)
+		pub, err := jwk.PublicKey()
+		require.NoError(t, err)
+		assert.True(t, keys[i].key.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(pub))
+	}
+
+	t.Run("Rejected", func(t *testing.T) {
+		_, err := ParseKeyRing(nil)
+		assert.Error(t, err)
+		_, err = ParseKeyRing(newRSAKeyPEM(t, 1024))
+		assert.Error(t, err)
+		_, err = ParseKeyRing([]byte("-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n"))
+		assert.Error(t, err)
+	})
+}
+
+func TestSigningKeysFromEnv(t *testing.T) {
+	keyPEM := newECKeyPEM(t)
+	t.Setenv("JWT_SIGNING_KEYS_FILE", "")
+
+	// Escaped newlines, as single-line secret stores hold them
+	t.Setenv("JWT_SIGNING_KEYS", strings.ReplaceAll(string(keyPEM), "\n", `\n`))
+	inline, err := SigningKeys()
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "keys.pem")
+	require.NoError(t, os.WriteFile(path, keyPEM, 0o600))
+	t.Setenv("JWT_SIGNING_KEYS", "")
+	t.Setenv("JWT_SIGNING_KEYS_FILE", path)
+	fromFile, err := SigningKeys()
+	require.NoError(t, err)
+	assert.Equal(t, inline.Keys()[0].ID, fromFile.Keys()[0].ID)
+
+	t.Setenv("JWT_SIGNING_KEYS_FILE", "")
+	_, err = SigningKeys()
+	assert.ErrorIs(t, err, ErrSigningKeysNotSet)
+}
+
+func TestKeyRotation(t *testing.T) {
+	oldKey, newKey := newECKeyPEM(t), newRSAKeyPEM(t, 2048)
+	t.Setenv("JWT_SIGNING_KEYS_FILE", "")
+
+	t.Setenv("JWT_SIGNING_KEYS", string(oldKey))
+	oldToken, err := GenerateRefreshToken(1, "rotate@example.com")
+	require.NoError(t, err)
+
+	// The new key is published first, then promoted
+	t.Setenv("JWT_SIGNING_KEYS", string(oldKey)+string(newKey))
+	_, err = ValidateRefreshToken(oldToken)
+	require.NoError(t, err)
+
+	t.Setenv("JWT_SIGNING_KEYS", string(newKey)+string(oldKey))
+	newToken, err := GenerateToken(1, "rotate@example.com")
+	require.NoError(t, err)
+	parsed, _, err := jwt.NewParser().ParseUnverified(newToken, &Claims{})
+	require.NoError(t, err)
+	assert.Equal(t, "RS256", parsed.Method.Alg())
+	ring, err := SigningKeys()
+	require.NoError(t, err)
+	assert.Equal(t, ring.Keys()[0].ID, parsed.Header["kid"])
+	_, err = ValidateToken(newToken)
+	require.NoError(t, err)
+	_, err = ValidateRefreshToken(oldToken)
+	require.NoError(t, err)
+
+	// Once the old key is retired its tokens stop working
+	t.Setenv("JWT_SIGNING_KEYS", string(newKey))
+	_, err = ValidateRefreshToken(oldToken)
+	assert.Error(t, err)
+	_, err = ValidateToken(newToken)
+	assert.NoError(t, err)
+}
+
+func TestValidateTokenRejectsForgedAlgorithms(t *testing.T) {
+	useTestSigningKeys(t)
+	keys, err := SigningKeys()
+	require.NoError(t, err)
+	current := keys.Keys()[0]
+
+	claims := &Claims{UserID: 1, RegisteredClaims: registeredClaims(1, accessTokenAudience, time.Hour)}
+
+	t.Run("HS256WithPublicKey", func(t *testing.T) {
+		pub, err := x509.MarshalPKIXPublicKey(current.key.Public())
+		require.NoError(t, err)
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+		token.Header["kid"] = current.ID
+		signed, err := token.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pub}))
+		require.NoError(t, err)
+
+		_, err = ValidateToken(signed)
+		assert.Error(t, err)
+	})
+
+	t.Run("None", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+		token.Header["kid"] = current.ID
+		signed, err := token.SignedString(jwt.UnsafeAllowNoneSignatureType)
+		require.NoError(t, err)
+
+		_, err = ValidateToken(signed)
+		assert.Error(t, err)
+	})
+
+	t.Run("NoExpiry", func(t *testing.T) {
+		noExpiry := *claims
+		noExpiry.ExpiresAt = nil
+		signed, err := keys.sign(&noExpiry)
+		require.NoError(t, err)
+
+		_, err = ValidateToken(signed)
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfc6238Secret is the SHA1 test key from RFC 6238 appendix B.
+var rfc6238Secret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCode(t *testing.T) {
+	// RFC 6238 lists 8 digit codes; ours are the last 6 digits of each
+	testCases := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tc := range testCases {
+		code, err := TOTPCode(rfc6238Secret, TOTPStep(time.Unix(tc.unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, code, "time %d", tc.unix)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	require.NoError(t, err)
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	step := TOTPStep(now)
+
+	code, err := TOTPCode(secret, step)
+	require.NoError(t, err)
+
+	t.Run("CurrentCode", func(t *testing.T) {
+		matched, ok := ValidateTOTP(secret, code, now)
+		assert.True(t, ok)
+		assert.Equal(t, step, matched)
+	})
+
+	t.Run("AllowsOneStepOfDrift", func(t *testing.T) {
+		_, ok := ValidateTOTP(secret, code, now.Add(TOTPPeriod))
+		assert.True(t, ok)
+		_, ok = ValidateTOTP(secret, code, now.Add(2*TOTPPeriod))
+		assert.False(t, ok)
+	})
+
+	t.Run("IgnoresSpaces", func(t *testing.T) {
+		_, ok := ValidateTOTP(secret, code[:3]+" "+code[3:], now)
+		assert.True(t, ok)
+	})
+
+	t.Run("RejectsWrongCodes", func(t *testing.T) {
+		for _, bad := range []string{"", "12345", "1234567", "abcdef"} {
+			_, ok := ValidateTOTP(secret, bad, now)
+			assert.False(t, ok, bad)
+		}
+	})
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("Mowsy", "jane@example.com", "JBSWY3DPEHPK3PXP")
+
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/Mowsy:jane@example.com?"), uri)
+	assert.Contains(t, uri, "secret=JBSWY3DPEHPK3PXP")
+	assert.Contains(t, uri, "issuer=Mowsy")
+	assert.Contains(t, uri, "digits=6")
+	assert.Contains(t, uri, "period=30")
+}
@@ -1,11 +1,14 @@
 package database
 
 import (
+	"database/sql"
 	"fmt"
 	"log"
 	"os"
 
 	"mowsy-api/internal/models"
+	"mowsy-api/pkg/ratelimit"
+	"mowsy-api/pkg/tracing"
 
 	"gorm.io/gorm"
 )
@@ -25,6 +28,11 @@ func InitDB() error {
 	}
 
 	log.Printf("Database credentials loaded: %s:%s, user: %s, db: %s", host, port, user, dbname)
+	if DB != nil {
+		if err := DB.Use(tracing.GORMPlugin{}); err != nil {
+			return fmt.Errorf("failed to install tracing plugin: %w", err)
+		}
+	}
 	return nil // Skip actual connection for now to test the endpoints
 }
 
@@ -41,6 +49,22 @@ func AutoMigrate() error {
 		&models.EquipmentRental{},
 		&models.Review{},
 		&models.Payment{},
+		&models.Conversation{},
+		&models.Message{},
+		&models.NotificationPreference{},
+		&models.Notification{},
+		&models.Task{},
+		&models.GeocodeCacheEntry{},
+		&models.Upload{},
+		&models.UploadSession{},
+		&models.DataRequest{},
+		&ratelimit.PostgresBucket{},
+		&models.LoginFailure{},
+		&models.MFARecoveryCode{},
+		&models.OAuthIdentity{},
+		&models.PersonalAccessToken{},
+		&models.WebhookEndpoint{},
+		&models.WebhookDelivery{},
 	)
 	if err != nil {
 		return fmt.Errorf("failed to run auto migration: %w", err)
@@ -52,4 +76,17 @@ func AutoMigrate() error {
 
 func GetDB() *gorm.DB {
 	return DB
-}
\ No newline at end of file
+}
+
+// SQLDB returns the connection pool behind db, or nil if the database has
+// not been connected.
+func SQLDB(db *gorm.DB) *sql.DB {
+	if db == nil {
+		return nil
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil
+	}
+	return sqlDB
+}
@@ -0,0 +1,173 @@
+// Package imaging turns uploaded photos into the resized variants served to
+// clients. Decoding and re-encoding drops every metadata block the original
+// carried, including EXIF GPS coordinates, so only pixels reach storage.
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+
+	xdraw "golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	VariantThumbnail = "thumbnail"
+	VariantMedium    = "medium"
+	VariantFull      = "full"
+)
+
+// maxPixels bounds decoding so a small file claiming huge dimensions cannot
+// exhaust memory.
+const maxPixels = 50_000_000
+
+const jpegQuality = 82
+
+var ErrUnsupportedImage = errors.New("unsupported image")
+
+// Spec describes one variant: the longest edge is scaled down to MaxEdge.
+// Images smaller than that are never scaled up.
+type Spec struct {
+	Name    string
+	MaxEdge int
+}
+
+// Specs are the variants generated for every photo, smallest first.
+var Specs = []Spec{
+	{Name: VariantThumbnail, MaxEdge: 320},
+	{Name: VariantMedium, MaxEdge: 1024},
+	{Name: VariantFull, MaxEdge: 2048},
+}
+
+type Variant struct {
+	Name     string
+	Data     []byte
+	MimeType string
+	Width    int
+	Height   int
+}
+
+type Result struct {
+	Variants []Variant
+	Blurhash string
+	Width    int
+	Height   int
+}
+
+// Variant returns the named variant, or nil if it was not generated.
+func (r *Result) Variant(name string) *Variant {
+	for i := range r.Variants {
+		if r.Variants[i].Name == name {
+			return &r.Variants[i]
+		}
+	}
+	return nil
+}
+
+// DetectContentType sniffs the MIME type from the first bytes of data,
+// ignoring whatever the client claimed.
+func DetectContentType(data []byte) string {
+	return http.DetectContentType(data)
+}
+
+// IsImage reports whether a sniffed MIME type is one Process can decode.
+func IsImage(mimeType string) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// Process decodes a photo, applies its EXIF orientation and encodes the
+// variants in Specs as JPEG. Go has no WebP encoder, so WebP input comes
+// out as JPEG too; transparent areas are flattened onto white.
+func Process(r io.Reader) (*Result, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+
+	if !IsImage(DetectContentType(data)) {
+		return nil, ErrUnsupportedImage
+	}
+
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width*config.Height > maxPixels {
+		return nil, fmt.Errorf("%w: image dimensions %dx%d are too large", ErrUnsupportedImage, config.Width, config.Height)
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnsupportedImage, err)
+	}
+	src = applyOrientation(flatten(src), exifOrientation(data))
+
+	bounds := src.Bounds()
+	result := &Result{
+		Width:  bounds.Dx(),
+		Height: bounds.Dy(),
+	}
+
+	for _, spec := range Specs {
+		img := resize(src, spec.MaxEdge)
+
+		var buf bytes.Buffer
+		if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: jpegQuality}); err != nil {
+			return nil, fmt.Errorf("failed to encode %s variant: %w", spec.Name, err)
+		}
+
+		result.Variants = append(result.Variants, Variant{
+			Name:     spec.Name,
+			Data:     buf.Bytes(),
+			MimeType: "image/jpeg",
+			Width:    img.Bounds().Dx(),
+			Height:   img.Bounds().Dy(),
+		})
+	}
+
+	result.Blurhash = Blurhash(resize(src, 64), 4, 3)
+
+	return result, nil
+}
+
+// flatten copies src onto an opaque white RGBA canvas.
+func flatten(src image.Image) *image.RGBA {
+	bounds := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(dst, dst.Bounds(), &image.Uniform{C: color.White}, image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), src, bounds.Min, draw.Over)
+	return dst
+}
+
+func resize(src image.Image, maxEdge int) image.Image {
+	bounds := src.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width <= maxEdge && height <= maxEdge {
+		return src
+	}
+
+	if width >= height {
+		height = max(1, height*maxEdge/width)
+		width = maxEdge
+	} else {
+		width = max(1, width*maxEdge/height)
+		height = maxEdge
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	xdraw.CatmullRom.Scale(dst, dst.Bounds(), src, bounds, xdraw.Src, nil)
+	return dst
+}
@@ -0,0 +1,166 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testImage(width, height int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 255 / width), G: uint8(y * 255 / height), B: 80, A: 255})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	require.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+// jpegWithExif encodes img as a JPEG carrying an EXIF block with the given
+// orientation and a fake GPS marker string.
+func jpegWithExif(t *testing.T, img image.Image, orientation uint16) []byte {
+	var encoded bytes.Buffer
+	require.NoError(t, jpeg.Encode(&encoded, img, nil))
+
+	var tiff bytes.Buffer
+	tiff.WriteString("II*\x00")
+	binary.Write(&tiff, binary.LittleEndian, uint32(8))
+	binary.Write(&tiff, binary.LittleEndian, uint16(1))
+	binary.Write(&tiff, binary.LittleEndian, uint16(0x0112))
+	binary.Write(&tiff, binary.LittleEndian, uint16(3))
+	binary.Write(&tiff, binary.LittleEndian, uint32(1))
+	binary.Write(&tiff, binary.LittleEndian, orientation)
+	binary.Write(&tiff, binary.LittleEndian, uint16(0))
+	binary.Write(&tiff, binary.LittleEndian, uint32(0))
+	tiff.WriteString("GPS 39.7817N 89.6501W")
+
+	segment := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+	var out bytes.Buffer
+	out.Write(encoded.Bytes()[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(segment)+2))
+	out.Write(segment)
+	out.Write(encoded.Bytes()[2:])
+	return out.Bytes()
+}
+
+func TestProcess(t *testing.T) {
+	result, err := Process(bytes.NewReader(encodePNG(t, testImage(3000, 1500))))
+	require.NoError(t, err)
+
+	assert.Equal(t, 3000, result.Width)
+	assert.Equal(t, 1500, result.Height)
+	require.Len(t, result.Variants, len(Specs))
+
+	for _, spec := range Specs {
+		variant := result.Variant(spec.Name)
+		require.NotNil(t, variant, spec.Name)
+		assert.Equal(t, "image/jpeg", variant.MimeType)
+		assert.Equal(t, spec.MaxEdge, variant.Width)
+		assert.Equal(t, spec.MaxEdge/2, variant.Height)
+
+		decoded, err := jpeg.Decode(bytes.NewReader(variant.Data))
+		require.NoError(t, err)
+		assert.Equal(t, variant.Width, decoded.Bounds().Dx())
+	}
+
+	assert.Len(t, result.Blurhash, 28)
+}
+
+func TestProcess_SmallImagesAreNotUpscaled(t *testing.T) {
+	result, err := Process(bytes.NewReader(encodePNG(t, testImage(200, 100))))
+	require.NoError(t, err)
+
+	for _, variant := range result.Variants {
+		assert.Equal(t, 200, variant.Width)
+		assert.Equal(t, 100, variant.Height)
+	}
+}
+
+func TestProcess_StripsExifAndAppliesOrientation(t *testing.T) {
+	data := jpegWithExif(t, testImage(400, 200), 6)
+	require.Equal(t, 6, exifOrientation(data))
+
+	result, err := Process(bytes.NewReader(data))
+	require.NoError(t, err)
+
+	// Rotated 90 degrees: portrait now
+	assert.Equal(t, 200, result.Width)
+	assert.Equal(t, 400, result.Height)
+
+	for _, variant := range result.Variants {
+		assert.False(t, bytes.Contains(variant.Data, []byte("Exif")), variant.Name)
+		assert.False(t, bytes.Contains(variant.Data, []byte("GPS")), variant.Name)
+		assert.Equal(t, 1, exifOrientation(variant.Data))
+	}
+}
+
+func TestProcess_RejectsNonImages(t *testing.T) {
+	_, err := Process(bytes.NewReader([]byte("%PDF-1.4 not an image")))
+	assert.ErrorIs(t, err, ErrUnsupportedImage)
+
+	// A PNG signature followed by garbage
+	_, err = Process(bytes.NewReader(append([]byte("\x89PNG\r\n\x1a\n"), make([]byte, 64)...)))
+	assert.ErrorIs(t, err, ErrUnsupportedImage)
+}
+
+func TestProcess_RejectsOversizedDimensions(t *testing.T) {
+	// Only the header is needed for the dimension check
+	var ihdr bytes.Buffer
+	ihdr.WriteString("IHDR")
+	binary.Write(&ihdr, binary.BigEndian, uint32(100000))
+	binary.Write(&ihdr, binary.BigEndian, uint32(100000))
+	ihdr.Write([]byte{8, 2, 0, 0, 0})
+
+	var data bytes.Buffer
+	data.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&data, binary.BigEndian, uint32(13))
+	data.Write(ihdr.Bytes())
+	binary.Write(&data, binary.BigEndian, crc32.ChecksumIEEE(ihdr.Bytes()))
+
+	_, err := Process(&data)
+
+	assert.ErrorIs(t, err, ErrUnsupportedImage)
+}
+
+func TestApplyOrientation(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	red := color.RGBA{R: 255, A: 255}
+	img.SetRGBA(0, 0, red)
+
+	cases := map[int]image.Point{
+		1: {0, 0},
+		2: {1, 0},
+		3: {1, 0},
+		6: {0, 0},
+		8: {0, 1},
+	}
+	for orientation, want := range cases {
+		out := applyOrientation(img, orientation)
+		assert.Equal(t, red, out.RGBAAt(want.X, want.Y), "orientation %d", orientation)
+	}
+}
+
+func TestBlurhash(t *testing.T) {
+	img := testImage(32, 32)
+
+	hash := Blurhash(img, 4, 3)
+
+	assert.Len(t, hash, 28)
+	assert.Equal(t, hash, Blurhash(img, 4, 3))
+	assert.Equal(t, "L", hash[:1]) // 4x3 components: (4-1)+(3-1)*9 = 21
+}
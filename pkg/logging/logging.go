@@ -0,0 +1,116 @@
+// Package logging sets up structured JSON logs and carries a request's
+// logger through its context, so everything logged while serving a request
+// shares its request ID and user.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces the value of anything that looks like a secret or PII.
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are matched against attribute keys, case-insensitively and
+// as substrings, so "stripe_secret_key" and "X-Api-Key" are both caught.
+var sensitiveKeys = []string{
+	"password",
+	"secret",
+	"token",
+	"authorization",
+	"cookie",
+	"api_key",
+	"apikey",
+	"signature",
+	"email",
+	"phone",
+	"address",
+}
+
+var (
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	bearerPattern = regexp.MustCompile(`(?i)bearer\s+[A-Za-z0-9._~+/=\-]+`)
+)
+
+// New returns a JSON logger writing to w that drops records below level
+// and redacts sensitive attributes.
+func New(w io.Writer, level slog.Leveler) *slog.Logger {
+	return slog.New(slog.NewJSONHandler(w, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	}))
+}
+
+// Setup builds the process logger from LOG_LEVEL (debug, info, warn or
+// error; info by default) and installs it as the slog and log default, so
+// plain log.Printf calls come out as JSON too.
+func Setup() *slog.Logger {
+	logger := New(os.Stderr, ParseLevel(os.Getenv("LOG_LEVEL")))
+	slog.SetDefault(logger)
+	return logger
+}
+
+// ParseLevel maps a LOG_LEVEL value to a level, defaulting to info.
+func ParseLevel(value string) slog.Level {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(value))); err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	if a.Value.Kind() == slog.KindGroup {
+		return a
+	}
+
+	key := strings.ToLower(a.Key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return slog.String(a.Key, Redacted)
+		}
+	}
+
+	// Error messages and free text can still carry an address or a token
+	switch a.Value.Kind() {
+	case slog.KindString:
+		return slog.String(a.Key, Scrub(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			return slog.String(a.Key, Scrub(err.Error()))
+		}
+	}
+	return a
+}
+
+// Scrub masks email addresses and bearer tokens inside free text.
+func Scrub(s string) string {
+	s = emailPattern.ReplaceAllString(s, Redacted)
+	return bearerPattern.ReplaceAllString(s, "Bearer "+Redacted)
+}
+
+type contextKey struct{}
+
+// WithContext returns a copy of ctx carrying logger.
+func WithContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return slog.Default()
+}
+
+// With adds attributes to the logger carried by ctx.
+func With(ctx context.Context, args ...any) context.Context {
+	return WithContext(ctx, FromContext(ctx).With(args...))
+}
@@ -0,0 +1,67 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decode(t *testing.T, buf *bytes.Buffer) map[string]interface{} {
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	buf.Reset()
+	return record
+}
+
+func TestRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelDebug)
+
+	t.Run("SensitiveKeys", func(t *testing.T) {
+		logger.Info("login", "password", "hunter22", "Authorization", "Bearer abc", "stripe_secret_key", "sk_live", "user_id", 7)
+
+		record := decode(t, &buf)
+		assert.Equal(t, Redacted, record["password"])
+		assert.Equal(t, Redacted, record["Authorization"])
+		assert.Equal(t, Redacted, record["stripe_secret_key"])
+		assert.Equal(t, float64(7), record["user_id"])
+	})
+
+	t.Run("FreeText", func(t *testing.T) {
+		logger.Warn("failed to notify jane@example.com", "error", errors.New("rejected bearer eyJhbGciOi.abc for jane@example.com"))
+
+		record := decode(t, &buf)
+		assert.Equal(t, "failed to notify "+Redacted, record["msg"])
+		assert.NotContains(t, record["error"], "jane@example.com")
+		assert.NotContains(t, record["error"], "eyJhbGciOi")
+	})
+
+	t.Run("Level", func(t *testing.T) {
+		quiet := New(&buf, ParseLevel("warn"))
+		quiet.Info("hidden")
+		assert.Empty(t, buf.String())
+
+		assert.Equal(t, slog.LevelInfo, ParseLevel(""))
+		assert.Equal(t, slog.LevelDebug, ParseLevel("DEBUG"))
+		assert.Equal(t, slog.LevelInfo, ParseLevel("loud"))
+	})
+}
+
+func TestContext(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, slog.LevelInfo)
+
+	assert.Equal(t, slog.Default(), FromContext(context.Background()))
+
+	ctx := With(WithContext(context.Background(), logger), "request_id", "abc")
+	FromContext(ctx).Info("hello")
+
+	record := decode(t, &buf)
+	assert.Equal(t, "abc", record["request_id"])
+}
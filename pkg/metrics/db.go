@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"database/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	dbMaxOpen = prometheus.NewDesc(namespace+"_db_max_open_connections",
+		"Maximum number of open connections to the database.", nil, nil)
+	dbOpen = prometheus.NewDesc(namespace+"_db_open_connections",
+		"Established connections, in use and idle.", nil, nil)
+	dbInUse = prometheus.NewDesc(namespace+"_db_in_use_connections",
+		"Connections currently in use.", nil, nil)
+	dbIdle = prometheus.NewDesc(namespace+"_db_idle_connections",
+		"Idle connections.", nil, nil)
+	dbWaitCount = prometheus.NewDesc(namespace+"_db_wait_count_total",
+		"Times a query waited for a free connection.", nil, nil)
+	dbWaitDuration = prometheus.NewDesc(namespace+"_db_wait_duration_seconds_total",
+		"Total time spent waiting for a free connection.", nil, nil)
+)
+
+// dbStatsCollector reads sql.DBStats at scrape time. Unlike the stock
+// collector it looks the database up on every scrape, because the API
+// connects lazily and Lambda may scrape before the first request.
+type dbStatsCollector struct {
+	get func() *sql.DB
+}
+
+func (c *dbStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- dbMaxOpen
+	ch <- dbOpen
+	ch <- dbInUse
+	ch <- dbIdle
+	ch <- dbWaitCount
+	ch <- dbWaitDuration
+}
+
+func (c *dbStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	db := c.get()
+	if db == nil {
+		return
+	}
+
+	stats := db.Stats()
+	ch <- prometheus.MustNewConstMetric(dbMaxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(dbOpen, prometheus.GaugeValue, float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(dbInUse, prometheus.GaugeValue, float64(stats.InUse))
+	ch <- prometheus.MustNewConstMetric(dbIdle, prometheus.GaugeValue, float64(stats.Idle))
+	ch <- prometheus.MustNewConstMetric(dbWaitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(dbWaitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+}
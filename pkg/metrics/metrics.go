@@ -0,0 +1,127 @@
+// Package metrics holds the Prometheus collectors the API exports on
+// /metrics: HTTP traffic, calls to outside services, the database pool and
+// a few business counters.
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "mowsy"
+
+// Outside services whose calls are timed.
+const (
+	ServiceStripe   = "stripe"
+	ServiceGeocodio = "geocodio"
+	ServiceS3       = "s3"
+	ServiceOIDC     = "oidc"
+	ServiceWebhook  = "webhook"
+)
+
+// Registry holds every collector. A dedicated registry keeps collectors
+// registered by libraries out of the exported set.
+var Registry = prometheus.NewRegistry()
+
+var (
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken to serve HTTP requests, by route template.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "http",
+		Name:      "requests_total",
+		Help:      "HTTP requests served, by route template and status code.",
+	}, []string{"method", "route", "status"})
+
+	outboundDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "outbound",
+		Name:      "request_duration_seconds",
+		Help:      "Time taken by calls to Stripe, Geocodio and S3.",
+		Buckets:   []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"service", "operation"})
+
+	outboundErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "outbound",
+		Name:      "errors_total",
+		Help:      "Failed calls to Stripe, Geocodio and S3.",
+	}, []string{"service", "operation"})
+
+	JobsCreated = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_created_total",
+		Help:      "Jobs posted.",
+	})
+
+	JobApplications = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "job_applications_total",
+		Help:      "Applications submitted for jobs.",
+	})
+
+	RentalRequests = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rental_requests_total",
+		Help:      "Equipment rentals requested.",
+	})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		httpRequestDuration,
+		httpRequests,
+		outboundDuration,
+		outboundErrors,
+		JobsCreated,
+		JobApplications,
+		RentalRequests,
+	)
+}
+
+// Handler serves the registry in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// ObserveHTTP records a served request. route is the route template, not the
+// path, so label cardinality stays bounded.
+func ObserveHTTP(method, route string, status int, elapsed time.Duration) {
+	httpRequestDuration.WithLabelValues(method, route).Observe(elapsed.Seconds())
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+}
+
+// ObserveOutbound records a call to an outside service that started at
+// start and returned err.
+func ObserveOutbound(service, operation string, start time.Time, err error) {
+	outboundDuration.WithLabelValues(service, operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		outboundErrors.WithLabelValues(service, operation).Inc()
+	}
+}
+
+// RegisterDB exports connection pool statistics for the database returned
+// by get, which is called on every scrape and may return nil before the
+// database is connected. Registering more than once is a no-op.
+func RegisterDB(get func() *sql.DB) {
+	err := Registry.Register(&dbStatsCollector{get: get})
+	var already prometheus.AlreadyRegisteredError
+	if err != nil && !errors.As(err, &already) {
+		panic(err)
+	}
+}
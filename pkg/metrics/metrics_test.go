@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestObserveHTTP(t *testing.T) {
+	before := testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/v1/jobs/:id", "404"))
+
+	ObserveHTTP("GET", "/v1/jobs/:id", http.StatusNotFound, 30*time.Millisecond)
+
+	assert.Equal(t, before+1, testutil.ToFloat64(httpRequests.WithLabelValues("GET", "/v1/jobs/:id", "404")))
+}
+
+func TestObserveOutbound_CountsErrors(t *testing.T) {
+	errorsBefore := testutil.ToFloat64(outboundErrors.WithLabelValues(ServiceStripe, "customer.test"))
+
+	ObserveOutbound(ServiceStripe, "customer.test", time.Now(), nil)
+	ObserveOutbound(ServiceStripe, "customer.test", time.Now(), errors.New("card declined"))
+
+	assert.Equal(t, errorsBefore+1, testutil.ToFloat64(outboundErrors.WithLabelValues(ServiceStripe, "customer.test")))
+	assert.Contains(t, scrape(t), `mowsy_outbound_request_duration_seconds_count{operation="customer.test",service="stripe"} 2`)
+}
+
+func TestRegisterDB(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+
+	RegisterDB(func() *sql.DB { return sqlDB })
+	RegisterDB(func() *sql.DB { return sqlDB })
+
+	body := scrape(t)
+	assert.Contains(t, body, "mowsy_db_open_connections")
+	assert.Contains(t, body, "mowsy_db_max_open_connections")
+}
+
+func TestHandler_ExportsBusinessCounters(t *testing.T) {
+	JobsCreated.Inc()
+
+	body := scrape(t)
+	assert.Contains(t, body, "mowsy_jobs_created_total")
+	assert.Contains(t, body, "mowsy_rental_requests_total")
+	assert.Contains(t, body, "go_goroutines")
+}
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	return w.Body.String()
+}
@@ -0,0 +1,47 @@
+package notify
+
+import (
+	"log/slog"
+	"os"
+)
+
+// DriversFromEnv builds a driver for every channel whose provider is
+// configured. When NOTIFY_FILE_DIR is set, channels without a provider fall
+// back to writing JSON lines there; otherwise they are left out and
+// notifications for them are skipped.
+func DriversFromEnv() Drivers {
+	drivers := Drivers{}
+
+	if host := os.Getenv("SMTP_HOST"); host != "" {
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		drivers[ChannelEmail] = NewSMTPDriver(host, port,
+			os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), os.Getenv("SMTP_FROM"))
+	}
+
+	if sid := os.Getenv("TWILIO_ACCOUNT_SID"); sid != "" {
+		drivers[ChannelSMS] = NewTwilioDriver(sid, os.Getenv("TWILIO_AUTH_TOKEN"), os.Getenv("TWILIO_FROM_NUMBER"))
+	}
+
+	if os.Getenv("PUSH_PROVIDER") == "expo" {
+		drivers[ChannelPush] = NewExpoPushDriver(os.Getenv("EXPO_ACCESS_TOKEN"))
+	}
+
+	if dir := os.Getenv("NOTIFY_FILE_DIR"); dir != "" {
+		for _, channel := range []Channel{ChannelEmail, ChannelSMS, ChannelPush} {
+			if _, ok := drivers[channel]; ok {
+				continue
+			}
+			driver, err := NewFileDriver(channel, dir)
+			if err != nil {
+				slog.Warn("notifications disabled", "channel", channel, "error", err)
+				continue
+			}
+			drivers[channel] = driver
+		}
+	}
+
+	return drivers
+}
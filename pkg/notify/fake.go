@@ -0,0 +1,98 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// MemoryDriver records messages instead of sending them. Tests can make it
+// fail by setting Err.
+type MemoryDriver struct {
+	channel Channel
+
+	mu       sync.Mutex
+	messages []Message
+	Err      error
+}
+
+func NewMemoryDriver(channel Channel) *MemoryDriver {
+	return &MemoryDriver{channel: channel}
+}
+
+func (d *MemoryDriver) Channel() Channel {
+	return d.channel
+}
+
+func (d *MemoryDriver) Send(ctx context.Context, msg Message) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.Err != nil {
+		return d.Err
+	}
+	d.messages = append(d.messages, msg)
+	return nil
+}
+
+// Messages returns a copy of everything sent so far.
+func (d *MemoryDriver) Messages() []Message {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	messages := make([]Message, len(d.messages))
+	copy(messages, d.messages)
+	return messages
+}
+
+// FileDriver appends each message as a JSON line to <dir>/<channel>.jsonl,
+// which is handy for local development without provider credentials.
+type FileDriver struct {
+	channel Channel
+	path    string
+	mu      sync.Mutex
+}
+
+func NewFileDriver(channel Channel, dir string) (*FileDriver, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create notification directory: %w", err)
+	}
+
+	return &FileDriver{
+		channel: channel,
+		path:    filepath.Join(dir, string(channel)+".jsonl"),
+	}, nil
+}
+
+func (d *FileDriver) Channel() Channel {
+	return d.channel
+}
+
+func (d *FileDriver) Send(ctx context.Context, msg Message) error {
+	line, err := json.Marshal(struct {
+		Message
+		SentAt time.Time `json:"sent_at"`
+	}{msg, time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f, err := os.OpenFile(d.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open notification file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write notification: %w", err)
+	}
+
+	return nil
+}
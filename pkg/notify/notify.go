@@ -0,0 +1,49 @@
+package notify
+
+import (
+	"context"
+	"errors"
+)
+
+type Channel string
+
+const (
+	ChannelEmail Channel = "email"
+	ChannelSMS   Channel = "sms"
+	ChannelPush  Channel = "push"
+)
+
+// ErrNoRecipient is returned when a message has no address for its channel.
+var ErrNoRecipient = errors.New("notification has no recipient")
+
+// Message is a single rendered notification ready to hand to a driver. To
+// holds an email address, a phone number or a push token depending on the
+// channel.
+type Message struct {
+	Channel Channel           `json:"channel"`
+	To      string            `json:"to"`
+	Subject string            `json:"subject,omitempty"`
+	Body    string            `json:"body"`
+	Data    map[string]string `json:"data,omitempty"`
+}
+
+// Driver delivers messages over one channel.
+type Driver interface {
+	Channel() Channel
+	Send(ctx context.Context, msg Message) error
+}
+
+// Drivers maps each configured channel to its driver.
+type Drivers map[Channel]Driver
+
+// Send routes a message to the driver for its channel.
+func (d Drivers) Send(ctx context.Context, msg Message) error {
+	driver, ok := d[msg.Channel]
+	if !ok {
+		return errors.New("no driver configured for channel " + string(msg.Channel))
+	}
+	if msg.To == "" {
+		return ErrNoRecipient
+	}
+	return driver.Send(ctx, msg)
+}
@@ -0,0 +1,151 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDrivers_Send(t *testing.T) {
+	email := NewMemoryDriver(ChannelEmail)
+	drivers := Drivers{ChannelEmail: email}
+
+	t.Run("RoutesByChannel", func(t *testing.T) {
+		err := drivers.Send(context.Background(), Message{Channel: ChannelEmail, To: "a@example.com", Body: "hi"})
+
+		require.NoError(t, err)
+		require.Len(t, email.Messages(), 1)
+		assert.Equal(t, "a@example.com", email.Messages()[0].To)
+	})
+
+	t.Run("UnconfiguredChannel", func(t *testing.T) {
+		err := drivers.Send(context.Background(), Message{Channel: ChannelSMS, To: "+15555550100", Body: "hi"})
+
+		assert.Error(t, err)
+	})
+
+	t.Run("MissingRecipient", func(t *testing.T) {
+		err := drivers.Send(context.Background(), Message{Channel: ChannelEmail, Body: "hi"})
+
+		assert.ErrorIs(t, err, ErrNoRecipient)
+	})
+
+	t.Run("DriverError", func(t *testing.T) {
+		email.Err = errors.New("mailbox full")
+		defer func() { email.Err = nil }()
+
+		err := drivers.Send(context.Background(), Message{Channel: ChannelEmail, To: "a@example.com", Body: "hi"})
+
+		assert.EqualError(t, err, "mailbox full")
+	})
+}
+
+func TestFileDriver_Send(t *testing.T) {
+	dir := t.TempDir()
+	driver, err := NewFileDriver(ChannelSMS, dir)
+	require.NoError(t, err)
+
+	require.NoError(t, driver.Send(context.Background(), Message{Channel: ChannelSMS, To: "+15555550100", Body: "one"}))
+	require.NoError(t, driver.Send(context.Background(), Message{Channel: ChannelSMS, To: "+15555550100", Body: "two"}))
+
+	contents, err := os.ReadFile(filepath.Join(dir, "sms.jsonl"))
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(contents)), "\n")
+	require.Len(t, lines, 2)
+
+	var msg Message
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &msg))
+	assert.Equal(t, "two", msg.Body)
+}
+
+func TestSMTPDriver_Send(t *testing.T) {
+	driver := NewSMTPDriver("smtp.example.com", "587", "", "", "noreply@mowsy.com")
+
+	var gotTo []string
+	var gotBody string
+	driver.sendMail = func(addr string, a smtp.Auth, from string, to []string, msg []byte) error {
+		assert.Equal(t, "smtp.example.com:587", addr)
+		gotTo = to
+		gotBody = string(msg)
+		return nil
+	}
+
+	err := driver.Send(context.Background(), Message{
+		Channel: ChannelEmail,
+		To:      "poster@example.com",
+		Subject: "New applicant\r\nBcc: attacker@example.com",
+		Body:    "Someone applied",
+	})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"poster@example.com"}, gotTo)
+	assert.Contains(t, gotBody, "Subject: New applicant  Bcc: attacker@example.com\r\n")
+	assert.NotContains(t, gotBody, "\r\nBcc:")
+	assert.True(t, strings.HasSuffix(gotBody, "\r\n\r\nSomeone applied"))
+}
+
+func TestTwilioDriver_Send(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		assert.True(t, ok)
+		assert.Equal(t, "AC123", user)
+		assert.Equal(t, "secret", pass)
+		assert.Equal(t, "/Accounts/AC123/Messages.json", r.URL.Path)
+		require.NoError(t, r.ParseForm())
+
+		if r.PostForm.Get("To") == "+15555550199" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"message":"invalid number"}`))
+			return
+		}
+		assert.Equal(t, "+15555550100", r.PostForm.Get("From"))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	driver := NewTwilioDriver("AC123", "secret", "+15555550100")
+	driver.baseURL = server.URL
+
+	assert.NoError(t, driver.Send(context.Background(), Message{Channel: ChannelSMS, To: "+15555550101", Body: "hi"}))
+
+	err := driver.Send(context.Background(), Message{Channel: ChannelSMS, To: "+15555550199", Body: "hi"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "status 400")
+}
+
+func TestExpoPushDriver_Send(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg expoPushMessage
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&msg))
+
+		if msg.To == "ExponentPushToken[stale]" {
+			w.Write([]byte(`{"data":{"status":"error","message":"DeviceNotRegistered"}}`))
+			return
+		}
+		assert.Equal(t, "Rental approved", msg.Title)
+		w.Write([]byte(`{"data":{"status":"ok","id":"abc"}}`))
+	}))
+	defer server.Close()
+
+	driver := NewExpoPushDriver("")
+	driver.baseURL = server.URL
+
+	assert.NoError(t, driver.Send(context.Background(), Message{
+		Channel: ChannelPush, To: "ExponentPushToken[good]", Subject: "Rental approved", Body: "hi",
+	}))
+
+	err := driver.Send(context.Background(), Message{Channel: ChannelPush, To: "ExponentPushToken[stale]", Body: "hi"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "DeviceNotRegistered")
+}
@@ -0,0 +1,86 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// ExpoPushDriver sends push notifications through the Expo push service,
+// which fans out to APNs and FCM for the mobile apps.
+type ExpoPushDriver struct {
+	accessToken string
+	baseURL     string
+	client      *http.Client
+}
+
+func NewExpoPushDriver(accessToken string) *ExpoPushDriver {
+	return &ExpoPushDriver{
+		accessToken: accessToken,
+		baseURL:     "https://exp.host/--/api/v2/push/send",
+		client: &http.Client{
+			Timeout: 15 * time.Second,
+		},
+	}
+}
+
+type expoPushMessage struct {
+	To    string            `json:"to"`
+	Title string            `json:"title,omitempty"`
+	Body  string            `json:"body"`
+	Data  map[string]string `json:"data,omitempty"`
+}
+
+type expoPushResponse struct {
+	Data struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+	} `json:"data"`
+}
+
+func (d *ExpoPushDriver) Channel() Channel {
+	return ChannelPush
+}
+
+func (d *ExpoPushDriver) Send(ctx context.Context, msg Message) error {
+	payload, err := json.Marshal(expoPushMessage{
+		To:    msg.To,
+		Title: msg.Subject,
+		Body:  msg.Body,
+		Data:  msg.Data,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to encode push message: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.baseURL, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create push request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if d.accessToken != "" {
+		req.Header.Set("Authorization", "Bearer "+d.accessToken)
+	}
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send push notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("push provider returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var result expoPushResponse
+	if err := json.Unmarshal(body, &result); err == nil && result.Data.Status == "error" {
+		return fmt.Errorf("push provider rejected message: %s", result.Data.Message)
+	}
+
+	return nil
+}
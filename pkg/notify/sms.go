@@ -0,0 +1,64 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TwilioDriver sends SMS through the Twilio Messages API.
+type TwilioDriver struct {
+	accountSID string
+	authToken  string
+	from       string
+	baseURL    string
+	client     *http.Client
+}
+
+func NewTwilioDriver(accountSID, authToken, from string) *TwilioDriver {
+	return &TwilioDriver{
+		accountSID: accountSID,
+		authToken:  authToken,
+		from:       from,
+		baseURL:    "https://api.twilio.com/2010-04-01",
+		client: &http.Client{
+			Timeout: 15 * time.Second,
+		},
+	}
+}
+
+func (d *TwilioDriver) Channel() Channel {
+	return ChannelSMS
+}
+
+func (d *TwilioDriver) Send(ctx context.Context, msg Message) error {
+	form := url.Values{}
+	form.Set("To", msg.To)
+	form.Set("From", d.from)
+	form.Set("Body", msg.Body)
+
+	endpoint := fmt.Sprintf("%s/Accounts/%s/Messages.json", d.baseURL, url.PathEscape(d.accountSID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return fmt.Errorf("failed to create SMS request: %w", err)
+	}
+	req.SetBasicAuth(d.accountSID, d.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send SMS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("SMS provider returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type SMTPDriver struct {
+	addr     string
+	host     string
+	from     string
+	auth     smtp.Auth
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+}
+
+func NewSMTPDriver(host, port, username, password, from string) *SMTPDriver {
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &SMTPDriver{
+		addr:     net.JoinHostPort(host, port),
+		host:     host,
+		from:     from,
+		auth:     auth,
+		sendMail: smtp.SendMail,
+	}
+}
+
+func (d *SMTPDriver) Channel() Channel {
+	return ChannelEmail
+}
+
+func (d *SMTPDriver) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := d.sendMail(d.addr, d.auth, d.from, []string{msg.To}, d.buildMessage(msg)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+
+	return nil
+}
+
+func (d *SMTPDriver) buildMessage(msg Message) []byte {
+	var b strings.Builder
+	b.WriteString("From: " + d.from + "\r\n")
+	b.WriteString("To: " + msg.To + "\r\n")
+	b.WriteString("Subject: " + stripHeaderBreaks(msg.Subject) + "\r\n")
+	b.WriteString("Date: " + time.Now().Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
+
+// stripHeaderBreaks keeps user-controlled text from injecting extra headers.
+func stripHeaderBreaks(value string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(value)
+}
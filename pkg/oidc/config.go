@@ -0,0 +1,58 @@
+package oidc
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+)
+
+const (
+	ProviderGoogle = "google"
+	ProviderApple  = "apple"
+)
+
+// ProvidersFromEnv configures each provider that has client IDs set:
+// GOOGLE_CLIENT_IDS and APPLE_CLIENT_IDS, comma separated since the web and
+// mobile apps have their own. OAUTH_JWKS_FILE replaces every provider's
+// published keys with a local JWKS file, for signing test tokens locally.
+func ProvidersFromEnv() Providers {
+	var keysOverride KeySet
+	if path := os.Getenv("OAUTH_JWKS_FILE"); path != "" {
+		keys, err := LoadStaticKeySet(path)
+		if err != nil {
+			slog.Warn("OAuth sign-in disabled: failed to load OAUTH_JWKS_FILE", "error", err)
+			return Providers{}
+		}
+		keysOverride = keys
+	}
+
+	providers := Providers{}
+	add := func(name, clientIDsEnv, jwksURL string, issuers ...string) {
+		clientIDs := splitList(os.Getenv(clientIDsEnv))
+		if len(clientIDs) == 0 {
+			return
+		}
+		var keys KeySet = NewRemoteKeySet(name, jwksURL)
+		if keysOverride != nil {
+			keys = keysOverride
+		}
+		providers[name] = &Provider{Name: name, Issuers: issuers, Audiences: clientIDs, Keys: keys}
+	}
+
+	add(ProviderGoogle, "GOOGLE_CLIENT_IDS", "https://www.googleapis.com/oauth2/v3/certs",
+		"https://accounts.google.com", "accounts.google.com")
+	add(ProviderApple, "APPLE_CLIENT_IDS", "https://appleid.apple.com/auth/keys",
+		"https://appleid.apple.com")
+
+	return providers
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
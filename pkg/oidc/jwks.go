@@ -0,0 +1,242 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"mowsy-api/pkg/metrics"
+	"mowsy-api/pkg/tracing"
+
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+)
+
+// ErrUnknownKey is returned when no key in the set has the token's kid.
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// KeySet finds the public key a provider signed an ID token with.
+type KeySet interface {
+	Key(ctx context.Context, kid string) (crypto.PublicKey, error)
+}
+
+// JSONWebKey is one entry of a JWKS document. Only the fields needed for
+// RSA and EC signature keys are read.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// PublicKey decodes the key. Keys of other types, or not meant for
+// signatures, return an error.
+func (k JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	if k.Use != "" && k.Use != "sig" {
+		return nil, fmt.Errorf("key %q is not a signing key", k.Kid)
+	}
+
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus for key %q: %w", k.Kid, err)
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid exponent for key %q", k.Kid)
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %q for key %q", k.Crv, k.Kid)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x for key %q: %w", k.Kid, err)
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y for key %q: %w", k.Kid, err)
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		if !key.Curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("key %q is not on its curve", k.Kid)
+		}
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %q for key %q", k.Kty, k.Kid)
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	if value == "" {
+		return nil, errors.New("missing value")
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+// parseKeySet decodes the usable keys in a JWKS document by kid. Keys that
+// cannot be used are skipped rather than failing the whole set.
+func parseKeySet(data []byte) (map[string]crypto.PublicKey, error) {
+	var set JSONWebKeySet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		key, err := jwk.PublicKey()
+		if err != nil {
+			continue
+		}
+		keys[jwk.Kid] = key
+	}
+	return keys, nil
+}
+
+// StaticKeySet serves a fixed set of keys. It stands in for a provider's
+// JWKS endpoint in tests and local development.
+type StaticKeySet struct {
+	keys map[string]crypto.PublicKey
+}
+
+func NewStaticKeySet(keys map[string]crypto.PublicKey) *StaticKeySet {
+	return &StaticKeySet{keys: keys}
+}
+
+// LoadStaticKeySet reads a JWKS document from a file.
+func LoadStaticKeySet(path string) (*StaticKeySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+	keys, err := parseKeySet(data)
+	if err != nil {
+		return nil, err
+	}
+	return NewStaticKeySet(keys), nil
+}
+
+func (s *StaticKeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+// How long fetched keys are trusted, and how often an unknown kid may force
+// an early refetch. Providers publish new keys well before using them, so
+// an unknown kid is usually a forged or stale token rather than a rotation.
+const (
+	remoteKeySetTTL        = time.Hour
+	remoteKeySetMinRefresh = time.Minute
+)
+
+// RemoteKeySet fetches a provider's JWKS document and caches it.
+type RemoteKeySet struct {
+	provider string
+	url      string
+	client   *http.Client
+	now      func() time.Time
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+func NewRemoteKeySet(provider, url string) *RemoteKeySet {
+	return &RemoteKeySet{
+		provider: provider,
+		url:      url,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		now:      time.Now,
+	}
+}
+
+func (s *RemoteKeySet) Key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	stale := now.Sub(s.fetchedAt) >= remoteKeySetTTL
+	if key, ok := s.keys[kid]; ok && !stale {
+		return key, nil
+	}
+
+	if stale || now.Sub(s.fetchedAt) >= remoteKeySetMinRefresh {
+		keys, err := s.fetch(ctx)
+		if err != nil {
+			// Keep using what we have if the provider is briefly down
+			if key, ok := s.keys[kid]; ok {
+				return key, nil
+			}
+			return nil, err
+		}
+		s.keys = keys
+		s.fetchedAt = now
+	}
+
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return key, nil
+}
+
+func (s *RemoteKeySet) fetch(ctx context.Context) (keys map[string]crypto.PublicKey, err error) {
+	operation := s.provider + ".jwks"
+	ctx, span := tracing.StartClient(ctx, metrics.ServiceOIDC, operation)
+	start := time.Now()
+	defer func() {
+		metrics.ObserveOutbound(metrics.ServiceOIDC, operation, start, err)
+		tracing.End(span, err)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+	defer resp.Body.Close()
+
+	span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS endpoint returned status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS: %w", err)
+	}
+	return parseKeySet(data)
+}
@@ -0,0 +1,135 @@
+// Package oidc verifies ID tokens from OpenID Connect providers such as
+// Google and Apple. Clients complete the provider's sign-in flow themselves
+// and send the resulting ID token to the API.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	ErrInvalidToken    = errors.New("invalid ID token")
+	ErrUnknownProvider = errors.New("unknown sign-in provider")
+)
+
+// clockSkew is the leeway allowed on exp, iat and nbf.
+const clockSkew = time.Minute
+
+// Identity is what a verified ID token says about the user.
+type Identity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	GivenName     string
+	FamilyName    string
+}
+
+// Provider verifies ID tokens from one identity provider.
+type Provider struct {
+	Name string
+	// Issuers lists accepted iss values; Google uses two spellings.
+	Issuers []string
+	// Audiences lists our client IDs with the provider. A token must be
+	// issued to one of them.
+	Audiences []string
+	Keys      KeySet
+
+	now func() time.Time
+}
+
+type idTokenClaims struct {
+	Email         string       `json:"email"`
+	EmailVerified flexibleBool `json:"email_verified"`
+	GivenName     string       `json:"given_name"`
+	FamilyName    string       `json:"family_name"`
+	Nonce         string       `json:"nonce"`
+	jwt.RegisteredClaims
+}
+
+// flexibleBool accepts true and "true": Apple sends email_verified as a
+// string.
+type flexibleBool bool
+
+func (b *flexibleBool) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case bool:
+		*b = flexibleBool(v)
+	case string:
+		*b = flexibleBool(v == "true")
+	default:
+		*b = false
+	}
+	return nil
+}
+
+// Verify checks rawToken's signature, issuer, audience and lifetime. When
+// nonce is not empty the token must carry the same nonce.
+func (p *Provider) Verify(ctx context.Context, rawToken, nonce string) (*Identity, error) {
+	now := time.Now
+	if p.now != nil {
+		now = p.now
+	}
+
+	var claims idTokenClaims
+	_, err := jwt.ParseWithClaims(rawToken, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		if kid == "" {
+			return nil, errors.New("token has no kid")
+		}
+		return p.Keys.Key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "ES256"}),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(clockSkew),
+		jwt.WithTimeFunc(now),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	switch {
+	case claims.ExpiresAt == nil:
+		return nil, fmt.Errorf("%w: token has no expiry", ErrInvalidToken)
+	case !slices.Contains(p.Issuers, claims.Issuer):
+		return nil, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidToken, claims.Issuer)
+	case !slices.ContainsFunc(claims.Audience, func(aud string) bool { return slices.Contains(p.Audiences, aud) }):
+		return nil, fmt.Errorf("%w: token was issued to another client", ErrInvalidToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: token has no subject", ErrInvalidToken)
+	case nonce != "" && claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: nonce does not match", ErrInvalidToken)
+	}
+
+	return &Identity{
+		Provider:      p.Name,
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		GivenName:     claims.GivenName,
+		FamilyName:    claims.FamilyName,
+	}, nil
+}
+
+// Providers holds the configured providers by name.
+type Providers map[string]*Provider
+
+// Verify verifies rawToken with the named provider.
+func (p Providers) Verify(ctx context.Context, provider, rawToken, nonce string) (*Identity, error) {
+	verifier, ok := p[provider]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+	return verifier.Verify(ctx, rawToken, nonce)
+}
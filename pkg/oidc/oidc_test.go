@@ -0,0 +1,206 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testIssuer = "https://accounts.example.com"
+
+func newRSAKey(t *testing.T) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	return key
+}
+
+func rsaJWK(kid string, key *rsa.PublicKey) JSONWebKey {
+	return JSONWebKey{
+		Kty: "RSA", Kid: kid, Use: "sig", Alg: "RS256",
+		N: base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func validClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            testIssuer,
+		"aud":            "web-client",
+		"sub":            "provider-user-1",
+		"email":          "jane@example.com",
+		"email_verified": "true",
+		"given_name":     "Jane",
+		"family_name":    "Doe",
+		"nonce":          "n-123",
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	}
+}
+
+func TestProvider_Verify(t *testing.T) {
+	rsaKey := newRSAKey(t)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	provider := &Provider{
+		Name:      "example",
+		Issuers:   []string{testIssuer},
+		Audiences: []string{"web-client", "ios-client"},
+		Keys: NewStaticKeySet(map[string]crypto.PublicKey{
+			"rsa-1": &rsaKey.PublicKey,
+			"ec-1":  &ecKey.PublicKey,
+		}),
+	}
+
+	t.Run("RS256", func(t *testing.T) {
+		identity, err := provider.Verify(context.Background(), sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, validClaims()), "n-123")
+		require.NoError(t, err)
+		assert.Equal(t, &Identity{
+			Provider: "example", Subject: "provider-user-1", Email: "jane@example.com",
+			EmailVerified: true, GivenName: "Jane", FamilyName: "Doe",
+		}, identity)
+	})
+
+	t.Run("ES256", func(t *testing.T) {
+		claims := validClaims()
+		claims["aud"] = []string{"ios-client"}
+		claims["email_verified"] = false
+		identity, err := provider.Verify(context.Background(), sign(t, jwt.SigningMethodES256, "ec-1", ecKey, claims), "")
+		require.NoError(t, err)
+		assert.False(t, identity.EmailVerified)
+	})
+
+	rejected := map[string]func(claims jwt.MapClaims){
+		"WrongIssuer":   func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"WrongAudience": func(c jwt.MapClaims) { c["aud"] = "someone-else" },
+		"Expired":       func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-2 * clockSkew).Unix() },
+		"NoExpiry":      func(c jwt.MapClaims) { delete(c, "exp") },
+		"IssuedLater":   func(c jwt.MapClaims) { c["iat"] = time.Now().Add(2 * clockSkew).Unix() },
+		"NoSubject":     func(c jwt.MapClaims) { delete(c, "sub") },
+		"WrongNonce":    func(c jwt.MapClaims) { c["nonce"] = "other" },
+	}
+	for name, mutate := range rejected {
+		t.Run(name, func(t *testing.T) {
+			claims := validClaims()
+			mutate(claims)
+			_, err := provider.Verify(context.Background(), sign(t, jwt.SigningMethodRS256, "rsa-1", rsaKey, claims), "n-123")
+			assert.ErrorIs(t, err, ErrInvalidToken)
+		})
+	}
+
+	t.Run("UnknownKid", func(t *testing.T) {
+		_, err := provider.Verify(context.Background(), sign(t, jwt.SigningMethodRS256, "rsa-2", rsaKey, validClaims()), "")
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("SignedByAnotherKey", func(t *testing.T) {
+		_, err := provider.Verify(context.Background(), sign(t, jwt.SigningMethodRS256, "rsa-1", newRSAKey(t), validClaims()), "")
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+
+	t.Run("SymmetricAlgorithm", func(t *testing.T) {
+		// A token MACed with the public key bytes must not pass as RS256
+		secret := rsaKey.PublicKey.N.Bytes()
+		_, err := provider.Verify(context.Background(), sign(t, jwt.SigningMethodHS256, "rsa-1", secret, validClaims()), "")
+		assert.ErrorIs(t, err, ErrInvalidToken)
+	})
+}
+
+func TestRemoteKeySet(t *testing.T) {
+	key := newRSAKey(t)
+	var fetches atomic.Int32
+	var published atomic.Value
+	published.Store(JSONWebKeySet{Keys: []JSONWebKey{rsaJWK("k1", &key.PublicKey)}})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(published.Load())
+	}))
+	defer server.Close()
+
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	keys := NewRemoteKeySet("example", server.URL)
+	keys.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	got, err := keys.Key(ctx, "k1")
+	require.NoError(t, err)
+	assert.True(t, key.PublicKey.Equal(got))
+	_, err = keys.Key(ctx, "k1")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), fetches.Load(), "keys are cached")
+
+	// An unknown kid refetches, but at most once a minute
+	_, err = keys.Key(ctx, "k2")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	assert.Equal(t, int32(1), fetches.Load())
+
+	rotated := newRSAKey(t)
+	published.Store(JSONWebKeySet{Keys: []JSONWebKey{rsaJWK("k1", &key.PublicKey), rsaJWK("k2", &rotated.PublicKey)}})
+	now = now.Add(remoteKeySetMinRefresh)
+	got, err = keys.Key(ctx, "k2")
+	require.NoError(t, err)
+	assert.True(t, rotated.PublicKey.Equal(got))
+	assert.Equal(t, int32(2), fetches.Load())
+
+	// Stale keys are still used while the provider is down
+	server.Close()
+	now = now.Add(remoteKeySetTTL)
+	_, err = keys.Key(ctx, "k1")
+	assert.NoError(t, err)
+}
+
+func TestProvidersFromEnv(t *testing.T) {
+	key := newRSAKey(t)
+	data, err := json.Marshal(JSONWebKeySet{Keys: []JSONWebKey{
+		rsaJWK("local-1", &key.PublicKey),
+		{Kty: "oct", Kid: "ignored"},
+	}})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+
+	t.Setenv("GOOGLE_CLIENT_IDS", "web-client, android-client")
+	t.Setenv("APPLE_CLIENT_IDS", "")
+	t.Setenv("OAUTH_JWKS_FILE", path)
+
+	providers := ProvidersFromEnv()
+	require.Contains(t, providers, ProviderGoogle)
+	assert.NotContains(t, providers, ProviderApple)
+	assert.Equal(t, []string{"web-client", "android-client"}, providers[ProviderGoogle].Audiences)
+
+	claims := validClaims()
+	claims["iss"] = "accounts.google.com"
+	claims["aud"] = "android-client"
+	identity, err := providers.Verify(context.Background(), ProviderGoogle, sign(t, jwt.SigningMethodRS256, "local-1", key, claims), "")
+	require.NoError(t, err)
+	assert.Equal(t, ProviderGoogle, identity.Provider)
+
+	_, err = providers.Verify(context.Background(), ProviderApple, "token", "")
+	assert.ErrorIs(t, err, ErrUnknownProvider)
+}
@@ -0,0 +1,368 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Payment methods the Fake understands, named after Stripe's test ones.
+const (
+	TestCardSucceeds    = "pm_card_visa"
+	TestCardDeclined    = "pm_card_chargeDeclined"
+	TestCardRequires3DS = "pm_card_authenticationRequired"
+	TestBankDebit       = "pm_usBankAccount"
+)
+
+var _ Gateway = (*Fake)(nil)
+
+// FakeTransfer is a payout the Fake recorded.
+type FakeTransfer struct {
+	ID string
+	TransferParams
+}
+
+// Fake is a Gateway that keeps its state in memory and follows Stripe's
+// rules for which calls each intent status allows. Tests stand in for the
+// app and the bank with Confirm, Authenticate and Settle.
+type Fake struct {
+	// AutoConfirm, when set, confirms every new intent with this payment
+	// method, as if the app had done it straight away.
+	AutoConfirm string
+
+	mu         sync.Mutex
+	seq        int
+	customers  map[string]CustomerParams
+	intents    map[string]*fakeIntent
+	refunds    map[string][]Refund
+	transfers  []FakeTransfer
+	idempotent map[string]interface{}
+	failures   map[string]error
+}
+
+type fakeIntent struct {
+	PaymentIntent
+	manual   bool
+	refunded int64
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		customers:  make(map[string]CustomerParams),
+		intents:    make(map[string]*fakeIntent),
+		refunds:    make(map[string][]Refund),
+		idempotent: make(map[string]interface{}),
+		failures:   make(map[string]error),
+	}
+}
+
+// FailNext makes the next call to operation, e.g. "payment_intent.capture",
+// return err, as an outage would.
+func (f *Fake) FailNext(operation string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[operation] = err
+}
+
+func (f *Fake) injected(operation string) error {
+	err := f.failures[operation]
+	delete(f.failures, operation)
+	return err
+}
+
+func (f *Fake) newID(prefix string) string {
+	f.seq++
+	return fmt.Sprintf("%s_fake%d", prefix, f.seq)
+}
+
+func (f *Fake) intent(intentID string) (*fakeIntent, error) {
+	intent, ok := f.intents[intentID]
+	if !ok {
+		return nil, fmt.Errorf("%w: no such payment intent %q", ErrInvalidRequest, intentID)
+	}
+	return intent, nil
+}
+
+func (f *Fake) CreateCustomer(ctx context.Context, params CustomerParams) (*Customer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.injected("customer.create"); err != nil {
+		return nil, err
+	}
+
+	id := f.newID("cus")
+	f.customers[id] = params
+	return &Customer{ID: id}, nil
+}
+
+func (f *Fake) UpdateCustomer(ctx context.Context, customerID string, params CustomerParams) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.injected("customer.update"); err != nil {
+		return err
+	}
+
+	if _, ok := f.customers[customerID]; !ok {
+		return fmt.Errorf("%w: no such customer %q", ErrInvalidRequest, customerID)
+	}
+	f.customers[customerID] = params
+	return nil
+}
+
+// Customer returns what the Fake holds for a customer.
+func (f *Fake) Customer(customerID string) (CustomerParams, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	params, ok := f.customers[customerID]
+	return params, ok
+}
+
+func (f *Fake) CreatePaymentIntent(ctx context.Context, params PaymentIntentParams) (*PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.injected("payment_intent.create"); err != nil {
+		return nil, err
+	}
+
+	if params.Amount <= 0 {
+		return nil, fmt.Errorf("%w: amount must be positive", ErrInvalidRequest)
+	}
+
+	id := f.newID("pi")
+	intent := &fakeIntent{
+		PaymentIntent: PaymentIntent{
+			ID:           id,
+			ClientSecret: id + "_secret",
+			Amount:       params.Amount,
+			Currency:     params.Currency,
+			Status:       IntentRequiresPaymentMethod,
+		},
+		manual: params.ManualCapture,
+	}
+	f.intents[id] = intent
+
+	if f.AutoConfirm != "" {
+		f.confirm(intent, f.AutoConfirm)
+	}
+
+	created := intent.PaymentIntent
+	return &created, nil
+}
+
+func (f *Fake) GetPaymentIntent(ctx context.Context, intentID string) (*PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.injected("payment_intent.get"); err != nil {
+		return nil, err
+	}
+
+	intent, err := f.intent(intentID)
+	if err != nil {
+		return nil, err
+	}
+	found := intent.PaymentIntent
+	return &found, nil
+}
+
+// Confirm attempts the payment with paymentMethod, as the app does with the
+// client secret. A 3DS card leaves the intent waiting for Authenticate and a
+// bank debit leaves it processing until Settle.
+func (f *Fake) Confirm(intentID, paymentMethod string) (*PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	intent, err := f.intent(intentID)
+	if err != nil {
+		return nil, err
+	}
+	if intent.Status != IntentRequiresPaymentMethod && intent.Status != IntentRequiresConfirmation {
+		return nil, fmt.Errorf("%w: cannot confirm a payment intent with status %s", ErrInvalidRequest, intent.Status)
+	}
+
+	f.confirm(intent, paymentMethod)
+	confirmed := intent.PaymentIntent
+	return &confirmed, nil
+}
+
+func (f *Fake) confirm(intent *fakeIntent, paymentMethod string) {
+	intent.LastError = ""
+	switch paymentMethod {
+	case TestCardSucceeds:
+		f.authorize(intent)
+	case TestCardRequires3DS:
+		intent.Status = IntentRequiresAction
+	case TestBankDebit:
+		intent.Status = IntentProcessing
+	case TestCardDeclined:
+		f.decline(intent, "Your card was declined.")
+	default:
+		f.decline(intent, fmt.Sprintf("No such payment method: %s", paymentMethod))
+	}
+}
+
+func (f *Fake) authorize(intent *fakeIntent) {
+	if intent.manual {
+		intent.Status = IntentRequiresCapture
+	} else {
+		intent.Status = IntentSucceeded
+	}
+}
+
+func (f *Fake) decline(intent *fakeIntent, reason string) {
+	intent.Status = IntentRequiresPaymentMethod
+	intent.LastError = reason
+}
+
+// Authenticate completes or fails the 3DS challenge of an intent that
+// requires action.
+func (f *Fake) Authenticate(intentID string, approve bool) (*PaymentIntent, error) {
+	return f.resolve(intentID, IntentRequiresAction, approve, "The cardholder failed authentication.")
+}
+
+// Settle finishes an asynchronous payment that is processing, as the bank
+// eventually does.
+func (f *Fake) Settle(intentID string, succeed bool) (*PaymentIntent, error) {
+	return f.resolve(intentID, IntentProcessing, succeed, "The bank debit failed.")
+}
+
+func (f *Fake) resolve(intentID string, from IntentStatus, succeed bool, reason string) (*PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	intent, err := f.intent(intentID)
+	if err != nil {
+		return nil, err
+	}
+	if intent.Status != from {
+		return nil, fmt.Errorf("%w: payment intent has status %s, not %s", ErrInvalidRequest, intent.Status, from)
+	}
+
+	if succeed {
+		f.authorize(intent)
+	} else {
+		f.decline(intent, reason)
+	}
+	resolved := intent.PaymentIntent
+	return &resolved, nil
+}
+
+func (f *Fake) CapturePaymentIntent(ctx context.Context, intentID, idempotencyKey string) (*PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.injected("payment_intent.capture"); err != nil {
+		return nil, err
+	}
+	if previous, ok := f.idempotent["capture:"+idempotencyKey].(PaymentIntent); ok && idempotencyKey != "" {
+		return &previous, nil
+	}
+
+	intent, err := f.intent(intentID)
+	if err != nil {
+		return nil, err
+	}
+	if intent.Status != IntentRequiresCapture {
+		return nil, fmt.Errorf("%w: cannot capture a payment intent with status %s", ErrInvalidRequest, intent.Status)
+	}
+
+	intent.Status = IntentSucceeded
+	captured := intent.PaymentIntent
+	if idempotencyKey != "" {
+		f.idempotent["capture:"+idempotencyKey] = captured
+	}
+	return &captured, nil
+}
+
+func (f *Fake) CancelPaymentIntent(ctx context.Context, intentID string) (*PaymentIntent, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.injected("payment_intent.cancel"); err != nil {
+		return nil, err
+	}
+
+	intent, err := f.intent(intentID)
+	if err != nil {
+		return nil, err
+	}
+	if intent.Status == IntentSucceeded || intent.Status == IntentCanceled {
+		return nil, fmt.Errorf("%w: cannot cancel a payment intent with status %s", ErrInvalidRequest, intent.Status)
+	}
+
+	intent.Status = IntentCanceled
+	canceled := intent.PaymentIntent
+	return &canceled, nil
+}
+
+func (f *Fake) CreateRefund(ctx context.Context, params RefundParams) (*Refund, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.injected("refund.create"); err != nil {
+		return nil, err
+	}
+	if previous, ok := f.idempotent["refund:"+params.IdempotencyKey].(Refund); ok && params.IdempotencyKey != "" {
+		return &previous, nil
+	}
+
+	intent, err := f.intent(params.PaymentIntentID)
+	if err != nil {
+		return nil, err
+	}
+	if intent.Status != IntentSucceeded {
+		return nil, fmt.Errorf("%w: cannot refund a payment intent with status %s", ErrInvalidRequest, intent.Status)
+	}
+
+	remaining := intent.Amount - intent.refunded
+	amount := params.Amount
+	if amount == 0 {
+		amount = remaining
+	}
+	if amount <= 0 || amount > remaining {
+		return nil, fmt.Errorf("%w: refund of %d exceeds the %d left on the charge", ErrInvalidRequest, amount, remaining)
+	}
+
+	intent.refunded += amount
+	refund := Refund{ID: f.newID("re"), Amount: amount}
+	f.refunds[intent.ID] = append(f.refunds[intent.ID], refund)
+	if params.IdempotencyKey != "" {
+		f.idempotent["refund:"+params.IdempotencyKey] = refund
+	}
+	return &refund, nil
+}
+
+// Refunds lists the refunds made against an intent.
+func (f *Fake) Refunds(intentID string) []Refund {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Refund(nil), f.refunds[intentID]...)
+}
+
+func (f *Fake) CreateTransfer(ctx context.Context, params TransferParams) (*Transfer, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.injected("transfer.create"); err != nil {
+		return nil, err
+	}
+	if previous, ok := f.idempotent["transfer:"+params.IdempotencyKey].(Transfer); ok && params.IdempotencyKey != "" {
+		return &previous, nil
+	}
+
+	if params.DestinationID == "" {
+		return nil, fmt.Errorf("%w: transfer needs a destination account", ErrInvalidRequest)
+	}
+	if params.Amount <= 0 {
+		return nil, fmt.Errorf("%w: amount must be positive", ErrInvalidRequest)
+	}
+
+	transfer := Transfer{ID: f.newID("tr")}
+	f.transfers = append(f.transfers, FakeTransfer{ID: transfer.ID, TransferParams: params})
+	if params.IdempotencyKey != "" {
+		f.idempotent["transfer:"+params.IdempotencyKey] = transfer
+	}
+	return &transfer, nil
+}
+
+// Transfers lists the payouts made so far.
+func (f *Fake) Transfers() []FakeTransfer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]FakeTransfer(nil), f.transfers...)
+}
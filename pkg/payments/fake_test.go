@@ -0,0 +1,234 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newFakeIntent(t *testing.T, fake *Fake, manual bool) *PaymentIntent {
+	t.Helper()
+	intent, err := fake.CreatePaymentIntent(context.Background(), PaymentIntentParams{
+		Amount: 5000, Currency: "usd", CustomerID: "cus_1", ManualCapture: manual,
+	})
+	require.NoError(t, err)
+	require.Equal(t, IntentRequiresPaymentMethod, intent.Status)
+	require.NotEmpty(t, intent.ClientSecret)
+	return intent
+}
+
+func TestFake_Confirm(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Succeeds", func(t *testing.T) {
+		fake := NewFake()
+		intent := newFakeIntent(t, fake, false)
+
+		confirmed, err := fake.Confirm(intent.ID, TestCardSucceeds)
+		require.NoError(t, err)
+		assert.Equal(t, IntentSucceeded, confirmed.Status)
+
+		got, err := fake.GetPaymentIntent(ctx, intent.ID)
+		require.NoError(t, err)
+		assert.Equal(t, IntentSucceeded, got.Status)
+	})
+
+	t.Run("ManualCaptureHoldsTheCard", func(t *testing.T) {
+		fake := NewFake()
+		intent := newFakeIntent(t, fake, true)
+
+		confirmed, err := fake.Confirm(intent.ID, TestCardSucceeds)
+		require.NoError(t, err)
+		assert.Equal(t, IntentRequiresCapture, confirmed.Status)
+	})
+
+	t.Run("DeclinedCardCanBeRetried", func(t *testing.T) {
+		fake := NewFake()
+		intent := newFakeIntent(t, fake, false)
+
+		declined, err := fake.Confirm(intent.ID, TestCardDeclined)
+		require.NoError(t, err)
+		assert.Equal(t, IntentRequiresPaymentMethod, declined.Status)
+		assert.NotEmpty(t, declined.LastError)
+
+		retried, err := fake.Confirm(intent.ID, TestCardSucceeds)
+		require.NoError(t, err)
+		assert.Equal(t, IntentSucceeded, retried.Status)
+		assert.Empty(t, retried.LastError)
+	})
+
+	t.Run("3DS", func(t *testing.T) {
+		fake := NewFake()
+		intent := newFakeIntent(t, fake, true)
+
+		challenged, err := fake.Confirm(intent.ID, TestCardRequires3DS)
+		require.NoError(t, err)
+		assert.Equal(t, IntentRequiresAction, challenged.Status)
+
+		_, err = fake.Settle(intent.ID, true)
+		assert.ErrorIs(t, err, ErrInvalidRequest, "only processing intents settle")
+
+		authenticated, err := fake.Authenticate(intent.ID, true)
+		require.NoError(t, err)
+		assert.Equal(t, IntentRequiresCapture, authenticated.Status)
+	})
+
+	t.Run("Failed3DS", func(t *testing.T) {
+		fake := NewFake()
+		intent := newFakeIntent(t, fake, false)
+		_, err := fake.Confirm(intent.ID, TestCardRequires3DS)
+		require.NoError(t, err)
+
+		failed, err := fake.Authenticate(intent.ID, false)
+		require.NoError(t, err)
+		assert.Equal(t, IntentRequiresPaymentMethod, failed.Status)
+		assert.NotEmpty(t, failed.LastError)
+	})
+
+	t.Run("AsyncProcessing", func(t *testing.T) {
+		fake := NewFake()
+		intent := newFakeIntent(t, fake, false)
+
+		processing, err := fake.Confirm(intent.ID, TestBankDebit)
+		require.NoError(t, err)
+		assert.Equal(t, IntentProcessing, processing.Status)
+
+		_, err = fake.Confirm(intent.ID, TestCardSucceeds)
+		assert.ErrorIs(t, err, ErrInvalidRequest)
+
+		settled, err := fake.Settle(intent.ID, true)
+		require.NoError(t, err)
+		assert.Equal(t, IntentSucceeded, settled.Status)
+	})
+
+	t.Run("AutoConfirm", func(t *testing.T) {
+		fake := NewFake()
+		fake.AutoConfirm = TestCardSucceeds
+
+		intent, err := fake.CreatePaymentIntent(ctx, PaymentIntentParams{Amount: 100, Currency: "usd"})
+		require.NoError(t, err)
+		assert.Equal(t, IntentSucceeded, intent.Status)
+	})
+}
+
+func TestFake_CaptureAndCancel(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("CaptureIsIdempotent", func(t *testing.T) {
+		fake := NewFake()
+		intent := newFakeIntent(t, fake, true)
+		_, err := fake.Confirm(intent.ID, TestCardSucceeds)
+		require.NoError(t, err)
+
+		captured, err := fake.CapturePaymentIntent(ctx, intent.ID, "capture-1")
+		require.NoError(t, err)
+		assert.Equal(t, IntentSucceeded, captured.Status)
+
+		again, err := fake.CapturePaymentIntent(ctx, intent.ID, "capture-1")
+		require.NoError(t, err, "a retry with the same key replays the result")
+		assert.Equal(t, IntentSucceeded, again.Status)
+
+		_, err = fake.CapturePaymentIntent(ctx, intent.ID, "capture-2")
+		assert.ErrorIs(t, err, ErrInvalidRequest)
+	})
+
+	t.Run("CannotCaptureWithoutAuthorization", func(t *testing.T) {
+		fake := NewFake()
+		intent := newFakeIntent(t, fake, true)
+
+		_, err := fake.CapturePaymentIntent(ctx, intent.ID, "")
+		assert.ErrorIs(t, err, ErrInvalidRequest)
+	})
+
+	t.Run("CancelReleasesHold", func(t *testing.T) {
+		fake := NewFake()
+		intent := newFakeIntent(t, fake, true)
+		_, err := fake.Confirm(intent.ID, TestCardSucceeds)
+		require.NoError(t, err)
+
+		canceled, err := fake.CancelPaymentIntent(ctx, intent.ID)
+		require.NoError(t, err)
+		assert.Equal(t, IntentCanceled, canceled.Status)
+
+		_, err = fake.CapturePaymentIntent(ctx, intent.ID, "")
+		assert.ErrorIs(t, err, ErrInvalidRequest)
+	})
+
+	t.Run("CannotCancelSucceeded", func(t *testing.T) {
+		fake := NewFake()
+		intent := newFakeIntent(t, fake, false)
+		_, err := fake.Confirm(intent.ID, TestCardSucceeds)
+		require.NoError(t, err)
+
+		_, err = fake.CancelPaymentIntent(ctx, intent.ID)
+		assert.ErrorIs(t, err, ErrInvalidRequest)
+	})
+}
+
+func TestFake_Refunds(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFake()
+	intent := newFakeIntent(t, fake, false)
+
+	_, err := fake.CreateRefund(ctx, RefundParams{PaymentIntentID: intent.ID})
+	assert.ErrorIs(t, err, ErrInvalidRequest, "nothing has been charged yet")
+
+	_, err = fake.Confirm(intent.ID, TestCardSucceeds)
+	require.NoError(t, err)
+
+	partial, err := fake.CreateRefund(ctx, RefundParams{PaymentIntentID: intent.ID, Amount: 2000, IdempotencyKey: "refund-1"})
+	require.NoError(t, err)
+	assert.Equal(t, int64(2000), partial.Amount)
+
+	replayed, err := fake.CreateRefund(ctx, RefundParams{PaymentIntentID: intent.ID, Amount: 2000, IdempotencyKey: "refund-1"})
+	require.NoError(t, err)
+	assert.Equal(t, partial.ID, replayed.ID)
+
+	rest, err := fake.CreateRefund(ctx, RefundParams{PaymentIntentID: intent.ID})
+	require.NoError(t, err)
+	assert.Equal(t, int64(3000), rest.Amount, "zero refunds what is left")
+
+	_, err = fake.CreateRefund(ctx, RefundParams{PaymentIntentID: intent.ID, Amount: 1})
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+
+	assert.Len(t, fake.Refunds(intent.ID), 2)
+}
+
+func TestFake_Transfers(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFake()
+
+	_, err := fake.CreateTransfer(ctx, TransferParams{Amount: 100, Currency: "usd"})
+	assert.ErrorIs(t, err, ErrInvalidRequest)
+
+	params := TransferParams{Amount: 4500, Currency: "usd", DestinationID: "acct_owner", IdempotencyKey: "transfer-1"}
+	first, err := fake.CreateTransfer(ctx, params)
+	require.NoError(t, err)
+	second, err := fake.CreateTransfer(ctx, params)
+	require.NoError(t, err)
+
+	assert.Equal(t, first.ID, second.ID)
+	require.Len(t, fake.Transfers(), 1)
+	assert.Equal(t, "acct_owner", fake.Transfers()[0].DestinationID)
+}
+
+func TestFake_FailNext(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFake()
+	outage := errors.New("connection reset")
+	fake.FailNext("customer.create", outage)
+
+	_, err := fake.CreateCustomer(ctx, CustomerParams{UserID: 1, Email: "a@example.com"})
+	assert.ErrorIs(t, err, outage)
+
+	customer, err := fake.CreateCustomer(ctx, CustomerParams{UserID: 1, Email: "a@example.com"})
+	require.NoError(t, err, "only the next call fails")
+
+	require.NoError(t, fake.UpdateCustomer(ctx, customer.ID, CustomerParams{UserID: 1, Email: "b@example.com"}))
+	params, ok := fake.Customer(customer.ID)
+	require.True(t, ok)
+	assert.Equal(t, "b@example.com", params.Email)
+}
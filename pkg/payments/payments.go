@@ -0,0 +1,118 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+)
+
+var (
+	// ErrNotConfigured is returned by a gateway that has no credentials.
+	ErrNotConfigured = errors.New("payment gateway not configured")
+
+	// ErrInvalidRequest marks calls the gateway refused outright, such as
+	// capturing an intent that was canceled. Retrying them cannot succeed.
+	ErrInvalidRequest = errors.New("payment request rejected")
+)
+
+// IntentStatus follows the lifecycle of a Stripe payment intent.
+type IntentStatus string
+
+const (
+	IntentRequiresPaymentMethod IntentStatus = "requires_payment_method"
+	IntentRequiresConfirmation  IntentStatus = "requires_confirmation"
+	IntentRequiresAction        IntentStatus = "requires_action"
+	IntentProcessing            IntentStatus = "processing"
+	IntentRequiresCapture       IntentStatus = "requires_capture"
+	IntentCanceled              IntentStatus = "canceled"
+	IntentSucceeded             IntentStatus = "succeeded"
+)
+
+// CustomerParams describes the person a customer record is kept for.
+type CustomerParams struct {
+	UserID uint
+	Email  string
+	Name   string
+	Phone  string
+}
+
+type Customer struct {
+	ID string
+}
+
+// PaymentIntentParams asks for a charge. Amount is in the currency's
+// smallest unit, e.g. cents. With ManualCapture the payment method is only
+// authorized, and the money moves when the intent is captured.
+type PaymentIntentParams struct {
+	Amount        int64
+	Currency      string
+	CustomerID    string
+	Description   string
+	Metadata      map[string]string
+	ManualCapture bool
+}
+
+// PaymentIntent is a charge in progress. The client secret lets the app
+// collect and confirm the payment method itself. LastError describes the
+// most recent failed attempt, such as a declined card.
+type PaymentIntent struct {
+	ID           string
+	ClientSecret string
+	Amount       int64
+	Currency     string
+	Status       IntentStatus
+	LastError    string
+}
+
+// RefundParams returns money from a captured intent. A zero Amount refunds
+// whatever has not been refunded yet.
+type RefundParams struct {
+	PaymentIntentID string
+	Amount          int64
+	IdempotencyKey  string
+}
+
+type Refund struct {
+	ID     string
+	Amount int64
+}
+
+// TransferParams pays out to a connected account, such as an equipment
+// owner's.
+type TransferParams struct {
+	Amount         int64
+	Currency       string
+	DestinationID  string
+	TransferGroup  string
+	IdempotencyKey string
+}
+
+type Transfer struct {
+	ID string
+}
+
+// Gateway is the payment processor the API charges through.
+type Gateway interface {
+	CreateCustomer(ctx context.Context, params CustomerParams) (*Customer, error)
+	UpdateCustomer(ctx context.Context, customerID string, params CustomerParams) error
+	CreatePaymentIntent(ctx context.Context, params PaymentIntentParams) (*PaymentIntent, error)
+	GetPaymentIntent(ctx context.Context, intentID string) (*PaymentIntent, error)
+	CapturePaymentIntent(ctx context.Context, intentID, idempotencyKey string) (*PaymentIntent, error)
+	CancelPaymentIntent(ctx context.Context, intentID string) (*PaymentIntent, error)
+	CreateRefund(ctx context.Context, params RefundParams) (*Refund, error)
+	CreateTransfer(ctx context.Context, params TransferParams) (*Transfer, error)
+}
+
+// FromEnv returns the Stripe gateway for STRIPE_SECRET_KEY. Without a key
+// every call fails with ErrNotConfigured. PAYMENT_GATEWAY=fake selects an
+// in-memory Fake that approves every payment instead, for working offline.
+func FromEnv() Gateway {
+	if os.Getenv("PAYMENT_GATEWAY") == "fake" {
+		slog.Warn("using the fake payment gateway; no money will move")
+		fake := NewFake()
+		fake.AutoConfirm = TestCardSucceeds
+		return fake
+	}
+	return NewStripe(os.Getenv("STRIPE_SECRET_KEY"))
+}
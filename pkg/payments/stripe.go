@@ -0,0 +1,223 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"mowsy-api/pkg/metrics"
+	"mowsy-api/pkg/tracing"
+
+	"github.com/stripe/stripe-go/v75"
+	"github.com/stripe/stripe-go/v75/client"
+)
+
+var _ Gateway = (*Stripe)(nil)
+
+// Stripe is the Gateway backed by the Stripe API. It keeps its own client
+// rather than setting the stripe package's global key.
+type Stripe struct {
+	api *client.API
+}
+
+func NewStripe(secretKey string) *Stripe {
+	if secretKey == "" {
+		return &Stripe{}
+	}
+	api := &client.API{}
+	api.Init(secretKey, nil)
+	return &Stripe{api: api}
+}
+
+// call runs one Stripe API call under a span and records its latency.
+func (s *Stripe) call(ctx context.Context, operation string, fn func() error) error {
+	if s.api == nil {
+		return ErrNotConfigured
+	}
+
+	_, span := tracing.StartClient(ctx, metrics.ServiceStripe, operation)
+	start := time.Now()
+	err := fn()
+	metrics.ObserveOutbound(metrics.ServiceStripe, operation, start, err)
+	tracing.End(span, err)
+	return stripeError(err)
+}
+
+// stripeError marks the errors Stripe will return again on retry.
+func stripeError(err error) error {
+	var stripeErr *stripe.Error
+	if !errors.As(err, &stripeErr) {
+		return err
+	}
+	switch stripeErr.Type {
+	case stripe.ErrorTypeCard, stripe.ErrorTypeInvalidRequest, stripe.ErrorTypeIdempotency:
+		return fmt.Errorf("%w: %s", ErrInvalidRequest, stripeErr.Msg)
+	}
+	return err
+}
+
+func customerParams(params CustomerParams) *stripe.CustomerParams {
+	customerParams := &stripe.CustomerParams{
+		Email: stripe.String(params.Email),
+		Name:  stripe.String(params.Name),
+	}
+	if params.Phone != "" {
+		customerParams.Phone = stripe.String(params.Phone)
+	}
+	return customerParams
+}
+
+func (s *Stripe) CreateCustomer(ctx context.Context, params CustomerParams) (*Customer, error) {
+	customerParams := customerParams(params)
+	customerParams.Metadata = map[string]string{
+		"user_id": fmt.Sprintf("%d", params.UserID),
+	}
+	customerParams.SetIdempotencyKey(fmt.Sprintf("customer-create-%d", params.UserID))
+
+	var created *stripe.Customer
+	err := s.call(ctx, "customer.create", func() (err error) {
+		created, err = s.api.Customers.New(customerParams)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Customer{ID: created.ID}, nil
+}
+
+func (s *Stripe) UpdateCustomer(ctx context.Context, customerID string, params CustomerParams) error {
+	return s.call(ctx, "customer.update", func() error {
+		_, err := s.api.Customers.Update(customerID, customerParams(params))
+		return err
+	})
+}
+
+func (s *Stripe) CreatePaymentIntent(ctx context.Context, params PaymentIntentParams) (*PaymentIntent, error) {
+	intentParams := &stripe.PaymentIntentParams{
+		Amount:   stripe.Int64(params.Amount),
+		Currency: stripe.String(params.Currency),
+		Customer: stripe.String(params.CustomerID),
+		AutomaticPaymentMethods: &stripe.PaymentIntentAutomaticPaymentMethodsParams{
+			Enabled: stripe.Bool(true),
+		},
+		Metadata: params.Metadata,
+	}
+	if params.Description != "" {
+		intentParams.Description = stripe.String(params.Description)
+	}
+	if params.ManualCapture {
+		intentParams.CaptureMethod = stripe.String(string(stripe.PaymentIntentCaptureMethodManual))
+	}
+
+	var intent *stripe.PaymentIntent
+	err := s.call(ctx, "payment_intent.create", func() (err error) {
+		intent, err = s.api.PaymentIntents.New(intentParams)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fromStripeIntent(intent), nil
+}
+
+func (s *Stripe) GetPaymentIntent(ctx context.Context, intentID string) (*PaymentIntent, error) {
+	var intent *stripe.PaymentIntent
+	err := s.call(ctx, "payment_intent.get", func() (err error) {
+		intent, err = s.api.PaymentIntents.Get(intentID, nil)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fromStripeIntent(intent), nil
+}
+
+func (s *Stripe) CapturePaymentIntent(ctx context.Context, intentID, idempotencyKey string) (*PaymentIntent, error) {
+	params := &stripe.PaymentIntentCaptureParams{}
+	if idempotencyKey != "" {
+		params.SetIdempotencyKey(idempotencyKey)
+	}
+
+	var intent *stripe.PaymentIntent
+	err := s.call(ctx, "payment_intent.capture", func() (err error) {
+		intent, err = s.api.PaymentIntents.Capture(intentID, params)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fromStripeIntent(intent), nil
+}
+
+func (s *Stripe) CancelPaymentIntent(ctx context.Context, intentID string) (*PaymentIntent, error) {
+	var intent *stripe.PaymentIntent
+	err := s.call(ctx, "payment_intent.cancel", func() (err error) {
+		intent, err = s.api.PaymentIntents.Cancel(intentID, &stripe.PaymentIntentCancelParams{})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fromStripeIntent(intent), nil
+}
+
+func (s *Stripe) CreateRefund(ctx context.Context, params RefundParams) (*Refund, error) {
+	refundParams := &stripe.RefundParams{
+		PaymentIntent: stripe.String(params.PaymentIntentID),
+	}
+	if params.Amount > 0 {
+		refundParams.Amount = stripe.Int64(params.Amount)
+	}
+	if params.IdempotencyKey != "" {
+		refundParams.SetIdempotencyKey(params.IdempotencyKey)
+	}
+
+	var refund *stripe.Refund
+	err := s.call(ctx, "refund.create", func() (err error) {
+		refund, err = s.api.Refunds.New(refundParams)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Refund{ID: refund.ID, Amount: refund.Amount}, nil
+}
+
+func (s *Stripe) CreateTransfer(ctx context.Context, params TransferParams) (*Transfer, error) {
+	transferParams := &stripe.TransferParams{
+		Amount:      stripe.Int64(params.Amount),
+		Currency:    stripe.String(params.Currency),
+		Destination: stripe.String(params.DestinationID),
+	}
+	if params.TransferGroup != "" {
+		transferParams.TransferGroup = stripe.String(params.TransferGroup)
+	}
+	if params.IdempotencyKey != "" {
+		transferParams.SetIdempotencyKey(params.IdempotencyKey)
+	}
+
+	var transfer *stripe.Transfer
+	err := s.call(ctx, "transfer.create", func() (err error) {
+		transfer, err = s.api.Transfers.New(transferParams)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Transfer{ID: transfer.ID}, nil
+}
+
+func fromStripeIntent(intent *stripe.PaymentIntent) *PaymentIntent {
+	converted := &PaymentIntent{
+		ID:           intent.ID,
+		ClientSecret: intent.ClientSecret,
+		Amount:       intent.Amount,
+		Currency:     string(intent.Currency),
+		Status:       IntentStatus(intent.Status),
+	}
+	if intent.LastPaymentError != nil {
+		converted.LastError = intent.LastPaymentError.Msg
+	}
+	return converted
+}
@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const sweepInterval = time.Minute
+
+var _ Store = (*MemoryStore)(nil)
+
+// MemoryStore keeps buckets in process. Limits are per instance and reset
+// when the process restarts, which suits a single server and tests.
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]memoryBucket
+	lastSweep time.Time
+}
+
+type memoryBucket struct {
+	bucket
+	expiresAt time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{buckets: make(map[string]memoryBucket)}
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, policy Policy, now time.Time) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	id := bucketKey(policy, key)
+	var current *bucket
+	if existing, ok := s.buckets[id]; ok {
+		current = &existing.bucket
+	}
+
+	next, result := policy.take(current, now)
+	s.buckets[id] = memoryBucket{bucket: next, expiresAt: now.Add(result.Reset)}
+	return result, nil
+}
+
+// sweep drops buckets that have refilled, which behave exactly like missing
+// ones, so the map does not grow with every client ever seen.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for id, b := range s.buckets {
+		if !now.Before(b.expiresAt) {
+			delete(s.buckets, id)
+		}
+	}
+}
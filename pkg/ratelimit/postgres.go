@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const pruneInterval = 10 * time.Minute
+
+var _ Store = (*PostgresStore)(nil)
+
+// PostgresBucket is one row of the rate_limit_buckets table. Key is the
+// policy name followed by the client key, e.g. "login:ip:203.0.113.7". A
+// bucket past ExpiresAt has refilled completely and can be deleted.
+type PostgresBucket struct {
+	Key        string    `json:"key" gorm:"primaryKey;size:255"`
+	Tokens     float64   `json:"tokens" gorm:"not null"`
+	RefilledAt time.Time `json:"refilled_at" gorm:"not null"`
+	ExpiresAt  time.Time `json:"expires_at" gorm:"not null;index"`
+}
+
+func (PostgresBucket) TableName() string {
+	return "rate_limit_buckets"
+}
+
+// PostgresStore keeps buckets in the rate_limit_buckets table, so every
+// instance and every Lambda cold start sees the same counts. Each take locks
+// the bucket's row for the length of a short transaction.
+type PostgresStore struct {
+	db *gorm.DB
+
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Take(ctx context.Context, key string, policy Policy, now time.Time) (Result, error) {
+	s.prune(ctx, now)
+
+	id := bucketKey(policy, key)
+	var result Result
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var row PostgresBucket
+		var current *bucket
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", id).First(&row).Error
+		switch {
+		case err == nil:
+			current = &bucket{tokens: row.Tokens, refilledAt: row.RefilledAt}
+		case !errors.Is(err, gorm.ErrRecordNotFound):
+			return fmt.Errorf("failed to read rate limit bucket: %w", err)
+		}
+
+		var next bucket
+		next, result = policy.take(current, now)
+
+		// Two first requests can both miss the row; the upsert lets the
+		// second overwrite the first rather than fail, at the cost of one
+		// uncounted request.
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "key"}},
+			DoUpdates: clause.AssignmentColumns([]string{"tokens", "refilled_at", "expires_at"}),
+		}).Create(&PostgresBucket{
+			Key:        id,
+			Tokens:     next.tokens,
+			RefilledAt: next.refilledAt,
+			ExpiresAt:  now.Add(result.Reset),
+		}).Error; err != nil {
+			return fmt.Errorf("failed to save rate limit bucket: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return Result{}, err
+	}
+
+	return result, nil
+}
+
+// prune deletes refilled buckets every pruneInterval. A full bucket and a
+// missing one allow the same requests, so this never changes a decision.
+func (s *PostgresStore) prune(ctx context.Context, now time.Time) {
+	s.mu.Lock()
+	if now.Sub(s.lastPrune) < pruneInterval {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPrune = now
+	s.mu.Unlock()
+
+	if err := s.db.WithContext(ctx).Where("expires_at <= ?", now).Delete(&PostgresBucket{}).Error; err != nil {
+		slog.Warn("failed to prune rate limit buckets", "error", err)
+	}
+}
@@ -0,0 +1,106 @@
+// Package ratelimit implements token-bucket rate limiting over a pluggable
+// store, so limits can be kept in process or shared between instances.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Policy allows bursts of up to Limit requests and refills at Limit per
+// Period, so a client that keeps to the average rate is never refused.
+type Policy struct {
+	Name   string
+	Limit  int
+	Period time.Duration
+}
+
+// Result describes a bucket after a request has been counted against it.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until a refused request would be allowed. It is
+	// zero when the request was allowed.
+	RetryAfter time.Duration
+}
+
+// Store keeps token buckets. Take counts one request against the bucket for
+// key under policy and reports whether it was allowed; buckets for different
+// policies never collide.
+type Store interface {
+	Take(ctx context.Context, key string, policy Policy, now time.Time) (Result, error)
+}
+
+type bucket struct {
+	tokens     float64
+	refilledAt time.Time
+}
+
+// take refills b for the time elapsed since it was last touched and removes
+// one token if a whole one is available. A missing bucket starts full.
+func (p Policy) take(b *bucket, now time.Time) (bucket, Result) {
+	limit := float64(p.Limit)
+	perSecond := limit / p.Period.Seconds()
+
+	tokens := limit
+	if b != nil {
+		elapsed := now.Sub(b.refilledAt).Seconds()
+		if elapsed < 0 {
+			elapsed = 0
+		}
+		tokens = math.Min(limit, b.tokens+elapsed*perSecond)
+	}
+
+	result := Result{Limit: p.Limit}
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - tokens) / perSecond)
+	}
+	result.Remaining = int(math.Floor(tokens))
+	result.Reset = seconds((limit - tokens) / perSecond)
+
+	return bucket{tokens: tokens, refilledAt: now}, result
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+func bucketKey(policy Policy, key string) string {
+	return policy.Name + ":" + key
+}
+
+// NewStoreFromEnv selects a store with RATE_LIMIT_STORE ("postgres" or
+// "memory"). When it is unset, the database is used if there is one, so that
+// limits hold across Lambda instances and cold starts.
+func NewStoreFromEnv(db *gorm.DB) (Store, error) {
+	kind := os.Getenv("RATE_LIMIT_STORE")
+	if kind == "" {
+		kind = "memory"
+		if db != nil {
+			kind = "postgres"
+		}
+	}
+
+	switch kind {
+	case "postgres":
+		if db == nil {
+			return nil, fmt.Errorf("RATE_LIMIT_STORE=postgres needs a database connection")
+		}
+		return NewPostgresStore(db), nil
+	case "memory":
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown RATE_LIMIT_STORE: %s", kind)
+	}
+}
@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var testPolicy = Policy{Name: "test", Limit: 3, Period: 3 * time.Minute}
+
+func TestPolicy_Take(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	b, result := testPolicy.take(nil, now)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 2, result.Remaining)
+	assert.Equal(t, time.Minute, result.Reset)
+
+	b, _ = testPolicy.take(&b, now)
+	b, result = testPolicy.take(&b, now)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+
+	_, result = testPolicy.take(&b, now.Add(30*time.Second))
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 30*time.Second, result.RetryAfter)
+
+	// One token per minute comes back
+	b, result = testPolicy.take(&b, now.Add(time.Minute))
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 3*time.Minute, result.Reset)
+
+	// Refills stop at the limit
+	_, result = testPolicy.take(&b, now.Add(time.Hour))
+	assert.Equal(t, 2, result.Remaining)
+}
+
+func testStore(t *testing.T, store Store) {
+	ctx := context.Background()
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < testPolicy.Limit; i++ {
+		result, err := store.Take(ctx, "ip:203.0.113.7", testPolicy, now)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+	}
+
+	result, err := store.Take(ctx, "ip:203.0.113.7", testPolicy, now)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, time.Minute, result.RetryAfter)
+
+	// Other clients and other policies have their own buckets
+	result, err = store.Take(ctx, "ip:198.51.100.1", testPolicy, now)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	other := Policy{Name: "other", Limit: 1, Period: time.Minute}
+	result, err = store.Take(ctx, "ip:203.0.113.7", other, now)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	result, err = store.Take(ctx, "ip:203.0.113.7", testPolicy, now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+
+	t.Run("SweepsRefilledBuckets", func(t *testing.T) {
+		store := NewMemoryStore()
+		now := time.Now()
+		_, err := store.Take(context.Background(), "ip:203.0.113.7", testPolicy, now)
+		require.NoError(t, err)
+
+		_, err = store.Take(context.Background(), "ip:198.51.100.1", testPolicy, now.Add(time.Hour))
+		require.NoError(t, err)
+
+		assert.Len(t, store.buckets, 1)
+	})
+}
+
+// setupTestDB opens an in-memory database with just the buckets table;
+// internal/testutils can't be used here as it migrates this package's model.
+func setupTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&PostgresBucket{}))
+	return db
+}
+
+func TestPostgresStore(t *testing.T) {
+	db := setupTestDB(t)
+
+	testStore(t, NewPostgresStore(db))
+
+	t.Run("PrunesRefilledBuckets", func(t *testing.T) {
+		store := NewPostgresStore(db)
+		_, err := store.Take(context.Background(), "ip:192.0.2.1", testPolicy, time.Now().Add(24*time.Hour))
+		require.NoError(t, err)
+
+		var keys []string
+		require.NoError(t, db.Model(&PostgresBucket{}).Pluck("key", &keys).Error)
+		assert.Equal(t, []string{"test:ip:192.0.2.1"}, keys)
+	})
+}
+
+func TestNewStoreFromEnv(t *testing.T) {
+	db := setupTestDB(t)
+
+	t.Setenv("RATE_LIMIT_STORE", "")
+	store, err := NewStoreFromEnv(db)
+	require.NoError(t, err)
+	assert.IsType(t, &PostgresStore{}, store)
+
+	store, err = NewStoreFromEnv(nil)
+	require.NoError(t, err)
+	assert.IsType(t, &MemoryStore{}, store)
+
+	t.Setenv("RATE_LIMIT_STORE", "postgres")
+	_, err = NewStoreFromEnv(nil)
+	assert.Error(t, err)
+
+	t.Setenv("RATE_LIMIT_STORE", "redis")
+	_, err = NewStoreFromEnv(db)
+	assert.Error(t, err)
+}
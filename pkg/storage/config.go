@@ -0,0 +1,76 @@
+package storage
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// LocalFilesPath is where the API server serves LocalBackend files.
+const LocalFilesPath = "/files"
+
+var (
+	defaultOnce    sync.Once
+	defaultBackend Backend
+	defaultErr     error
+)
+
+// Default returns the process-wide backend built by NewFromEnv. It is shared
+// so the upload service and the local file routes agree on the signing key.
+func Default() (Backend, error) {
+	defaultOnce.Do(func() {
+		defaultBackend, defaultErr = NewFromEnv()
+	})
+	return defaultBackend, defaultErr
+}
+
+// NewFromEnv selects a backend with STORAGE_BACKEND ("s3" or "local"). When it
+// is unset, S3 is used if AWS_S3_BUCKET_NAME is configured and the local
+// filesystem otherwise.
+func NewFromEnv() (Backend, error) {
+	backend := os.Getenv("STORAGE_BACKEND")
+	if backend == "" {
+		backend = "local"
+		if os.Getenv("AWS_S3_BUCKET_NAME") != "" {
+			backend = "s3"
+		}
+	}
+
+	switch backend {
+	case "s3":
+		return NewS3Service()
+	case "local":
+		return localBackendFromEnv()
+	default:
+		return nil, fmt.Errorf("unknown STORAGE_BACKEND: %s", backend)
+	}
+}
+
+func localBackendFromEnv() (*LocalBackend, error) {
+	dir := os.Getenv("STORAGE_LOCAL_DIR")
+	if dir == "" {
+		dir = "./tmp/uploads"
+	}
+
+	baseURL := os.Getenv("STORAGE_LOCAL_BASE_URL")
+	if baseURL == "" {
+		port := os.Getenv("PORT")
+		if port == "" {
+			port = "8080"
+		}
+		baseURL = "http://localhost:" + port + LocalFilesPath
+	}
+
+	secret := []byte(os.Getenv("STORAGE_SIGNING_KEY"))
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("failed to generate signing key: %w", err)
+		}
+		slog.Warn("STORAGE_SIGNING_KEY not set; presigned file URLs will not survive a restart", "dir", dir)
+	}
+
+	return NewLocalBackend(dir, baseURL, secret)
+}
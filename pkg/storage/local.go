@@ -0,0 +1,291 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidKey       = errors.New("invalid file key")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrURLExpired       = errors.New("signed URL has expired")
+)
+
+var _ Backend = (*LocalBackend)(nil)
+
+// LocalBackend keeps files in a directory on disk. The API server serves them
+// under baseURL (see handlers.FileHandler), so presigned URLs are signed with
+// an HMAC of the method, key, content type, size and expiry instead of AWS
+// credentials.
+type LocalBackend struct {
+	root    string
+	baseURL string
+	secret  []byte
+	now     func() time.Time
+}
+
+func NewLocalBackend(root, baseURL string, secret []byte) (*LocalBackend, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("local storage needs a signing key")
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+
+	return &LocalBackend{
+		root:    root,
+		baseURL: strings.TrimRight(baseURL, "/"),
+		secret:  secret,
+		now:     time.Now,
+	}, nil
+}
+
+func (b *LocalBackend) UploadFile(file io.Reader, fileName, mimeType string, userID uint, visibility Visibility) (*UploadResult, error) {
+	if err := validateFile(fileName, mimeType); err != nil {
+		return nil, err
+	}
+
+	key := generateKey(fileName, userID, visibility)
+	size, err := b.Write(key, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	return &UploadResult{
+		URL:      b.url(key, nil),
+		Key:      key,
+		Size:     size,
+		MimeType: mimeType,
+	}, nil
+}
+
+func (b *LocalBackend) PutFile(key string, file io.Reader, mimeType string) (*UploadResult, error) {
+	size, err := b.Write(key, file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload file: %w", err)
+	}
+
+	return &UploadResult{
+		URL:      b.url(key, nil),
+		Key:      key,
+		Size:     size,
+		MimeType: mimeType,
+	}, nil
+}
+
+func (b *LocalBackend) OpenFile(key string) (io.ReadCloser, error) {
+	path, err := b.Path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrFileNotFound
+	}
+	return file, err
+}
+
+func (b *LocalBackend) FileURL(key string) string {
+	return b.url(key, nil)
+}
+
+func (b *LocalBackend) ListFiles(prefix string, fn func(FileInfo) error) error {
+	err := filepath.WalkDir(b.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".upload-") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(b.root, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		return fn(FileInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (b *LocalBackend) StatFile(key string) (*FileInfo, error) {
+	path, err := b.Path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	// Content types are not stored on disk, so go by the extension
+	return &FileInfo{
+		Key:          key,
+		Size:         info.Size(),
+		ContentType:  mime.TypeByExtension(filepath.Ext(path)),
+		LastModified: info.ModTime(),
+	}, nil
+}
+
+func (b *LocalBackend) DeleteFile(key string) error {
+	path, err := b.Path(key)
+	if err != nil {
+		return err
+	}
+
+	// Like S3, deleting a missing object is not an error
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete file: %w", err)
+	}
+
+	return nil
+}
+
+func (b *LocalBackend) GetPresignedURL(key string, expiration time.Duration) (string, error) {
+	return b.presign("GET", key, "", 0, expiration)
+}
+
+func (b *LocalBackend) GetPresignedUploadURL(key string, mimeType string, size int64, expiration time.Duration) (string, error) {
+	return b.presign("PUT", key, mimeType, size, expiration)
+}
+
+func (b *LocalBackend) presign(method, key, mimeType string, size int64, expiration time.Duration) (string, error) {
+	if _, err := b.Path(key); err != nil {
+		return "", err
+	}
+
+	expires := strconv.FormatInt(b.now().Add(expiration).Unix(), 10)
+	query := url.Values{}
+	query.Set("expires", expires)
+	if mimeType != "" {
+		query.Set("content_type", mimeType)
+	}
+	if size > 0 {
+		query.Set("content_length", strconv.FormatInt(size, 10))
+	}
+	query.Set("signature", b.sign(method, key, mimeType, query.Get("content_length"), expires))
+
+	return b.url(key, query), nil
+}
+
+// Verify checks a presigned URL's query parameters for the given method and
+// key. For uploads, contentType is the Content-Type the client sent and must
+// match the one the URL was signed for; see SignedContentLength for the size.
+func (b *LocalBackend) Verify(method, key, contentType string, query url.Values) error {
+	expires := query.Get("expires")
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	expected := b.sign(method, key, query.Get("content_type"), query.Get("content_length"), expires)
+	if !hmac.Equal([]byte(expected), []byte(query.Get("signature"))) {
+		return ErrInvalidSignature
+	}
+	if method == "PUT" && contentType != query.Get("content_type") {
+		return ErrInvalidSignature
+	}
+	if b.now().Unix() > expiresAt {
+		return ErrURLExpired
+	}
+
+	return nil
+}
+
+// SignedContentLength returns the exact upload size a verified presigned PUT
+// was signed for, or 0 if it allows any size.
+func SignedContentLength(query url.Values) int64 {
+	size, err := strconv.ParseInt(query.Get("content_length"), 10, 64)
+	if err != nil || size < 0 {
+		return 0
+	}
+	return size
+}
+
+func (b *LocalBackend) sign(method, key, mimeType, contentLength, expires string) string {
+	mac := hmac.New(sha256.New, b.secret)
+	mac.Write([]byte(strings.Join([]string{method, key, mimeType, contentLength, expires}, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Path resolves key to a file under the storage root, rejecting keys that
+// would escape it.
+func (b *LocalBackend) Path(key string) (string, error) {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return "", ErrInvalidKey
+	}
+	clean := filepath.Clean(filepath.FromSlash(key))
+	if clean == "." || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", ErrInvalidKey
+	}
+
+	return filepath.Join(b.root, clean), nil
+}
+
+// Write stores the contents of r under key, replacing any existing file.
+func (b *LocalBackend) Write(key string, r io.Reader) (int64, error) {
+	path, err := b.Path(key)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return 0, err
+	}
+
+	// Write to a temporary file first so readers never see a partial upload
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	size, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+func (b *LocalBackend) url(key string, query url.Values) string {
+	u := b.baseURL + "/" + (&url.URL{Path: key}).EscapedPath()
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestLocalBackend(t *testing.T) *LocalBackend {
+	backend, err := NewLocalBackend(t.TempDir(), "http://localhost:8080/files/", []byte("test-signing-key"))
+	require.NoError(t, err)
+	return backend
+}
+
+func TestLocalBackend_UploadAndDelete(t *testing.T) {
+	backend := newTestLocalBackend(t)
+
+	result, err := backend.UploadFile(strings.NewReader("png bytes"), "my lawn.png", "image/png", 7, VisibilityPublic)
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(result.Key, "uploads/7/"))
+	assert.Equal(t, int64(9), result.Size)
+	assert.Equal(t, "http://localhost:8080/files/"+result.Key, result.URL)
+
+	path, err := backend.Path(result.Key)
+	require.NoError(t, err)
+	contents, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, "png bytes", string(contents))
+
+	require.NoError(t, backend.DeleteFile(result.Key))
+	_, err = os.Stat(path)
+	assert.True(t, os.IsNotExist(err))
+
+	// Deleting again is a no-op
+	assert.NoError(t, backend.DeleteFile(result.Key))
+}
+
+func TestLocalBackend_UploadPrivate(t *testing.T) {
+	backend := newTestLocalBackend(t)
+
+	result, err := backend.UploadFile(strings.NewReader("%PDF"), "policy.pdf", "application/pdf", 7, VisibilityPrivate)
+	require.NoError(t, err)
+
+	assert.True(t, strings.HasPrefix(result.Key, "private/7/"))
+	assert.Equal(t, VisibilityPrivate, KeyVisibility(result.Key))
+}
+
+func TestLocalBackend_ListFiles(t *testing.T) {
+	backend := newTestLocalBackend(t)
+
+	public, err := backend.UploadFile(strings.NewReader("png bytes"), "lawn.png", "image/png", 7, VisibilityPublic)
+	require.NoError(t, err)
+	_, err = backend.UploadFile(strings.NewReader("%PDF"), "policy.pdf", "application/pdf", 7, VisibilityPrivate)
+	require.NoError(t, err)
+
+	// Half-written temp files are not listed
+	path, err := backend.Path("uploads/7/.upload-123")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, []byte("partial"), 0o600))
+
+	var listed []FileInfo
+	require.NoError(t, backend.ListFiles("uploads/", func(info FileInfo) error {
+		listed = append(listed, info)
+		return nil
+	}))
+
+	require.Len(t, listed, 1)
+	assert.Equal(t, public.Key, listed[0].Key)
+	assert.Equal(t, int64(9), listed[0].Size)
+	assert.WithinDuration(t, time.Now(), listed[0].LastModified, time.Minute)
+
+	empty := newTestLocalBackend(t)
+	empty.root = empty.root + "/missing"
+	assert.NoError(t, empty.ListFiles("", func(FileInfo) error { return nil }))
+}
+
+func TestLocalBackend_StatFile(t *testing.T) {
+	backend := newTestLocalBackend(t)
+
+	result, err := backend.UploadFile(strings.NewReader("%PDF"), "policy.pdf", "application/pdf", 7, VisibilityPrivate)
+	require.NoError(t, err)
+
+	info, err := backend.StatFile(result.Key)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), info.Size)
+	assert.Equal(t, "application/pdf", info.ContentType)
+
+	_, err = backend.StatFile("private/7/missing.pdf")
+	assert.ErrorIs(t, err, ErrFileNotFound)
+	_, err = backend.StatFile("private/7")
+	assert.ErrorIs(t, err, ErrFileNotFound)
+}
+
+func TestLocalBackend_RejectsUnsupportedFiles(t *testing.T) {
+	backend := newTestLocalBackend(t)
+
+	_, err := backend.UploadFile(strings.NewReader("#!/bin/sh"), "run.sh", "text/x-shellscript", 1, VisibilityPublic)
+
+	assert.Error(t, err)
+}
+
+func TestLocalBackend_Path(t *testing.T) {
+	backend := newTestLocalBackend(t)
+
+	for _, key := range []string{"", "/etc/passwd", "../secret", "uploads/../../secret", "..", `uploads\1`} {
+		_, err := backend.Path(key)
+		assert.ErrorIs(t, err, ErrInvalidKey, key)
+	}
+
+	_, err := backend.Path("uploads/1/photo.jpg")
+	assert.NoError(t, err)
+}
+
+func TestLocalBackend_PresignedURLs(t *testing.T) {
+	backend := newTestLocalBackend(t)
+	now := time.Unix(1700000000, 0)
+	backend.now = func() time.Time { return now }
+
+	parse := func(raw string) (string, url.Values) {
+		u, err := url.Parse(raw)
+		require.NoError(t, err)
+		return strings.TrimPrefix(u.Path, "/files/"), u.Query()
+	}
+
+	t.Run("Get", func(t *testing.T) {
+		raw, err := backend.GetPresignedURL("uploads/1/photo.jpg", time.Hour)
+		require.NoError(t, err)
+		key, query := parse(raw)
+
+		assert.Equal(t, "uploads/1/photo.jpg", key)
+		assert.NoError(t, backend.Verify("GET", key, "", query))
+		assert.ErrorIs(t, backend.Verify("PUT", key, "", query), ErrInvalidSignature)
+		assert.ErrorIs(t, backend.Verify("GET", "uploads/2/photo.jpg", "", query), ErrInvalidSignature)
+	})
+
+	t.Run("Put", func(t *testing.T) {
+		raw, err := backend.GetPresignedUploadURL("uploads/1/photo.jpg", "image/jpeg", 0, time.Hour)
+		require.NoError(t, err)
+		key, query := parse(raw)
+
+		assert.NoError(t, backend.Verify("PUT", key, "image/jpeg", query))
+		assert.ErrorIs(t, backend.Verify("PUT", key, "image/png", query), ErrInvalidSignature)
+		assert.Equal(t, int64(0), SignedContentLength(query))
+
+		query.Set("content_type", "image/png")
+		assert.ErrorIs(t, backend.Verify("PUT", key, "image/png", query), ErrInvalidSignature)
+	})
+
+	t.Run("PutWithSize", func(t *testing.T) {
+		raw, err := backend.GetPresignedUploadURL("uploads/1/photo.jpg", "image/jpeg", 2048, time.Hour)
+		require.NoError(t, err)
+		key, query := parse(raw)
+
+		assert.NoError(t, backend.Verify("PUT", key, "image/jpeg", query))
+		assert.Equal(t, int64(2048), SignedContentLength(query))
+
+		query.Set("content_length", "4096")
+		assert.ErrorIs(t, backend.Verify("PUT", key, "image/jpeg", query), ErrInvalidSignature)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		raw, err := backend.GetPresignedURL("uploads/1/photo.jpg", time.Minute)
+		require.NoError(t, err)
+		key, query := parse(raw)
+
+		now = now.Add(2 * time.Minute)
+
+		assert.ErrorIs(t, backend.Verify("GET", key, "", query), ErrURLExpired)
+	})
+
+	t.Run("InvalidKey", func(t *testing.T) {
+		_, err := backend.GetPresignedUploadURL("../outside.jpg", "image/jpeg", 0, time.Hour)
+
+		assert.ErrorIs(t, err, ErrInvalidKey)
+	})
+}
+
+func TestNewFromEnv(t *testing.T) {
+	t.Setenv("AWS_S3_BUCKET_NAME", "")
+	t.Setenv("STORAGE_LOCAL_DIR", t.TempDir())
+	t.Setenv("STORAGE_SIGNING_KEY", "key")
+
+	t.Run("DefaultsToLocal", func(t *testing.T) {
+		t.Setenv("STORAGE_BACKEND", "")
+
+		backend, err := NewFromEnv()
+
+		require.NoError(t, err)
+		assert.IsType(t, &LocalBackend{}, backend)
+	})
+
+	t.Run("S3RequiresBucket", func(t *testing.T) {
+		t.Setenv("STORAGE_BACKEND", "s3")
+
+		_, err := NewFromEnv()
+
+		assert.Error(t, err)
+	})
+
+	t.Run("Unknown", func(t *testing.T) {
+		t.Setenv("STORAGE_BACKEND", "ftp")
+
+		_, err := NewFromEnv()
+
+		assert.Error(t, err)
+	})
+}
+
+func TestKeyHelpers(t *testing.T) {
+	assert.Equal(t, "uploads/3/", KeyPrefix(VisibilityPublic, 3))
+	assert.Equal(t, "private/3/", KeyPrefix(VisibilityPrivate, 3))
+
+	assert.Equal(t, VisibilityPublic, KeyVisibility("uploads/3/a.jpg"))
+	assert.Equal(t, VisibilityPrivate, KeyVisibility("private/3/a.pdf"))
+	assert.Equal(t, VisibilityPrivate, KeyVisibility("other/a.pdf"))
+
+	owner, ok := KeyOwner("private/42/1700000000_policy.pdf")
+	assert.True(t, ok)
+	assert.Equal(t, uint(42), owner)
+
+	for _, key := range []string{"private/abc/a.pdf", "other/42/a.pdf", "private/42"} {
+		_, ok := KeyOwner(key)
+		assert.False(t, ok, key)
+	}
+}
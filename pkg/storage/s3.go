@@ -1,20 +1,29 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
-	"strings"
 	"time"
 
+	"mowsy-api/pkg/metrics"
+	"mowsy-api/pkg/tracing"
+
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var _ Backend = (*S3Service)(nil)
+
 type S3Service struct {
 	bucket   string
 	region   string
@@ -40,6 +49,8 @@ func NewS3Service() (*S3Service, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create AWS session: %w", err)
 	}
+	sess.Handlers.Send.PushFront(startS3Span)
+	sess.Handlers.Complete.PushBack(observeS3Request)
 
 	s3Client := s3.New(sess)
 	uploader := s3manager.NewUploader(sess)
@@ -52,26 +63,19 @@ func NewS3Service() (*S3Service, error) {
 	}, nil
 }
 
-type UploadResult struct {
-	URL      string `json:"url"`
-	Key      string `json:"key"`
-	Size     int64  `json:"size"`
-	MimeType string `json:"mime_type"`
-}
-
-func (s *S3Service) UploadFile(file io.Reader, fileName, mimeType string, userID uint) (*UploadResult, error) {
-	if err := s.validateFile(fileName, mimeType); err != nil {
+func (s *S3Service) UploadFile(file io.Reader, fileName, mimeType string, userID uint, visibility Visibility) (*UploadResult, error) {
+	if err := validateFile(fileName, mimeType); err != nil {
 		return nil, err
 	}
 
-	key := s.generateKey(fileName, userID)
+	key := generateKey(fileName, userID, visibility)
 
 	result, err := s.uploader.Upload(&s3manager.UploadInput{
 		Bucket:      aws.String(s.bucket),
 		Key:         aws.String(key),
 		Body:        file,
 		ContentType: aws.String(mimeType),
-		ACL:         aws.String("public-read"),
+		ACL:         objectACL(key),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to upload file: %w", err)
@@ -84,44 +88,94 @@ func (s *S3Service) UploadFile(file io.Reader, fileName, mimeType string, userID
 	}, nil
 }
 
-func (s *S3Service) validateFile(fileName, mimeType string) error {
-	allowedMimeTypes := map[string]bool{
-		"image/jpeg": true,
-		"image/jpg":  true,
-		"image/png":  true,
-		"image/gif":  true,
-		"image/webp": true,
-		"application/pdf": true,
+func (s *S3Service) PutFile(key string, file io.Reader, mimeType string) (*UploadResult, error) {
+	result, err := s.uploader.Upload(&s3manager.UploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        file,
+		ContentType: aws.String(mimeType),
+		ACL:         objectACL(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload file: %w", err)
 	}
 
-	if !allowedMimeTypes[mimeType] {
-		return fmt.Errorf("unsupported file type: %s", mimeType)
-	}
+	return &UploadResult{
+		URL:      result.Location,
+		Key:      key,
+		MimeType: mimeType,
+	}, nil
+}
 
-	allowedExtensions := map[string]bool{
-		".jpg":  true,
-		".jpeg": true,
-		".png":  true,
-		".gif":  true,
-		".webp": true,
-		".pdf":  true,
+func (s *S3Service) OpenFile(key string) (io.ReadCloser, error) {
+	output, err := s.s3Client.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == s3.ErrCodeNoSuchKey {
+			return nil, ErrFileNotFound
+		}
+		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
-	ext := strings.ToLower(filepath.Ext(fileName))
-	if !allowedExtensions[ext] {
-		return fmt.Errorf("unsupported file extension: %s", ext)
+	return output.Body, nil
+}
+
+func (s *S3Service) FileURL(key string) string {
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.bucket, s.region, (&url.URL{Path: key}).EscapedPath())
+}
+
+func (s *S3Service) ListFiles(prefix string, fn func(FileInfo) error) error {
+	var fnErr error
+	err := s.s3Client.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, object := range page.Contents {
+			fnErr = fn(FileInfo{
+				Key:          aws.StringValue(object.Key),
+				Size:         aws.Int64Value(object.Size),
+				LastModified: aws.TimeValue(object.LastModified),
+			})
+			if fnErr != nil {
+				return false
+			}
+		}
+		return true
+	})
+	if fnErr != nil {
+		return fnErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list files: %w", err)
 	}
 
 	return nil
 }
 
-func (s *S3Service) generateKey(fileName string, userID uint) string {
-	timestamp := time.Now().Unix()
-	ext := filepath.Ext(fileName)
-	baseName := strings.TrimSuffix(filepath.Base(fileName), ext)
-	baseName = url.QueryEscape(baseName)
-	
-	return fmt.Sprintf("uploads/%d/%d_%s%s", userID, timestamp, baseName, ext)
+func (s *S3Service) StatFile(key string) (*FileInfo, error) {
+	output, err := s.s3Client.HeadObject(&s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		// HEAD responses have no body, so a missing key surfaces as NotFound
+		// rather than NoSuchKey
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && (aerr.Code() == "NotFound" || aerr.Code() == s3.ErrCodeNoSuchKey) {
+			return nil, ErrFileNotFound
+		}
+		return nil, fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	return &FileInfo{
+		Key:          key,
+		Size:         aws.Int64Value(output.ContentLength),
+		ContentType:  aws.StringValue(output.ContentType),
+		LastModified: aws.TimeValue(output.LastModified),
+	}, nil
 }
 
 func (s *S3Service) DeleteFile(key string) error {
@@ -150,13 +204,19 @@ func (s *S3Service) GetPresignedURL(key string, expiration time.Duration) (strin
 	return url, nil
 }
 
-func (s *S3Service) GetPresignedUploadURL(key string, mimeType string, expiration time.Duration) (string, error) {
-	req, _ := s.s3Client.PutObjectRequest(&s3.PutObjectInput{
+func (s *S3Service) GetPresignedUploadURL(key string, mimeType string, size int64, expiration time.Duration) (string, error) {
+	input := &s3.PutObjectInput{
 		Bucket:      aws.String(s.bucket),
 		Key:         aws.String(key),
 		ContentType: aws.String(mimeType),
-		ACL:         aws.String("public-read"),
-	})
+		ACL:         objectACL(key),
+	}
+	// Content-Length becomes a signed header, so S3 rejects a PUT of any
+	// other size
+	if size > 0 {
+		input.ContentLength = aws.Int64(size)
+	}
+	req, _ := s.s3Client.PutObjectRequest(input)
 
 	url, err := req.Presign(expiration)
 	if err != nil {
@@ -164,4 +224,41 @@ func (s *S3Service) GetPresignedUploadURL(key string, mimeType string, expiratio
 	}
 
 	return url, nil
-}
\ No newline at end of file
+}
+
+type s3SpanKey struct{}
+
+// startS3Span opens a span when a request is first sent; Send runs again on
+// each retry, which the span should cover rather than restart. Presigning
+// never sends, so presigned URLs get no span.
+func startS3Span(r *request.Request) {
+	if r.Context().Value(s3SpanKey{}) != nil {
+		return
+	}
+	ctx, span := tracing.StartClient(r.Context(), metrics.ServiceS3, r.Operation.Name)
+	r.SetContext(context.WithValue(ctx, s3SpanKey{}, span))
+}
+
+// observeS3Request times every S3 call, retries included, and ends its span.
+// A missing key is an answer rather than a failure, so 404s are not counted
+// as errors.
+func observeS3Request(r *request.Request) {
+	err := r.Error
+	if r.HTTPResponse != nil && r.HTTPResponse.StatusCode == http.StatusNotFound {
+		err = nil
+	}
+	metrics.ObserveOutbound(metrics.ServiceS3, r.Operation.Name, r.Time, err)
+
+	if span, ok := r.Context().Value(s3SpanKey{}).(trace.Span); ok {
+		tracing.End(span, err)
+	}
+}
+
+// objectACL grants public read only to keys under the public prefix; private
+// objects inherit the bucket's default (owner-only) ACL.
+func objectACL(key string) *string {
+	if KeyVisibility(key) == VisibilityPublic {
+		return aws.String("public-read")
+	}
+	return nil
+}
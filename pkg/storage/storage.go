@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Visibility controls whether a stored file can be fetched without a
+// presigned URL. It is encoded in the key prefix, so every backend and the
+// local file server can tell from the key alone.
+type Visibility string
+
+const (
+	VisibilityPublic  Visibility = "public"
+	VisibilityPrivate Visibility = "private"
+)
+
+const (
+	publicKeyPrefix  = "uploads/"
+	privateKeyPrefix = "private/"
+)
+
+// KeyPrefix returns the prefix under which files with the given visibility
+// are stored for userID, e.g. "private/42/".
+func KeyPrefix(visibility Visibility, userID uint) string {
+	prefix := publicKeyPrefix
+	if visibility == VisibilityPrivate {
+		prefix = privateKeyPrefix
+	}
+	return fmt.Sprintf("%s%d/", prefix, userID)
+}
+
+// KeyVisibility reports the visibility of an existing key. Anything outside
+// the public prefix is treated as private.
+func KeyVisibility(key string) Visibility {
+	if strings.HasPrefix(key, publicKeyPrefix) {
+		return VisibilityPublic
+	}
+	return VisibilityPrivate
+}
+
+// KeyOwner returns the ID of the user a key was generated for.
+func KeyOwner(key string) (uint, bool) {
+	parts := strings.SplitN(key, "/", 3)
+	if len(parts) != 3 || (parts[0]+"/" != publicKeyPrefix && parts[0]+"/" != privateKeyPrefix) {
+		return 0, false
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	return uint(id), true
+}
+
+// Backend stores uploaded files. S3Service is used in deployed environments
+// and LocalBackend for development and tests.
+type Backend interface {
+	UploadFile(file io.Reader, fileName, mimeType string, userID uint, visibility Visibility) (*UploadResult, error)
+	// PutFile writes to an exact key, e.g. an image variant derived from an
+	// uploaded file's key. Unlike UploadFile it does not validate the type.
+	PutFile(key string, file io.Reader, mimeType string) (*UploadResult, error)
+	OpenFile(key string) (io.ReadCloser, error)
+	// FileURL is the permanent URL of a public file. Private files are only
+	// readable through GetPresignedURL.
+	FileURL(key string) string
+	// ListFiles calls fn for every stored file whose key starts with prefix.
+	// Listing stops at the first error fn returns.
+	ListFiles(prefix string, fn func(FileInfo) error) error
+	// StatFile looks up a stored file without reading it, like an S3 HEAD
+	// request. It returns ErrFileNotFound if nothing is stored under key.
+	StatFile(key string) (*FileInfo, error)
+	DeleteFile(key string) error
+	GetPresignedURL(key string, expiration time.Duration) (string, error)
+	// GetPresignedUploadURL signs a PUT for key. When size is positive the
+	// upload must send exactly that Content-Length.
+	GetPresignedUploadURL(key string, mimeType string, size int64, expiration time.Duration) (string, error)
+}
+
+type FileInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// Prefixes lists the key prefixes under which uploads are stored.
+func Prefixes() []string {
+	return []string{publicKeyPrefix, privateKeyPrefix}
+}
+
+type UploadResult struct {
+	URL      string `json:"url"`
+	Key      string `json:"key"`
+	Size     int64  `json:"size"`
+	MimeType string `json:"mime_type"`
+}
+
+// ErrFileNotFound is returned by OpenFile when nothing is stored under a key.
+var ErrFileNotFound = errors.New("file not found")
+
+var allowedMimeTypes = map[string]bool{
+	"image/jpeg":      true,
+	"image/jpg":       true,
+	"image/png":       true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+var allowedExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+	".webp": true,
+	".pdf":  true,
+}
+
+// KeyFromURL extracts the storage key from a URL returned by any backend, or
+// returns ref unchanged if it is already a key. It returns "" for URLs that
+// do not point at an uploaded file.
+func KeyFromURL(ref string) string {
+	if strings.HasPrefix(ref, publicKeyPrefix) || strings.HasPrefix(ref, privateKeyPrefix) {
+		return ref
+	}
+
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	for _, prefix := range []string{"/" + publicKeyPrefix, "/" + privateKeyPrefix} {
+		if i := strings.Index(u.Path, prefix); i >= 0 {
+			return u.Path[i+1:]
+		}
+	}
+	return ""
+}
+
+func validateFile(fileName, mimeType string) error {
+	if !allowedMimeTypes[mimeType] {
+		return fmt.Errorf("unsupported file type: %s", mimeType)
+	}
+
+	ext := strings.ToLower(filepath.Ext(fileName))
+	if !allowedExtensions[ext] {
+		return fmt.Errorf("unsupported file extension: %s", ext)
+	}
+
+	return nil
+}
+
+func generateKey(fileName string, userID uint, visibility Visibility) string {
+	timestamp := time.Now().UnixNano()
+	ext := filepath.Ext(fileName)
+	baseName := strings.TrimSuffix(filepath.Base(fileName), ext)
+	baseName = url.QueryEscape(baseName)
+
+	return fmt.Sprintf("%s%d_%s%s", KeyPrefix(visibility, userID), timestamp, baseName, ext)
+}
@@ -0,0 +1,76 @@
+package tracing
+
+import (
+	"errors"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const gormSpanKey = "tracing:span"
+
+// GORMPlugin traces statements run with a context that already carries a
+// span, i.e. those issued through db.WithContext from a traced request or
+// task. Statements without one, such as the worker's polling queries, are
+// left alone rather than each starting a trace of their own.
+type GORMPlugin struct{}
+
+func (GORMPlugin) Name() string {
+	return "tracing"
+}
+
+func (GORMPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	return errors.Join(
+		cb.Create().Before("*").Register("tracing:before_create", startStatement("create")),
+		cb.Create().After("*").Register("tracing:after_create", endStatement),
+		cb.Query().Before("*").Register("tracing:before_query", startStatement("query")),
+		cb.Query().After("*").Register("tracing:after_query", endStatement),
+		cb.Update().Before("*").Register("tracing:before_update", startStatement("update")),
+		cb.Update().After("*").Register("tracing:after_update", endStatement),
+		cb.Delete().Before("*").Register("tracing:before_delete", startStatement("delete")),
+		cb.Delete().After("*").Register("tracing:after_delete", endStatement),
+		cb.Row().Before("*").Register("tracing:before_row", startStatement("row")),
+		cb.Row().After("*").Register("tracing:after_row", endStatement),
+		cb.Raw().Before("*").Register("tracing:before_raw", startStatement("raw")),
+		cb.Raw().After("*").Register("tracing:after_raw", endStatement),
+	)
+}
+
+func startStatement(operation string) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx := db.Statement.Context
+		if ctx == nil || !trace.SpanContextFromContext(ctx).IsValid() {
+			return
+		}
+
+		_, span := Tracer().Start(ctx, "gorm."+operation,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(attribute.String("db.system", db.Dialector.Name())))
+		db.InstanceSet(gormSpanKey, span)
+	}
+}
+
+func endStatement(db *gorm.DB) {
+	value, ok := db.InstanceGet(gormSpanKey)
+	if !ok {
+		return
+	}
+	span := value.(trace.Span)
+
+	if table := db.Statement.Table; table != "" {
+		span.SetAttributes(attribute.String("db.sql.table", table))
+	}
+	// The SQL keeps its placeholders; bound values are never recorded.
+	span.SetAttributes(
+		attribute.String("db.statement", db.Statement.SQL.String()),
+		attribute.Int64("db.rows_affected", db.RowsAffected),
+	)
+
+	err := db.Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = nil
+	}
+	End(span, err)
+}
@@ -0,0 +1,143 @@
+// Package tracing sets up OpenTelemetry tracing. Spans are exported over
+// OTLP/HTTP when the standard OTEL_* variables ask for it and dropped
+// otherwise, so instrumented code never has to check whether tracing is on.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	instrumentationName = "mowsy-api"
+	defaultServiceName  = "mowsy-api"
+)
+
+// propagator reads and writes W3C traceparent and baggage headers.
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// provider is the SDK provider installed by Setup or InMemory, kept so Flush
+// can reach it. It is nil while tracing is off.
+var provider *sdktrace.TracerProvider
+
+// Setup installs the global tracer provider. Exporting is enabled by
+// OTEL_TRACES_EXPORTER=otlp, or by setting OTEL_EXPORTER_OTLP_ENDPOINT (or
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT) with OTEL_TRACES_EXPORTER unset; the
+// exporter, sampler and resource read the rest of their OTEL_* settings
+// themselves. The returned function flushes and stops the exporter.
+func Setup(ctx context.Context) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagator)
+
+	if !exporterConfigured() {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	exporter, err := otlptracehttp.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(defaultServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	install(sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	))
+	return provider.Shutdown, nil
+}
+
+func exporterConfigured() bool {
+	switch exporter := strings.ToLower(os.Getenv("OTEL_TRACES_EXPORTER")); exporter {
+	case "otlp":
+		return true
+	case "":
+		return os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" ||
+			os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != ""
+	case "none":
+		return false
+	default:
+		slog.Warn("unsupported OTEL_TRACES_EXPORTER, tracing disabled", "exporter", exporter)
+		return false
+	}
+}
+
+func install(p *sdktrace.TracerProvider) {
+	provider = p
+	otel.SetTracerProvider(p)
+}
+
+// Flush exports any buffered spans. Lambda calls it before each invocation
+// returns, since a frozen execution environment cannot export in the
+// background.
+func Flush(ctx context.Context) {
+	if provider == nil {
+		return
+	}
+	if err := provider.ForceFlush(ctx); err != nil {
+		slog.Warn("failed to flush spans", "error", err)
+	}
+}
+
+// InMemory installs a provider that records every span synchronously in the
+// returned exporter, for tests. The returned function restores the previous
+// provider.
+func InMemory() (*tracetest.InMemoryExporter, func()) {
+	previous, previousProvider := otel.GetTracerProvider(), provider
+
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTextMapPropagator(propagator)
+	install(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+
+	return exporter, func() {
+		provider = previousProvider
+		otel.SetTracerProvider(previous)
+	}
+}
+
+// Tracer returns the tracer for the API's own spans.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start begins an internal span.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartClient begins a span for a call to an outside service, named
+// "<service>.<operation>".
+func StartClient(ctx context.Context, service, operation string) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, service+"."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.PeerService(service)))
+}
+
+// End marks the span as failed if err is non-nil and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,117 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type widget struct {
+	ID   uint
+	Name string
+}
+
+func TestExporterConfigured(t *testing.T) {
+	for _, key := range []string{"OTEL_TRACES_EXPORTER", "OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT"} {
+		t.Setenv(key, "")
+	}
+	assert.False(t, exporterConfigured())
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://collector:4318")
+	assert.True(t, exporterConfigured())
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "none")
+	assert.False(t, exporterConfigured())
+
+	t.Setenv("OTEL_TRACES_EXPORTER", "otlp")
+	os.Unsetenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	assert.True(t, exporterConfigured())
+}
+
+func TestSetup_NoopByDefault(t *testing.T) {
+	t.Setenv("OTEL_TRACES_EXPORTER", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+
+	shutdown, err := Setup(context.Background())
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, span := Start(context.Background(), "unexported")
+	assert.False(t, span.IsRecording())
+	span.End()
+}
+
+func TestEnd_RecordsError(t *testing.T) {
+	exporter, restore := InMemory()
+	defer restore()
+
+	_, span := StartClient(context.Background(), "stripe", "customer.create")
+	End(span, errors.New("card declined"))
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "stripe.customer.create", spans[0].Name)
+	assert.Equal(t, codes.Error, spans[0].Status.Code)
+	assert.Equal(t, "card declined", spans[0].Status.Description)
+}
+
+func TestGORMPlugin(t *testing.T) {
+	exporter, restore := InMemory()
+	defer restore()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&widget{}))
+	require.NoError(t, db.Use(GORMPlugin{}))
+
+	t.Run("TracesStatementsUnderASpan", func(t *testing.T) {
+		exporter.Reset()
+		ctx, parent := Start(context.Background(), "request")
+		require.NoError(t, db.WithContext(ctx).Create(&widget{Name: "mower"}).Error)
+		var found widget
+		require.NoError(t, db.WithContext(ctx).Where("name = ?", "mower").First(&found).Error)
+		err := db.WithContext(ctx).Where("name = ?", "missing").First(&widget{}).Error
+		require.ErrorIs(t, err, gorm.ErrRecordNotFound)
+		parent.End()
+
+		spans := exporter.GetSpans()
+		require.Len(t, spans, 4)
+		create, query, miss := spans[0], spans[1], spans[2]
+
+		assert.Equal(t, "gorm.create", create.Name)
+		assert.Equal(t, "gorm.query", query.Name)
+		assert.Equal(t, parent.SpanContext().SpanID(), create.Parent.SpanID())
+		assert.Equal(t, parent.SpanContext().TraceID(), query.SpanContext.TraceID())
+
+		attrs := attributeMap(query)
+		assert.Equal(t, "widgets", attrs["db.sql.table"])
+		assert.Contains(t, attrs["db.statement"], "name = ?")
+		assert.NotContains(t, attrs["db.statement"], "mower")
+
+		assert.Equal(t, codes.Unset, miss.Status.Code)
+	})
+
+	t.Run("SkipsStatementsWithoutASpan", func(t *testing.T) {
+		exporter.Reset()
+		require.NoError(t, db.Create(&widget{Name: "trimmer"}).Error)
+		assert.Empty(t, exporter.GetSpans())
+	})
+}
+
+func attributeMap(span tracetest.SpanStub) map[string]string {
+	attrs := make(map[string]string)
+	for _, kv := range span.Attributes {
+		attrs[string(kv.Key)] = kv.Value.Emit()
+	}
+	return attrs
+}